@@ -301,6 +301,214 @@ type Transfers struct {
 	DownloadLimit int `default:"0" yaml:"download_limit"`
 }
 
+// ResticConfiguration defines the configuration for restic based backups that are
+// managed by this Wings instance.
+type ResticConfiguration struct {
+	// Binary is the name of, or path to, the restic executable. When only a name
+	// is provided it is resolved using the PATH of the Wings process.
+	Binary string `default:"restic" json:"-" yaml:"binary"`
+
+	// RepositoryDirectory is the base directory that all server repositories are
+	// created within. Job status files are also tracked in hidden directories at
+	// this location.
+	//
+	// Defaults to "restic" within the root directory of the system.
+	RepositoryDirectory string `json:"-" yaml:"repository_directory"`
+
+	// TempDirectory is where snapshots are restored to and archived when they are
+	// being prepared for download.
+	//
+	// Defaults to "temp" within the repository directory.
+	TempDirectory string `json:"-" yaml:"temp_directory"`
+
+	// ArchiveDirectory is where repositories are moved to when the server they belong
+	// to is deleted from this instance.
+	//
+	// Defaults to "archive" within the repository directory.
+	ArchiveDirectory string `json:"-" yaml:"archive_directory"`
+
+	// MasterKeyFile is the node master key that the passwords of repositories are
+	// encrypted with before they are stored in the Wings database. It is created
+	// the first time it is needed, and must be kept outside of the repository
+	// directory so that a copy of a repository never includes its password.
+	MasterKeyFile string `default:"/etc/pterodactyl/restic-master.key" json:"-" yaml:"master_key_file"`
+
+	// RejectQueryKeys causes requests that send the encryption key of a repository
+	// in the query string to be rejected. Query strings are written to the access
+	// logs of proxies, so the key should be sent in the X-Restic-Encryption-Key
+	// header or the request body instead.
+	RejectQueryKeys bool `default:"false" json:"-" yaml:"reject_query_keys"`
+
+	// Backend configures a remote location to store repositories in instead of
+	// RepositoryDirectory, so that backups survive the loss of the node.
+	Backend ResticBackend `json:"-" yaml:"backend"`
+
+	// Replication configures a secondary repository that the snapshots of every
+	// server are copied to after each successful backup.
+	Replication ResticReplication `json:"-" yaml:"replication"`
+
+	// TransferRepositories sends the repositories of a server, along with their
+	// stored keys and the backup policy of the server, to the target node when the
+	// server is transferred, and removes them from this node once the target node
+	// has verified them. Transfers can also request this for a single server.
+	TransferRepositories bool `default:"false" json:"-" yaml:"transfer_repositories"`
+
+	// DefaultExcludes are rules that are excluded from every restic backup created
+	// on this node, in addition to the rules in a server's .pteroignore file. They
+	// use the same format as .pteroignore, so negated rules in a server's own file
+	// are able to re-include paths excluded here.
+	DefaultExcludes []string `json:"-" yaml:"default_excludes"`
+
+	// PreRestoreWindow is the amount of time in seconds that the snapshot taken of
+	// a server immediately before a restore is exempt from being removed to make
+	// room for new backups when the server has reached its backup limit.
+	PreRestoreWindow int `default:"86400" json:"-" yaml:"pre_restore_window"`
+
+	Timeouts ResticTimeouts `json:"timeouts" yaml:"timeouts"`
+
+	Workers ResticWorkers `json:"workers" yaml:"workers"`
+
+	Stale ResticStaleThresholds `json:"stale" yaml:"stale"`
+}
+
+// ResticTimeouts defines the amount of time in seconds that each type of restic
+// operation is allowed to run for before the process is terminated.
+type ResticTimeouts struct {
+	// Backup is the timeout for creating a new snapshot.
+	Backup int `default:"21600" yaml:"backup"`
+
+	// Restore is the timeout for restoring a snapshot into a server's data directory.
+	Restore int `default:"21600" yaml:"restore"`
+
+	// Prepare is the timeout for each step of preparing a snapshot for download, which
+	// is restoring the snapshot to the temporary directory and then archiving it.
+	Prepare int `default:"7200" yaml:"prepare"`
+
+	// Prune is the timeout for applying a retention policy to a repository.
+	Prune int `default:"7200" yaml:"prune"`
+
+	// Check is the timeout for a repository health check that runs in the background.
+	Check int `default:"7200" yaml:"check"`
+
+	// Copy is the timeout for copying snapshots to a secondary repository and
+	// applying its retention policy.
+	Copy int `default:"21600" yaml:"copy"`
+
+	// Import is the timeout for importing each backup archive into a repository,
+	// which includes extracting the archive and verifying the snapshot.
+	Import int `default:"21600" yaml:"import"`
+
+	// CheckSync is the timeout for a repository health check that blocks the request
+	// until it has completed.
+	CheckSync int `default:"600" yaml:"check_sync"`
+
+	// Snapshots is the timeout for listing the snapshots in a repository.
+	Snapshots int `default:"60" yaml:"snapshots"`
+
+	// Stats is the timeout for each of the statistic modes collected for a repository.
+	Stats int `default:"120" yaml:"stats"`
+
+	// Browse is the timeout for listing the contents of a directory within a snapshot.
+	Browse int `default:"120" yaml:"browse"`
+
+	// Diff is the timeout for comparing two snapshots, which includes listing both
+	// of them to find the size of the files that changed.
+	Diff int `default:"600" yaml:"diff"`
+
+	// Unlock is the timeout for removing stale locks from a repository.
+	Unlock int `default:"30" yaml:"unlock"`
+}
+
+// ResticWorkers defines how many restic operations of each type are allowed to run
+// at the same time across every server on this instance. Operations beyond the limit
+// wait in a queue until a worker becomes available.
+type ResticWorkers struct {
+	// Backup is the number of snapshots that can be created at the same time.
+	Backup int `default:"2" yaml:"backup"`
+
+	// Restore is the number of snapshots that can be restored at the same time.
+	Restore int `default:"2" yaml:"restore"`
+
+	// Prune is the number of retention policies that can be applied at the same time.
+	Prune int `default:"1" yaml:"prune"`
+
+	// Check is the number of repository health checks that can run at the same time.
+	Check int `default:"1" yaml:"check"`
+
+	// Prepare is the number of snapshots that can be prepared for download at the same time.
+	Prepare int `default:"2" yaml:"prepare"`
+
+	// Copy is the number of repositories that can be replicated at the same time.
+	Copy int `default:"1" yaml:"copy"`
+
+	// Import is the number of servers that can import backup archives at the same time.
+	Import int `default:"1" yaml:"import"`
+}
+
+// setDirectories sets any of the restic directories that have not been configured
+// to their default location within the root directory of the system, so that
+// nodes using a custom root directory do not keep repositories outside of it.
+func (rc *ResticConfiguration) setDirectories(root string) {
+	if rc.RepositoryDirectory == "" {
+		rc.RepositoryDirectory = filepath.Join(root, "restic")
+	}
+	if rc.TempDirectory == "" {
+		rc.TempDirectory = filepath.Join(rc.RepositoryDirectory, "temp")
+	}
+	if rc.ArchiveDirectory == "" {
+		rc.ArchiveDirectory = filepath.Join(rc.RepositoryDirectory, "archive")
+	}
+}
+
+// ResticBackend defines where repositories are stored when they are not kept on
+// the local disk of the node.
+type ResticBackend struct {
+	// URL is the location that the repository of each server is created within,
+	// such as "rest:https://host:8000/wings", "s3:https://host/bucket/wings" or
+	// "sftp:user@host:/srv/restic". Repositories are stored on the local disk
+	// when it is empty.
+	URL string `json:"-" yaml:"url"`
+
+	// Servers overrides URL for individual servers, keyed by the UUID of the
+	// server.
+	Servers map[string]string `json:"-" yaml:"servers"`
+
+	// Env is added to the environment of every restic process that accesses a
+	// remote repository, and is where the credentials of the backend are set,
+	// such as AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY for S3, or
+	// RESTIC_REST_USERNAME and RESTIC_REST_PASSWORD for a REST server.
+	Env map[string]string `json:"-" yaml:"env"`
+}
+
+// ResticReplication defines the secondary repositories that snapshots are copied to.
+type ResticReplication struct {
+	// URL is the location that the secondary repository of each server is created
+	// within, in the same format as the URL of a backend or a local directory.
+	// Servers can set their own location, and snapshots are only copied for those
+	// that do when it is empty.
+	URL string `json:"-" yaml:"url"`
+
+	// Env is added to the environment of every restic process that accesses a
+	// secondary repository, and is where the credentials of its backend are set.
+	Env map[string]string `json:"-" yaml:"env"`
+}
+
+// ResticStaleThresholds defines the amount of time in seconds after which restic
+// jobs and repository locks are considered abandoned.
+type ResticStaleThresholds struct {
+	// Job is the amount of time after which a job that is still marked as running
+	// is assumed to have died and is marked as failed.
+	Job int `default:"21600" yaml:"job"`
+
+	// Lock is the minimum age of a repository lock before Wings will automatically
+	// run "restic unlock" when an operation fails because the repository is locked.
+	Lock int `default:"1800" yaml:"lock"`
+
+	// ForceUnlock is the minimum age that every lock file in a repository must be before
+	// a forced unlock is allowed to delete them from the disk.
+	ForceUnlock int `default:"3600" yaml:"force_unlock"`
+}
+
 type ConsoleThrottles struct {
 	// Whether or not the throttler is enabled for this instance.
 	Enabled bool `json:"enabled" yaml:"enabled" default:"true"`
@@ -346,6 +554,7 @@ type Configuration struct {
 	Api    ApiConfiguration    `json:"api" yaml:"api"`
 	System SystemConfiguration `json:"system" yaml:"system"`
 	Docker DockerConfiguration `json:"docker" yaml:"docker"`
+	Restic ResticConfiguration `json:"restic" yaml:"restic"`
 
 	// Defines internal throttling configurations for server processes to prevent
 	// someone from running an endless loop that spams data to logs.
@@ -595,6 +804,7 @@ func FromFile(path string) error {
 	if err := yaml.Unmarshal(b, c); err != nil {
 		return err
 	}
+	c.Restic.setDirectories(c.System.RootDirectory)
 
 	c.Token = Token{
 		ID:    os.Getenv("WINGS_TOKEN_ID"),
@@ -669,6 +879,11 @@ func ConfigureDirectories() error {
 		return err
 	}
 
+	log.WithField("path", _config.Restic.RepositoryDirectory).Debug("ensuring restic repository directory exists")
+	if err := os.MkdirAll(_config.Restic.RepositoryDirectory, 0o700); err != nil {
+		return err
+	}
+
 	if _config.System.Passwd.Enable {
 		log.WithField("path", _config.System.Passwd.Directory).Debug("ensuring passwd directory exists")
 		if err := os.MkdirAll(_config.System.Passwd.Directory, 0o755); err != nil {
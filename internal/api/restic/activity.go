@@ -0,0 +1,38 @@
+package restic
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/pterodactyl/wings/internal/models"
+	"github.com/pterodactyl/wings/internal/resticsvc"
+	"github.com/pterodactyl/wings/server"
+)
+
+// The Panel forwards the user that triggered a restic operation, and the IP
+// address they connected from, using these headers. Without them the activity
+// is attributed to the system user.
+const (
+	activityUserHeader = "X-Activity-User"
+	activityIPHeader   = "X-Activity-Ip"
+)
+
+// newResticActivity starts tracking an operation for the server on the request
+// context, attributed to the user and IP address forwarded by the Panel.
+func newResticActivity(c *gin.Context, event models.Event) *resticsvc.Activity {
+	user, ip := requestActor(c)
+	return resticsvc.NewActivity(c.MustGet("server").(*server.Server), user, ip, event)
+}
+
+// requestActor returns the user that made the request, and the IP address they
+// connected from, as forwarded by the Panel. The user is empty if the header is
+// missing or is not a valid UUID.
+func requestActor(c *gin.Context) (user string, ip string) {
+	user = strings.TrimSpace(c.GetHeader(activityUserHeader))
+	if _, err := uuid.Parse(user); err != nil {
+		user = ""
+	}
+	return user, strings.TrimSpace(c.GetHeader(activityIPHeader))
+}
@@ -0,0 +1,384 @@
+package restic
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+	"github.com/juju/ratelimit"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/models"
+	resticcli "github.com/pterodactyl/wings/internal/restic"
+	"github.com/pterodactyl/wings/internal/resticsvc"
+	"github.com/pterodactyl/wings/remote"
+	"github.com/pterodactyl/wings/server"
+	"github.com/pterodactyl/wings/server/backup"
+	"github.com/pterodactyl/wings/server/filesystem"
+)
+
+// ErrSnapshotLocked is returned when removing a backup whose snapshot has been
+// locked against removal.
+var ErrSnapshotLocked = errors.New("restic: snapshot is locked")
+
+// BackupAdapter stores the backups that the Panel creates through the standard
+// backup routes as snapshots in the restic repository of the server. Each
+// snapshot is tagged with the UUID of its backup so that it can be found again
+// when the backup is restored or removed.
+type BackupAdapter struct {
+	backup.Backup
+
+	server     *server.Server
+	logContext map[string]interface{}
+
+	// client and snapshot are set once the repository has been opened and the
+	// snapshot of the backup has been found.
+	client   *resticcli.Client
+	snapshot *resticcli.Snapshot
+}
+
+var _ backup.BackupInterface = (*BackupAdapter)(nil)
+
+// NewBackupAdapter returns the restic adapter for a backup of the server.
+func NewBackupAdapter(client remote.Client, s *server.Server, uuid string, ignore string) *BackupAdapter {
+	b := &BackupAdapter{
+		Backup: backup.Backup{Uuid: uuid, Ignore: ignore},
+		server: s,
+	}
+	b.SetClient(client)
+	return b
+}
+
+// LocateBackup finds the snapshot of a backup of the server. An error wrapping
+// os.ErrNotExist is returned if the backup is not stored in restic.
+func LocateBackup(client remote.Client, s *server.Server, uuid string) (*BackupAdapter, error) {
+	b := NewBackupAdapter(client, s, uuid, "")
+	ctx, cancel := context.WithTimeout(s.Context(), resticsvc.Seconds(config.Get().Restic.Timeouts.Snapshots))
+	defer cancel()
+	if _, err := b.find(ctx); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// WithLogContext attaches additional context to the log output for this backup.
+func (b *BackupAdapter) WithLogContext(c map[string]interface{}) {
+	b.logContext = c
+}
+
+func (b *BackupAdapter) log() *log.Entry {
+	l := log.WithField("backup", b.Identifier()).WithField("adapter", backup.ResticBackupAdapter)
+	for k, v := range b.logContext {
+		l = l.WithField(k, v)
+	}
+	return l
+}
+
+// Path returns the location of the repository that the backup is stored in.
+func (b *BackupAdapter) Path() string {
+	return resticsvc.RepoPath(resticsvc.ResolveRepoDir(b.server.ID(), ""))
+}
+
+// generateResticKey returns a random password for a repository that the Panel
+// has never provided one for.
+func generateResticKey() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "restic: failed to generate repository password")
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// open returns the client for the repository of the server using its stored
+// password. When create is true and the repository has not been initialized
+// yet, a password is generated and stored for it if there is not one already.
+func (b *BackupAdapter) open(create bool) (*resticcli.Client, string, error) {
+	repo := b.Path()
+	key, err := resticsvc.LoadKey(repo)
+	if err != nil {
+		return nil, "", err
+	}
+	if key != "" {
+		return resticsvc.NewClient(repo, key), key, nil
+	}
+	if !create {
+		return nil, "", errors.Wrap(os.ErrNotExist, "restic: no encryption key is stored for the repository")
+	}
+	if resticsvc.RepoInitialized(repo) {
+		return nil, "", errors.New("restic: no encryption key is stored for the repository")
+	}
+	if key, err = generateResticKey(); err != nil {
+		return nil, "", err
+	}
+	if err := resticsvc.StoreKey(repo, key); err != nil {
+		return nil, "", err
+	}
+	return resticsvc.NewClient(repo, key), key, nil
+}
+
+// find returns the snapshot that is tagged with the UUID of the backup.
+func (b *BackupAdapter) find(ctx context.Context) (*resticcli.Snapshot, error) {
+	if b.snapshot != nil {
+		return b.snapshot, nil
+	}
+	if b.client == nil {
+		client, _, err := b.open(false)
+		if err != nil {
+			return nil, err
+		}
+		b.client = client
+	}
+	snapshots, err := b.client.Snapshots(ctx, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "restic: failed to list snapshots: "+resticsvc.Output(err))
+	}
+	for _, snap := range snapshots {
+		if snap.BackupUUID() == b.Identifier() {
+			b.snapshot = &snap
+			return b.snapshot, nil
+		}
+	}
+	return nil, errors.Wrap(os.ErrNotExist, "restic: no snapshot exists for backup "+b.Identifier())
+}
+
+// Generate creates a snapshot of the server data directory, tagged with the UUID
+// of the backup, through the job queue.
+func (b *BackupAdapter) Generate(ctx context.Context, _ *filesystem.Filesystem, ignore string) (*backup.ArchiveDetails, error) {
+	client, key, err := b.open(true)
+	if err != nil {
+		return nil, err
+	}
+	if err := resticsvc.InitRepoIfMissing(client); err != nil {
+		return nil, errors.New("restic: failed to initialize repository: " + resticsvc.Output(err))
+	}
+
+	serverId := b.server.ID()
+	volumePath := resticsvc.VolumePath(serverId)
+	rules := append(append([]string{}, config.Get().Restic.DefaultExcludes...), ignore)
+	opts := resticcli.BackupOptions{
+		Paths:    []string{volumePath},
+		Tags:     []string{resticcli.BackupTagPrefix + b.Identifier()},
+		Excludes: resticcli.IgnorePatterns(volumePath, strings.Join(rules, "\n")),
+		OnStatus: resticsvc.BackupProgress(b.server),
+	}
+
+	b.log().WithField("repository", client.Repository()).Info("creating restic snapshot for server backup")
+	activity := resticsvc.NewActivity(b.server, "", "", server.ActivityResticBackup)
+	resticsvc.SetBackupStatus(serverId, "running", "")
+	err = resticsvc.Jobs.Run(&resticsvc.Job{Type: resticsvc.JobBackup, Server: serverId, Priority: resticsvc.PriorityManual, Client: client}, func(j *resticsvc.Job) error {
+		resticsvc.SetBackupStatus(serverId, "running", "")
+		ctx, cancel := jobContext(ctx, j)
+		defer cancel()
+		summary, err := resticsvc.RunBackupWithRecovery(ctx, client, opts, key, serverId)
+		meta := models.ActivityMeta{"backup_uuid": b.Identifier()}
+		if summary != nil {
+			j.Snapshot = summary.SnapshotID
+			j.Bytes = summary.TotalBytesProcessed
+			meta["snapshot_id"] = summary.SnapshotID
+			meta["bytes"] = summary.TotalBytesProcessed
+		}
+		activity.Save(err, meta)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "restic: backup failed: "+resticsvc.Output(err))
+	}
+	b.log().Info("created restic snapshot successfully")
+	resticsvc.Replicate(b.server, client, key, resticsvc.PriorityManual, "", "")
+
+	b.client = client
+	ad, err := b.Details(ctx, nil)
+	if err != nil {
+		return nil, errors.WrapIf(err, "backup: failed to get snapshot details for restic backup")
+	}
+	return ad, nil
+}
+
+// jobContext returns a context for the commands of a job that is canceled when
+// either the job is cancelled or ctx is done.
+func jobContext(ctx context.Context, j *resticsvc.Job) (context.Context, context.CancelFunc) {
+	jctx, cancel := context.WithCancel(j.Context())
+	stop := context.AfterFunc(ctx, cancel)
+	return jctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// Checksum returns the ID of the snapshot, which is the SHA-256 hash of the
+// snapshot as stored in the repository.
+func (b *BackupAdapter) Checksum() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(b.server.Context(), resticsvc.Seconds(config.Get().Restic.Timeouts.Snapshots))
+	defer cancel()
+	snap, err := b.find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(snap.ID)
+}
+
+// Size returns the number of bytes in the snapshot. Snapshots created by
+// versions of restic older than 0.17 do not record it, so it is counted with
+// "restic stats" instead.
+func (b *BackupAdapter) Size() (int64, error) {
+	ctx, cancel := context.WithTimeout(b.server.Context(), resticsvc.Seconds(config.Get().Restic.Timeouts.Snapshots))
+	defer cancel()
+	snap, err := b.find(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if size, ok := snap.Size(); ok {
+		return int64(size), nil
+	}
+	stats, err := b.client.Stats(ctx, resticcli.StatsRestoreSize, snap.ID)
+	if err != nil {
+		return 0, errors.Wrap(err, "restic: failed to get snapshot size: "+resticsvc.Output(err))
+	}
+	return int64(stats.TotalSize), nil
+}
+
+// Details returns the ID and size of the snapshot to the caller.
+func (b *BackupAdapter) Details(ctx context.Context, parts []remote.BackupPart) (*backup.ArchiveDetails, error) {
+	ctx, cancel := context.WithTimeout(ctx, resticsvc.Seconds(config.Get().Restic.Timeouts.Snapshots))
+	defer cancel()
+	snap, err := b.find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	size, err := b.Size()
+	if err != nil {
+		return nil, err
+	}
+	return &backup.ArchiveDetails{Checksum: snap.ID, ChecksumType: "sha256", Size: size, Parts: parts}, nil
+}
+
+// Remove forgets the snapshot of the backup and prunes the data that is no
+// longer referenced, through the job queue. Locked snapshots are never removed.
+func (b *BackupAdapter) Remove() error {
+	ctx, cancel := context.WithTimeout(b.server.Context(), resticsvc.Seconds(config.Get().Restic.Timeouts.Snapshots))
+	snap, err := b.find(ctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	if snap.Locked() {
+		return ErrSnapshotLocked
+	}
+
+	activity := resticsvc.NewActivity(b.server, "", "", server.ActivityResticDelete)
+	job := &resticsvc.Job{Type: resticsvc.JobPrune, Server: b.server.ID(), Priority: resticsvc.PriorityManual, Snapshot: snap.ID, Client: b.client}
+	err = resticsvc.Jobs.Run(job, func(j *resticsvc.Job) error {
+		return resticsvc.RetryAfterStaleUnlock(b.client, func() error {
+			ctx, cancel := context.WithTimeout(j.Context(), resticsvc.Seconds(config.Get().Restic.Timeouts.Prune))
+			defer cancel()
+			out, err := b.client.Forget(ctx, snap.ID)
+			j.Output = string(out)
+			return err
+		})
+	})
+	meta := models.ActivityMeta{"snapshot_id": snap.ID, "backup_uuid": b.Identifier()}
+	if size, ok := snap.Size(); ok {
+		meta["bytes"] = size
+	}
+	activity.Save(err, meta)
+	if err != nil {
+		return errors.Wrap(err, "restic: failed to remove snapshot: "+resticsvc.Output(err))
+	}
+	b.snapshot = nil
+	return nil
+}
+
+// Restore streams the server data directory out of the snapshot as a tar archive
+// and calls the callback for every file in it, through the job queue.
+func (b *BackupAdapter) Restore(ctx context.Context, _ io.Reader, callback backup.RestoreCallback) error {
+	lookup, cancel := context.WithTimeout(ctx, resticsvc.Seconds(config.Get().Restic.Timeouts.Snapshots))
+	snap, err := b.find(lookup)
+	cancel()
+	if err != nil {
+		return err
+	}
+	// Restore the directory that the snapshot was taken of, which is not the
+	// current data directory if the server has been moved since. Imported
+	// snapshots record the staging directory they were taken from, but have the
+	// layout of the data directory.
+	dir := resticsvc.VolumePath(b.server.ID())
+	if len(snap.Paths) == 1 && !snap.Imported() {
+		dir = snap.Paths[0]
+	}
+
+	activity := resticsvc.NewActivity(b.server, "", "", server.ActivityResticRestore)
+	job := &resticsvc.Job{Type: resticsvc.JobRestore, Server: b.server.ID(), Priority: resticsvc.PriorityManual, Snapshot: snap.ID, Client: b.client}
+	err = resticsvc.Jobs.Run(job, func(j *resticsvc.Job) error {
+		ctx, cancel := jobContext(ctx, j)
+		defer cancel()
+		ctx, cancelTimeout := context.WithTimeout(ctx, resticsvc.Seconds(config.Get().Restic.Timeouts.Restore))
+		defer cancelTimeout()
+
+		pr, pw := io.Pipe()
+		dumped := make(chan error, 1)
+		go func() {
+			err := b.client.DumpArchive(ctx, snap.ID, dir, pw)
+			_ = pw.CloseWithError(err)
+			dumped <- err
+		}()
+
+		var r io.Reader = pr
+		// Apply the same write limit that is used when restoring local backups.
+		if writeLimit := int64(config.Get().System.Backups.WriteLimit * 1024 * 1024); writeLimit > 0 {
+			r = ratelimit.Reader(pr, ratelimit.NewBucketWithRate(float64(writeLimit), writeLimit))
+		}
+		err := restoreArchive(r, callback)
+		if err == nil {
+			// Restic may pad the archive after the end marker, which must still be
+			// read for the dump to succeed.
+			_, err = io.Copy(io.Discard, pr)
+		}
+		if err != nil {
+			cancel()
+		}
+		_ = pr.CloseWithError(err)
+		derr := <-dumped
+		if err != nil {
+			return err
+		}
+		return derr
+	})
+	activity.Save(err, models.ActivityMeta{"snapshot_id": snap.ID, "backup_uuid": b.Identifier()})
+	if err != nil {
+		return errors.Wrap(err, "restic: restore failed: "+resticsvc.Output(err))
+	}
+	return nil
+}
+
+// restoreArchive calls the callback for every regular file in a tar archive
+// written by "restic dump".
+func restoreArchive(r io.Reader, callback backup.RestoreCallback) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		if name == "" {
+			continue
+		}
+		if err := callback(name, hdr.FileInfo(), io.NopCloser(tr)); err != nil {
+			return err
+		}
+	}
+}
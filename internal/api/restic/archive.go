@@ -3,6 +3,7 @@ package restic
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
 	"io"
 	"net/http"
 	"os"
@@ -11,12 +12,12 @@ import (
 	"sort"
 	"time"
 
+	"github.com/apex/log"
 	"github.com/gin-gonic/gin"
-)
 
-// Archived repos are created when a server is deleted: the repo folder is moved into this directory.
-// This API is intended for panel-admin tooling (browse/download/delete).
-const resticArchiveBaseDir = "/var/lib/pterodactyl/restic/archive"
+	resticcli "github.com/pterodactyl/wings/internal/restic"
+	"github.com/pterodactyl/wings/internal/resticsvc"
+)
 
 var archiveIdRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+@-]{0,254}$`)
 
@@ -30,7 +31,7 @@ func safeArchivePath(id string) (string, bool) {
 	if !archiveIdRe.MatchString(id) {
 		return "", false
 	}
-	base := filepath.Clean(resticArchiveBaseDir)
+	base := resticsvc.ArchiveDir()
 	target := filepath.Clean(filepath.Join(base, id))
 
 	rel, err := filepath.Rel(base, target)
@@ -94,9 +95,10 @@ func dirSizeBytes(root string) (int64, error) {
 	return total, err
 }
 
-// ListArchivedRepos returns archived repo folder names in resticArchiveBaseDir.
+// ListArchivedRepos returns archived repo folder names in the configured archive directory.
+// Remote repositories of deleted servers are left on their backend and are not listed.
 func ListArchivedRepos(c *gin.Context) {
-	entries, err := os.ReadDir(resticArchiveBaseDir)
+	entries, err := os.ReadDir(resticsvc.ArchiveDir())
 	if err != nil {
 		if os.IsNotExist(err) {
 			c.JSON(http.StatusOK, gin.H{"archives": []archiveItem{}})
@@ -159,6 +161,9 @@ func DeleteArchivedRepo(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete archive."})
 		return
 	}
+	if err := resticsvc.DeleteKey(target); err != nil {
+		log.WithFields(log.Fields{"archive": id, "error": err}).Warn("restic: failed to delete key of archived repo")
+	}
 	c.JSON(http.StatusOK, gin.H{"deleted": true})
 }
 
@@ -167,53 +172,37 @@ func writeTarGz(w io.Writer, dir string, baseName string) error {
 	defer gw.Close()
 	tw := tar.NewWriter(gw)
 	defer tw.Close()
+	return resticsvc.WriteTarDir(tw, dir, baseName)
+}
 
-	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		rel, err := filepath.Rel(dir, p)
-		if err != nil {
-			return err
-		}
-		if rel == "." {
-			return nil
-		}
-
-		name := filepath.ToSlash(filepath.Join(baseName, rel))
-
-		link := ""
-		if info.Mode()&os.ModeSymlink != 0 {
-			// Best-effort: record the link target in the archive header.
-			if t, e := os.Readlink(p); e == nil {
-				link = t
+// ArchiveServerRepositories archives the repositories of a server that is being
+// deleted. Each repository is pruned down to its latest snapshot and then moved
+// out of the way by its backend: local repositories are moved to the archive
+// directory, while remote repositories stay where they are.
+func ArchiveServerRepositories(serverId string) {
+	if serverId == "" {
+		return
+	}
+	backend := resticsvc.ServerBackend(serverId)
+	for _, name := range backend.Dirs(serverId) {
+		repo := backend.Location(name)
+		if key := readResticKeyFromRepo(repo); key != "" {
+			if out, err := resticsvc.NewClient(repo, key).ApplyPolicy(context.Background(), resticcli.Policy{KeepLast: 1}); err != nil {
+				log.WithFields(log.Fields{"repo": repo, "error": err, "output": string(out)}).Warn("failed to prune restic repo to last snapshot before archive")
 			}
+		} else {
+			log.WithField("repo", repo).Warn("restic key missing; archiving full repo without pruning")
 		}
 
-		hdr, err := tar.FileInfoHeader(info, link)
+		to, err := backend.Archive(repo)
 		if err != nil {
-			return err
+			log.WithFields(log.Fields{"repo": repo, "error": err}).Warn("failed to archive restic repo")
+			continue
 		}
-		hdr.Name = name
-		if info.IsDir() {
-			hdr.Name += "/"
+		if err := resticsvc.MoveKey(repo, to); err != nil {
+			log.WithFields(log.Fields{"from": repo, "to": to, "error": err}).Warn("failed to move restic key of archived repo")
 		}
-
-		if err := tw.WriteHeader(hdr); err != nil {
-			return err
-		}
-		if info.Mode().IsRegular() {
-			f, err := os.Open(p)
-			if err != nil {
-				return err
-			}
-			defer f.Close()
-			if _, err := io.Copy(tw, f); err != nil {
-				return err
-			}
-		}
-		return nil
-	})
+	}
 }
 
 // DownloadArchivedRepo streams a tar.gz of an archived repo folder.
@@ -241,4 +230,3 @@ func DownloadArchivedRepo(c *gin.Context) {
 	c.Status(http.StatusOK)
 	_ = writeTarGz(c.Writer, target, id)
 }
-
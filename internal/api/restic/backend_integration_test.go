@@ -0,0 +1,75 @@
+package restic
+
+import (
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/resticsvc"
+)
+
+// TestRemoteResticBackendIntegration runs restic against a real REST server. It
+// is skipped unless RESTIC_REST_URL is set to the location that repositories can
+// be created within, such as "rest:http://127.0.0.1:8000/", and restic is on the
+// PATH. The credentials of the server are read by restic from the environment,
+// as RESTIC_REST_USERNAME and RESTIC_REST_PASSWORD.
+func TestRemoteResticBackendIntegration(t *testing.T) {
+	url := os.Getenv("RESTIC_REST_URL")
+	if url == "" {
+		t.Skip("RESTIC_REST_URL is not set")
+	}
+	binary, err := exec.LookPath("restic")
+	if err != nil {
+		t.Skip("restic is not installed")
+	}
+
+	g := Goblin(t)
+	var f *fakeRestic
+	var base string
+	params := gin.Params{{Key: "server", Value: testServer}}
+
+	g.Describe("RemoteResticBackend against a REST server", func() {
+		g.BeforeEach(func() {
+			f = newFakeRestic(t)
+			base = strings.TrimRight(url, "/") + "/wings-" + uuid.NewString()
+			config.Update(func(c *config.Configuration) {
+				c.Restic.Binary = binary
+				c.Restic.Backend.URL = base
+			})
+			g.Assert(os.MkdirAll(filepath.Join(f.data, testServer), 0o755)).IsNil()
+			g.Assert(os.WriteFile(filepath.Join(f.data, testServer, "server.properties"), []byte("motd="), 0o644)).IsNil()
+		})
+
+		g.It("initializes, backs up, lists and unlocks a repository", func() {
+			s := f.newServer(testServer)
+			body := map[string]interface{}{"owner_username": testOwner, "encryption_key": testKey}
+			w := f.request(CreateServerResticBackup, http.MethodPost, "/", params, body, s)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			id, _ := decode(w)["snapshot_id"].(string)
+			g.Assert(id != "").IsTrue()
+
+			repo := base + "/" + testRepoDir
+			g.Assert(resticsvc.ServerBackend(testServer).Initialized(repo)).IsTrue()
+
+			f.header.Set(encryptionKeyHeader, testKey)
+			w = f.request(ListServerResticBackups, http.MethodGet, "/?owner_username="+testOwner, params, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			backups, _ := decode(w)["backups"].([]interface{})
+			g.Assert(len(backups)).Equal(1)
+			g.Assert(backups[0].(map[string]interface{})["id"]).Equal(id)
+
+			g.Assert(resticsvc.ServerBackend(testServer).HasLocks(repo)).IsFalse()
+			w = f.request(UnlockServerResticRepo, http.MethodPost, "/", params, body, s)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(decode(w)["unlocked"]).Equal(float64(1))
+		})
+	})
+}
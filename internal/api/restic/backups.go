@@ -2,34 +2,41 @@ package restic
 
 import (
     "context"
-    "encoding/json"
     "fmt"
-    "io/fs"
     "net/http"
-    "os"
     "os/exec"
-    "path/filepath"
     "sort"
     "strconv"
     "strings"
     "time"
 
+    "emperror.dev/errors"
+    "github.com/apex/log"
     "github.com/gin-gonic/gin"
     "github.com/gin-gonic/gin/binding"
+
+    "github.com/pterodactyl/wings/config"
+    "github.com/pterodactyl/wings/internal/models"
+    resticcli "github.com/pterodactyl/wings/internal/restic"
+    "github.com/pterodactyl/wings/internal/resticsvc"
+    "github.com/pterodactyl/wings/server"
 )
 
 // POST /api/servers/:server/backups/restic
 func CreateServerResticBackup(c *gin.Context) {
+    if refuseWhileTransferring(c) {
+        return
+    }
     serverId := c.Param("server")
     if serverId == "" {
         c.JSON(http.StatusBadRequest, gin.H{"error": "missing server id"})
         return
     }
 
-    if status, err := readBackupStatus(serverId); err == nil && status.Status == "running" {
+    if status, err := resticsvc.ReadBackupStatus(serverId); err == nil && status.Status == "running" {
         if status.StartedAt != "" {
             if started, err := time.Parse(time.RFC3339, status.StartedAt); err == nil {
-                if time.Since(started) <= 6*time.Hour {
+                if time.Since(started) <= resticsvc.StaleJobAfter() {
                     c.JSON(http.StatusConflict, gin.H{"error": "backup already running"})
                     return
                 }
@@ -38,7 +45,7 @@ func CreateServerResticBackup(c *gin.Context) {
                 if status.Message == "" {
                     status.Message = "Backup appears stale. Please retry."
                 }
-                writeBackupStatus(serverId, status)
+                resticsvc.WriteBackupStatus(serverId, status)
             }
         } else {
             c.JSON(http.StatusConflict, gin.H{"error": "backup already running"})
@@ -46,7 +53,12 @@ func CreateServerResticBackup(c *gin.Context) {
         }
     }
 
-    var ownerUsername, encryptionKey string
+    encryptionKey, err := requestEncryptionKey(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    var ownerUsername string
     var maxBackups int
     var maxRepoBytes int64
     if v, ok := c.GetPostForm("owner_username"); ok && v != "" {
@@ -54,13 +66,11 @@ func CreateServerResticBackup(c *gin.Context) {
     } else {
         var body struct {
             OwnerUsername string `json:"owner_username"`
-            EncryptionKey string `json:"encryption_key"`
             MaxBackups    int    `json:"max_backups"`
             MaxRepoBytes  int64  `json:"max_repo_bytes"`
         }
-        if err := c.ShouldBindJSON(&body); err == nil {
+        if err := c.ShouldBindBodyWith(&body, binding.JSON); err == nil {
             ownerUsername = body.OwnerUsername
-            encryptionKey = body.EncryptionKey
             maxBackups = body.MaxBackups
             maxRepoBytes = body.MaxRepoBytes
         }
@@ -70,41 +80,32 @@ func CreateServerResticBackup(c *gin.Context) {
         return
     }
 
-    repoDir := resolveRepoDir(serverId, ownerUsername)
-    repo := fmt.Sprintf("/var/lib/pterodactyl/restic/%s", repoDir)
-    if err := os.MkdirAll(repo, 0755); err != nil {
+    repoDir := resticsvc.ResolveRepoDir(serverId, ownerUsername)
+    repo := resticsvc.RepoPath(repoDir)
+    if err := resticsvc.PrepareRepo(repo); err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create repo dir"})
         return
     }
-    resolvedKey, err := resolveResticKey(repo, encryptionKey)
+    resolvedKey, err := resticsvc.ResolveKey(repo, encryptionKey)
     if err != nil {
         c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
         return
     }
 
-    env := buildResticEnv(resolvedKey)
+    client := resticsvc.NewClient(repo, resolvedKey)
 
-    if _, err := exec.LookPath("restic"); err != nil {
+    if _, err := exec.LookPath(resticsvc.Binary()); err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "restic not found"})
         return
     }
 
-    // Init repo if needed
-    if _, err := os.Stat(repo + "/config"); os.IsNotExist(err) {
-        initCmd := exec.Command("restic", "-r", repo, "init")
-        initCmd.Env = env
-        if out, err := initCmd.CombinedOutput(); err != nil {
-            if _, statErr := os.Stat(repo + "/config"); statErr == nil || strings.Contains(string(out), "already initialized") || strings.Contains(string(out), "config already exists") {
-                // repo initialized concurrently; continue
-            } else {
-                c.JSON(http.StatusInternalServerError, gin.H{"error": "init failed", "output": string(out)})
-                return
-            }
-        }
+    if err := resticsvc.InitRepoIfMissing(client); err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "init failed", "output": resticsvc.Output(err)})
+        return
     }
 
     if maxRepoBytes > 0 {
-        if repoSize, err := getRepoSizeBytes(repo); err == nil {
+        if repoSize, err := resticsvc.RepoSizeBytes(repo); err == nil {
             if repoSize >= maxRepoBytes {
                 c.JSON(http.StatusBadRequest, gin.H{"error": "repo size limit reached"})
                 return
@@ -114,153 +115,103 @@ func CreateServerResticBackup(c *gin.Context) {
 
     // Prune oldest backup if maxBackups reached (keep locked snapshots)
     if maxBackups > 0 {
-        countCmd := exec.Command("restic", "-r", repo, "snapshots", "--json", "--no-lock")
-        countCmd.Env = env
-        countOut, countErr := countCmd.CombinedOutput()
-        if countErr == nil {
-            var snapshots []map[string]interface{}
-            if err := json.Unmarshal(countOut, &snapshots); err == nil {
-                if len(snapshots) >= maxBackups {
-                    // Build locked set (prefer tags in snapshot list; fallback to --tag when tags missing)
-                    lockedIDs := map[string]bool{}
-                    sawTags := false
-                    hasLockedTag := func(tags interface{}) bool {
-                        switch v := tags.(type) {
-                        case []interface{}:
-                            for _, t := range v {
-                                if s, ok := t.(string); ok && s == "locked" {
-                                    return true
-                                }
-                            }
-                        case []string:
-                            for _, s := range v {
-                                if s == "locked" {
-                                    return true
-                                }
-                            }
-                        }
-                        return false
-                    }
-                    for _, snap := range snapshots {
-                        if tags, ok := snap["tags"]; ok {
-                            sawTags = true
-                            if hasLockedTag(tags) {
-                                if id, ok := snap["id"].(string); ok && id != "" {
-                                    lockedIDs[id] = true
-                                    if len(id) >= 8 {
-                                        lockedIDs[id[:8]] = true
-                                    }
-                                }
-                                if shortID, ok := snap["short_id"].(string); ok && shortID != "" {
-                                    lockedIDs[shortID] = true
-                                }
-                            }
-                        }
-                    }
-                    if !sawTags {
-                        lockCmd := exec.Command("restic", "-r", repo, "snapshots", "--json", "--tag", "locked", "--no-lock")
-                        lockCmd.Env = env
-                        if lockOut, lockErr := lockCmd.CombinedOutput(); lockErr == nil {
-                            var lockedSnapshots []map[string]interface{}
-                            if err := json.Unmarshal(lockOut, &lockedSnapshots); err == nil {
-                                for _, snap := range lockedSnapshots {
-                                    if id, ok := snap["id"].(string); ok && id != "" {
-                                        lockedIDs[id] = true
-                                        if len(id) >= 8 {
-                                            lockedIDs[id[:8]] = true
-                                        }
-                                    }
-                                    if shortID, ok := snap["short_id"].(string); ok && shortID != "" {
-                                        lockedIDs[shortID] = true
-                                    }
-                                }
-                            }
-                        }
-                    }
-
-                    type snapItem struct {
-                        ID   string
-                        Time time.Time
-                    }
+        ctx, cancel := context.WithTimeout(context.Background(), resticsvc.Seconds(config.Get().Restic.Timeouts.Snapshots))
+        snapshots, listErr := client.Snapshots(ctx, 0)
+        cancel()
+        if listErr == nil && len(snapshots) >= maxBackups {
+            unlocked := make([]resticcli.Snapshot, 0, len(snapshots))
+            for _, snap := range snapshots {
+                if snap.ID == "" || snap.Locked() || isProtectedPreRestore(snap) {
+                    continue
+                }
+                unlocked = append(unlocked, snap)
+            }
 
-                    parseTime := func(val interface{}) time.Time {
-                        s, _ := val.(string)
-                        if s == "" {
-                            return time.Time{}
-                        }
-                        if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
-                            return t
-                        }
-                        if t, err := time.Parse(time.RFC3339, s); err == nil {
-                            return t
-                        }
-                        return time.Time{}
-                    }
+            sort.Slice(unlocked, func(i, j int) bool {
+                return unlocked[i].Time.Before(unlocked[j].Time)
+            })
 
-                    unlocked := make([]snapItem, 0, len(snapshots))
-                    for _, snap := range snapshots {
-                        id, _ := snap["id"].(string)
-                        shortID, _ := snap["short_id"].(string)
-                        if id != "" && len(id) >= 8 && shortID == "" {
-                            shortID = id[:8]
-                        }
-                        if (id != "" && lockedIDs[id]) || (shortID != "" && lockedIDs[shortID]) {
-                            continue
-                        }
-                        if id == "" {
-                            continue
-                        }
-                        unlocked = append(unlocked, snapItem{ID: id, Time: parseTime(snap["time"])})
-                    }
+            if len(unlocked) == 0 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "backup limit reached and all snapshots are locked"})
+                return
+            }
 
-                    sort.Slice(unlocked, func(i, j int) bool {
-                        return unlocked[i].Time.Before(unlocked[j].Time)
-                    })
+            toDelete := len(snapshots) - maxBackups + 1
+            if toDelete < 1 {
+                toDelete = 1
+            }
 
-                    if len(unlocked) == 0 {
-                        c.JSON(http.StatusBadRequest, gin.H{"error": "backup limit reached and all snapshots are locked"})
+            for i := 0; i < toDelete && i < len(unlocked); i++ {
+                ctx, cancel := context.WithTimeout(context.Background(), resticsvc.Seconds(config.Get().Restic.Timeouts.Prune))
+                _, err := client.Forget(ctx, unlocked[i].ID)
+                cancel()
+                if err != nil {
+                    if resticcli.IsLocked(err) {
+                        resticsvc.SetBackupStatus(serverId, "failed", "Repository is busy. Please try again later.")
+                        c.JSON(http.StatusConflict, gin.H{"error": "repo busy"})
                         return
                     }
-
-                    toDelete := len(snapshots) - maxBackups + 1
-                    if toDelete < 1 {
-                        toDelete = 1
-                    }
-
-                    for i := 0; i < toDelete && i < len(unlocked); i++ {
-                        pruneCmd := exec.Command("restic", "-r", repo, "forget", unlocked[i].ID, "--prune")
-                        pruneCmd.Env = env
-                        if out, err := pruneCmd.CombinedOutput(); err != nil {
-                            if isRepoLockedError(string(out)) {
-                                setBackupStatus(serverId, "failed", "Repository is busy. Please try again later.")
-                                c.JSON(http.StatusConflict, gin.H{"error": "repo busy"})
-                                return
-                            }
-                            c.JSON(http.StatusInternalServerError, gin.H{"error": "prune failed"})
-                            return
-                        }
-                    }
+                    c.JSON(http.StatusInternalServerError, gin.H{"error": "prune failed"})
+                    return
                 }
             }
         }
     }
 
-    volumePath := fmt.Sprintf("/var/lib/pterodactyl/volumes/%s", serverId)
+    s := c.MustGet("server").(*server.Server)
+    volumePath := resticsvc.VolumePath(serverId)
+    opts := resticcli.BackupOptions{
+        Paths:    []string{volumePath},
+        Excludes: resticsvc.BackupExcludes(s, volumePath),
+        OnStatus: resticsvc.BackupProgress(s),
+    }
     asyncParam := strings.ToLower(strings.TrimSpace(c.Query("async")))
     async := asyncParam == "1" || asyncParam == "true" || asyncParam == "yes"
 
-    setBackupStatus(serverId, "running", "")
+    activity := newResticActivity(c, server.ActivityResticBackup)
+    run := func(ctx context.Context) (*resticcli.BackupSummary, error) {
+        summary, err := resticsvc.RunBackupWithRecovery(ctx, client, opts, resolvedKey, serverId)
+        meta := models.ActivityMeta{}
+        if summary != nil {
+            meta["snapshot_id"] = summary.SnapshotID
+            meta["bytes"] = summary.TotalBytesProcessed
+        }
+        activity.Save(err, meta)
+        return summary, err
+    }
+
+    resticsvc.SetBackupStatus(serverId, "running", "")
 
+    var summary *resticcli.BackupSummary
+    job := newJob(c, resticsvc.JobBackup, serverId, "")
+    job.Client = client
+    resticsvc.Jobs.Submit(job, func(j *resticsvc.Job) error {
+        // Reset the start time now that the backup has a worker, since it may have
+        // been waiting in the queue for a while.
+        resticsvc.SetBackupStatus(serverId, "running", "")
+        summary, err = run(j.Context())
+        if summary != nil {
+            j.Snapshot = summary.SnapshotID
+            j.Bytes = summary.TotalBytesProcessed
+        }
+        if err == nil {
+            resticsvc.Replicate(s, client, resolvedKey, j.Priority, j.Actor, j.IP)
+        }
+        return err
+    })
     if async {
-        go runBackupWithRecovery(repo, env, volumePath, resolvedKey, serverId)
-        c.JSON(http.StatusAccepted, gin.H{"message": "backup started"})
+        c.JSON(http.StatusAccepted, gin.H{"message": "backup started", "job_id": job.ID, "queue_position": resticsvc.Jobs.Position(resticsvc.JobBackup, serverId, "")})
         return
     }
 
-    out, err := runBackupWithRecovery(repo, env, volumePath, resolvedKey, serverId)
+    <-job.Done()
+    if job.Cancelled() {
+        c.JSON(http.StatusConflict, gin.H{"error": "backup cancelled"})
+        return
+    }
     if err != nil {
-        if isRepoLockedError(out) {
-            setBackupStatus(serverId, "failed", "Repository is busy. Please try again later.")
+        if resticcli.IsLocked(err) {
+            resticsvc.SetBackupStatus(serverId, "failed", "Repository is busy. Please try again later.")
             c.JSON(http.StatusConflict, gin.H{"error": "repo busy"})
             return
         }
@@ -268,7 +219,7 @@ func CreateServerResticBackup(c *gin.Context) {
         return
     }
 
-    c.JSON(http.StatusOK, gin.H{"message": "backup created", "output": out})
+    c.JSON(http.StatusOK, gin.H{"message": "backup created", "snapshot_id": summary.SnapshotID, "summary": summary})
 }
 
 // GET /api/servers/:server/backups/restic
@@ -279,31 +230,26 @@ func ListServerResticBackups(c *gin.Context) {
         return
     }
 
-    var ownerUsername, encryptionKey string
-    if v, ok := c.GetQuery("owner_username"); ok && v != "" {
-        ownerUsername = v
-    } else {
-        ownerUsername = c.Query("owner_username")
-    }
-    if v, ok := c.GetQuery("encryption_key"); ok && v != "" {
-        encryptionKey = v
-    } else {
-        encryptionKey = c.Query("encryption_key")
+    encryptionKey, err := requestEncryptionKey(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
     }
+    ownerUsername := c.Query("owner_username")
     if encryptionKey == "" {
         c.JSON(http.StatusBadRequest, gin.H{"error": "missing encryption key"})
         return
     }
 
-    repoDir := resolveRepoDir(serverId, ownerUsername)
-    repo := fmt.Sprintf("/var/lib/pterodactyl/restic/%s", repoDir)
-    resolvedKey, err := resolveResticKey(repo, encryptionKey)
+    repoDir := resticsvc.ResolveRepoDir(serverId, ownerUsername)
+    repo := resticsvc.RepoPath(repoDir)
+    resolvedKey, err := resticsvc.ResolveKey(repo, encryptionKey)
     if err != nil {
         c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
         return
     }
 
-    env := buildResticEnv(resolvedKey)
+    client := resticsvc.NewClient(repo, resolvedKey)
 
     // Pagination + filtering
     limit := 25
@@ -326,125 +272,42 @@ func ListServerResticBackups(c *gin.Context) {
     }
 
     // List snapshots (fast path when no filters/cursor)
-    args := []string{"-r", repo, "snapshots", "--json", "--no-lock"}
+    latest := 0
     totalUnknown := false
     if sinceStr == "" && untilStr == "" && cursorStr == "" && limit > 0 {
-        args = append(args, "--latest", strconv.Itoa(limit))
+        latest = limit
         totalUnknown = true
     }
-    ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+    ctx, cancel := context.WithTimeout(context.Background(), resticsvc.Seconds(config.Get().Restic.Timeouts.Snapshots))
     defer cancel()
-    cmd := exec.CommandContext(ctx, "restic", args...)
-    cmd.Env = env
-    out, err := cmd.CombinedOutput()
-    if ctx.Err() == context.DeadlineExceeded {
+    snapshots, err := client.Snapshots(ctx, latest)
+    if resticcli.IsTimeout(err) {
         c.JSON(http.StatusGatewayTimeout, gin.H{"error": "snapshot listing timed out"})
         return
     }
     if err != nil {
         // If repo missing/uninitialized, initialize and return empty list
-        if _, statErr := os.Stat(repo + "/config"); os.IsNotExist(statErr) {
-            if _, pathErr := exec.LookPath("restic"); pathErr != nil {
+        if !resticsvc.RepoInitialized(repo) {
+            if _, pathErr := exec.LookPath(resticsvc.Binary()); pathErr != nil {
                 c.JSON(http.StatusInternalServerError, gin.H{"error": "restic not found"})
                 return
             }
-            initCmd := exec.Command("restic", "-r", repo, "init")
-            initCmd.Env = env
-            if initOut, initErr := initCmd.CombinedOutput(); initErr == nil {
-                c.JSON(http.StatusOK, gin.H{
-                    "backups":     []map[string]interface{}{},
-                    "next_cursor": "",
-                    "limit":       0,
-                    "total":       0,
-                })
-                return
-            } else {
-                c.JSON(http.StatusInternalServerError, gin.H{"error": "init failed", "output": string(initOut)})
+            if initErr := client.Init(context.Background()); initErr != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "init failed", "output": resticsvc.Output(initErr)})
                 return
             }
+            c.JSON(http.StatusOK, gin.H{
+                "backups":     []snapshotListItem{},
+                "next_cursor": "",
+                "limit":       0,
+                "total":       0,
+            })
+            return
         }
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list backups", "output": string(out)})
-        return
-    }
-
-    // Parse JSON output
-    var snapshots []map[string]interface{}
-    if err := json.Unmarshal(out, &snapshots); err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to parse restic output", "output": string(out)})
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list backups", "output": resticsvc.Output(err)})
         return
     }
 
-    // Detect locked snapshots by tags in list (fallback to --tag when tags missing)
-    lockedIDs := map[string]bool{}
-    sawTags := false
-    hasLockedTag := func(tags interface{}) bool {
-        switch v := tags.(type) {
-        case []interface{}:
-            for _, t := range v {
-                if s, ok := t.(string); ok && s == "locked" {
-                    return true
-                }
-            }
-        case []string:
-            for _, s := range v {
-                if s == "locked" {
-                    return true
-                }
-            }
-        }
-        return false
-    }
-    for _, snap := range snapshots {
-        if tags, ok := snap["tags"]; ok {
-            sawTags = true
-            if hasLockedTag(tags) {
-                if id, ok := snap["id"].(string); ok && id != "" {
-                    lockedIDs[id] = true
-                    if len(id) >= 8 {
-                        lockedIDs[id[:8]] = true
-                    }
-                }
-                if shortID, ok := snap["short_id"].(string); ok && shortID != "" {
-                    lockedIDs[shortID] = true
-                }
-            }
-        }
-    }
-    if !sawTags {
-        lockCmd := exec.Command("restic", "-r", repo, "snapshots", "--json", "--tag", "locked", "--no-lock")
-        lockCmd.Env = env
-        if lockOut, lockErr := lockCmd.CombinedOutput(); lockErr == nil {
-            var lockedSnapshots []map[string]interface{}
-            if err := json.Unmarshal(lockOut, &lockedSnapshots); err == nil {
-                for _, snap := range lockedSnapshots {
-                    if id, ok := snap["id"].(string); ok && id != "" {
-                        lockedIDs[id] = true
-                        if len(id) >= 8 {
-                            lockedIDs[id[:8]] = true
-                        }
-                    }
-                    if shortID, ok := snap["short_id"].(string); ok && shortID != "" {
-                        lockedIDs[shortID] = true
-                    }
-                }
-            }
-        }
-    }
-
-    parseSnapshotTime := func(val interface{}) time.Time {
-        s, _ := val.(string)
-        if s == "" {
-            return time.Time{}
-        }
-        if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
-            return t
-        }
-        if t, err := time.Parse(time.RFC3339, s); err == nil {
-            return t
-        }
-        return time.Time{}
-    }
-
     var sinceTime time.Time
     var untilTime time.Time
     var sinceOk bool
@@ -486,138 +349,37 @@ func ListServerResticBackups(c *gin.Context) {
         }
     }
 
-    type snapshotItem struct {
-        Raw  map[string]interface{}
-        Time time.Time
-    }
-
-    items := make([]snapshotItem, 0, len(snapshots))
-    for _, snap := range snapshots {
-        id, _ := snap["id"].(string)
-        shortID, _ := snap["short_id"].(string)
-        if id != "" && len(id) >= 8 && shortID == "" {
-            shortID = id[:8]
-        }
-
-        isLocked := false
-        if sawTags {
-            if tags, ok := snap["tags"]; ok {
-                isLocked = hasLockedTag(tags)
-            }
-        } else {
-            isLocked = (id != "" && lockedIDs[id]) || (shortID != "" && lockedIDs[shortID])
-        }
-        if isLocked {
-            if tags, ok := snap["tags"].([]interface{}); ok {
-                hasLocked := false
-                for _, t := range tags {
-                    if s, ok := t.(string); ok && s == "locked" {
-                        hasLocked = true
-                        break
-                    }
-                }
-                if !hasLocked {
-                    snap["tags"] = append(tags, "locked")
-                }
-            } else {
-                snap["tags"] = []string{"locked"}
-            }
-        }
-        if isLocked {
-            snap["locked"] = true
-        } else {
-            snap["locked"] = false
-        }
-        items = append(items, snapshotItem{Raw: snap, Time: parseSnapshotTime(snap["time"])})
-    }
-
-    sort.Slice(items, func(i, j int) bool {
-        return items[i].Time.After(items[j].Time)
+    sort.Slice(snapshots, func(i, j int) bool {
+        return snapshots[i].Time.After(snapshots[j].Time)
     })
 
-    filteredAll := make([]snapshotItem, 0, len(items))
-    filtered := make([]snapshotItem, 0, len(items))
-    for _, item := range items {
-        if sinceOk && !item.Time.IsZero() && item.Time.Before(sinceTime) {
+    filteredAll := 0
+    filtered := make([]resticcli.Snapshot, 0, len(snapshots))
+    for _, snap := range snapshots {
+        if sinceOk && !snap.Time.IsZero() && snap.Time.Before(sinceTime) {
             continue
         }
-        if untilOk && !item.Time.IsZero() && item.Time.After(untilTime) {
+        if untilOk && !snap.Time.IsZero() && snap.Time.After(untilTime) {
             continue
         }
-        filteredAll = append(filteredAll, item)
-        if cursorOk && !item.Time.IsZero() && (item.Time.Equal(cursorTime) || item.Time.After(cursorTime)) {
+        filteredAll++
+        if cursorOk && !snap.Time.IsZero() && (snap.Time.Equal(cursorTime) || snap.Time.After(cursorTime)) {
             continue
         }
-        filtered = append(filtered, item)
+        filtered = append(filtered, snap)
     }
 
-    page := make([]map[string]interface{}, 0, limit)
-    for i, item := range filtered {
+    page := make([]snapshotListItem, 0, limit)
+    for i, snap := range filtered {
         if i >= limit {
             break
         }
-        page = append(page, item.Raw)
-    }
-
-    var extractNumber func(interface{}) (float64, bool)
-    extractNumber = func(val interface{}) (float64, bool) {
-        switch t := val.(type) {
-        case float64:
-            return t, true
-        case float32:
-            return float64(t), true
-        case int:
-            return float64(t), true
-        case int64:
-            return float64(t), true
-        case string:
-            if f, err := strconv.ParseFloat(strings.TrimSpace(t), 64); err == nil {
-                return f, true
-            }
-        case json.Number:
-            if f, err := t.Float64(); err == nil {
-                return f, true
-            }
-        case map[string]interface{}:
-            if v, ok := t["bytes"]; ok {
-                return extractNumber(v)
-            }
-        }
-        return 0, false
-    }
-
-    // Fill snapshot size for the current page when missing (best-effort)
-    if len(page) > 0 {
-        for _, snap := range page {
-            if _, ok := snap["size"]; ok {
-                continue
-            }
-            if summary, ok := snap["summary"].(map[string]interface{}); ok {
-                if v, ok := summary["total_bytes_processed"]; ok {
-                    if n, ok := extractNumber(v); ok {
-                        snap["size"] = n
-                        continue
-                    }
-                }
-                if v, ok := summary["total_bytes"]; ok {
-                    if n, ok := extractNumber(v); ok {
-                        snap["size"] = n
-                        continue
-                    }
-                }
-            }
-
-        }
+        page = append(page, newSnapshotListItem(snap))
     }
 
     var nextCursor string
     if len(filtered) > limit {
-        last := page[len(page)-1]
-        if last != nil {
-            if t, ok := last["time"].(string); ok {
-                nextCursor = t
-            }
-        }
+        nextCursor = page[len(page)-1].Time.Format(time.RFC3339Nano)
     }
 
     response := gin.H{
@@ -626,39 +388,32 @@ func ListServerResticBackups(c *gin.Context) {
         "limit":       limit,
     }
     if !totalUnknown {
-        response["total"] = len(filteredAll)
+        response["total"] = filteredAll
     } else if includeTotal {
         // Slow path: compute total count without changing fast page results
-        ctxCount, cancelCount := context.WithTimeout(context.Background(), 60*time.Second)
+        ctxCount, cancelCount := context.WithTimeout(context.Background(), resticsvc.Seconds(config.Get().Restic.Timeouts.Snapshots))
         defer cancelCount()
-        countCmd := exec.CommandContext(ctxCount, "restic", "-r", repo, "snapshots", "--json", "--no-lock")
-        countCmd.Env = env
-        if countOut, countErr := countCmd.CombinedOutput(); countErr == nil {
-            var allSnaps []map[string]interface{}
-            if err := json.Unmarshal(countOut, &allSnaps); err == nil {
-                response["total"] = len(allSnaps)
-            }
+        if allSnaps, countErr := client.Snapshots(ctxCount, 0); countErr == nil {
+            response["total"] = len(allSnaps)
         }
     }
     c.JSON(http.StatusOK, response)
 }
 
-func resolveResticKey(repo string, provided string) (string, error) {
-    if repo == "" {
-        return "", fmt.Errorf("missing repo")
-    }
-    keyPath := filepath.Join(repo, ".restic-key")
-    if data, err := os.ReadFile(keyPath); err == nil {
-        stored := strings.TrimSpace(string(data))
-        if stored != "" {
-            return stored, nil
-        }
-    }
-    if provided == "" {
-        return "", fmt.Errorf("missing encryption key")
+// snapshotListItem is a snapshot as returned by the listing endpoint, which
+// flattens the lock state and size of the snapshot for the Panel.
+type snapshotListItem struct {
+    resticcli.Snapshot
+    Locked bool    `json:"locked"`
+    Size   *uint64 `json:"size,omitempty"`
+}
+
+func newSnapshotListItem(snap resticcli.Snapshot) snapshotListItem {
+    item := snapshotListItem{Snapshot: snap, Locked: snap.Locked()}
+    if size, ok := snap.Size(); ok {
+        item.Size = &size
     }
-    _ = os.WriteFile(keyPath, []byte(provided+"\n"), 0600)
-    return provided, nil
+    return item
 }
 
 // GET /api/servers/:server/backups/restic/stats
@@ -669,132 +424,65 @@ func GetServerResticStats(c *gin.Context) {
         return
     }
 
-    var ownerUsername, encryptionKey string
-    if v, ok := c.GetQuery("owner_username"); ok && v != "" {
-        ownerUsername = v
-    } else {
-        ownerUsername = c.Query("owner_username")
-    }
-    if v, ok := c.GetQuery("encryption_key"); ok && v != "" {
-        encryptionKey = v
-    } else {
-        encryptionKey = c.Query("encryption_key")
+    encryptionKey, err := requestEncryptionKey(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
     }
+    ownerUsername := c.Query("owner_username")
     if encryptionKey == "" {
         c.JSON(http.StatusBadRequest, gin.H{"error": "missing encryption key"})
         return
     }
 
-    repoDir := resolveRepoDir(serverId, ownerUsername)
-    repo := fmt.Sprintf("/var/lib/pterodactyl/restic/%s", repoDir)
+    repoDir := resticsvc.ResolveRepoDir(serverId, ownerUsername)
+    repo := resticsvc.RepoPath(repoDir)
 
-    resolvedKey, err := resolveResticKey(repo, encryptionKey)
+    resolvedKey, err := resticsvc.ResolveKey(repo, encryptionKey)
     if err != nil {
         c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
         return
     }
 
-    env := buildResticEnv(resolvedKey)
+    client := resticsvc.NewClient(repo, resolvedKey)
 
-    if _, err := os.Stat(repo + "/config"); os.IsNotExist(err) {
-        if err := os.MkdirAll(repo, 0755); err != nil {
+    if !resticsvc.RepoInitialized(repo) {
+        if err := resticsvc.PrepareRepo(repo); err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create repo dir"})
             return
         }
-        initCmd := exec.Command("restic", "-r", repo, "init")
-        initCmd.Env = env
-        if out, err := initCmd.CombinedOutput(); err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "init failed", "output": string(out)})
+        if err := client.Init(context.Background()); err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "init failed", "output": resticsvc.Output(err)})
             return
         }
         c.JSON(http.StatusOK, gin.H{"total_size": 0})
         return
     }
 
-    runStats := func(mode string, timeout time.Duration) (map[string]interface{}, error) {
-        args := []string{"-r", repo, "stats", "--json", "--no-lock"}
-        if mode != "" {
-            args = append(args, "--mode", mode)
-        }
-        ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    runStats := func(mode string) (*resticcli.Stats, error) {
+        ctx, cancel := context.WithTimeout(context.Background(), resticsvc.Seconds(config.Get().Restic.Timeouts.Stats))
         defer cancel()
-        cmd := exec.CommandContext(ctx, "restic", args...)
-        cmd.Env = env
-        out, err := cmd.CombinedOutput()
-        if ctx.Err() == context.DeadlineExceeded {
-            return nil, fmt.Errorf("stats timed out")
-        }
-        if err != nil {
-            return nil, fmt.Errorf("%s", string(out))
-        }
-        var parsed map[string]interface{}
-        if err := json.Unmarshal(out, &parsed); err != nil {
-            return nil, fmt.Errorf("%s", string(out))
-        }
-        return parsed, nil
-    }
-
-    var extractNumber func(interface{}) (float64, bool)
-    extractNumber = func(val interface{}) (float64, bool) {
-        switch t := val.(type) {
-        case float64:
-            return t, true
-        case float32:
-            return float64(t), true
-        case int:
-            return float64(t), true
-        case int64:
-            return float64(t), true
-        case string:
-            if f, err := strconv.ParseFloat(strings.TrimSpace(t), 64); err == nil {
-                return f, true
-            }
-        case json.Number:
-            if f, err := t.Float64(); err == nil {
-                return f, true
-            }
-        case map[string]interface{}:
-            if v, ok := t["bytes"]; ok {
-                return extractNumber(v)
-            }
-        }
-        return 0, false
+        return client.Stats(ctx, mode)
     }
 
     response := gin.H{}
 
     // Restore-size (default) => total size if restoring all snapshots (includes duplicates)
-    if restoreStats, restoreErr := runStats("", 120*time.Second); restoreErr == nil {
-        if v, ok := restoreStats["total_size"]; ok {
-            if n, ok := extractNumber(v); ok {
-                response["total_uncompressed_size"] = n
-            }
-        }
-        if v, ok := restoreStats["snapshots_count"]; ok {
-            if n, ok := extractNumber(v); ok {
-                response["snapshots_count"] = n
-            }
-        }
+    if restoreStats, restoreErr := runStats(""); restoreErr == nil {
+        response["total_uncompressed_size"] = restoreStats.TotalSize
+        response["snapshots_count"] = restoreStats.SnapshotsCount
     } else {
         response["uncompressed_error"] = restoreErr.Error()
     }
 
     // Files-by-contents => file-level deduped size (no duplicates)
-    if fbcStats, fbcErr := runStats("files-by-contents", 120*time.Second); fbcErr == nil {
-        if v, ok := fbcStats["total_size"]; ok {
-            if n, ok := extractNumber(v); ok {
-                response["total_deduped_size"] = n
-            }
-        }
+    if fbcStats, fbcErr := runStats(resticcli.StatsFilesByContents); fbcErr == nil {
+        response["total_deduped_size"] = fbcStats.TotalSize
     }
 
     // Raw-data => on-disk size after compression/deduplication
-    if rawStats, rawErr := runStats("raw-data", 120*time.Second); rawErr == nil {
-        if v, ok := rawStats["total_size"]; ok {
-            if n, ok := extractNumber(v); ok {
-                response["total_compressed_size"] = n
-            }
-        }
+    if rawStats, rawErr := runStats(resticcli.StatsRawData); rawErr == nil {
+        response["total_compressed_size"] = rawStats.TotalSize
     }
 
     // Backwards-compatible fields
@@ -805,158 +493,40 @@ func GetServerResticStats(c *gin.Context) {
     c.JSON(http.StatusOK, response)
 }
 
-func resticRepoFromRequest(c *gin.Context) (string, []string, error) {
+func resticRepoFromRequest(c *gin.Context) (*resticcli.Client, error) {
     serverId := c.Param("server")
     if serverId == "" {
-        return "", nil, fmt.Errorf("missing server id")
+        return nil, fmt.Errorf("missing server id")
     }
 
-    var ownerUsername, encryptionKey string
+    encryptionKey, err := requestEncryptionKey(c)
+    if err != nil {
+        return nil, err
+    }
+    var ownerUsername string
     if v, ok := c.GetPostForm("owner_username"); ok && v != "" {
         ownerUsername = v
     }
-    if v, ok := c.GetPostForm("encryption_key"); ok && v != "" {
-        encryptionKey = v
-    }
 
-    if ownerUsername == "" || encryptionKey == "" {
+    if ownerUsername == "" {
         var body struct {
             OwnerUsername string `json:"owner_username"`
-            EncryptionKey string `json:"encryption_key"`
         }
         // Use ShouldBindBodyWith so handlers can safely bind the body again later (Gin caches the bytes).
         if err := c.ShouldBindBodyWith(&body, binding.JSON); err == nil {
-            if ownerUsername == "" {
-                ownerUsername = body.OwnerUsername
-            }
-            if encryptionKey == "" {
-                encryptionKey = body.EncryptionKey
-            }
+            ownerUsername = body.OwnerUsername
         }
     }
 
-    repoDir := resolveRepoDir(serverId, ownerUsername)
-    repo := fmt.Sprintf("/var/lib/pterodactyl/restic/%s", repoDir)
+    repoDir := resticsvc.ResolveRepoDir(serverId, ownerUsername)
+    repo := resticsvc.RepoPath(repoDir)
 
-    resolvedKey, err := resolveResticKey(repo, encryptionKey)
+    resolvedKey, err := resticsvc.ResolveKey(repo, encryptionKey)
     if err != nil {
-        return "", nil, err
+        return nil, err
     }
 
-    env := buildResticEnv(resolvedKey)
-    return repo, env, nil
-}
-
-func buildResticEnv(encryptionKey string) []string {
-    base := os.Environ()
-    filtered := make([]string, 0, len(base)+1)
-    for _, v := range base {
-        if strings.HasPrefix(v, "RESTIC_PASSWORD") {
-            continue
-        }
-        filtered = append(filtered, v)
-    }
-    filtered = append(filtered, "RESTIC_PASSWORD="+encryptionKey)
-    return filtered
-}
-
-func isKeyMismatchError(output string) bool {
-    lower := strings.ToLower(output)
-    return strings.Contains(lower, "ciphertext verification failed") ||
-        strings.Contains(lower, "wrong password") ||
-        strings.Contains(lower, "config or key")
-}
-
-func isRepoLockedError(output string) bool {
-    lower := strings.ToLower(output)
-    return strings.Contains(lower, "repository is already locked") ||
-        strings.Contains(lower, "unable to create lock")
-}
-
-func parseLockCreatedAt(output string) *time.Time {
-    marker := "lock was created at "
-    idx := strings.Index(output, marker)
-    if idx == -1 {
-        return nil
-    }
-    rest := output[idx+len(marker):]
-    end := strings.Index(rest, " (")
-    if end == -1 {
-        end = len(rest)
-    }
-    ts := strings.TrimSpace(rest[:end])
-    if ts == "" {
-        return nil
-    }
-    if t, err := time.Parse("2006-01-02 15:04:05", ts); err == nil {
-        return &t
-    }
-    return nil
-}
-
-func tryUnlockStaleLock(repo string, env []string, output string) bool {
-    createdAt := parseLockCreatedAt(output)
-    if createdAt == nil {
-        return false
-    }
-    if time.Since(*createdAt) < 30*time.Minute {
-        return false
-    }
-    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-    defer cancel()
-    unlockCmd := exec.CommandContext(ctx, "restic", "-r", repo, "unlock")
-    unlockCmd.Env = env
-    if _, err := unlockCmd.CombinedOutput(); err != nil {
-        return false
-    }
-    return true
-}
-
-func runBackupWithRecovery(repo string, env []string, volumePath string, encryptionKey string, serverId string) (string, error) {
-    cmd := exec.Command("restic", "-r", repo, "backup", volumePath)
-    cmd.Env = env
-    out, err := cmd.CombinedOutput()
-    if err == nil {
-        setBackupStatus(serverId, "completed", "")
-        return string(out), nil
-    }
-
-    if isRepoLockedError(string(out)) {
-        if tryUnlockStaleLock(repo, env, string(out)) {
-            retry := exec.Command("restic", "-r", repo, "backup", volumePath)
-            retry.Env = env
-            retryOut, retryErr := retry.CombinedOutput()
-            if retryErr == nil {
-                setBackupStatus(serverId, "completed", "")
-                return string(retryOut), nil
-            }
-            setBackupStatus(serverId, "failed", truncateStatusMessage(string(retryOut)))
-            return string(retryOut), retryErr
-        }
-    }
-
-    if isKeyMismatchError(string(out)) && isRecentRepo(repo, 2*time.Minute) && isSafeToReinitRepo(repo) && !repoHasLocks(repo) {
-        if reinitErr := reinitRepo(repo, encryptionKey); reinitErr == nil {
-            retry := exec.Command("restic", "-r", repo, "backup", volumePath)
-            retry.Env = env
-            retryOut, retryErr := retry.CombinedOutput()
-            if retryErr == nil {
-                setBackupStatus(serverId, "completed", "")
-                return string(retryOut), nil
-            }
-            setBackupStatus(serverId, "failed", truncateStatusMessage(string(retryOut)))
-            return string(retryOut), retryErr
-        }
-    }
-    setBackupStatus(serverId, "failed", truncateStatusMessage(string(out)))
-    return string(out), err
-}
-
-type resticBackupStatus struct {
-    Status     string `json:"status"`
-    StartedAt  string `json:"started_at,omitempty"`
-    FinishedAt string `json:"finished_at,omitempty"`
-    Message    string `json:"message,omitempty"`
+    return resticsvc.NewClient(repo, resolvedKey), nil
 }
 
 func GetServerResticBackupStatus(c *gin.Context) {
@@ -966,21 +536,22 @@ func GetServerResticBackupStatus(c *gin.Context) {
         return
     }
 
-    status, err := readBackupStatus(serverId)
+    status, err := resticsvc.ReadBackupStatus(serverId)
     if err != nil || status.Status == "" {
         c.JSON(http.StatusOK, gin.H{"status": "idle"})
         return
     }
 
-    if status.Status == "running" && status.StartedAt != "" {
+    status.QueuePosition = resticsvc.Jobs.Position(resticsvc.JobBackup, serverId, "")
+    if status.Status == "running" && status.QueuePosition == 0 && status.StartedAt != "" {
         if started, err := time.Parse(time.RFC3339, status.StartedAt); err == nil {
-            if time.Since(started) > 6*time.Hour {
+            if time.Since(started) > resticsvc.StaleJobAfter() {
                 status.Status = "failed"
                 status.FinishedAt = time.Now().Format(time.RFC3339)
                 if status.Message == "" {
                     status.Message = "Backup appears stale. Please retry."
                 }
-                writeBackupStatus(serverId, status)
+                resticsvc.WriteBackupStatus(serverId, status)
             }
         }
     }
@@ -988,271 +559,43 @@ func GetServerResticBackupStatus(c *gin.Context) {
     c.JSON(http.StatusOK, status)
 }
 
-func statusDir() string {
-    return "/var/lib/pterodactyl/restic/.status"
-}
-
-func statusPath(serverId string) string {
-    return filepath.Join(statusDir(), serverId+".json")
-}
-
-func readBackupStatus(serverId string) (resticBackupStatus, error) {
-    var status resticBackupStatus
-    data, err := os.ReadFile(statusPath(serverId))
-    if err != nil {
-        return status, err
-    }
-    if err := json.Unmarshal(data, &status); err != nil {
-        return resticBackupStatus{}, err
-    }
-    return status, nil
-}
-
-func writeBackupStatus(serverId string, status resticBackupStatus) {
-    if serverId == "" {
-        return
-    }
-    _ = os.MkdirAll(statusDir(), 0755)
-    data, err := json.Marshal(status)
-    if err != nil {
-        return
-    }
-    tmp := statusPath(serverId) + ".tmp"
-    if err := os.WriteFile(tmp, data, 0644); err == nil {
-        _ = os.Rename(tmp, statusPath(serverId))
-    }
-    cleanupStatusDir(statusDir(), 7*24*time.Hour)
-}
-
-
-func cleanupStatusDir(dir string, maxAge time.Duration) {
-    entries, err := os.ReadDir(dir)
-    if err != nil {
-        return
-    }
-    now := time.Now()
-    for _, entry := range entries {
-        info, err := entry.Info()
-        if err != nil {
-            continue
-        }
-        if now.Sub(info.ModTime()) > maxAge {
-            _ = os.Remove(filepath.Join(dir, entry.Name()))
-        }
-    }
-}
-
-func setBackupStatus(serverId string, status string, message string) {
-    if serverId == "" {
-        return
-    }
-    current, _ := readBackupStatus(serverId)
-    next := resticBackupStatus{Status: status}
-
-    if status == "running" {
-        // Always reset start time for a new run
-        next.StartedAt = time.Now().Format(time.RFC3339)
-        next.FinishedAt = ""
-        next.Message = ""
-    } else {
-        if current.StartedAt != "" {
-            next.StartedAt = current.StartedAt
-        }
-        if status == "completed" || status == "failed" {
-            next.FinishedAt = time.Now().Format(time.RFC3339)
-        }
-        if message != "" {
-            next.Message = message
-        } else if current.Message != "" {
-            next.Message = current.Message
-        }
-    }
-    writeBackupStatus(serverId, next)
-}
-
-func truncateStatusMessage(msg string) string {
-    const max = 2000
-    trimmed := strings.TrimSpace(msg)
-    if len(trimmed) <= max {
-        return trimmed
-    }
-    return trimmed[:max]
-}
-
-func truncateCommandOutput(msg string) string {
-    // Keep outputs readable in the panel/admin UI but prevent huge blobs from being returned.
-    const max = 20000
-    trimmed := strings.TrimSpace(msg)
-    if len(trimmed) <= max {
-        return trimmed
-    }
-    return trimmed[:max]
-}
-
-func isRecentRepo(repo string, window time.Duration) bool {
-    st, err := os.Stat(filepath.Join(repo, "config"))
-    if err != nil {
-        return false
-    }
-    return time.Since(st.ModTime()) <= window
-}
-
-func isSafeToReinitRepo(repo string) bool {
-    if repo == "" {
-        return false
-    }
-    size, err := getRepoSizeBytes(repo)
-    if err != nil {
-        return false
-    }
-    return size <= 1024*1024
-}
-
-func reinitRepo(repo string, encryptionKey string) error {
-    if repo == "" {
-        return fmt.Errorf("missing repo")
-    }
-    _ = os.RemoveAll(repo)
-    if err := os.MkdirAll(repo, 0755); err != nil {
-        return err
-    }
-    _, err := resolveResticKey(repo, encryptionKey)
-    if err != nil {
-        return err
-    }
-    env := buildResticEnv(encryptionKey)
-    initCmd := exec.Command("restic", "-r", repo, "init")
-    initCmd.Env = env
-    if _, err := initCmd.CombinedOutput(); err != nil {
-        return err
-    }
-    return nil
-}
-
-func getRepoSizeBytes(repo string) (int64, error) {
-    if repo == "" {
-        return 0, fmt.Errorf("missing repo")
-    }
-    cmd := exec.Command("du", "-sb", repo)
-    out, err := cmd.CombinedOutput()
-    if err != nil {
-        return 0, err
-    }
-    fields := strings.Fields(string(out))
-    if len(fields) == 0 {
-        return 0, fmt.Errorf("failed to read repo size")
-    }
-    size, err := strconv.ParseInt(fields[0], 10, 64)
-    if err != nil {
-        return 0, err
-    }
-    return size, nil
-}
-
-func resolveRepoDir(serverId string, ownerUsername string) string {
-    candidates := []string{}
-    if ownerUsername != "" {
-        candidates = append(candidates, fmt.Sprintf("%s+%s", serverId, ownerUsername))
-    }
-    candidates = append(candidates, serverId)
-
-    for _, dir := range candidates {
-        repo := fmt.Sprintf("/var/lib/pterodactyl/restic/%s", dir)
-        if repoExists(repo) {
-            return dir
-        }
-    }
-
-    base := "/var/lib/pterodactyl/restic"
-    if entries, err := os.ReadDir(base); err == nil {
-        for _, entry := range entries {
-            if !entry.IsDir() {
-                continue
-            }
-            name := entry.Name()
-            if strings.HasPrefix(name, serverId+"+") {
-                return name
-            }
-        }
-    }
-    if ownerUsername != "" {
-        return fmt.Sprintf("%s+%s", serverId, ownerUsername)
-    }
-    return serverId
-}
-
-func repoExists(repo string) bool {
-    if repo == "" {
-        return false
-    }
-    if _, err := os.Stat(filepath.Join(repo, "config")); err == nil {
-        return true
-    }
-    if info, err := os.Stat(repo); err == nil && info.IsDir() {
-        return true
-    }
-    return false
-}
-
-func resolveSnapshotID(repo string, env []string, backupId string) string {
+func resolveSnapshotID(client *resticcli.Client, backupId string) string {
     if backupId == "" {
         return ""
     }
-    listCmd := exec.Command("restic", "-r", repo, "snapshots", "--json")
-    listCmd.Env = env
-    out, err := listCmd.CombinedOutput()
-    if err != nil {
-        return backupId
-    }
-    var snapshots []map[string]interface{}
-    if err := json.Unmarshal(out, &snapshots); err != nil {
-        return backupId
-    }
-    for _, snap := range snapshots {
-        if id, ok := snap["id"].(string); ok && id != "" {
-            if id == backupId || (len(id) >= 8 && id[:8] == backupId) {
-                return id
-            }
-        }
-        if shortID, ok := snap["short_id"].(string); ok && shortID != "" && shortID == backupId {
-            if id, ok := snap["id"].(string); ok && id != "" {
-                return id
-            }
-        }
+    ctx, cancel := context.WithTimeout(context.Background(), resticsvc.Seconds(config.Get().Restic.Timeouts.Snapshots))
+    defer cancel()
+    snap, err := client.FindSnapshot(ctx, backupId)
+    if err != nil || snap == nil {
+        return backupId
     }
-    return backupId
+    return snap.ID
 }
 
 // POST /api/servers/:server/backups/restic/:backupId/lock
 func LockServerResticBackup(c *gin.Context) {
+    if refuseWhileTransferring(c) {
+        return
+    }
     backupId := c.Param("backupId")
     if backupId == "" {
         c.JSON(http.StatusBadRequest, gin.H{"error": "missing backup id"})
         return
     }
 
-    repo, env, err := resticRepoFromRequest(c)
+    client, err := resticRepoFromRequest(c)
     if err != nil {
         c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
         return
     }
 
-    resolvedId := resolveSnapshotID(repo, env, backupId)
-    tagCmd := exec.Command("restic", "-r", repo, "tag", "--add", "locked", resolvedId)
-    tagCmd.Env = env
-    out, err := tagCmd.CombinedOutput()
+    activity := newResticActivity(c, server.ActivityResticLock)
+    resolvedId := resolveSnapshotID(client, backupId)
+    err = resticsvc.RetryAfterStaleUnlock(client, func() error {
+        return client.AddTags(context.Background(), resolvedId, resticcli.LockedTag)
+    })
+    activity.Save(err, models.ActivityMeta{"snapshot_id": resolvedId})
     if err != nil {
-        if isRepoLockedError(string(out)) && tryUnlockStaleLock(repo, env, string(out)) {
-            retry := exec.Command("restic", "-r", repo, "tag", "--add", "locked", resolvedId)
-            retry.Env = env
-            if retryOut, retryErr := retry.CombinedOutput(); retryErr == nil {
-                c.JSON(http.StatusOK, gin.H{"message": "locked", "locked": true})
-                return
-            } else {
-                out = retryOut
-                err = retryErr
-            }
-        }
         c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to lock backup"})
         return
     }
@@ -1262,34 +605,28 @@ func LockServerResticBackup(c *gin.Context) {
 
 // POST /api/servers/:server/backups/restic/:backupId/unlock
 func UnlockServerResticBackup(c *gin.Context) {
+    if refuseWhileTransferring(c) {
+        return
+    }
     backupId := c.Param("backupId")
     if backupId == "" {
         c.JSON(http.StatusBadRequest, gin.H{"error": "missing backup id"})
         return
     }
 
-    repo, env, err := resticRepoFromRequest(c)
+    client, err := resticRepoFromRequest(c)
     if err != nil {
         c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
         return
     }
 
-    resolvedId := resolveSnapshotID(repo, env, backupId)
-    tagCmd := exec.Command("restic", "-r", repo, "tag", "--remove", "locked", resolvedId)
-    tagCmd.Env = env
-    out, err := tagCmd.CombinedOutput()
+    activity := newResticActivity(c, server.ActivityResticUnlock)
+    resolvedId := resolveSnapshotID(client, backupId)
+    err = resticsvc.RetryAfterStaleUnlock(client, func() error {
+        return client.RemoveTags(context.Background(), resolvedId, resticcli.LockedTag)
+    })
+    activity.Save(err, models.ActivityMeta{"snapshot_id": resolvedId})
     if err != nil {
-        if isRepoLockedError(string(out)) && tryUnlockStaleLock(repo, env, string(out)) {
-            retry := exec.Command("restic", "-r", repo, "tag", "--remove", "locked", resolvedId)
-            retry.Env = env
-            if retryOut, retryErr := retry.CombinedOutput(); retryErr == nil {
-                c.JSON(http.StatusOK, gin.H{"message": "unlocked", "locked": false})
-                return
-            } else {
-                out = retryOut
-                err = retryErr
-            }
-        }
         c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unlock backup"})
         return
     }
@@ -1299,130 +636,57 @@ func UnlockServerResticBackup(c *gin.Context) {
 
 // DELETE /api/servers/:server/backups/restic/:backupId
 func DeleteServerResticBackup(c *gin.Context) {
+    if refuseWhileTransferring(c) {
+        return
+    }
     backupId := c.Param("backupId")
     if backupId == "" {
         c.JSON(http.StatusBadRequest, gin.H{"error": "missing backup id"})
         return
     }
 
-    repo, env, err := resticRepoFromRequest(c)
+    client, err := resticRepoFromRequest(c)
     if err != nil {
         c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
         return
     }
 
-    resolvedId := resolveSnapshotID(repo, env, backupId)
+    resolvedId := resolveSnapshotID(client, backupId)
     if resolvedId == "" {
         c.JSON(http.StatusBadRequest, gin.H{"error": "invalid backup id"})
         return
     }
 
-    isLocked := func() (bool, error) {
-        listCmd := exec.Command("restic", "-r", repo, "snapshots", "--json", "--no-lock")
-        listCmd.Env = env
-        out, err := listCmd.CombinedOutput()
-        if err != nil {
-            return false, err
-        }
-        var snapshots []map[string]interface{}
-        if err := json.Unmarshal(out, &snapshots); err != nil {
-            return false, err
-        }
-
-        hasLockedTag := func(tags interface{}) bool {
-            switch v := tags.(type) {
-            case []interface{}:
-                for _, t := range v {
-                    if s, ok := t.(string); ok && s == "locked" {
-                        return true
-                    }
-                }
-            case []string:
-                for _, s := range v {
-                    if s == "locked" {
-                        return true
-                    }
-                }
-            }
-            return false
-        }
-
-        sawTags := false
-        for _, snap := range snapshots {
-            id, _ := snap["id"].(string)
-            shortID, _ := snap["short_id"].(string)
-            match := id == resolvedId || (shortID != "" && shortID == resolvedId) || (len(id) >= 8 && id[:8] == resolvedId)
-            if !match {
-                continue
-            }
-            if tags, ok := snap["tags"]; ok {
-                sawTags = true
-                if hasLockedTag(tags) {
-                    return true, nil
-                }
-            }
-            if sawTags {
-                return false, nil
-            }
-        }
-
-        // Fallback when tags are missing: query locked snapshots
-        lockCmd := exec.Command("restic", "-r", repo, "snapshots", "--json", "--tag", "locked", "--no-lock")
-        lockCmd.Env = env
-        lockOut, lockErr := lockCmd.CombinedOutput()
-        if lockErr != nil {
-            return false, lockErr
-        }
-        var lockedSnapshots []map[string]interface{}
-        if err := json.Unmarshal(lockOut, &lockedSnapshots); err != nil {
-            return false, err
-        }
-        for _, snap := range lockedSnapshots {
-            if id, ok := snap["id"].(string); ok && id != "" {
-                if id == resolvedId || (len(id) >= 8 && id[:8] == resolvedId) {
-                    return true, nil
-                }
-            }
-            if shortID, ok := snap["short_id"].(string); ok && shortID != "" {
-                if shortID == resolvedId {
-                    return true, nil
-                }
-            }
-        }
-        return false, nil
-    }
-
-    locked, lockErr := isLocked()
+    ctx, cancel := context.WithTimeout(context.Background(), resticsvc.Seconds(config.Get().Restic.Timeouts.Snapshots))
+    snap, lockErr := client.FindSnapshot(ctx, resolvedId)
+    cancel()
     if lockErr != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check lock status"})
         return
     }
-    if locked {
+    if snap != nil && snap.Locked() {
         c.JSON(http.StatusConflict, gin.H{"error": "snapshot is locked"})
         return
     }
 
-    cmd := exec.Command("restic", "-r", repo, "forget", resolvedId, "--prune")
-    cmd.Env = env
-    out, err := cmd.CombinedOutput()
-    if err != nil {
-        if isRepoLockedError(string(out)) && tryUnlockStaleLock(repo, env, string(out)) {
-            retry := exec.Command("restic", "-r", repo, "forget", resolvedId, "--prune")
-            retry.Env = env
-            if retryOut, retryErr := retry.CombinedOutput(); retryErr == nil {
-                c.JSON(http.StatusOK, gin.H{"message": "snapshot deleted", "output": string(retryOut)})
-                return
-            } else {
-                out = retryOut
-                err = retryErr
-            }
+    activity := newResticActivity(c, server.ActivityResticDelete)
+    err = resticsvc.RetryAfterStaleUnlock(client, func() error {
+        _, err := client.Forget(context.Background(), resolvedId)
+        return err
+    })
+    meta := models.ActivityMeta{"snapshot_id": resolvedId}
+    if snap != nil {
+        if size, ok := snap.Size(); ok {
+            meta["bytes"] = size
         }
-        lower := strings.ToLower(string(out))
-        if strings.Contains(lower, "snapshot") && strings.Contains(lower, "not found") {
+    }
+    activity.Save(err, meta)
+    if err != nil {
+        if resticcli.AsError(err).NotFound() {
             c.JSON(http.StatusNotFound, gin.H{"error": "snapshot not found"})
             return
         }
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete snapshot", "output": string(out)})
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete snapshot", "output": resticsvc.Output(err)})
         return
     }
 
@@ -1431,7 +695,10 @@ func DeleteServerResticBackup(c *gin.Context) {
 
 // POST /api/servers/:server/backups/restic/prune
 func PruneServerResticBackup(c *gin.Context) {
-    repo, env, err := resticRepoFromRequest(c)
+    if refuseWhileTransferring(c) {
+        return
+    }
+    client, err := resticRepoFromRequest(c)
     if err != nil {
         c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
         return
@@ -1443,10 +710,10 @@ func PruneServerResticBackup(c *gin.Context) {
 
     // Best-effort concurrency guard.
     if serverId != "" {
-        if status, err := readPruneStatus(serverId); err == nil && status.Status == "running" {
+        if status, err := resticsvc.ReadPruneStatus(serverId); err == nil && status.Status == "running" {
             if status.StartedAt != "" {
                 if started, err := time.Parse(time.RFC3339, status.StartedAt); err == nil {
-                    if time.Since(started) <= 6*time.Hour {
+                    if time.Since(started) <= resticsvc.StaleJobAfter() {
                         c.JSON(http.StatusConflict, gin.H{"error": "prune already running"})
                         return
                     }
@@ -1455,7 +722,7 @@ func PruneServerResticBackup(c *gin.Context) {
                     if status.Message == "" {
                         status.Message = "Prune appears stale. Please retry."
                     }
-                    writePruneStatus(serverId, status)
+                    resticsvc.WritePruneStatus(serverId, status)
                 }
             } else {
                 c.JSON(http.StatusConflict, gin.H{"error": "prune already running"})
@@ -1480,188 +747,94 @@ func PruneServerResticBackup(c *gin.Context) {
         keepWithin = strings.TrimSpace(*body.KeepWithin)
     }
 
-    keepLast := 0
+    policy := resticcli.Policy{KeepWithin: keepWithin, KeepTags: []string{resticcli.LockedTag}}
     if body.KeepLast != nil {
-        keepLast = *body.KeepLast
+        policy.KeepLast = *body.KeepLast
     }
-    keepDaily := 0
     if body.KeepDaily != nil {
-        keepDaily = *body.KeepDaily
+        policy.KeepDaily = *body.KeepDaily
     }
-    keepWeekly := 0
     if body.KeepWeekly != nil {
-        keepWeekly = *body.KeepWeekly
+        policy.KeepWeekly = *body.KeepWeekly
     }
-    keepMonthly := 0
     if body.KeepMonthly != nil {
-        keepMonthly = *body.KeepMonthly
+        policy.KeepMonthly = *body.KeepMonthly
     }
-    keepYearly := 0
     if body.KeepYearly != nil {
-        keepYearly = *body.KeepYearly
+        policy.KeepYearly = *body.KeepYearly
     }
 
-    if keepLast <= 0 && keepDaily <= 0 && keepWeekly <= 0 && keepMonthly <= 0 && keepYearly <= 0 && keepWithin == "" {
+    if policy.Empty() {
         c.JSON(http.StatusBadRequest, gin.H{"error": "at least one retention rule is required"})
         return
     }
 
-    args := []string{"-r", repo, "forget", "--prune", "--keep-tag", "locked"}
-    if keepLast > 0 {
-        args = append(args, "--keep-last", strconv.Itoa(keepLast))
-    }
-    if keepDaily > 0 {
-        args = append(args, "--keep-daily", strconv.Itoa(keepDaily))
-    }
-    if keepWeekly > 0 {
-        args = append(args, "--keep-weekly", strconv.Itoa(keepWeekly))
-    }
-    if keepMonthly > 0 {
-        args = append(args, "--keep-monthly", strconv.Itoa(keepMonthly))
-    }
-    if keepYearly > 0 {
-        args = append(args, "--keep-yearly", strconv.Itoa(keepYearly))
-    }
-    if keepWithin != "" {
-        args = append(args, "--keep-within", keepWithin)
-    }
-
-    run := func() (string, error) {
-        cmdCtx, cancel := context.WithTimeout(context.Background(), 2*time.Hour)
-        defer cancel()
-        cmd := exec.CommandContext(cmdCtx, "restic", args...)
-        cmd.Env = env
-        out, err := cmd.CombinedOutput()
-        if cmdCtx.Err() == context.DeadlineExceeded {
-            return string(out), fmt.Errorf("prune timed out")
-        }
-        if err != nil {
-            if isRepoLockedError(string(out)) && tryUnlockStaleLock(repo, env, string(out)) {
-                retry := exec.Command("restic", args...)
-                retry.Env = env
-                if retryOut, retryErr := retry.CombinedOutput(); retryErr == nil {
-                    return string(retryOut), nil
-                }
-            }
-            return string(out), err
-        }
-        return string(out), nil
+    activity := newResticActivity(c, server.ActivityResticPrune)
+    run := func(ctx context.Context) (string, error) {
+        var out []byte
+        err := resticsvc.RetryAfterStaleUnlock(client, func() error {
+            cmdCtx, cancel := context.WithTimeout(ctx, resticsvc.Seconds(config.Get().Restic.Timeouts.Prune))
+            defer cancel()
+            var err error
+            out, err = client.ApplyPolicy(cmdCtx, policy)
+            return err
+        })
+        activity.Save(err, models.ActivityMeta{"policy": strings.Join(policy.Args(), " ")})
+        return string(out), err
     }
 
+    job := newJob(c, resticsvc.JobPrune, serverId, "")
+    job.Client = client
     if async && serverId != "" {
-        setPruneStatus(serverId, "running", "", "")
-        go func() {
-            out, err := run()
+        resticsvc.SetPruneStatus(serverId, "running", "", "")
+        resticsvc.Jobs.Submit(job, func(j *resticsvc.Job) error {
+            resticsvc.SetPruneStatus(serverId, "running", "", "")
+            out, err := run(j.Context())
+            j.Output = out
             if err != nil {
                 msg := err.Error()
-                if isRepoLockedError(out) {
+                if resticcli.IsLocked(err) {
                     msg = "Repository is busy. Please try again later."
                 }
-                setPruneStatus(serverId, "failed", truncateStatusMessage(msg), truncateCommandOutput(out))
-                return
+                resticsvc.SetPruneStatus(serverId, "failed", resticsvc.TruncateStatusMessage(msg), resticsvc.TruncateCommandOutput(out))
+                return err
             }
-            setPruneStatus(serverId, "completed", "", truncateCommandOutput(out))
-        }()
-        c.JSON(http.StatusAccepted, gin.H{"message": "prune started"})
+            resticsvc.SetPruneStatus(serverId, "completed", "", resticsvc.TruncateCommandOutput(out))
+            return nil
+        })
+        c.JSON(http.StatusAccepted, gin.H{"message": "prune started", "job_id": job.ID, "queue_position": resticsvc.Jobs.Position(resticsvc.JobPrune, serverId, "")})
         return
     }
 
-    out, err := run()
+    var out string
+    err = resticsvc.Jobs.Run(job, func(j *resticsvc.Job) error {
+        var err error
+        out, err = run(j.Context())
+        j.Output = out
+        return err
+    })
+    if errors.Is(err, resticsvc.ErrJobCancelled) {
+        c.JSON(http.StatusConflict, gin.H{"error": "prune cancelled"})
+        return
+    }
     if err != nil {
-        if isRepoLockedError(out) {
+        if resticcli.IsLocked(err) {
             if serverId != "" {
-                setPruneStatus(serverId, "failed", "Repository is busy. Please try again later.", truncateCommandOutput(out))
+                resticsvc.SetPruneStatus(serverId, "failed", "Repository is busy. Please try again later.", resticsvc.TruncateCommandOutput(out))
             }
             c.JSON(http.StatusConflict, gin.H{"error": "repo busy"})
             return
         }
         if serverId != "" {
-            setPruneStatus(serverId, "failed", truncateStatusMessage(err.Error()), truncateCommandOutput(out))
+            resticsvc.SetPruneStatus(serverId, "failed", resticsvc.TruncateStatusMessage(err.Error()), resticsvc.TruncateCommandOutput(out))
         }
         c.JSON(http.StatusInternalServerError, gin.H{"error": "prune failed"})
         return
     }
     if serverId != "" {
-        setPruneStatus(serverId, "completed", "", truncateCommandOutput(out))
-    }
-    c.JSON(http.StatusOK, gin.H{"message": "prune completed", "output": truncateCommandOutput(out)})
-}
-
-type resticPruneStatus struct {
-    Status     string `json:"status"`
-    StartedAt  string `json:"started_at,omitempty"`
-    FinishedAt string `json:"finished_at,omitempty"`
-    Message    string `json:"message,omitempty"`
-    Output     string `json:"output,omitempty"`
-}
-
-func pruneStatusDir() string {
-    return "/var/lib/pterodactyl/restic/.prune-status"
-}
-
-func pruneStatusPath(serverId string) string {
-    return filepath.Join(pruneStatusDir(), serverId+".json")
-}
-
-func readPruneStatus(serverId string) (resticPruneStatus, error) {
-    var status resticPruneStatus
-    data, err := os.ReadFile(pruneStatusPath(serverId))
-    if err != nil {
-        return status, err
-    }
-    if err := json.Unmarshal(data, &status); err != nil {
-        return resticPruneStatus{}, err
-    }
-    return status, nil
-}
-
-func writePruneStatus(serverId string, status resticPruneStatus) {
-    if serverId == "" {
-        return
-    }
-    _ = os.MkdirAll(pruneStatusDir(), 0755)
-    data, err := json.Marshal(status)
-    if err != nil {
-        return
-    }
-    tmp := pruneStatusPath(serverId) + ".tmp"
-    if err := os.WriteFile(tmp, data, 0644); err == nil {
-        _ = os.Rename(tmp, pruneStatusPath(serverId))
+        resticsvc.SetPruneStatus(serverId, "completed", "", resticsvc.TruncateCommandOutput(out))
     }
-    cleanupStatusDir(pruneStatusDir(), 7*24*time.Hour)
-}
-
-func setPruneStatus(serverId string, status string, message string, output string) {
-    if serverId == "" {
-        return
-    }
-    current, _ := readPruneStatus(serverId)
-    next := resticPruneStatus{Status: status}
-
-    if status == "running" {
-        next.StartedAt = time.Now().Format(time.RFC3339)
-        next.FinishedAt = ""
-        next.Message = ""
-        next.Output = ""
-    } else {
-        if current.StartedAt != "" {
-            next.StartedAt = current.StartedAt
-        }
-        if status == "completed" || status == "failed" {
-            next.FinishedAt = time.Now().Format(time.RFC3339)
-        }
-        if message != "" {
-            next.Message = truncateStatusMessage(message)
-        } else if current.Message != "" {
-            next.Message = current.Message
-        }
-        if output != "" {
-            next.Output = truncateCommandOutput(output)
-        } else if current.Output != "" {
-            next.Output = current.Output
-        }
-    }
-    writePruneStatus(serverId, next)
+    c.JSON(http.StatusOK, gin.H{"message": "prune completed", "output": resticsvc.TruncateCommandOutput(out)})
 }
 
 // GET /api/servers/:server/backups/restic/prune/status
@@ -1672,21 +845,22 @@ func GetServerResticPruneStatus(c *gin.Context) {
         return
     }
 
-    status, err := readPruneStatus(serverId)
+    status, err := resticsvc.ReadPruneStatus(serverId)
     if err != nil || status.Status == "" {
         c.JSON(http.StatusOK, gin.H{"status": "idle"})
         return
     }
 
-    if status.Status == "running" && status.StartedAt != "" {
+    status.QueuePosition = resticsvc.Jobs.Position(resticsvc.JobPrune, serverId, "")
+    if status.Status == "running" && status.QueuePosition == 0 && status.StartedAt != "" {
         if started, err := time.Parse(time.RFC3339, status.StartedAt); err == nil {
-            if time.Since(started) > 6*time.Hour {
+            if time.Since(started) > resticsvc.StaleJobAfter() {
                 status.Status = "failed"
                 status.FinishedAt = time.Now().Format(time.RFC3339)
                 if status.Message == "" {
                     status.Message = "Prune appears stale. Please retry."
                 }
-                writePruneStatus(serverId, status)
+                resticsvc.WritePruneStatus(serverId, status)
             }
         }
     }
@@ -1702,23 +876,13 @@ func GetServerResticLocks(c *gin.Context) {
         return
     }
 
-    encryptionKey := c.Query("encryption_key")
-    if encryptionKey == "" {
-        var body struct {
-            EncryptionKey string `json:"encryption_key"`
-        }
-        _ = c.ShouldBindJSON(&body)
-        encryptionKey = body.EncryptionKey
-    }
-    if encryptionKey == "" {
-        var body struct {
-            EncryptionKey string `json:"encryption_key"`
-        }
-        _ = c.ShouldBindJSON(&body)
-        encryptionKey = body.EncryptionKey
+    encryptionKey, err := requestEncryptionKey(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
     }
 
-    repos := listReposForServer(serverId)
+    repos := resticsvc.ReposForServer(serverId)
     if len(repos) == 0 {
         c.JSON(http.StatusOK, gin.H{"repos": []map[string]interface{}{}, "locks": []map[string]interface{}{}})
         return
@@ -1730,118 +894,39 @@ func GetServerResticLocks(c *gin.Context) {
         if key == "" {
             key = encryptionKey
         }
-        env := buildResticEnv(key)
-        cmd := exec.Command("restic", "-r", repo, "list", "locks", "--json")
-        cmd.Env = env
-        out, err := cmd.CombinedOutput()
+        ctx, cancel := context.WithTimeout(context.Background(), resticsvc.Seconds(config.Get().Restic.Timeouts.Snapshots))
+        locks, err := resticsvc.NewClient(repo, key).Locks(ctx)
+        cancel()
 
         entry := map[string]interface{}{
-            "repo": repo,
+            "repo":   repo,
             "locked": false,
-            "locks": []map[string]interface{}{},
+            "locks":  []resticcli.Lock{},
         }
 
         if err != nil {
-            entry["error"] = "failed to list locks"
+            if resticcli.IsWrongPassword(err) {
+                entry["error"] = "invalid repository password"
+            } else {
+                entry["error"] = "failed to list locks"
+            }
             results = append(results, entry)
             continue
         }
 
-        locks, parseErr := parseResticJSONLines(out)
-        if parseErr == nil {
-            entry["locks"] = locks
-            entry["locked"] = len(locks) > 0
-        } else {
-            // Fallback to plain text output
-            textCmd := exec.Command("restic", "-r", repo, "list", "locks")
-            textCmd.Env = env
-            textOut, _ := textCmd.CombinedOutput()
-            fallbackLocks := parseResticLockOutput(string(textOut))
-            entry["locks"] = fallbackLocks
-            entry["locked"] = len(fallbackLocks) > 0
-            if len(fallbackLocks) == 0 {
-                raw := strings.TrimSpace(string(out))
-                if raw == "" {
-                    raw = strings.TrimSpace(string(textOut))
-                }
-                if raw == "" {
-                    entry["error"] = "invalid lock data"
-                } else if strings.Contains(strings.ToLower(raw), "wrong password") || strings.Contains(strings.ToLower(raw), "ciphertext") {
-                    entry["error"] = "invalid repository password"
-                } else {
-                    entry["error"] = raw
-                }
-            }
-        }
+        entry["locks"] = locks
+        entry["locked"] = len(locks) > 0
         results = append(results, entry)
     }
 
     c.JSON(http.StatusOK, gin.H{"repos": results})
 }
 
-func parseResticJSONLines(out []byte) ([]map[string]interface{}, error) {
-    trimmed := strings.TrimSpace(string(out))
-    if trimmed == "" {
-        return []map[string]interface{}{}, nil
-    }
-
-    if strings.HasPrefix(trimmed, "[") {
-        var arr []map[string]interface{}
-        if err := json.Unmarshal([]byte(trimmed), &arr); err != nil {
-            return nil, err
-        }
-        return arr, nil
-    }
-
-    lines := strings.Split(trimmed, "\n")
-    items := make([]map[string]interface{}, 0, len(lines))
-    for _, line := range lines {
-        line = strings.TrimSpace(line)
-        if line == "" {
-            continue
-        }
-        var obj map[string]interface{}
-        if err := json.Unmarshal([]byte(line), &obj); err != nil {
-            return nil, err
-        }
-        items = append(items, obj)
-    }
-    return items, nil
-}
-
-func parseResticLockOutput(output string) []map[string]interface{} {
-    out := strings.TrimSpace(output)
-    if out == "" {
-        return []map[string]interface{}{}
-    }
-    lower := strings.ToLower(out)
-    if strings.Contains(lower, "no locks") || strings.Contains(lower, "no lock") {
-        return []map[string]interface{}{}
-    }
-    if strings.Contains(lower, "repository is already locked") || strings.Contains(lower, "lock was created at") {
-        lock := map[string]interface{}{}
-        if t := parseLockCreatedAt(out); t != nil {
-            lock["created_at"] = t.Format(time.RFC3339)
-        }
-        lock["raw"] = "locked"
-        return []map[string]interface{}{lock}
-    }
-    lines := strings.Split(out, "\n")
-    locks := []map[string]interface{}{}
-    for _, line := range lines {
-        line = strings.TrimSpace(line)
-        if line == "" {
-            continue
-        }
-        if len(line) >= 8 {
-            locks = append(locks, map[string]interface{}{"id": line})
-        }
-    }
-    return locks
-}
-
 // POST /api/servers/:server/backups/restic/unlock
 func UnlockServerResticRepo(c *gin.Context) {
+    if refuseWhileTransferring(c) {
+        return
+    }
     serverId := c.Param("server")
     if serverId == "" {
         c.JSON(http.StatusBadRequest, gin.H{"error": "missing server id"})
@@ -1850,19 +935,24 @@ func UnlockServerResticRepo(c *gin.Context) {
 
     force := strings.ToLower(strings.TrimSpace(c.Query("force")))
     forceUnlock := force == "1" || force == "true" || force == "yes"
-    encryptionKey := c.Query("encryption_key")
+    encryptionKey, err := requestEncryptionKey(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
 
-    repos := listReposForServer(serverId)
+    repos := resticsvc.ReposForServer(serverId)
     if len(repos) == 0 {
         c.JSON(http.StatusOK, gin.H{"message": "no repos found"})
         return
     }
 
+    activity := newResticActivity(c, server.ActivityResticRepoUnlock)
     unlocked := 0
     results := []map[string]interface{}{}
     for _, repo := range repos {
         if forceUnlock {
-            if ok, reason := forceRemoveRepoLocks(repo, 1*time.Hour); ok {
+            if ok, reason := resticsvc.ForceRemoveRepoLocks(repo, resticsvc.Seconds(config.Get().Restic.Stale.ForceUnlock)); ok {
                 unlocked++
                 results = append(results, map[string]interface{}{"repo": repo, "status": "forced"})
                 continue
@@ -1876,126 +966,60 @@ func UnlockServerResticRepo(c *gin.Context) {
         if key == "" {
             key = encryptionKey
         }
-        env := buildResticEnv(key)
-        cmd := exec.Command("restic", "-r", repo, "unlock")
-        cmd.Env = env
-        if out, err := cmd.CombinedOutput(); err == nil {
+        ctx, cancel := context.WithTimeout(context.Background(), resticsvc.Seconds(config.Get().Restic.Timeouts.Unlock))
+        err := resticsvc.NewClient(repo, key).Unlock(ctx)
+        cancel()
+        if err == nil {
             unlocked++
             results = append(results, map[string]interface{}{"repo": repo, "status": "unlocked"})
-        } else if !forceUnlock {
-            results = append(results, map[string]interface{}{"repo": repo, "status": "unlock_failed", "error": strings.TrimSpace(string(out))})
         } else {
-            results = append(results, map[string]interface{}{"repo": repo, "status": "unlock_failed", "error": strings.TrimSpace(string(out))})
+            results = append(results, map[string]interface{}{"repo": repo, "status": "unlock_failed", "error": resticsvc.Output(err)})
         }
     }
 
+    activity.Save(nil, models.ActivityMeta{"unlocked": unlocked, "total": len(repos), "forced": forceUnlock})
     c.JSON(http.StatusOK, gin.H{"message": "repo unlock attempted", "unlocked": unlocked, "total": len(repos), "forced": forceUnlock, "results": results})
 }
 
-func listReposForServer(serverId string) []string {
-    if serverId == "" {
-        return []string{}
-    }
-    base := "/var/lib/pterodactyl/restic"
-    entries, err := os.ReadDir(base)
-    if err != nil {
-        return []string{}
-    }
-    repos := []string{}
-    if strings.Contains(serverId, "+") {
-        candidate := filepath.Join(base, serverId)
-        if repoExists(candidate) {
-            repos = append(repos, candidate)
-        }
-    }
-    for _, entry := range entries {
-        if !entry.IsDir() {
-            continue
-        }
-        name := entry.Name()
-        if name == serverId || strings.HasPrefix(name, serverId+"+") {
-            repos = append(repos, filepath.Join(base, name))
-        }
-    }
-    return repos
-}
 
 func readResticKeyFromRepo(repo string) string {
     if repo == "" {
         return ""
     }
-    keyPath := filepath.Join(repo, ".restic-key")
-    if data, err := os.ReadFile(keyPath); err == nil {
-        return strings.TrimSpace(string(data))
-    }
-    return ""
-}
-
-func repoHasLocks(repo string) bool {
-    if repo == "" {
-        return false
-    }
-    lockDir := filepath.Join(repo, "locks")
-    entries, err := os.ReadDir(lockDir)
+    key, err := resticsvc.LoadKey(repo)
     if err != nil {
-        return false
+        log.WithFields(log.Fields{"repo": repo, "error": err}).Warn("restic: failed to read repository password")
     }
-    return len(entries) > 0
+    return key
 }
 
-func forceRemoveRepoLocks(repo string, minAge time.Duration) (bool, string) {
-    if repo == "" {
-        return false, ""
-    }
-    lockDir := filepath.Join(repo, "locks")
-    entries, err := os.ReadDir(lockDir)
-    if err != nil {
-        return false, ""
-    }
-    if len(entries) == 0 {
-        return false, "no locks"
-    }
-    for _, entry := range entries {
-        info, err := entry.Info()
-        if err != nil {
-            return false, ""
-        }
-        if time.Since(info.ModTime()) < minAge {
-            return false, "locks not stale"
-        }
-    }
-    for _, entry := range entries {
-        _ = os.Remove(filepath.Join(lockDir, entry.Name()))
-    }
-    return true, ""
-}
+
 
 // DELETE /api/servers/:server/backups/restic/repo
 func DeleteServerResticRepo(c *gin.Context) {
+    if refuseWhileTransferring(c) {
+        return
+    }
     serverId := c.Param("server")
     if serverId == "" {
         c.JSON(http.StatusBadRequest, gin.H{"error": "missing server id"})
         return
     }
 
-    base := "/var/lib/pterodactyl/restic/"
-    entries, err := os.ReadDir(base)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read repo dir"})
-        return
-    }
-
+    backend := resticsvc.ServerBackend(serverId)
     deleted := 0
-    for _, entry := range entries {
-        name := entry.Name()
-        if name == serverId || strings.HasPrefix(name, serverId+"+") {
-            path := base + name
-            if err := os.RemoveAll(path); err != nil {
-                c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete repo"})
+    for _, name := range backend.Dirs(serverId) {
+        repo := backend.Location(name)
+        if err := backend.Remove(repo); err != nil {
+            if errors.Is(err, resticsvc.ErrRemoteRemove) {
+                c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
                 return
             }
-            deleted++
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete repo"})
+            return
         }
+        _ = resticsvc.DeleteKey(repo)
+        deleted++
     }
 
     c.JSON(http.StatusOK, gin.H{"message": "repo deleted", "deleted": deleted})
@@ -2009,49 +1033,18 @@ func CheckServerResticRepo(c *gin.Context) {
         return
     }
 
-    base := "/var/lib/pterodactyl/restic/"
-    entries, err := os.ReadDir(base)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read repo dir"})
-        return
-    }
-
-    count := 0
-    for _, entry := range entries {
-        name := entry.Name()
-        if name == serverId || strings.HasPrefix(name, serverId+"+") {
-            count++
-        }
-    }
+    count := len(resticsvc.ServerBackend(serverId).Dirs(serverId))
 
     c.JSON(http.StatusOK, gin.H{"exists": count > 0, "count": count})
 }
 
-func repoDiskUsageBytes(path string) (int64, error) {
-    var total int64
-    err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
-        if err != nil {
-            return err
-        }
-        if d.IsDir() {
-            return nil
-        }
-        info, err := d.Info()
-        if err != nil {
-            return err
-        }
-        if info.Mode()&os.ModeSymlink != 0 {
-            return nil
-        }
-        total += info.Size()
-        return nil
-    })
-    return total, err
-}
 
 // POST /api/servers/:server/backups/restic/repo/check
 func CheckServerResticRepoHealth(c *gin.Context) {
-    repo, env, err := resticRepoFromRequest(c)
+    if refuseWhileTransferring(c) {
+        return
+    }
+    client, err := resticRepoFromRequest(c)
     if err != nil {
         c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
         return
@@ -2063,10 +1056,10 @@ func CheckServerResticRepoHealth(c *gin.Context) {
 
     // Best-effort concurrency guard.
     if async && serverId != "" {
-        if status, err := readRepoHealthStatus(serverId); err == nil && status.Status == "running" {
+        if status, err := resticsvc.ReadRepoHealthStatus(serverId); err == nil && status.Status == "running" {
             if status.StartedAt != "" {
                 if started, err := time.Parse(time.RFC3339, status.StartedAt); err == nil {
-                    if time.Since(started) <= 6*time.Hour {
+                    if time.Since(started) <= resticsvc.StaleJobAfter() {
                         c.JSON(http.StatusConflict, gin.H{"error": "health check already running"})
                         return
                     }
@@ -2075,7 +1068,7 @@ func CheckServerResticRepoHealth(c *gin.Context) {
                     if status.Message == "" {
                         status.Message = "Health check appears stale. Please retry."
                     }
-                    writeRepoHealthStatus(serverId, status)
+                    resticsvc.WriteRepoHealthStatus(serverId, status)
                 }
             } else {
                 c.JSON(http.StatusConflict, gin.H{"error": "health check already running"})
@@ -2089,140 +1082,65 @@ func CheckServerResticRepoHealth(c *gin.Context) {
     }
     _ = c.ShouldBindBodyWith(&body, binding.JSON)
 
-    args := []string{"-r", repo, "check"}
-    if strings.TrimSpace(body.ReadDataSubset) != "" {
-        args = append(args, "--read-data-subset", strings.TrimSpace(body.ReadDataSubset))
-    }
-
-    run := func(timeout time.Duration) (string, error) {
-        ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    run := func(ctx context.Context, timeout time.Duration) (string, error) {
+        ctx, cancel := context.WithTimeout(ctx, timeout)
         defer cancel()
-        cmd := exec.CommandContext(ctx, "restic", args...)
-        cmd.Env = env
-        output, err := cmd.CombinedOutput()
-        if ctx.Err() == context.DeadlineExceeded {
-            return string(output), fmt.Errorf("health check timed out")
-        }
-        if err != nil {
-            return string(output), err
-        }
-        return string(output), nil
+        output, err := client.Check(ctx, body.ReadDataSubset)
+        return string(output), err
     }
 
+    job := newJob(c, resticsvc.JobCheck, serverId, "")
+    job.Client = client
     if async && serverId != "" {
-        setRepoHealthStatus(serverId, "running", "", "")
-        go func() {
-            out, err := run(2 * time.Hour)
+        resticsvc.SetRepoHealthStatus(serverId, "running", "", "")
+        resticsvc.Jobs.Submit(job, func(j *resticsvc.Job) error {
+            resticsvc.SetRepoHealthStatus(serverId, "running", "", "")
+            out, err := run(j.Context(), resticsvc.Seconds(config.Get().Restic.Timeouts.Check))
+            j.Output = out
             if err != nil {
                 msg := err.Error()
-                if isRepoLockedError(out) {
+                if resticcli.IsLocked(err) {
                     msg = "Repository is busy. Please try again later."
                 }
-                setRepoHealthStatus(serverId, "failed", truncateStatusMessage(msg), truncateCommandOutput(out))
-                return
+                resticsvc.SetRepoHealthStatus(serverId, "failed", resticsvc.TruncateStatusMessage(msg), resticsvc.TruncateCommandOutput(out))
+                return err
             }
-            setRepoHealthStatus(serverId, "completed", "", truncateCommandOutput(out))
-        }()
-        c.JSON(http.StatusAccepted, gin.H{"message": "health check started"})
+            resticsvc.SetRepoHealthStatus(serverId, "completed", "", resticsvc.TruncateCommandOutput(out))
+            return nil
+        })
+        c.JSON(http.StatusAccepted, gin.H{"message": "health check started", "job_id": job.ID, "queue_position": resticsvc.Jobs.Position(resticsvc.JobCheck, serverId, "")})
         return
     }
 
-    out, err := run(10 * time.Minute)
+    var out string
+    err = resticsvc.Jobs.Run(job, func(j *resticsvc.Job) error {
+        var err error
+        out, err = run(j.Context(), resticsvc.Seconds(config.Get().Restic.Timeouts.CheckSync))
+        j.Output = out
+        return err
+    })
+    if errors.Is(err, resticsvc.ErrJobCancelled) {
+        c.JSON(http.StatusConflict, gin.H{"error": "health check cancelled"})
+        return
+    }
     if err != nil {
-        if strings.Contains(strings.ToLower(err.Error()), "timed out") {
+        if resticcli.IsTimeout(err) {
             c.JSON(http.StatusGatewayTimeout, gin.H{"error": "health check timed out"})
             return
         }
         c.JSON(http.StatusInternalServerError, gin.H{
             "status": "failed",
-            "output": truncateCommandOutput(out),
+            "output": resticsvc.TruncateCommandOutput(out),
         })
         return
     }
 
     c.JSON(http.StatusOK, gin.H{
         "status": "ok",
-        "output": truncateCommandOutput(out),
+        "output": resticsvc.TruncateCommandOutput(out),
     })
 }
 
-type resticRepoHealthStatus struct {
-    Status     string `json:"status"`
-    StartedAt  string `json:"started_at,omitempty"`
-    FinishedAt string `json:"finished_at,omitempty"`
-    Message    string `json:"message,omitempty"`
-    Output     string `json:"output,omitempty"`
-}
-
-func repoHealthStatusDir() string {
-    return "/var/lib/pterodactyl/restic/.repo-health-status"
-}
-
-func repoHealthStatusPath(serverId string) string {
-    return filepath.Join(repoHealthStatusDir(), serverId+".json")
-}
-
-func readRepoHealthStatus(serverId string) (resticRepoHealthStatus, error) {
-    var status resticRepoHealthStatus
-    data, err := os.ReadFile(repoHealthStatusPath(serverId))
-    if err != nil {
-        return status, err
-    }
-    if err := json.Unmarshal(data, &status); err != nil {
-        return resticRepoHealthStatus{}, err
-    }
-    return status, nil
-}
-
-func writeRepoHealthStatus(serverId string, status resticRepoHealthStatus) {
-    if serverId == "" {
-        return
-    }
-    _ = os.MkdirAll(repoHealthStatusDir(), 0755)
-    data, err := json.Marshal(status)
-    if err != nil {
-        return
-    }
-    tmp := repoHealthStatusPath(serverId) + ".tmp"
-    if err := os.WriteFile(tmp, data, 0644); err == nil {
-        _ = os.Rename(tmp, repoHealthStatusPath(serverId))
-    }
-    cleanupStatusDir(repoHealthStatusDir(), 7*24*time.Hour)
-}
-
-func setRepoHealthStatus(serverId string, status string, message string, output string) {
-    if serverId == "" {
-        return
-    }
-    current, _ := readRepoHealthStatus(serverId)
-    next := resticRepoHealthStatus{Status: status}
-
-    if status == "running" {
-        next.StartedAt = time.Now().Format(time.RFC3339)
-        next.FinishedAt = ""
-        next.Message = ""
-        next.Output = ""
-    } else {
-        if current.StartedAt != "" {
-            next.StartedAt = current.StartedAt
-        }
-        if status == "completed" || status == "failed" {
-            next.FinishedAt = time.Now().Format(time.RFC3339)
-        }
-        if message != "" {
-            next.Message = truncateStatusMessage(message)
-        } else if current.Message != "" {
-            next.Message = current.Message
-        }
-        if output != "" {
-            next.Output = truncateCommandOutput(output)
-        } else if current.Output != "" {
-            next.Output = current.Output
-        }
-    }
-    writeRepoHealthStatus(serverId, next)
-}
-
 // GET /api/servers/:server/backups/restic/repo/check/status
 func GetServerResticRepoHealthStatus(c *gin.Context) {
     serverId := c.Param("server")
@@ -2231,21 +1149,22 @@ func GetServerResticRepoHealthStatus(c *gin.Context) {
         return
     }
 
-    status, err := readRepoHealthStatus(serverId)
+    status, err := resticsvc.ReadRepoHealthStatus(serverId)
     if err != nil || status.Status == "" {
         c.JSON(http.StatusOK, gin.H{"status": "idle"})
         return
     }
 
-    if status.Status == "running" && status.StartedAt != "" {
+    status.QueuePosition = resticsvc.Jobs.Position(resticsvc.JobCheck, serverId, "")
+    if status.Status == "running" && status.QueuePosition == 0 && status.StartedAt != "" {
         if started, err := time.Parse(time.RFC3339, status.StartedAt); err == nil {
-            if time.Since(started) > 6*time.Hour {
+            if time.Since(started) > resticsvc.StaleJobAfter() {
                 status.Status = "failed"
                 status.FinishedAt = time.Now().Format(time.RFC3339)
                 if status.Message == "" {
                     status.Message = "Health check appears stale. Please retry."
                 }
-                writeRepoHealthStatus(serverId, status)
+                resticsvc.WriteRepoHealthStatus(serverId, status)
             }
         }
     }
@@ -2261,22 +1180,12 @@ func GetServerResticRepoDiskUsage(c *gin.Context) {
         return
     }
 
-    base := "/var/lib/pterodactyl/restic/"
-    entries, err := os.ReadDir(base)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read repo dir"})
-        return
-    }
-
+    backend := resticsvc.ServerBackend(serverId)
     totalBytes := int64(0)
     repos := make([]gin.H, 0)
-    for _, entry := range entries {
-        name := entry.Name()
-        if name != serverId && !strings.HasPrefix(name, serverId+"+") {
-            continue
-        }
-        path := filepath.Join(base, name)
-        sizeBytes, sizeErr := repoDiskUsageBytes(path)
+    for _, name := range backend.Dirs(serverId) {
+        path := backend.Location(name)
+        sizeBytes, sizeErr := backend.Size(path)
         if sizeErr != nil {
             repos = append(repos, gin.H{
                 "name":  name,
@@ -0,0 +1,48 @@
+package restic
+
+import (
+	"strings"
+
+	"emperror.dev/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/pterodactyl/wings/config"
+)
+
+// encryptionKeyHeader is the header that the encryption key of a server's
+// repository can be sent in, which keeps it out of URLs and so out of the
+// access logs of any proxy in front of Wings.
+const encryptionKeyHeader = "X-Restic-Encryption-Key"
+
+var errQueryEncryptionKey = errors.New("encryption_key must be sent in the " + encryptionKeyHeader + " header or the request body, not the query string")
+
+// requestEncryptionKey returns the encryption key sent with the request, or an
+// empty string if there is not one. The header is preferred over the request
+// body, which is preferred over the query string. Keys in the query string are
+// rejected when restic.reject_query_keys is enabled, even if the key was also
+// sent some other way, so that clients still sending them are noticed.
+//
+// The body is read with ShouldBindBodyWith, so handlers must do the same if they
+// bind the body themselves.
+func requestEncryptionKey(c *gin.Context) (string, error) {
+	if _, ok := c.GetQuery("encryption_key"); ok && config.Get().Restic.RejectQueryKeys {
+		return "", errQueryEncryptionKey
+	}
+	if v := strings.TrimSpace(c.GetHeader(encryptionKeyHeader)); v != "" {
+		return v, nil
+	}
+	if v, ok := c.GetPostForm("encryption_key"); ok && v != "" {
+		return v, nil
+	}
+	var body struct {
+		EncryptionKey string `json:"encryption_key"`
+	}
+	if c.Request.Body != nil {
+		_ = c.ShouldBindBodyWith(&body, binding.JSON)
+	}
+	if body.EncryptionKey != "" {
+		return body.EncryptionKey, nil
+	}
+	return c.Query("encryption_key"), nil
+}
@@ -0,0 +1,191 @@
+package restic
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/patrickmn/go-cache"
+
+	"github.com/pterodactyl/wings/config"
+	resticcli "github.com/pterodactyl/wings/internal/restic"
+	"github.com/pterodactyl/wings/internal/resticsvc"
+)
+
+// The kinds of change returned by the diff endpoint.
+const (
+	changeAdded    = "added"
+	changeRemoved  = "removed"
+	changeModified = "modified"
+)
+
+// snapshotIDPattern matches a full or short snapshot ID, and makes sure that an
+// ID from a query string can never be mistaken for a flag by restic.
+var snapshotIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{1,64}$`)
+
+// snapshotChange is a path that differs between two snapshots, relative to the
+// root of the server data directory. Sizes are only known for files.
+type snapshotChange struct {
+	Path       string `json:"path"`
+	Type       string `json:"type"`
+	Modifier   string `json:"modifier"`
+	Directory  bool   `json:"directory"`
+	SizeBefore uint64 `json:"size_before"`
+	SizeAfter  uint64 `json:"size_after"`
+	SizeDelta  int64  `json:"size_delta"`
+}
+
+// snapshotDiff is the complete comparison of two snapshots, which is cached so
+// that it can be paged through without running restic again.
+type snapshotDiff struct {
+	Changes []snapshotChange
+	Stats   resticcli.DiffStatistics
+}
+
+// GET /api/servers/:server/backups/restic/diff?from=<id>&to=<id>
+func DiffServerResticBackups(c *gin.Context) {
+	serverId := c.Param("server")
+	from := c.Query("from")
+	to := c.Query("to")
+	if !snapshotIDPattern.MatchString(from) || !snapshotIDPattern.MatchString(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be snapshot ids"})
+		return
+	}
+
+	encryptionKey, err := requestEncryptionKey(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if encryptionKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing encryption key"})
+		return
+	}
+
+	filter := c.Query("type")
+	if filter != "" && filter != changeAdded && filter != changeRemoved && filter != changeModified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid change type"})
+		return
+	}
+	limit, offset := pageParams(c)
+
+	repo := resticsvc.RepoPath(resticsvc.ResolveRepoDir(serverId, c.Query("owner_username")))
+	resolvedKey, err := resticsvc.ResolveKey(repo, encryptionKey)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	diff, err := diffSnapshots(resticsvc.NewClient(repo, resolvedKey), resolvedKey, from, to, resticsvc.VolumePath(serverId))
+	if err != nil {
+		switch {
+		case resticcli.IsTimeout(err):
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "snapshot comparison timed out"})
+		case resticcli.AsError(err).NotFound():
+			c.JSON(http.StatusNotFound, gin.H{"error": "backup not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compare backups", "output": resticsvc.Output(err)})
+		}
+		return
+	}
+
+	counts := map[string]int{changeAdded: 0, changeRemoved: 0, changeModified: 0}
+	changes := make([]snapshotChange, 0, len(diff.Changes))
+	for _, ch := range diff.Changes {
+		counts[ch.Type]++
+		if filter == "" || ch.Type == filter {
+			changes = append(changes, ch)
+		}
+	}
+
+	page, nextCursor := paginate(changes, limit, offset)
+	c.JSON(http.StatusOK, gin.H{
+		"from":        from,
+		"to":          to,
+		"changes":     page,
+		"next_cursor": nextCursor,
+		"limit":       limit,
+		"total":       len(changes),
+		"summary": gin.H{
+			"added":         counts[changeAdded],
+			"removed":       counts[changeRemoved],
+			"modified":      counts[changeModified],
+			"bytes_added":   diff.Stats.Added.Bytes,
+			"bytes_removed": diff.Stats.Removed.Bytes,
+		},
+	})
+}
+
+// diffSnapshots compares two snapshots and returns the paths within the server
+// data directory that changed, sorted by path. Restic does not report the size
+// of the files that changed, so both snapshots are walked afterwards to find
+// them, keeping only the sizes of the changed files in memory.
+func diffSnapshots(client *resticcli.Client, key string, from string, to string, volumePath string) (*snapshotDiff, error) {
+	cacheKey := snapshotCacheKey(client, key, "diff", from, to)
+	if v, ok := listingCache.Get(cacheKey); ok {
+		return v.(*snapshotDiff), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), resticsvc.Seconds(config.Get().Restic.Timeouts.Diff))
+	defer cancel()
+	changes, stats, err := client.Diff(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	root := filepath.ToSlash(volumePath)
+	byPath := make(map[string]*snapshotChange, len(changes))
+	diff := &snapshotDiff{Changes: make([]snapshotChange, 0, len(changes)), Stats: *stats}
+	for _, ch := range changes {
+		p := path.Clean(ch.Path)
+		if !strings.HasPrefix(p, root+"/") {
+			continue
+		}
+		change := snapshotChange{
+			Path:      strings.TrimPrefix(p, root+"/"),
+			Type:      changeModified,
+			Modifier:  ch.Modifier,
+			Directory: strings.HasSuffix(ch.Path, "/"),
+		}
+		switch ch.Modifier {
+		case resticcli.DiffAdded:
+			change.Type = changeAdded
+		case resticcli.DiffRemoved:
+			change.Type = changeRemoved
+		}
+		diff.Changes = append(diff.Changes, change)
+	}
+	for i := range diff.Changes {
+		byPath[path.Join(root, diff.Changes[i].Path)] = &diff.Changes[i]
+	}
+
+	if len(byPath) > 0 {
+		sizes := func(snapshot string, set func(ch *snapshotChange, size uint64)) error {
+			return client.Walk(ctx, snapshot, root, func(n resticcli.Node) {
+				if ch, ok := byPath[path.Clean(n.Path)]; ok && n.Type == resticcli.NodeFile {
+					set(ch, n.Size)
+				}
+			})
+		}
+		if err := sizes(from, func(ch *snapshotChange, size uint64) { ch.SizeBefore = size }); err != nil {
+			return nil, err
+		}
+		if err := sizes(to, func(ch *snapshotChange, size uint64) { ch.SizeAfter = size }); err != nil {
+			return nil, err
+		}
+	}
+	for i := range diff.Changes {
+		diff.Changes[i].SizeDelta = int64(diff.Changes[i].SizeAfter) - int64(diff.Changes[i].SizeBefore)
+	}
+	sort.Slice(diff.Changes, func(i, j int) bool {
+		return diff.Changes[i].Path < diff.Changes[j].Path
+	})
+
+	listingCache.Set(cacheKey, diff, cache.DefaultExpiration)
+	return diff, nil
+}
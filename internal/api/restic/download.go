@@ -7,34 +7,77 @@ import (
     "os"
 
     "github.com/gin-gonic/gin"
+    "github.com/pterodactyl/wings/internal/resticsvc"
     "github.com/pterodactyl/wings/server"
 )
 
 // GET /api/servers/:server/backups/restic/:backupId/download
+//
+// Streams the snapshot as a compressed archive using restic dump. Passing
+// mode=prepared restores and archives the snapshot on disk first instead, which
+// is slower and needs free disk space but allows a Content-Length to be sent.
 func DownloadServerResticBackup(c *gin.Context) {
     serverId := c.Param("server")
     backupId := c.Param("backupId")
-    encryptionKey := c.Query("encryption_key")
+    encryptionKey, err := requestEncryptionKey(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
     ownerUsername := c.Query("owner_username")
     if serverId == "" || backupId == "" || encryptionKey == "" || ownerUsername == "" {
         c.JSON(http.StatusBadRequest, gin.H{"error": "missing required parameters"})
         return
     }
+    if !snapshotIDPattern.MatchString(backupId) {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid backup id"})
+        return
+    }
 
     s := c.MustGet("server").(*server.Server)
-    if err := prepareServerResticBackupInternal(s.ID(), backupId, encryptionKey, ownerUsername); err != nil {
+    if c.Query("mode") != "prepared" {
+        repo := resticsvc.RepoPath(resticsvc.ResolveRepoDir(s.ID(), ownerUsername))
+        key, err := resticsvc.ResolveKey(repo, encryptionKey)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        streamResticExport(c, s, resticsvc.NewClient(repo, key), backupId, c.Query("format"))
+        return
+    }
+    if err := prepareQueued(newJob(c, resticsvc.JobPrepare, s.ID(), backupId), encryptionKey, ownerUsername); err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "prepare failed"})
         return
     }
     StreamPreparedResticBackup(c, s, backupId)
 }
 
-// DownloadServerResticBackupFromToken streams a Restic backup as tar.gz.
-// Assumes the archive has already been prepared using PrepareServerResticBackup.
+// DownloadServerResticBackupFromToken streams a Restic backup archive. If the
+// archive has been prepared using PrepareServerResticBackup that file is sent,
+// otherwise the snapshot is streamed using restic dump with the key stored in
+// the repository, since the token never contains it.
 func DownloadServerResticBackupFromToken(c *gin.Context, s *server.Server, backupId string) {
+    if backupId != "" && !preparedArchiveExists(s.ID(), backupId) {
+        repo := resticsvc.RepoPath(resticsvc.ResolveRepoDir(s.ID(), ""))
+        if key, err := resticsvc.ResolveKey(repo, ""); err == nil {
+            streamResticExport(c, s, resticsvc.NewClient(repo, key), backupId, c.Query("format"))
+            return
+        }
+    }
     StreamPreparedResticBackup(c, s, backupId)
 }
 
+// preparedArchiveExists reports whether an archive of the snapshot has already
+// been prepared for download.
+func preparedArchiveExists(serverId, backupId string) bool {
+    for _, ext := range []string{".tar.zst", ".tar.gz"} {
+        if st, err := os.Stat(preparedArchivePath(serverId, backupId, ext)); err == nil && st.Size() > 0 {
+            return true
+        }
+    }
+    return false
+}
+
 // StreamPreparedResticBackup streams a prepared Restic archive from temp storage.
 func StreamPreparedResticBackup(c *gin.Context, s *server.Server, backupId string) {
     serverId := s.ID()
@@ -51,7 +94,7 @@ func StreamPreparedResticBackup(c *gin.Context, s *server.Server, backupId strin
         c.JSON(http.StatusBadRequest, gin.H{"error": "missing backup_id"})
         return
     }
-    tempDir := "/var/lib/pterodactyl/restic/temp"
+    tempDir := resticsvc.TempDir()
     if err := os.MkdirAll(tempDir, 0700); err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create temp dir"})
         return
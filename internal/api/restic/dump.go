@@ -0,0 +1,131 @@
+package restic
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-gonic/gin"
+
+	"github.com/pterodactyl/wings/config"
+	resticcli "github.com/pterodactyl/wings/internal/restic"
+	"github.com/pterodactyl/wings/internal/resticsvc"
+	"github.com/pterodactyl/wings/server"
+)
+
+// sniffLength is the number of bytes from the start of a file that are used to
+// detect its content type, which matches what the mimetype package reads.
+const sniffLength = 3072
+
+// StreamResticFileFromToken streams a single file out of a snapshot using
+// "restic dump", with the file path relative to the server data directory. The
+// file is looked up in the snapshot first so that the response has the correct
+// length, and nothing is staged on the disk while it is streamed.
+func StreamResticFileFromToken(c *gin.Context, s *server.Server, backupId string, ownerUsername string, file string) {
+	notFound := func() {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The requested resource was not found on this server."})
+	}
+
+	rel := strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(file)), "/")
+	if backupId == "" || rel == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing backup id or file path"})
+		return
+	}
+	if !snapshotIDPattern.MatchString(backupId) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid backup id"})
+		return
+	}
+
+	// Downloads never include the key, so only repositories that already have one
+	// stored alongside them can be read.
+	repo := resticsvc.RepoPath(resticsvc.ResolveRepoDir(s.ID(), ownerUsername))
+	key, err := resticsvc.ResolveKey(repo, "")
+	if err != nil {
+		notFound()
+		return
+	}
+	client := resticsvc.NewClient(repo, key)
+	volumePath := resticsvc.VolumePath(s.ID())
+
+	files, err := listSnapshotDir(client, key, backupId, volumePath, path.Dir(rel))
+	if err != nil {
+		if resticcli.AsError(err).NotFound() {
+			notFound()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to read backup", "output": resticsvc.Output(err)})
+		return
+	}
+	var node *snapshotFile
+	for i := range files {
+		if files[i].Path == rel {
+			node = &files[i]
+			break
+		}
+	}
+	if node == nil || node.Type != resticcli.NodeFile {
+		notFound()
+		return
+	}
+
+	c.Header("Content-Length", strconv.FormatUint(node.Size, 10))
+	c.Header("Content-Disposition", "attachment; filename="+strconv.Quote(node.Name))
+	c.Header("X-Accel-Buffering", "no")
+
+	// Stop restic if the client goes away, rather than waiting for it to time out.
+	ctx, cancel := context.WithTimeout(c.Request.Context(), resticsvc.Seconds(config.Get().Restic.Timeouts.Prepare))
+	defer cancel()
+	w := &sniffWriter{w: c.Writer}
+	err = client.Dump(ctx, backupId, path.Join(filepath.ToSlash(volumePath), rel), w)
+	if err == nil {
+		err = w.flush()
+	}
+	if err != nil {
+		if !w.flushed {
+			c.Header("Content-Length", "")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to read file from backup", "output": resticsvc.Output(err)})
+			return
+		}
+		s.Log().WithField("backup_id", backupId).WithField("error", resticsvc.Output(err)).Warn("failed to stream file from restic backup")
+	}
+}
+
+// sniffWriter holds back the start of a file until enough of it has been
+// written to detect its content type, at which point the response headers are
+// sent and everything else is written straight through.
+type sniffWriter struct {
+	w       gin.ResponseWriter
+	buf     []byte
+	flushed bool
+}
+
+func (s *sniffWriter) Write(p []byte) (int, error) {
+	if s.flushed {
+		return s.w.Write(p)
+	}
+	s.buf = append(s.buf, p...)
+	if len(s.buf) >= sniffLength {
+		if err := s.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// flush detects the content type from what has been written so far and sends
+// it to the client along with the response headers.
+func (s *sniffWriter) flush() error {
+	if s.flushed {
+		return nil
+	}
+	s.flushed = true
+	s.w.Header().Set("Content-Type", mimetype.Detect(s.buf).String())
+	s.w.WriteHeader(http.StatusOK)
+	_, err := s.w.Write(s.buf)
+	s.buf = nil
+	return err
+}
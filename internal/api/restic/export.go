@@ -0,0 +1,93 @@
+package restic
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+
+	"github.com/pterodactyl/wings/config"
+	resticcli "github.com/pterodactyl/wings/internal/restic"
+	"github.com/pterodactyl/wings/internal/resticsvc"
+	"github.com/pterodactyl/wings/server"
+)
+
+// The formats that a snapshot can be exported in without staging it on disk.
+const (
+	exportZstd = "zstd"
+	exportGzip = "gzip"
+)
+
+// exportWriter is a compressor that can be pointed somewhere else if the export
+// fails before anything has been sent to the client.
+type exportWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// streamResticExport streams the server data directory within a snapshot to the
+// client as a compressed tar archive. Restic writes the archive into the
+// compressor, which writes into the response, so nothing is staged on the disk
+// and restic only reads from the repository as fast as the client downloads.
+// The size of the archive is not known up front, so no Content-Length is sent.
+func streamResticExport(c *gin.Context, s *server.Server, client *resticcli.Client, backupId string, format string) {
+	if !snapshotIDPattern.MatchString(backupId) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid backup id"})
+		return
+	}
+
+	var zw exportWriter
+	var ext, contentType string
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "zst", exportZstd:
+		enc, err := zstd.NewWriter(c.Writer, zstd.WithEncoderLevel(zstd.SpeedDefault))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create compressor"})
+			return
+		}
+		zw, ext, contentType = enc, ".tar.zst", "application/zstd"
+	case "gz", exportGzip:
+		gw, _ := pgzip.NewWriterLevel(c.Writer, pgzip.DefaultCompression)
+		zw, ext, contentType = gw, ".tar.gz", "application/gzip"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid export format"})
+		return
+	}
+
+	fileName := fmt.Sprintf("attachment; filename=backup-%s%s", resticcli.Snapshot{ID: backupId}.Short(), ext)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fileName)
+	c.Header("X-Accel-Buffering", "no")
+
+	// Stop restic if the client goes away, rather than waiting for it to time out.
+	ctx, cancel := context.WithTimeout(c.Request.Context(), resticsvc.Seconds(config.Get().Restic.Timeouts.Prepare))
+	defer cancel()
+	err := client.DumpArchive(ctx, backupId, resticsvc.VolumePath(s.ID()), zw)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		return
+	}
+
+	if !c.Writer.Written() {
+		zw.Reset(io.Discard)
+		_ = zw.Close()
+		c.Header("Content-Type", "")
+		c.Header("Content-Disposition", "")
+		code := http.StatusInternalServerError
+		if resticcli.AsError(err).NotFound() {
+			code = http.StatusNotFound
+		}
+		c.JSON(code, gin.H{"error": "failed to export backup", "output": resticsvc.Output(err)})
+		return
+	}
+	// The archive has been partially sent, so all that can be done is to end the
+	// response early, which leaves the client with an archive it cannot read.
+	s.Log().WithField("backup_id", backupId).WithField("error", resticsvc.Output(err)).Warn("failed to stream restic backup export")
+}
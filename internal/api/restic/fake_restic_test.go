@@ -0,0 +1,319 @@
+package restic
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creasty/defaults"
+	"github.com/gin-gonic/gin"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/events"
+	"github.com/pterodactyl/wings/internal/database"
+	"github.com/pterodactyl/wings/internal/models"
+	"github.com/pterodactyl/wings/internal/resticsvc"
+	"github.com/pterodactyl/wings/remote"
+	"github.com/pterodactyl/wings/server"
+)
+
+// fakeResticScript is installed as "restic" on the PATH while the tests run. It
+// records every invocation and replies with the canned responses stored in the
+// state directory. A response for the Nth call of a subcommand is read from
+// "<subcommand>.<N>.{out,err,code}" and falls back to "<subcommand>.{out,err,code}".
+//
+// The repository and password of every invocation are recorded in the same
+// order. The init and restore subcommands also perform the minimum amount of
+// work on the disk that the handlers depend on when they succeed, and the
+// contents of any exclude file passed to backup and of any new password file
+// are copied into the state directory. A
+// subcommand that has a "<subcommand>.block" file sleeps until it is killed.
+const fakeResticScript = `#!/bin/sh
+state="@STATE@"
+repo=""
+if [ "$1" = "-r" ]; then
+	repo="$2"
+	shift 2
+fi
+cmd="$1"
+printf '%s\n' "$*" >> "$state/calls"
+printf '%s\n' "$repo" >> "$state/repos"
+printf '%s\n' "$(cat "$RESTIC_PASSWORD_FILE" 2>/dev/null)" >> "$state/passwords"
+n=$(grep -c "^$cmd\( \|\$\)" "$state/calls")
+resp="$state/responses/$cmd"
+if [ -e "$resp.$n.out" ] || [ -e "$resp.$n.err" ] || [ -e "$resp.$n.code" ]; then
+	resp="$resp.$n"
+fi
+if [ -e "$state/responses/$cmd.block" ]; then
+	exec sleep 60
+fi
+code=0
+if [ -e "$resp.code" ]; then
+	code=$(cat "$resp.code")
+fi
+prev=""
+for arg in "$@"; do
+	if [ "$prev" = "--exclude-file" ]; then
+		cp "$arg" "$state/exclude-file"
+	fi
+	if [ "$prev" = "--new-password-file" ]; then
+		cat "$arg" > "$state/new-password"
+	fi
+	prev="$arg"
+done
+if [ "$code" = "0" ]; then
+	case "$cmd" in
+	init)
+		case "$repo" in
+		/*) mkdir -p "$repo" && touch "$repo/config" ;;
+		esac
+		;;
+	restore)
+		target=""
+		while [ $# -gt 0 ]; do
+			if [ "$1" = "--target" ]; then
+				target="$2"
+			fi
+			shift
+		done
+		if [ -n "$target" ] && [ "$target" != "/" ]; then
+			mkdir -p "$target" && printf 'restored\n' > "$target/restored.txt"
+		fi
+		;;
+	esac
+fi
+if [ -e "$resp.out" ]; then
+	cat "$resp.out"
+fi
+if [ -e "$resp.err" ]; then
+	cat "$resp.err" >&2
+fi
+exit "$code"
+`
+
+// TestMain initializes the activity database once for all the tests in the
+// package, since it cannot be initialized again afterwards.
+func TestMain(m *testing.M) {
+	root, err := os.MkdirTemp("", "wings-restic-")
+	if err != nil {
+		panic(err)
+	}
+	config.Set(&config.Configuration{AuthenticationToken: "abc", System: config.SystemConfiguration{RootDirectory: root}})
+	if err := database.Initialize(); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	_ = os.RemoveAll(root)
+	os.Exit(code)
+}
+
+type fakeRestic struct {
+	t        *testing.T
+	state    string
+	repoBase string
+	data     string
+	// header is added to every request made to a handler.
+	header http.Header
+}
+
+// newFakeRestic installs the fake restic binary on the PATH and configures Wings
+// to keep its repositories and server data within a temporary directory.
+func newFakeRestic(t *testing.T) *fakeRestic {
+	root := t.TempDir()
+	f := &fakeRestic{
+		t:        t,
+		state:    filepath.Join(root, "state"),
+		repoBase: filepath.Join(root, "restic"),
+		data:     filepath.Join(root, "volumes"),
+		header:   http.Header{},
+	}
+	bin := filepath.Join(root, "bin")
+	for _, d := range []string{bin, filepath.Join(f.state, "responses"), f.repoBase, f.data} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := strings.ReplaceAll(fakeResticScript, "@STATE@", f.state)
+	if err := os.WriteFile(filepath.Join(bin, "restic"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	var rc config.ResticConfiguration
+	if err := defaults.Set(&rc); err != nil {
+		t.Fatal(err)
+	}
+	rc.Binary = "restic"
+	rc.RepositoryDirectory = f.repoBase
+	rc.TempDirectory = filepath.Join(f.repoBase, "temp")
+	rc.ArchiveDirectory = filepath.Join(f.repoBase, "archive")
+	rc.MasterKeyFile = filepath.Join(root, "master.key")
+	config.Set(&config.Configuration{
+		AuthenticationToken: "abc",
+		System:              config.SystemConfiguration{Data: f.data},
+		Restic:              rc,
+	})
+
+	f.respond("snapshots", 0, "[]", "", 0)
+	f.respond("backup", 0, `{"message_type":"summary","snapshot_id":"0123456789abcdef0123456789abcdef","files_new":1}`, "", 0)
+	f.respond("stats", 0, `{"total_size":0,"snapshots_count":0}`, "", 0)
+	return f
+}
+
+// respond sets the reply for a subcommand. A call of 0 sets the reply used for
+// every call that does not have a more specific reply.
+func (f *fakeRestic) respond(cmd string, call int, stdout string, stderr string, code int) {
+	name := cmd
+	if call > 0 {
+		name += "." + strconv.Itoa(call)
+	}
+	p := filepath.Join(f.state, "responses", name)
+	for ext, v := range map[string]string{".out": stdout, ".err": stderr, ".code": strconv.Itoa(code)} {
+		if err := os.WriteFile(p+ext, []byte(v), 0o644); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+}
+
+// block makes every call of a subcommand run until it is interrupted.
+func (f *fakeRestic) block(cmd string) {
+	if err := os.WriteFile(filepath.Join(f.state, "responses", cmd+".block"), nil, 0o644); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// calls returns the arguments of every restic invocation for the given
+// subcommand, excluding the repository flag.
+func (f *fakeRestic) calls(cmd string) []string {
+	b, _ := os.ReadFile(filepath.Join(f.state, "calls"))
+	var out []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		if line == cmd || strings.HasPrefix(line, cmd+" ") {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+// repos returns the repository of every restic invocation, in order.
+func (f *fakeRestic) repos() []string {
+	b, _ := os.ReadFile(filepath.Join(f.state, "repos"))
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+// passwords returns the repository password of every invocation, in order.
+func (f *fakeRestic) passwords() []string {
+	b, _ := os.ReadFile(filepath.Join(f.state, "passwords"))
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+// initRepo creates an initialized repository for the server with the key
+// stored in the key store, returning the path to the repository.
+func (f *fakeRestic) initRepo(dir string, key string) string {
+	repo := filepath.Join(f.repoBase, dir)
+	if err := os.MkdirAll(repo, 0o755); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "config"), []byte("config"), 0o644); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := resticsvc.StoreKey(repo, key); err != nil {
+		f.t.Fatal(err)
+	}
+	return repo
+}
+
+// storedKey returns the key stored for the repository in the given directory.
+func (f *fakeRestic) storedKey(dir string) string {
+	key, err := resticsvc.LoadKey(filepath.Join(f.repoBase, dir))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return key
+}
+
+// newServer returns a server instance with the given UUID, as placed on the
+// request context by the ServerExists middleware. The data directory for the
+// server is created so that its filesystem can be used.
+func (f *fakeRestic) newServer(uuid string) *server.Server {
+	if err := os.MkdirAll(filepath.Join(f.data, uuid), 0o755); err != nil {
+		f.t.Fatal(err)
+	}
+	s, err := server.NewEmptyManager(nil).InitServer(remote.ServerConfigurationResponse{Settings: []byte(`{"uuid":"` + uuid + `"}`)})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return s
+}
+
+// request calls the handler with a request for the given target and returns the
+// recorded response. The body is encoded as JSON when it is not nil.
+func (f *fakeRestic) request(h gin.HandlerFunc, method string, target string, params gin.Params, body interface{}, s *server.Server) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, target, &buf)
+	c.Request.Header.Set("Content-Type", "application/json")
+	for k, v := range f.header {
+		c.Request.Header[k] = v
+	}
+	c.Params = params
+	if s != nil {
+		c.Set("server", s)
+	}
+	h(c)
+	return w
+}
+
+// listen subscribes to the event bus of the server and returns a function that
+// returns every event published on the bus since.
+func listen(s *server.Server) func() []events.Event {
+	ch := make(chan []byte, 64)
+	s.Events().On(ch)
+	return func() []events.Event {
+		var out []events.Event
+		for {
+			select {
+			case b := <-ch:
+				out = append(out, events.MustDecode(b))
+			default:
+				return out
+			}
+		}
+	}
+}
+
+// activity waits for an activity with the given event to be saved for the
+// server by a request from the IP address, returning nil if one is not saved
+// within a second. Activities are saved in the background, so the IP address
+// is used to tell them apart from those saved by earlier tests.
+func activity(s *server.Server, event models.Event, ip string) *models.Activity {
+	for i := 0; i < 100; i++ {
+		var a models.Activity
+		tx := database.Instance().Where("server = ? AND event = ? AND ip = ?", s.ID(), event, ip).Limit(1).Find(&a)
+		if tx.Error == nil && tx.RowsAffected == 1 {
+			return &a
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+// decode unmarshals the body of the response into a map.
+func decode(w *httptest.ResponseRecorder) map[string]interface{} {
+	var m map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &m)
+	return m
+}
@@ -0,0 +1,175 @@
+package restic
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/patrickmn/go-cache"
+
+	"github.com/pterodactyl/wings/config"
+	resticcli "github.com/pterodactyl/wings/internal/restic"
+	"github.com/pterodactyl/wings/internal/resticsvc"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// listingCache holds the directory listings and comparisons of snapshots. The
+// contents of a snapshot never change, so they only need to be fetched from
+// restic once while someone is browsing them.
+var listingCache = cache.New(10*time.Minute, 5*time.Minute)
+
+// snapshotFile is an entry within a directory of a snapshot, with a path that is
+// relative to the root of the server data directory.
+type snapshotFile struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Type     string    `json:"type"`
+	Size     uint64    `json:"size"`
+	Mode     string    `json:"mode"`
+	ModeBits string    `json:"mode_bits"`
+	ModTime  time.Time `json:"mtime"`
+}
+
+// GET /api/servers/:server/backups/restic/:backupId/files?path=
+func ListServerResticBackupFiles(c *gin.Context) {
+	serverId := c.Param("server")
+	backupId := c.Param("backupId")
+	if serverId == "" || backupId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing server or backup id"})
+		return
+	}
+	if !snapshotIDPattern.MatchString(backupId) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid backup id"})
+		return
+	}
+
+	encryptionKey, err := requestEncryptionKey(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if encryptionKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing encryption key"})
+		return
+	}
+
+	limit, offset := pageParams(c)
+
+	repo := resticsvc.RepoPath(resticsvc.ResolveRepoDir(serverId, c.Query("owner_username")))
+	resolvedKey, err := resticsvc.ResolveKey(repo, encryptionKey)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	volumePath := resticsvc.VolumePath(serverId)
+	dir := strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(c.Query("path"))), "/")
+
+	files, err := listSnapshotDir(resticsvc.NewClient(repo, resolvedKey), resolvedKey, backupId, volumePath, dir)
+	if err != nil {
+		switch {
+		case resticcli.IsTimeout(err):
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "file listing timed out"})
+		case resticcli.AsError(err).NotFound():
+			c.JSON(http.StatusNotFound, gin.H{"error": "backup or directory not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list files", "output": resticsvc.Output(err)})
+		}
+		return
+	}
+
+	page, nextCursor := paginate(files, limit, offset)
+	c.JSON(http.StatusOK, gin.H{
+		"path":        dir,
+		"files":       page,
+		"next_cursor": nextCursor,
+		"limit":       limit,
+		"total":       len(files),
+	})
+}
+
+// listSnapshotDir returns the entries of a directory within a snapshot, where
+// the directory is relative to the server data directory that was backed up.
+// Directories are listed before files, and both are sorted by name.
+func listSnapshotDir(client *resticcli.Client, key string, snapshot string, volumePath string, dir string) ([]snapshotFile, error) {
+	cacheKey := snapshotCacheKey(client, key, "ls", snapshot, dir)
+	if v, ok := listingCache.Get(cacheKey); ok {
+		return v.([]snapshotFile), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), resticsvc.Seconds(config.Get().Restic.Timeouts.Browse))
+	defer cancel()
+	nodes, err := client.List(ctx, snapshot, path.Join(filepath.ToSlash(volumePath), dir))
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]snapshotFile, 0, len(nodes))
+	for _, n := range nodes {
+		files = append(files, snapshotFile{
+			Name:     n.Name,
+			Path:     path.Join(dir, n.Name),
+			Type:     n.Type,
+			Size:     n.Size,
+			Mode:     n.Mode.String(),
+			ModeBits: strconv.FormatUint(uint64(n.Mode.Perm()), 8),
+			ModTime:  n.ModTime,
+		})
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if (files[i].Type == resticcli.NodeDir) != (files[j].Type == resticcli.NodeDir) {
+			return files[i].Type == resticcli.NodeDir
+		}
+		return files[i].Name < files[j].Name
+	})
+
+	listingCache.Set(cacheKey, files, cache.DefaultExpiration)
+	return files, nil
+}
+
+// snapshotCacheKey returns the key that a result read from the repository of
+// the client is cached under. The restic key is part of the cache key so that a
+// result is never returned for a request that could not open the repository.
+func snapshotCacheKey(client *resticcli.Client, key string, parts ...string) string {
+	sum := sha256.Sum256([]byte(key))
+	return strings.Join(append([]string{client.Repository(), hex.EncodeToString(sum[:])}, parts...), "\x00")
+}
+
+// pageParams returns the limit and offset requested using the limit and cursor
+// query parameters.
+func pageParams(c *gin.Context) (int, int) {
+	limit := defaultPageLimit
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 {
+		limit = min(v, maxPageLimit)
+	}
+	offset := 0
+	if v, err := strconv.Atoi(c.Query("cursor")); err == nil && v > 0 {
+		offset = v
+	}
+	return limit, offset
+}
+
+// paginate returns a page of items along with the cursor for the next page,
+// which is empty when there are no more items.
+func paginate[T any](items []T, limit int, offset int) ([]T, string) {
+	page := []T{}
+	if offset < len(items) {
+		page = items[offset:]
+	}
+	if len(page) > limit {
+		return page[:limit], strconv.Itoa(offset + limit)
+	}
+	return page, ""
+}
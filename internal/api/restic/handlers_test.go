@@ -0,0 +1,1974 @@
+package restic
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+	"github.com/gin-gonic/gin"
+	"github.com/go-co-op/gocron"
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/environment"
+	"github.com/pterodactyl/wings/internal/database"
+	"github.com/pterodactyl/wings/internal/models"
+	"github.com/pterodactyl/wings/internal/resticsvc"
+	"github.com/pterodactyl/wings/server"
+)
+
+const (
+	testServer = "5a5c0f2e-1111-4222-8333-944445555666"
+	testOwner  = "owner"
+	testKey    = "secret"
+)
+
+var testRepoDir = testServer + "+" + testOwner
+
+const threeSnapshots = `[
+	{"id":"aaaaaaaa00000000","short_id":"aaaaaaaa","time":"2025-01-01T00:00:00Z","tree":"t","paths":["/data"],"tags":["locked"]},
+	{"id":"bbbbbbbb00000000","short_id":"bbbbbbbb","time":"2025-01-02T00:00:00Z","tree":"t","paths":["/data"],"summary":{"total_bytes_processed":512}},
+	{"id":"cccccccc00000000","short_id":"cccccccc","time":"2025-01-03T00:00:00Z","tree":"t","paths":["/data"]}
+]`
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestCreateServerResticBackup(t *testing.T) {
+	g := Goblin(t)
+	var f *fakeRestic
+
+	params := gin.Params{{Key: "server", Value: testServer}}
+	body := func(extra map[string]interface{}) map[string]interface{} {
+		b := map[string]interface{}{"owner_username": testOwner, "encryption_key": testKey}
+		for k, v := range extra {
+			b[k] = v
+		}
+		return b
+	}
+
+	g.Describe("CreateServerResticBackup", func() {
+		g.BeforeEach(func() {
+			f = newFakeRestic(t)
+		})
+
+		g.It("requires an encryption key", func() {
+			w := f.request(CreateServerResticBackup, http.MethodPost, "/", params, map[string]interface{}{"owner_username": testOwner}, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusBadRequest)
+			g.Assert(len(f.calls("backup"))).Equal(0)
+		})
+
+		g.It("initializes the repository and creates a snapshot", func() {
+			w := f.request(CreateServerResticBackup, http.MethodPost, "/", params, body(nil), f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(decode(w)["snapshot_id"]).Equal("0123456789abcdef0123456789abcdef")
+
+			g.Assert(len(f.calls("init"))).Equal(1)
+			g.Assert(f.calls("backup")).Equal([]string{"backup --json " + filepath.Join(f.data, testServer)})
+
+			g.Assert(f.storedKey(testRepoDir)).Equal(testKey)
+			_, err := os.Stat(filepath.Join(f.repoBase, testRepoDir, resticsvc.LegacyKeyFile))
+			g.Assert(os.IsNotExist(err)).IsTrue()
+
+			status, err := resticsvc.ReadBackupStatus(testServer)
+			g.Assert(err).IsNil()
+			g.Assert(status.Status).Equal("completed")
+		})
+
+		g.It("forgets the oldest unlocked snapshot when the limit is reached", func() {
+			f.initRepo(testRepoDir, testKey)
+			f.respond("snapshots", 0, threeSnapshots, "", 0)
+
+			w := f.request(CreateServerResticBackup, http.MethodPost, "/", params, body(map[string]interface{}{"max_backups": 3}), f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(f.calls("forget")).Equal([]string{"forget bbbbbbbb00000000 --prune"})
+			g.Assert(len(f.calls("backup"))).Equal(1)
+		})
+
+		g.It("does not forget a recent pre-restore snapshot to make room", func() {
+			f.initRepo(testRepoDir, testKey)
+			recent := time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)
+			f.respond("snapshots", 0, `[
+				{"id":"aaaaaaaa00000000","time":"2025-01-01T00:00:00Z","tree":"t","paths":["/data"],"tags":["pre-restore"]},
+				{"id":"bbbbbbbb00000000","time":"`+recent+`","tree":"t","paths":["/data"],"tags":["pre-restore"]},
+				{"id":"cccccccc00000000","time":"2025-01-02T00:00:00Z","tree":"t","paths":["/data"]}
+			]`, "", 0)
+
+			w := f.request(CreateServerResticBackup, http.MethodPost, "/", params, body(map[string]interface{}{"max_backups": 2}), f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(f.calls("forget")).Equal([]string{"forget aaaaaaaa00000000 --prune", "forget cccccccc00000000 --prune"})
+		})
+
+		g.It("refuses to back up when every snapshot is locked", func() {
+			f.initRepo(testRepoDir, testKey)
+			f.respond("snapshots", 0, `[{"id":"aaaaaaaa00000000","time":"2025-01-01T00:00:00Z","tree":"t","paths":["/data"],"tags":["locked"]}]`, "", 0)
+
+			w := f.request(CreateServerResticBackup, http.MethodPost, "/", params, body(map[string]interface{}{"max_backups": 1}), f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusBadRequest)
+			g.Assert(len(f.calls("forget"))).Equal(0)
+			g.Assert(len(f.calls("backup"))).Equal(0)
+		})
+
+		g.It("unlocks a stale lock and retries the backup", func() {
+			f.initRepo(testRepoDir, testKey)
+			created := time.Now().UTC().Add(-2 * time.Hour).Format("2006-01-02 15:04:05")
+			f.respond("backup", 1, "", "repository is already locked by PID 1\nlock was created at "+created+" (2h0m0s ago)\n", 11)
+
+			w := f.request(CreateServerResticBackup, http.MethodPost, "/", params, body(nil), f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(len(f.calls("unlock"))).Equal(1)
+			g.Assert(len(f.calls("backup"))).Equal(2)
+		})
+
+		g.It("reports a busy repository when the lock is not stale", func() {
+			f.initRepo(testRepoDir, testKey)
+			created := time.Now().UTC().Add(-time.Minute).Format("2006-01-02 15:04:05")
+			f.respond("backup", 0, "", "repository is already locked by PID 1\nlock was created at "+created+" (1m0s ago)\n", 11)
+
+			w := f.request(CreateServerResticBackup, http.MethodPost, "/", params, body(nil), f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusConflict)
+			g.Assert(len(f.calls("unlock"))).Equal(0)
+
+			status, _ := resticsvc.ReadBackupStatus(testServer)
+			g.Assert(status.Status).Equal("failed")
+		})
+
+		g.It("reinitializes a new empty repository when the key does not match", func() {
+			f.initRepo(testRepoDir, testKey)
+			f.respond("backup", 1, "", "Fatal: wrong password or no key found\n", 12)
+
+			w := f.request(CreateServerResticBackup, http.MethodPost, "/", params, body(nil), f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(len(f.calls("init"))).Equal(1)
+			g.Assert(len(f.calls("backup"))).Equal(2)
+		})
+
+		g.It("records the backup in the activity log", func() {
+			f.header.Set("X-Activity-User", "not-a-uuid")
+			f.header.Set("X-Activity-Ip", "203.0.113.20")
+			s := f.newServer(testServer)
+
+			w := f.request(CreateServerResticBackup, http.MethodPost, "/", params, body(nil), s)
+			g.Assert(w.Code).Equal(http.StatusOK)
+
+			a := activity(s, server.ActivityResticBackup, "203.0.113.20")
+			g.Assert(a == nil).IsFalse()
+			g.Assert(a.User.Valid).IsFalse()
+			g.Assert(a.Metadata["snapshot_id"]).Equal("0123456789abcdef0123456789abcdef")
+			g.Assert(a.Metadata["result"]).Equal("success")
+		})
+
+		g.It("publishes the progress reported by restic", func() {
+			f.respond("backup", 0, `{"message_type":"status","percent_done":0.5,"total_files":4,"files_done":2,"total_bytes":100,"bytes_done":50,"seconds_remaining":3}
+{"message_type":"status","percent_done":1,"total_files":4,"files_done":4,"total_bytes":100,"bytes_done":100}
+{"message_type":"summary","snapshot_id":"0123456789abcdef0123456789abcdef"}`, "", 0)
+			s := f.newServer(testServer)
+			published := listen(s)
+
+			w := f.request(CreateServerResticBackup, http.MethodPost, "/", params, body(nil), s)
+			g.Assert(w.Code).Equal(http.StatusOK)
+
+			evts := published()
+			g.Assert(len(evts)).Equal(2)
+			g.Assert(evts[0].Topic).Equal(server.ResticBackupProgressEvent)
+			first := evts[0].Data.(map[string]interface{})
+			g.Assert(first["percent_done"]).Equal(0.5)
+			g.Assert(first["bytes_done"]).Equal(float64(50))
+			g.Assert(first["seconds_remaining"]).Equal(float64(3))
+			g.Assert(evts[1].Data.(map[string]interface{})["files_done"]).Equal(float64(4))
+		})
+
+		g.It("excludes the default rules and those in the .pteroignore file", func() {
+			config.Update(func(c *config.Configuration) {
+				c.Restic.DefaultExcludes = []string{"*.log", "cache/"}
+			})
+			s := f.newServer(testServer)
+			volume := filepath.Join(f.data, testServer)
+			g.Assert(os.WriteFile(filepath.Join(volume, ".pteroignore"), []byte("# comment\n/world/region\n!logs/keep.log\n"), 0o644)).IsNil()
+
+			w := f.request(CreateServerResticBackup, http.MethodPost, "/", params, body(nil), s)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(strings.Contains(f.calls("backup")[0], "--exclude-file ")).IsTrue()
+
+			b, err := os.ReadFile(filepath.Join(f.state, "exclude-file"))
+			g.Assert(err).IsNil()
+			g.Assert(strings.Split(strings.TrimSpace(string(b)), "\n")).Equal([]string{
+				volume + "/**/*.log",
+				volume + "/**/cache",
+				volume + "/world/region",
+				"!" + volume + "/logs/keep.log",
+			})
+		})
+	})
+}
+
+func TestListServerResticBackups(t *testing.T) {
+	g := Goblin(t)
+	var f *fakeRestic
+
+	params := gin.Params{{Key: "server", Value: testServer}}
+	query := "/?owner_username=" + testOwner + "&encryption_key=" + testKey
+
+	g.Describe("ListServerResticBackups", func() {
+		g.BeforeEach(func() {
+			f = newFakeRestic(t)
+			f.initRepo(testRepoDir, testKey)
+			f.respond("snapshots", 0, threeSnapshots, "", 0)
+		})
+
+		g.It("accepts the encryption key in a header", func() {
+			f.header.Set("X-Restic-Encryption-Key", testKey)
+			w := f.request(ListServerResticBackups, http.MethodGet, "/?owner_username="+testOwner, params, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusOK)
+		})
+
+		g.It("rejects an encryption key in the query string when configured to", func() {
+			config.Update(func(c *config.Configuration) {
+				c.Restic.RejectQueryKeys = true
+			})
+			w := f.request(ListServerResticBackups, http.MethodGet, query, params, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusBadRequest)
+			g.Assert(len(f.calls("snapshots"))).Equal(0)
+
+			f.header.Set("X-Restic-Encryption-Key", testKey)
+			w = f.request(ListServerResticBackups, http.MethodGet, "/?owner_username="+testOwner, params, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusOK)
+		})
+
+		g.It("only requests the latest snapshots when not filtering", func() {
+			w := f.request(ListServerResticBackups, http.MethodGet, query, params, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(f.calls("snapshots")).Equal([]string{"snapshots --json --no-lock --latest 25"})
+			g.Assert(decode(w)["total"]).IsNil()
+		})
+
+		g.It("returns the newest snapshots first with lock state and size", func() {
+			w := f.request(ListServerResticBackups, http.MethodGet, query+"&since=2024-01-01", params, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusOK)
+
+			res := decode(w)
+			backups := res["backups"].([]interface{})
+			g.Assert(len(backups)).Equal(3)
+			g.Assert(res["total"]).Equal(float64(3))
+
+			first := backups[0].(map[string]interface{})
+			g.Assert(first["id"]).Equal("cccccccc00000000")
+			g.Assert(first["locked"]).IsFalse()
+			g.Assert(first["size"]).IsNil()
+
+			second := backups[1].(map[string]interface{})
+			g.Assert(second["size"]).Equal(float64(512))
+
+			last := backups[2].(map[string]interface{})
+			g.Assert(last["locked"]).IsTrue()
+		})
+
+		g.It("paginates using the cursor", func() {
+			w := f.request(ListServerResticBackups, http.MethodGet, query+"&since=2024-01-01&limit=2", params, nil, nil)
+			res := decode(w)
+			g.Assert(len(res["backups"].([]interface{}))).Equal(2)
+			g.Assert(res["next_cursor"]).Equal("2025-01-02T00:00:00Z")
+
+			w = f.request(ListServerResticBackups, http.MethodGet, query+"&limit=2&cursor=2025-01-02T00:00:00Z", params, nil, nil)
+			res = decode(w)
+			backups := res["backups"].([]interface{})
+			g.Assert(len(backups)).Equal(1)
+			g.Assert(backups[0].(map[string]interface{})["id"]).Equal("aaaaaaaa00000000")
+			g.Assert(res["next_cursor"]).Equal("")
+		})
+
+		g.It("filters snapshots by date", func() {
+			w := f.request(ListServerResticBackups, http.MethodGet, query+"&since=2025-01-01T12:00:00Z&until=2025-01-02T12:00:00Z", params, nil, nil)
+			res := decode(w)
+			backups := res["backups"].([]interface{})
+			g.Assert(len(backups)).Equal(1)
+			g.Assert(backups[0].(map[string]interface{})["id"]).Equal("bbbbbbbb00000000")
+		})
+	})
+}
+
+func TestRollbackServerResticRestore(t *testing.T) {
+	g := Goblin(t)
+	var f *fakeRestic
+
+	params := gin.Params{{Key: "server", Value: testServer}}
+	body := map[string]interface{}{"owner_username": testOwner, "encryption_key": testKey}
+
+	g.Describe("RollbackServerResticRestore", func() {
+		g.BeforeEach(func() {
+			f = newFakeRestic(t)
+			f.initRepo(testRepoDir, testKey)
+		})
+
+		g.It("restores the most recent pre-restore snapshot", func() {
+			f.respond("snapshots", 0, `[
+				{"id":"aaaaaaaa00000000","time":"2025-01-01T00:00:00Z","tree":"t","paths":["/data"],"tags":["pre-restore","restore-source:cccccccc"]},
+				{"id":"bbbbbbbb00000000","time":"2025-01-03T00:00:00Z","tree":"t","paths":["/data"],"tags":["pre-restore","restore-source:dddddddd"]},
+				{"id":"eeeeeeee00000000","time":"2025-01-04T00:00:00Z","tree":"t","paths":["/data"]}
+			]`, "", 0)
+
+			w := f.request(RollbackServerResticRestore, http.MethodPost, "/", params, body, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(f.calls("restore")).Equal([]string{"restore bbbbbbbb00000000 --json --target / --path " + filepath.Join(f.data, testServer)})
+		})
+
+		g.It("returns not found when there is no pre-restore snapshot", func() {
+			f.respond("snapshots", 0, threeSnapshots, "", 0)
+
+			w := f.request(RollbackServerResticRestore, http.MethodPost, "/", params, body, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusNotFound)
+			g.Assert(len(f.calls("restore"))).Equal(0)
+		})
+	})
+}
+
+func TestDeleteServerResticBackup(t *testing.T) {
+	g := Goblin(t)
+	var f *fakeRestic
+
+	params := func(id string) gin.Params {
+		return gin.Params{{Key: "server", Value: testServer}, {Key: "backupId", Value: id}}
+	}
+	body := map[string]interface{}{"owner_username": testOwner, "encryption_key": testKey}
+
+	g.Describe("DeleteServerResticBackup", func() {
+		g.BeforeEach(func() {
+			f = newFakeRestic(t)
+			f.initRepo(testRepoDir, testKey)
+			f.respond("snapshots", 0, threeSnapshots, "", 0)
+		})
+
+		g.It("does not delete a locked snapshot", func() {
+			w := f.request(DeleteServerResticBackup, http.MethodDelete, "/", params("aaaaaaaa"), body, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusConflict)
+			g.Assert(len(f.calls("forget"))).Equal(0)
+		})
+
+		g.It("resolves a short id and forgets the snapshot", func() {
+			w := f.request(DeleteServerResticBackup, http.MethodDelete, "/", params("bbbbbbbb"), body, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(f.calls("forget")).Equal([]string{"forget bbbbbbbb00000000 --prune"})
+		})
+
+		g.It("records the deletion in the activity log", func() {
+			f.header.Set("X-Activity-User", "4f6ad8b1-1c6f-4c35-9e49-7c5bd9a0f3a2")
+			f.header.Set("X-Activity-Ip", "203.0.113.10")
+			s := f.newServer(testServer)
+
+			w := f.request(DeleteServerResticBackup, http.MethodDelete, "/", params("bbbbbbbb"), body, s)
+			g.Assert(w.Code).Equal(http.StatusOK)
+
+			a := activity(s, server.ActivityResticDelete, "203.0.113.10")
+			g.Assert(a == nil).IsFalse()
+			g.Assert(a.User.String).Equal("4f6ad8b1-1c6f-4c35-9e49-7c5bd9a0f3a2")
+			g.Assert(a.Metadata["snapshot_id"]).Equal("bbbbbbbb00000000")
+			g.Assert(a.Metadata["bytes"]).Equal(float64(512))
+			g.Assert(a.Metadata["result"]).Equal("success")
+		})
+
+		g.It("returns not found when restic cannot find the snapshot", func() {
+			f.respond("forget", 0, "", "Ignoring \"dddddddd\": snapshot dddddddd not found\n", 1)
+
+			w := f.request(DeleteServerResticBackup, http.MethodDelete, "/", params("dddddddd"), body, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusNotFound)
+		})
+	})
+}
+
+func TestPruneServerResticBackup(t *testing.T) {
+	g := Goblin(t)
+	var f *fakeRestic
+
+	params := gin.Params{{Key: "server", Value: testServer}}
+	body := func(rules map[string]interface{}) map[string]interface{} {
+		b := map[string]interface{}{"owner_username": testOwner, "encryption_key": testKey}
+		for k, v := range rules {
+			b[k] = v
+		}
+		return b
+	}
+
+	g.Describe("PruneServerResticBackup", func() {
+		g.BeforeEach(func() {
+			f = newFakeRestic(t)
+			f.initRepo(testRepoDir, testKey)
+		})
+
+		g.It("requires at least one retention rule", func() {
+			w := f.request(PruneServerResticBackup, http.MethodPost, "/", params, body(map[string]interface{}{"keep_last": nil}), f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusBadRequest)
+			g.Assert(len(f.calls("forget"))).Equal(0)
+		})
+
+		g.It("applies the policy while keeping locked snapshots", func() {
+			w := f.request(PruneServerResticBackup, http.MethodPost, "/", params, body(map[string]interface{}{"keep_last": 3, "keep_within": "7d"}), f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(f.calls("forget")).Equal([]string{"forget --prune --group-by host --keep-tag locked --keep-last 3 --keep-within 7d"})
+
+			status, err := resticsvc.ReadPruneStatus(testServer)
+			g.Assert(err).IsNil()
+			g.Assert(status.Status).Equal("completed")
+		})
+
+		g.It("reports a busy repository", func() {
+			f.respond("forget", 0, "", "unable to create lock in backend: repository is already locked\n", 11)
+
+			w := f.request(PruneServerResticBackup, http.MethodPost, "/", params, body(map[string]interface{}{"keep_daily": 7}), f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusConflict)
+
+			status, _ := resticsvc.ReadPruneStatus(testServer)
+			g.Assert(status.Status).Equal("failed")
+		})
+	})
+}
+
+func TestRestoreServerResticBackup(t *testing.T) {
+	g := Goblin(t)
+	var f *fakeRestic
+
+	params := gin.Params{{Key: "server", Value: testServer}, {Key: "backupId", Value: "bbbbbbbb"}}
+	body := map[string]interface{}{"owner_username": testOwner, "encryption_key": testKey}
+
+	g.Describe("RestoreServerResticBackupHandler", func() {
+		g.BeforeEach(func() {
+			f = newFakeRestic(t)
+			f.initRepo(testRepoDir, testKey)
+		})
+
+		g.It("requires the owner and encryption key", func() {
+			w := f.request(RestoreServerResticBackupHandler, http.MethodPost, "/", params, map[string]interface{}{}, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusBadRequest)
+		})
+
+		g.It("restores the snapshot over the server data directory", func() {
+			w := f.request(RestoreServerResticBackupHandler, http.MethodPost, "/", params, body, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(f.calls("restore")).Equal([]string{"restore bbbbbbbb --json --target / --path " + filepath.Join(f.data, testServer)})
+
+			status, err := resticsvc.ReadRestoreStatus(testServer)
+			g.Assert(err).IsNil()
+			g.Assert(status.Status).Equal("completed")
+		})
+
+		g.It("restores with the key stored for the repository", func() {
+			f.initRepo(testRepoDir, "stored-key")
+			w := f.request(RestoreServerResticBackupHandler, http.MethodPost, "/", params, body, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusOK)
+			passwords := f.passwords()
+			g.Assert(passwords[len(passwords)-1]).Equal("stored-key")
+		})
+
+		g.It("snapshots the server before restoring over it", func() {
+			w := f.request(RestoreServerResticBackupHandler, http.MethodPost, "/", params, body, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(decode(w)["pre_restore_snapshot_id"]).Equal("0123456789abcdef0123456789abcdef")
+			g.Assert(f.calls("backup")).Equal([]string{"backup --json --tag pre-restore --tag restore-source:bbbbbbbb " + filepath.Join(f.data, testServer)})
+
+			status, _ := resticsvc.ReadRestoreStatus(testServer)
+			g.Assert(status.PreRestoreSnapshotID).Equal("0123456789abcdef0123456789abcdef")
+		})
+
+		g.It("does not restore if the pre-restore snapshot fails", func() {
+			f.respond("backup", 0, "", "Fatal: unable to save snapshot\n", 1)
+
+			w := f.request(RestoreServerResticBackupHandler, http.MethodPost, "/", params, body, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusInternalServerError)
+			g.Assert(len(f.calls("restore"))).Equal(0)
+		})
+
+		g.It("refuses to restore over a running server when asked to", func() {
+			s := f.newServer(testServer)
+			s.Environment.SetState(environment.ProcessRunningState)
+
+			w := f.request(RestoreServerResticBackupHandler, http.MethodPost, "/", params, map[string]interface{}{"owner_username": testOwner, "encryption_key": testKey, "mode": "refuse"}, s)
+			g.Assert(w.Code).Equal(http.StatusConflict)
+			g.Assert(len(f.calls("restore"))).Equal(0)
+		})
+
+		g.It("does not restore while another restore is in progress", func() {
+			s := f.newServer(testServer)
+			s.SetRestoring(true)
+
+			w := f.request(RestoreServerResticBackupHandler, http.MethodPost, "/", params, body, s)
+			g.Assert(w.Code).Equal(http.StatusConflict)
+			g.Assert(len(f.calls("restore"))).Equal(0)
+		})
+
+		g.It("restores only the requested paths", func() {
+			vol := filepath.Join(f.data, testServer)
+
+			w := f.request(RestoreServerResticBackupHandler, http.MethodPost, "/", params, map[string]interface{}{"owner_username": testOwner, "encryption_key": testKey, "paths": []string{"/world/", "config[1].yml"}}, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(f.calls("restore")).Equal([]string{"restore bbbbbbbb:" + vol + " --json --target " + vol + " --include /world --include /config\\[1].yml"})
+		})
+
+		g.It("rejects paths outside of the server data directory", func() {
+			s := f.newServer(testServer)
+			g.Assert(os.Symlink(f.data, filepath.Join(s.Filesystem().Path(), "escape"))).IsNil()
+
+			w := f.request(RestoreServerResticBackupHandler, http.MethodPost, "/", params, map[string]interface{}{"owner_username": testOwner, "encryption_key": testKey, "paths": []string{"escape/other"}}, s)
+			g.Assert(w.Code).Equal(http.StatusBadRequest)
+			w = f.request(RestoreServerResticBackupHandler, http.MethodPost, "/", params, map[string]interface{}{"owner_username": testOwner, "encryption_key": testKey, "paths": []string{"../"}}, s)
+			g.Assert(w.Code).Equal(http.StatusBadRequest)
+			g.Assert(len(f.calls("restore"))).Equal(0)
+		})
+
+		g.It("restores into a new folder without stopping the server", func() {
+			vol := filepath.Join(f.data, testServer)
+			s := f.newServer(testServer)
+			s.Environment.SetState(environment.ProcessRunningState)
+
+			w := f.request(RestoreServerResticBackupHandler, http.MethodPost, "/", params, map[string]interface{}{"owner_username": testOwner, "encryption_key": testKey, "mode": "refuse", "destination": "folder"}, s)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(decode(w)["restored_to"]).Equal("restored-bbbbbbbb")
+			g.Assert(f.calls("restore")).Equal([]string{"restore bbbbbbbb:" + vol + " --json --target " + restoreStagingPath(testServer)})
+			g.Assert(len(f.calls("backup"))).Equal(0)
+
+			b, err := os.ReadFile(filepath.Join(vol, "restored-bbbbbbbb", "restored.txt"))
+			g.Assert(err).IsNil()
+			g.Assert(string(b)).Equal("restored\n")
+			_, err = os.Stat(restoreStagingPath(testServer))
+			g.Assert(os.IsNotExist(err)).IsTrue()
+		})
+
+		g.It("does not move a restored folder through a symbolic link", func() {
+			s := f.newServer(testServer)
+			outside := filepath.Join(f.repoBase, "outside")
+			g.Assert(os.MkdirAll(outside, 0o755)).IsNil()
+			g.Assert(os.Symlink(outside, filepath.Join(s.Filesystem().Path(), "restored-bbbbbbbb"))).IsNil()
+			staging := restoreStagingPath(testServer)
+			g.Assert(os.MkdirAll(staging, 0o700)).IsNil()
+			g.Assert(os.WriteFile(filepath.Join(staging, "restored.txt"), []byte("restored"), 0o644)).IsNil()
+
+			g.Assert(moveRestoredFolder(s, staging, "restored-bbbbbbbb")).IsNotNil()
+			entries, err := os.ReadDir(outside)
+			g.Assert(err).IsNil()
+			g.Assert(len(entries)).Equal(0)
+		})
+
+		g.It("rejects an unknown restore destination", func() {
+			w := f.request(RestoreServerResticBackupHandler, http.MethodPost, "/", params, map[string]interface{}{"owner_username": testOwner, "encryption_key": testKey, "destination": "elsewhere"}, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusBadRequest)
+		})
+
+		g.It("rejects an unknown restore mode", func() {
+			w := f.request(RestoreServerResticBackupHandler, http.MethodPost, "/?mode=ignore", params, body, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusBadRequest)
+		})
+
+		g.It("clears the restoring state once the restore has finished", func() {
+			s := f.newServer(testServer)
+
+			w := f.request(RestoreServerResticBackupHandler, http.MethodPost, "/", params, body, s)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(s.IsRestoring()).IsFalse()
+		})
+
+		g.It("publishes the progress with an estimated time remaining", func() {
+			f.respond("restore", 0, `{"message_type":"status","seconds_elapsed":10,"percent_done":0.25,"total_files":8,"files_restored":2,"total_bytes":400,"bytes_restored":100}`, "", 0)
+			s := f.newServer(testServer)
+			published := listen(s)
+
+			w := f.request(RestoreServerResticBackupHandler, http.MethodPost, "/", params, body, s)
+			g.Assert(w.Code).Equal(http.StatusOK)
+
+			evts := published()
+			g.Assert(len(evts)).Equal(1)
+			g.Assert(evts[0].Topic).Equal(server.ResticRestoreProgressEvent)
+			data := evts[0].Data.(map[string]interface{})
+			g.Assert(data["snapshot_id"]).Equal("bbbbbbbb")
+			g.Assert(data["files_done"]).Equal(float64(2))
+			g.Assert(data["seconds_remaining"]).Equal(float64(30))
+		})
+
+		g.It("records the failure reported by restic", func() {
+			f.respond("restore", 0, "", "Fatal: no matching ID found\n", 1)
+
+			w := f.request(RestoreServerResticBackupHandler, http.MethodPost, "/", params, body, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusInternalServerError)
+
+			status, _ := resticsvc.ReadRestoreStatus(testServer)
+			g.Assert(status.Status).Equal("failed")
+			g.Assert(status.Message).Equal("restic restore failed: Fatal: no matching ID found")
+		})
+	})
+}
+
+func TestPrepareAndDownloadServerResticBackup(t *testing.T) {
+	g := Goblin(t)
+	var f *fakeRestic
+
+	params := gin.Params{{Key: "server", Value: testServer}, {Key: "backupId", Value: "bbbbbbbb"}}
+	body := map[string]interface{}{"owner_username": testOwner, "encryption_key": testKey}
+
+	g.Describe("PrepareServerResticBackupHandler", func() {
+		g.BeforeEach(func() {
+			f = newFakeRestic(t)
+			f.initRepo(testRepoDir, testKey)
+		})
+
+		g.It("restores the snapshot into an archive that can be streamed once", func() {
+			s := f.newServer(testServer)
+			w := f.request(PrepareServerResticBackupHandler, http.MethodPost, "/", params, body, s)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(len(f.calls("restore"))).Equal(1)
+
+			w = f.request(func(c *gin.Context) { StreamPreparedResticBackup(c, s, "bbbbbbbb") }, http.MethodGet, "/", params, nil, s)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(w.Body.Len() > 0).IsTrue()
+			g.Assert(strings.Contains(w.Header().Get("Content-Disposition"), "backup-bbbbbbbb.tar.")).IsTrue()
+
+			w = f.request(func(c *gin.Context) { StreamPreparedResticBackup(c, s, "bbbbbbbb") }, http.MethodGet, "/", params, nil, s)
+			g.Assert(w.Code).Equal(http.StatusInternalServerError)
+		})
+
+		g.It("restores with the key stored for the repository", func() {
+			f.initRepo(testRepoDir, "stored-key")
+			w := f.request(PrepareServerResticBackupHandler, http.MethodPost, "/", params, body, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(f.passwords()).Equal([]string{"stored-key"})
+		})
+
+		g.It("does not leave restored files behind when restic fails", func() {
+			f.respond("restore", 0, "", "Fatal: no matching ID found\n", 1)
+
+			w := f.request(PrepareServerResticBackupHandler, http.MethodPost, "/", params, body, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusInternalServerError)
+
+			entries, _ := os.ReadDir(resticsvc.TempDir())
+			g.Assert(len(entries)).Equal(0)
+		})
+	})
+
+	g.Describe("DownloadServerResticBackup", func() {
+		g.BeforeEach(func() {
+			f = newFakeRestic(t)
+			f.initRepo(testRepoDir, testKey)
+		})
+
+		g.It("requires the owner and encryption key", func() {
+			w := f.request(DownloadServerResticBackup, http.MethodGet, "/", params, nil, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusBadRequest)
+		})
+
+		g.It("prepares and streams the archive when asked to", func() {
+			w := f.request(DownloadServerResticBackup, http.MethodGet, "/?mode=prepared&owner_username="+testOwner+"&encryption_key="+testKey, params, nil, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(w.Body.Len() > 0).IsTrue()
+			g.Assert(w.Header().Get("Content-Length") != "").IsTrue()
+		})
+
+		g.It("streams a compressed archive from restic dump without staging it", func() {
+			f.respond("dump", 0, "tar-data", "", 0)
+
+			w := f.request(DownloadServerResticBackup, http.MethodGet, "/?owner_username="+testOwner+"&encryption_key="+testKey, params, nil, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(w.Header().Get("Content-Type")).Equal("application/zstd")
+			g.Assert(w.Header().Get("Content-Disposition")).Equal("attachment; filename=backup-bbbbbbbb.tar.zst")
+			g.Assert(f.calls("dump")).Equal([]string{"dump --no-lock --archive tar bbbbbbbb:" + filepath.Join(f.data, testServer) + " /"})
+			g.Assert(len(f.calls("restore"))).Equal(0)
+
+			dec, err := zstd.NewReader(w.Body)
+			g.Assert(err).IsNil()
+			b, err := io.ReadAll(dec)
+			g.Assert(err).IsNil()
+			g.Assert(string(b)).Equal("tar-data")
+		})
+
+		g.It("streams a gzip archive when asked to", func() {
+			f.respond("dump", 0, "tar-data", "", 0)
+
+			w := f.request(DownloadServerResticBackup, http.MethodGet, "/?format=gzip&owner_username="+testOwner+"&encryption_key="+testKey, params, nil, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(w.Header().Get("Content-Type")).Equal("application/gzip")
+
+			gr, err := gzip.NewReader(w.Body)
+			g.Assert(err).IsNil()
+			b, err := io.ReadAll(gr)
+			g.Assert(err).IsNil()
+			g.Assert(string(b)).Equal("tar-data")
+		})
+
+		g.It("streams from restic dump for a signed download that was not prepared", func() {
+			f.respond("dump", 0, "tar-data", "", 0)
+			s := f.newServer(testServer)
+
+			w := f.request(func(c *gin.Context) { DownloadServerResticBackupFromToken(c, s, "bbbbbbbb") }, http.MethodGet, "/", nil, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(len(f.calls("dump"))).Equal(1)
+		})
+
+		g.It("reports an error if restic fails before anything is sent", func() {
+			f.respond("dump", 0, "", "Fatal: failed to find snapshot: no matching ID found for prefix \"bbbbbbbb\"\n", 1)
+
+			w := f.request(DownloadServerResticBackup, http.MethodGet, "/?owner_username="+testOwner+"&encryption_key="+testKey, params, nil, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusNotFound)
+			g.Assert(w.Header().Get("Content-Disposition")).Equal("")
+			g.Assert(strings.HasPrefix(w.Header().Get("Content-Type"), "application/json")).IsTrue()
+		})
+
+		g.It("rejects backup ids that are not snapshot ids", func() {
+			for _, id := range []string{"--help", "latest"} {
+				w := f.request(DownloadServerResticBackup, http.MethodGet, "/?owner_username="+testOwner+"&encryption_key="+testKey, gin.Params{{Key: "server", Value: testServer}, {Key: "backupId", Value: id}}, nil, f.newServer(testServer))
+				g.Assert(w.Code).Equal(http.StatusBadRequest)
+			}
+			s := f.newServer(testServer)
+			w := f.request(func(c *gin.Context) { DownloadServerResticBackupFromToken(c, s, "--help") }, http.MethodGet, "/", nil, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusBadRequest)
+			g.Assert(len(f.calls("dump"))).Equal(0)
+		})
+	})
+}
+
+func TestListServerResticBackupFiles(t *testing.T) {
+	g := Goblin(t)
+	var f *fakeRestic
+
+	params := gin.Params{{Key: "server", Value: testServer}, {Key: "backupId", Value: "bbbbbbbb"}}
+	query := "/?owner_username=" + testOwner + "&encryption_key=" + testKey
+
+	g.Describe("ListServerResticBackupFiles", func() {
+		g.BeforeEach(func() {
+			f = newFakeRestic(t)
+			f.initRepo(testRepoDir, testKey)
+
+			vol := filepath.Join(f.data, testServer)
+			f.respond("ls", 0, strings.Join([]string{
+				`{"time":"2025-01-02T00:00:00Z","tree":"t","paths":["` + vol + `"],"id":"bbbbbbbb00000000","short_id":"bbbbbbbb","message_type":"snapshot","struct_type":"snapshot"}`,
+				`{"name":"world","type":"dir","path":"` + vol + `/world","mode":2147484141,"mtime":"2025-01-01T00:00:00Z","message_type":"node","struct_type":"node"}`,
+				`{"name":"server.properties","type":"file","path":"` + vol + `/server.properties","size":42,"mode":420,"mtime":"2025-01-01T00:00:00Z","struct_type":"node"}`,
+				`{"name":"banned-ips.json","type":"file","path":"` + vol + `/banned-ips.json","size":2,"mode":420,"mtime":"2025-01-01T00:00:00Z","struct_type":"node"}`,
+			}, "\n"), "", 0)
+		})
+
+		g.It("lists a directory relative to the server data directory", func() {
+			w := f.request(ListServerResticBackupFiles, http.MethodGet, query+"&path=../", params, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(f.calls("ls")).Equal([]string{"ls --json --no-lock bbbbbbbb " + filepath.Join(f.data, testServer)})
+
+			res := decode(w)
+			g.Assert(res["total"]).Equal(float64(3))
+			files := res["files"].([]interface{})
+			g.Assert(len(files)).Equal(3)
+			world := files[0].(map[string]interface{})
+			g.Assert(world["path"]).Equal("world")
+			g.Assert(world["type"]).Equal("dir")
+			g.Assert(world["mode"]).Equal("drwxr-xr-x")
+			file := files[2].(map[string]interface{})
+			g.Assert(file["name"]).Equal("server.properties")
+			g.Assert(file["size"]).Equal(float64(42))
+			g.Assert(file["mode_bits"]).Equal("644")
+		})
+
+		g.It("paginates using the cursor", func() {
+			w := f.request(ListServerResticBackupFiles, http.MethodGet, query+"&path=/plugins&limit=2", params, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			res := decode(w)
+			g.Assert(res["path"]).Equal("plugins")
+			g.Assert(len(res["files"].([]interface{}))).Equal(2)
+			g.Assert(res["next_cursor"]).Equal("2")
+
+			w = f.request(ListServerResticBackupFiles, http.MethodGet, query+"&path=/plugins&limit=2&cursor=2", params, nil, nil)
+			res = decode(w)
+			g.Assert(len(res["files"].([]interface{}))).Equal(1)
+			g.Assert(res["next_cursor"]).Equal("")
+		})
+
+		g.It("caches the listing of each snapshot directory", func() {
+			for i := 0; i < 2; i++ {
+				w := f.request(ListServerResticBackupFiles, http.MethodGet, query+"&path=world", params, nil, nil)
+				g.Assert(w.Code).Equal(http.StatusOK)
+			}
+			g.Assert(len(f.calls("ls"))).Equal(1)
+		})
+
+		g.It("returns not found when restic cannot find the snapshot", func() {
+			f.respond("ls", 0, "", "Fatal: failed to find snapshot: no matching ID found for prefix \"bbbbbbbb\"\n", 1)
+
+			w := f.request(ListServerResticBackupFiles, http.MethodGet, query, params, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusNotFound)
+		})
+
+		g.It("rejects a backup id that is not a snapshot id", func() {
+			w := f.request(ListServerResticBackupFiles, http.MethodGet, query, gin.Params{{Key: "server", Value: testServer}, {Key: "backupId", Value: "--help"}}, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusBadRequest)
+			g.Assert(len(f.calls("ls"))).Equal(0)
+		})
+	})
+}
+
+func TestStreamResticFileFromToken(t *testing.T) {
+	g := Goblin(t)
+	var f *fakeRestic
+
+	stream := func(s *server.Server, file string) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			StreamResticFileFromToken(c, s, "bbbbbbbb", testOwner, file)
+		}
+	}
+
+	g.Describe("StreamResticFileFromToken", func() {
+		g.BeforeEach(func() {
+			f = newFakeRestic(t)
+			f.initRepo(testRepoDir, testKey)
+
+			vol := filepath.Join(f.data, testServer)
+			f.respond("ls", 0, strings.Join([]string{
+				`{"name":"plugins","type":"dir","path":"` + vol + `/config/plugins","mode":2147484141,"struct_type":"node"}`,
+				`{"name":"ops.json","type":"file","path":"` + vol + `/config/ops.json","size":8,"mode":420,"struct_type":"node"}`,
+			}, "\n"), "", 0)
+			f.respond("dump", 0, `{"a":1}`+"\n", "", 0)
+		})
+
+		g.It("streams the file with its type and length", func() {
+			s := f.newServer(testServer)
+
+			w := f.request(stream(s, "/config/../config/ops.json"), http.MethodGet, "/", nil, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(w.Body.String()).Equal(`{"a":1}` + "\n")
+			g.Assert(w.Header().Get("Content-Length")).Equal("8")
+			g.Assert(w.Header().Get("Content-Type")).Equal("application/json")
+			g.Assert(w.Header().Get("Content-Disposition")).Equal(`attachment; filename="ops.json"`)
+			g.Assert(f.calls("ls")).Equal([]string{"ls --json --no-lock bbbbbbbb " + filepath.Join(f.data, testServer, "config")})
+			g.Assert(f.calls("dump")).Equal([]string{"dump --no-lock bbbbbbbb " + filepath.Join(f.data, testServer, "config/ops.json")})
+		})
+
+		g.It("does not stream directories or missing files", func() {
+			s := f.newServer(testServer)
+
+			for _, file := range []string{"config/plugins", "config/missing.json", "/"} {
+				w := f.request(stream(s, file), http.MethodGet, "/", nil, nil, nil)
+				g.Assert(w.Code < 200 || w.Code >= 300).IsTrue()
+			}
+			g.Assert(len(f.calls("dump"))).Equal(0)
+		})
+
+		g.It("rejects a backup id that is not a snapshot id", func() {
+			s := f.newServer(testServer)
+			h := func(c *gin.Context) { StreamResticFileFromToken(c, s, "--help", testOwner, "config/ops.json") }
+
+			w := f.request(h, http.MethodGet, "/", nil, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusBadRequest)
+			g.Assert(len(f.calls("ls"))).Equal(0)
+			g.Assert(len(f.calls("dump"))).Equal(0)
+		})
+
+		g.It("reports an error if restic fails before writing the file", func() {
+			f.respond("dump", 0, "", "Fatal: pack not found\n", 1)
+			s := f.newServer(testServer)
+
+			w := f.request(stream(s, "config/ops.json"), http.MethodGet, "/", nil, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusInternalServerError)
+			g.Assert(w.Header().Get("Content-Length")).Equal("")
+		})
+	})
+}
+
+func TestDiffServerResticBackups(t *testing.T) {
+	g := Goblin(t)
+	var f *fakeRestic
+
+	params := gin.Params{{Key: "server", Value: testServer}}
+	query := "/?owner_username=" + testOwner + "&encryption_key=" + testKey + "&from=aaaaaaaa&to=bbbbbbbb"
+
+	g.Describe("DiffServerResticBackups", func() {
+		g.BeforeEach(func() {
+			f = newFakeRestic(t)
+			f.initRepo(testRepoDir, testKey)
+
+			vol := filepath.Join(f.data, testServer)
+			f.respond("diff", 0, strings.Join([]string{
+				`{"message_type":"change","path":"` + vol + `/world/","modifier":"M"}`,
+				`{"message_type":"change","path":"` + vol + `/world/level.dat","modifier":"M"}`,
+				`{"message_type":"change","path":"` + vol + `/plugins/grief.jar","modifier":"+"}`,
+				`{"message_type":"change","path":"` + vol + `/ops.json","modifier":"-"}`,
+				`{"message_type":"statistics","source_snapshot":"aaaaaaaa","target_snapshot":"bbbbbbbb","changed_files":1,"added":{"files":1,"bytes":300},"removed":{"files":1,"bytes":20}}`,
+			}, "\n"), "", 0)
+			f.respond("ls", 1, strings.Join([]string{
+				`{"name":"level.dat","type":"file","path":"` + vol + `/world/level.dat","size":100,"struct_type":"node"}`,
+				`{"name":"ops.json","type":"file","path":"` + vol + `/ops.json","size":20,"struct_type":"node"}`,
+			}, "\n"), "", 0)
+			f.respond("ls", 2, strings.Join([]string{
+				`{"name":"level.dat","type":"file","path":"` + vol + `/world/level.dat","size":150,"struct_type":"node"}`,
+				`{"name":"grief.jar","type":"file","path":"` + vol + `/plugins/grief.jar","size":300,"struct_type":"node"}`,
+			}, "\n"), "", 0)
+		})
+
+		g.It("requires valid snapshot ids", func() {
+			w := f.request(DiffServerResticBackups, http.MethodGet, "/?encryption_key="+testKey+"&from=--help&to=bbbbbbbb", params, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusBadRequest)
+			g.Assert(len(f.calls("diff"))).Equal(0)
+		})
+
+		g.It("returns the changed paths with their size deltas", func() {
+			w := f.request(DiffServerResticBackups, http.MethodGet, query, params, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			vol := filepath.Join(f.data, testServer)
+			g.Assert(f.calls("diff")).Equal([]string{"diff --json --no-lock aaaaaaaa bbbbbbbb"})
+			g.Assert(f.calls("ls")).Equal([]string{"ls --json --no-lock --recursive aaaaaaaa " + vol, "ls --json --no-lock --recursive bbbbbbbb " + vol})
+
+			res := decode(w)
+			g.Assert(res["total"]).Equal(float64(4))
+			summary := res["summary"].(map[string]interface{})
+			g.Assert(summary["added"]).Equal(float64(1))
+			g.Assert(summary["modified"]).Equal(float64(2))
+			g.Assert(summary["bytes_added"]).Equal(float64(300))
+
+			changes := res["changes"].([]interface{})
+			first := changes[0].(map[string]interface{})
+			g.Assert(first["path"]).Equal("ops.json")
+			g.Assert(first["type"]).Equal("removed")
+			g.Assert(first["size_delta"]).Equal(float64(-20))
+			last := changes[3].(map[string]interface{})
+			g.Assert(last["path"]).Equal("world/level.dat")
+			g.Assert(last["size_delta"]).Equal(float64(50))
+			g.Assert(changes[2].(map[string]interface{})["directory"]).IsTrue()
+		})
+
+		g.It("filters and pages through the changes without running restic again", func() {
+			w := f.request(DiffServerResticBackups, http.MethodGet, query+"&type=modified&limit=1", params, nil, nil)
+			res := decode(w)
+			g.Assert(res["total"]).Equal(float64(2))
+			g.Assert(res["next_cursor"]).Equal("1")
+
+			w = f.request(DiffServerResticBackups, http.MethodGet, query+"&type=modified&limit=1&cursor=1", params, nil, nil)
+			res = decode(w)
+			g.Assert(res["changes"].([]interface{})[0].(map[string]interface{})["path"]).Equal("world/level.dat")
+			g.Assert(res["next_cursor"]).Equal("")
+			g.Assert(len(f.calls("diff"))).Equal(1)
+		})
+	})
+}
+
+func TestListServerResticJobs(t *testing.T) {
+	g := Goblin(t)
+	var f *fakeRestic
+
+	const jobServer = "7b7c0f2e-1111-4222-8333-944445555777"
+	const actor = "2f3a0c1d-1111-4222-8333-944445555888"
+	params := gin.Params{{Key: "server", Value: jobServer}}
+	body := map[string]interface{}{"owner_username": testOwner, "encryption_key": testKey, "keep_last": 3}
+
+	g.Describe("ListServerResticJobs", func() {
+		// Run a failed prune followed by a successful one for the server, removing
+		// the jobs left behind by any earlier run of the tests.
+		g.Before(func() {
+			database.Instance().Where("server = ?", jobServer).Delete(&models.ResticJob{})
+			f = newFakeRestic(t)
+			f.initRepo(jobServer+"+"+testOwner, testKey)
+			f.header.Set("X-Activity-User", actor)
+			f.header.Set("X-Activity-Ip", "203.0.113.30")
+			f.respond("forget", 1, "", "Fatal: unable to open repository\n", 1)
+			s := f.newServer(jobServer)
+
+			f.request(PruneServerResticBackup, http.MethodPost, "/", params, body, s)
+			f.request(PruneServerResticBackup, http.MethodPost, "/", params, body, s)
+		})
+
+		g.It("records each job with its result and the user that started it", func() {
+			w := f.request(ListServerResticJobs, http.MethodGet, "/?type=prune", params, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			res := decode(w)
+			g.Assert(res["total"]).Equal(float64(2))
+			jobs := res["jobs"].([]interface{})
+			newest := jobs[0].(map[string]interface{})
+			g.Assert(newest["status"]).Equal("completed")
+			g.Assert(newest["actor"]).Equal(actor)
+			g.Assert(newest["ip"]).Equal("203.0.113.30")
+			g.Assert(newest["finished_at"] == nil).IsFalse()
+			oldest := jobs[1].(map[string]interface{})
+			g.Assert(oldest["status"]).Equal("failed")
+			g.Assert(strings.Contains(oldest["output"].(string), "unable to open repository")).IsTrue()
+		})
+
+		g.It("filters and pages through the jobs", func() {
+			w := f.request(ListServerResticJobs, http.MethodGet, "/?status=failed&limit=1", params, nil, nil)
+			res := decode(w)
+			g.Assert(res["next_cursor"]).Equal("")
+			g.Assert(len(res["jobs"].([]interface{}))).Equal(1)
+
+			w = f.request(ListServerResticJobs, http.MethodGet, "/?type=prune&limit=1", params, nil, nil)
+			res = decode(w)
+			g.Assert(res["next_cursor"]).Equal("1")
+			g.Assert(res["jobs"].([]interface{})[0].(map[string]interface{})["status"]).Equal("completed")
+		})
+	})
+}
+
+func TestCancelServerResticJob(t *testing.T) {
+	g := Goblin(t)
+	var f *fakeRestic
+
+	params := gin.Params{{Key: "server", Value: testServer}}
+	body := map[string]interface{}{"owner_username": testOwner, "encryption_key": testKey}
+	cancel := func(id string) *httptest.ResponseRecorder {
+		return f.request(CancelServerResticJob, http.MethodDelete, "/", append(params, gin.Param{Key: "id", Value: id}), nil, nil)
+	}
+	// waitFor waits until restic has been called with the subcommand.
+	waitFor := func(cmd string) {
+		for i := 0; i < 500 && len(f.calls(cmd)) == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	g.Describe("CancelServerResticJob", func() {
+		g.BeforeEach(func() {
+			f = newFakeRestic(t)
+			f.initRepo(testRepoDir, testKey)
+		})
+
+		g.It("interrupts a running backup and unlocks the repository", func() {
+			f.block("backup")
+			w := f.request(CreateServerResticBackup, http.MethodPost, "/?async=true", params, body, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusAccepted)
+			id := decode(w)["job_id"].(string)
+			waitFor("backup")
+
+			w = cancel(id)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(len(f.calls("unlock"))).Equal(1)
+
+			status, _ := resticsvc.ReadBackupStatus(testServer)
+			g.Assert(status.Status).Equal("cancelled")
+			g.Assert(status.FinishedAt == "").IsFalse()
+
+			w = f.request(ListServerResticJobs, http.MethodGet, "/?type=backup&limit=1", params, nil, nil)
+			job := decode(w)["jobs"].([]interface{})[0].(map[string]interface{})
+			g.Assert(job["id"]).Equal(id)
+			g.Assert(job["status"]).Equal("cancelled")
+		})
+
+		g.It("does not cancel a job that has already finished", func() {
+			w := f.request(CreateServerResticBackup, http.MethodPost, "/?async=true", params, body, f.newServer(testServer))
+			id := decode(w)["job_id"].(string)
+			var job models.ResticJob
+			for i := 0; i < 500; i++ {
+				if database.Instance().First(&job, "id = ?", id); job.Status == models.ResticJobCompleted {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			w = cancel(id)
+			g.Assert(w.Code).Equal(http.StatusConflict)
+		})
+
+		g.It("returns not found for an unknown job", func() {
+			w := cancel("00000000-0000-4000-8000-000000000000")
+			g.Assert(w.Code).Equal(http.StatusNotFound)
+		})
+	})
+}
+
+func TestServerResticPolicy(t *testing.T) {
+	g := Goblin(t)
+	var f *fakeRestic
+
+	const policyServer = "8c8c0f2e-1111-4222-8333-944445555999"
+	params := gin.Params{{Key: "server", Value: policyServer}}
+	save := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		b := map[string]interface{}{"owner_username": testOwner, "encryption_key": testKey, "enabled": true, "cron": "0 3 * * *"}
+		for k, v := range body {
+			b[k] = v
+		}
+		return f.request(SaveServerResticPolicy, http.MethodPost, "/", params, b, nil)
+	}
+	// runSchedule runs the scheduled backup of the server straight away, with m as
+	// the servers on the node, and returns the policy once the run has finished.
+	runSchedule := func(m *server.Manager) map[string]interface{} {
+		s := gocron.NewScheduler(time.UTC)
+		g.Assert(resticsvc.StartSchedules(s, m)).IsNil()
+		s.StartAsync()
+		defer s.Stop()
+		g.Assert(s.RunByTag("restic:" + policyServer)).IsNil()
+		for i := 0; i < 500; i++ {
+			res := decode(f.request(GetServerResticPolicy, http.MethodGet, "/", params, nil, nil))
+			if st := res["last_status"]; st != "" && st != "running" {
+				return res
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		g.Fail("the scheduled backup did not finish")
+		return nil
+	}
+
+	g.Describe("ResticPolicy", func() {
+		g.BeforeEach(func() {
+			database.Instance().Where("server = ?", policyServer).Delete(&models.ResticPolicy{})
+			f = newFakeRestic(t)
+		})
+
+		g.It("returns a disabled policy for a server without one", func() {
+			w := f.request(GetServerResticPolicy, http.MethodGet, "/", params, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			res := decode(w)
+			g.Assert(res["enabled"]).IsFalse()
+			g.Assert(res["next_run_at"]).IsNil()
+		})
+
+		g.It("rejects an invalid schedule or retention rule", func() {
+			g.Assert(save(map[string]interface{}{"cron": "every day"}).Code).Equal(http.StatusBadRequest)
+			g.Assert(save(map[string]interface{}{"cron": ""}).Code).Equal(http.StatusBadRequest)
+			g.Assert(save(map[string]interface{}{"keep_daily": -1}).Code).Equal(http.StatusBadRequest)
+			g.Assert(save(map[string]interface{}{"keep_within": "a week"}).Code).Equal(http.StatusBadRequest)
+		})
+
+		g.It("saves the policy and reports when it will next run", func() {
+			w := save(map[string]interface{}{"keep_daily": 7, "keep_weekly": nil})
+			g.Assert(w.Code).Equal(http.StatusOK)
+
+			w = f.request(GetServerResticPolicy, http.MethodGet, "/", params, nil, nil)
+			res := decode(w)
+			g.Assert(res["cron"]).Equal("0 3 * * *")
+			g.Assert(res["keep_daily"]).Equal(float64(7))
+			next, err := time.Parse(time.RFC3339, res["next_run_at"].(string))
+			g.Assert(err).IsNil()
+			g.Assert(next.After(time.Now())).IsTrue()
+			g.Assert(next.Before(time.Now().Add(25 * time.Hour))).IsTrue()
+
+			g.Assert(f.storedKey(policyServer + "+" + testOwner)).Equal(testKey)
+		})
+
+		g.It("adds the schedule to the scheduler and removes it when disabled", func() {
+			s := gocron.NewScheduler(time.UTC)
+			g.Assert(resticsvc.StartSchedules(s, server.NewEmptyManager(nil))).IsNil()
+
+			save(nil)
+			jobs, err := s.FindJobsByTag("restic:" + policyServer)
+			g.Assert(err).IsNil()
+			g.Assert(len(jobs)).Equal(1)
+
+			save(map[string]interface{}{"enabled": false})
+			_, err = s.FindJobsByTag("restic:" + policyServer)
+			g.Assert(err == nil).IsFalse()
+		})
+
+		g.It("backs up the server and applies the retention policy when it runs", func() {
+			f.initRepo(policyServer+"+"+testOwner, testKey)
+			save(map[string]interface{}{"keep_daily": 7, "keep_within": "30d"})
+			m := server.NewEmptyManager(nil)
+			m.Add(f.newServer(policyServer))
+
+			res := runSchedule(m)
+			g.Assert(len(f.calls("backup"))).Equal(1)
+			g.Assert(f.calls("forget")).Equal([]string{"forget --prune --group-by host --keep-tag locked --keep-daily 7 --keep-within 30d"})
+			g.Assert(res["last_status"]).Equal("completed")
+			g.Assert(res["last_run_at"] == nil).IsFalse()
+			g.Assert(res["last_job_id"] == "").IsFalse()
+		})
+
+		g.It("records a failure when the server does not exist on the node", func() {
+			save(nil)
+
+			res := runSchedule(server.NewEmptyManager(nil))
+			g.Assert(res["last_status"]).Equal("failed")
+			g.Assert(res["last_message"]).Equal("server does not exist on this node")
+		})
+	})
+}
+
+func TestServerResticKeys(t *testing.T) {
+	g := Goblin(t)
+	var f *fakeRestic
+	params := gin.Params{{Key: "server", Value: testServer}}
+	const (
+		oldKeyId = "1111111111111111111111111111111111111111111111111111111111111111"
+		newKeyId = "2222222222222222222222222222222222222222222222222222222222222222"
+	)
+	keyList := func(current string) string {
+		return `[{"id":"` + oldKeyId + `","current":` + strconv.FormatBool(current == oldKeyId) + `},` +
+			`{"id":"` + newKeyId + `","current":` + strconv.FormatBool(current == newKeyId) + `}]`
+	}
+	storedKey := func() string {
+		return f.storedKey(testRepoDir)
+	}
+	body := func(newKey string) map[string]interface{} {
+		return map[string]interface{}{"owner_username": testOwner, "encryption_key": testKey, "new_key": newKey}
+	}
+
+	g.Describe("RotateServerResticKey", func() {
+		g.BeforeEach(func() {
+			f = newFakeRestic(t)
+			f.initRepo(testRepoDir, testKey)
+			f.respond("key", 1, keyList(oldKeyId), "", 0)
+			f.respond("key", 2, "saved new key with ID "+newKeyId+"\n", "", 0)
+			f.respond("key", 3, keyList(newKeyId), "", 0)
+		})
+
+		g.It("adds and verifies the new key before removing the old one", func() {
+			w := f.request(RotateServerResticKey, http.MethodPost, "/", params, body("new-secret"), f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusOK)
+			res := decode(w)
+			g.Assert(res["key_id"]).Equal(newKeyId)
+			g.Assert(res["old_key_id"]).Equal(oldKeyId)
+			g.Assert(res["old_key_removed"]).IsTrue()
+			g.Assert(storedKey()).Equal("new-secret")
+
+			calls := f.calls("key")
+			g.Assert(len(calls)).Equal(4)
+			g.Assert(calls[1]).Equal("key add --new-password-file /dev/fd/4")
+			password, err := os.ReadFile(filepath.Join(f.state, "new-password"))
+			g.Assert(err).IsNil()
+			g.Assert(string(password)).Equal("new-secret")
+			g.Assert(calls[3]).Equal("key remove " + oldKeyId)
+		})
+
+		g.It("keeps the old key when the new key cannot be verified", func() {
+			f.respond("key", 3, "", "Fatal: wrong password or no key found\n", 1)
+
+			w := f.request(RotateServerResticKey, http.MethodPost, "/", params, body("new-secret"), f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusInternalServerError)
+			g.Assert(storedKey()).Equal(testKey)
+			g.Assert(f.calls("key")[3]).Equal("key remove " + newKeyId)
+		})
+
+		g.It("rejects a new key that is already in use", func() {
+			w := f.request(RotateServerResticKey, http.MethodPost, "/", params, body(testKey), f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusBadRequest)
+			g.Assert(len(f.calls("key"))).Equal(0)
+		})
+	})
+
+	g.Describe("RemoveServerResticKey", func() {
+		g.BeforeEach(func() {
+			f = newFakeRestic(t)
+			f.initRepo(testRepoDir, testKey)
+			f.respond("key", 1, keyList(oldKeyId), "", 0)
+		})
+
+		g.It("does not remove the key that Wings uses", func() {
+			p := append(params, gin.Param{Key: "keyId", Value: oldKeyId[:8]})
+			w := f.request(RemoveServerResticKey, http.MethodDelete, "/", p, body(""), f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusConflict)
+			g.Assert(len(f.calls("key"))).Equal(1)
+		})
+
+		g.It("removes another key", func() {
+			p := append(params, gin.Param{Key: "keyId", Value: newKeyId})
+			w := f.request(RemoveServerResticKey, http.MethodDelete, "/", p, body(""), f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(f.calls("key")[1]).Equal("key remove " + newKeyId)
+		})
+	})
+}
+
+func TestResticKeyStore(t *testing.T) {
+	g := Goblin(t)
+	var f *fakeRestic
+
+	g.Describe("ResticKeyStore", func() {
+		g.BeforeEach(func() {
+			f = newFakeRestic(t)
+		})
+
+		g.It("stores the key encrypted with the master key", func() {
+			repo := f.initRepo(testRepoDir, testKey)
+
+			var m models.ResticKey
+			g.Assert(database.Instance().First(&m, "repository = ?", repo).Error).IsNil()
+			g.Assert(strings.Contains(m.Ciphertext, testKey)).IsFalse()
+			g.Assert(f.storedKey(testRepoDir)).Equal(testKey)
+
+			st, err := os.Stat(config.Get().Restic.MasterKeyFile)
+			g.Assert(err).IsNil()
+			g.Assert(st.Mode().Perm()).Equal(os.FileMode(0o600))
+		})
+
+		g.It("moves a key stored in the repository into the key store", func() {
+			repo := filepath.Join(f.repoBase, testRepoDir)
+			g.Assert(os.MkdirAll(repo, 0o755)).IsNil()
+			g.Assert(os.WriteFile(filepath.Join(repo, resticsvc.LegacyKeyFile), []byte("legacy\n"), 0o600)).IsNil()
+
+			key, err := resticsvc.ResolveKey(repo, testKey)
+			g.Assert(err).IsNil()
+			g.Assert(key).Equal("legacy")
+			_, err = os.Stat(filepath.Join(repo, resticsvc.LegacyKeyFile))
+			g.Assert(os.IsNotExist(err)).IsTrue()
+			g.Assert(f.storedKey(testRepoDir)).Equal("legacy")
+		})
+
+		g.It("does not include a key in archive downloads", func() {
+			archive := filepath.Join(config.Get().Restic.ArchiveDirectory, testRepoDir+"-20250101-000000")
+			g.Assert(os.MkdirAll(archive, 0o755)).IsNil()
+			g.Assert(os.WriteFile(filepath.Join(archive, "config"), []byte("config"), 0o644)).IsNil()
+			g.Assert(os.WriteFile(filepath.Join(archive, resticsvc.LegacyKeyFile), []byte(testKey+"\n"), 0o600)).IsNil()
+
+			id := filepath.Base(archive)
+			w := f.request(DownloadArchivedRepo, http.MethodGet, "/", gin.Params{{Key: "archiveId", Value: id}}, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusOK)
+
+			gr, err := gzip.NewReader(w.Body)
+			g.Assert(err).IsNil()
+			var names []string
+			tr := tar.NewReader(gr)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				g.Assert(err).IsNil()
+				names = append(names, hdr.Name)
+			}
+			g.Assert(names).Equal([]string{id + "/config"})
+		})
+	})
+}
+
+func TestRemoteResticBackend(t *testing.T) {
+	g := Goblin(t)
+	var f *fakeRestic
+
+	var remoteBase, remoteRepo string
+	params := gin.Params{{Key: "server", Value: testServer}}
+	stored := func() string {
+		key, err := resticsvc.LoadKey(remoteRepo)
+		g.Assert(err).IsNil()
+		return key
+	}
+
+	g.Describe("RemoteResticBackend", func() {
+		g.BeforeEach(func() {
+			// Every test uses a new location so that nothing is known about its
+			// repositories yet.
+			remoteBase = "rest:http://127.0.0.1:8000/wings-" + uuid.NewString()
+			remoteRepo = remoteBase + "/" + testRepoDir
+			f = newFakeRestic(t)
+			config.Update(func(c *config.Configuration) {
+				c.Restic.Backend.URL = remoteBase + "/"
+				c.Restic.Backend.Env = map[string]string{"RESTIC_REST_USERNAME": "wings"}
+			})
+		})
+
+		g.It("stores repositories within the configured location", func() {
+			g.Assert(resticsvc.RepoPath(testRepoDir)).Equal(remoteRepo)
+			g.Assert(resticsvc.NewClient(remoteRepo, testKey).Repository()).Equal(remoteRepo)
+		})
+
+		g.It("initializes a missing repository on the backend", func() {
+			f.respond("cat", 0, "", "Fatal: repository does not exist: unable to open config file", 10)
+			body := map[string]interface{}{"owner_username": testOwner, "encryption_key": testKey}
+			w := f.request(CreateServerResticBackup, http.MethodPost, "/", params, body, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusOK)
+
+			g.Assert(len(f.calls("init"))).Equal(1)
+			for _, repo := range f.repos() {
+				g.Assert(repo).Equal(remoteRepo)
+			}
+			_, err := os.Stat(filepath.Join(f.repoBase, testRepoDir))
+			g.Assert(os.IsNotExist(err)).IsTrue()
+			g.Assert(stored()).Equal(testKey)
+		})
+
+		g.It("lists the repositories that have a stored key", func() {
+			g.Assert(resticsvc.StoreKey(remoteRepo, testKey)).IsNil()
+			g.Assert(resticsvc.StoreKey(remoteBase+"/other+"+testOwner, testKey)).IsNil()
+
+			w := f.request(CheckServerResticRepo, http.MethodGet, "/", params, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(decode(w)["count"]).Equal(float64(1))
+			g.Assert(resticsvc.ReposForServer(testServer)).Equal([]string{remoteRepo})
+		})
+
+		g.It("does not delete repositories from the backend", func() {
+			g.Assert(resticsvc.StoreKey(remoteRepo, testKey)).IsNil()
+
+			w := f.request(DeleteServerResticRepo, http.MethodDelete, "/", params, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusNotImplemented)
+			g.Assert(stored()).Equal(testKey)
+		})
+
+		g.It("only removes locks that are known to be stale", func() {
+			g.Assert(resticsvc.StoreKey(remoteRepo, testKey)).IsNil()
+			f.respond("list", 0, "a1b2c3d4e5f6\nf6e5d4c3b2a1\n", "", 0)
+			f.respond("cat", 1, `{"time":"2025-01-01T00:00:00Z","hostname":"node","pid":1}`, "", 0)
+			f.respond("cat", 2, `{"time":"`+time.Now().Format(time.RFC3339)+`","hostname":"node","pid":2}`, "", 0)
+			f.respond("cat", 3, `{"time":"2025-01-01T00:00:00Z","hostname":"node","pid":1}`, "", 0)
+			f.respond("cat", 4, "", "Fatal: load lock: not found", 1)
+
+			ok, reason := resticsvc.ForceRemoveRepoLocks(remoteRepo, time.Hour)
+			g.Assert(ok).IsFalse()
+			g.Assert(reason).Equal("locks not stale")
+			ok, reason = resticsvc.ForceRemoveRepoLocks(remoteRepo, time.Hour)
+			g.Assert(ok).IsFalse()
+			g.Assert(reason).Equal("locks not stale")
+			g.Assert(f.calls("cat")).Equal([]string{
+				"cat lock --no-lock a1b2c3d4e5f6",
+				"cat lock --no-lock f6e5d4c3b2a1",
+				"cat lock --no-lock a1b2c3d4e5f6",
+				"cat lock --no-lock f6e5d4c3b2a1",
+			})
+			g.Assert(len(f.calls("unlock"))).Equal(0)
+
+			f.respond("cat", 5, `{"time":"2025-01-01T00:00:00Z","hostname":"node","pid":1}`, "", 0)
+			f.respond("cat", 6, `{"time":"2025-01-01T00:00:00Z","hostname":"node","pid":1}`, "", 0)
+			ok, _ = resticsvc.ForceRemoveRepoLocks(remoteRepo, time.Hour)
+			g.Assert(ok).IsTrue()
+			g.Assert(f.calls("unlock")).Equal([]string{"unlock --remove-all"})
+		})
+	})
+}
+
+func TestServerResticReplication(t *testing.T) {
+	g := Goblin(t)
+	var f *fakeRestic
+	var primary, secondary string
+
+	params := gin.Params{{Key: "server", Value: testServer}}
+	backup := func() {
+		body := map[string]interface{}{"owner_username": testOwner, "encryption_key": testKey}
+		w := f.request(CreateServerResticBackup, http.MethodPost, "/", params, body, f.newServer(testServer))
+		g.Assert(w.Code).Equal(http.StatusOK)
+	}
+	save := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		return f.request(SaveServerResticReplication, http.MethodPost, "/", params, body, nil)
+	}
+	// wait returns the replication settings once the copy job has finished.
+	wait := func() models.ResticReplica {
+		for i := 0; i < 100; i++ {
+			r, err := resticsvc.LoadReplica(testServer)
+			g.Assert(err).IsNil()
+			if r.LastStatus == "completed" || r.LastStatus == "failed" {
+				return r
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		g.Fail("copy job did not finish")
+		return models.ResticReplica{}
+	}
+
+	g.Describe("ResticReplication", func() {
+		g.BeforeEach(func() {
+			database.Instance().Where("server = ?", testServer).Delete(&models.ResticReplica{})
+			f = newFakeRestic(t)
+			primary = f.initRepo(testRepoDir, testKey)
+			replicas := filepath.Join(filepath.Dir(f.repoBase), "replicas")
+			secondary = filepath.Join(replicas, testRepoDir)
+			config.Update(func(c *config.Configuration) {
+				c.Restic.Replication.URL = replicas
+			})
+		})
+
+		g.It("copies snapshots into a new secondary repository after a backup", func() {
+			f.respond("cat", 0, "", "Fatal: repository does not exist: unable to open config file", 10)
+			backup()
+
+			r := wait()
+			g.Assert(r.LastStatus).Equal("completed")
+			g.Assert(r.LastJobID != "").IsTrue()
+			from := "--from-repo " + primary + " --from-password-file /dev/fd/4"
+			g.Assert(f.calls("init")).Equal([]string{"init --copy-chunker-params " + from})
+			g.Assert(f.calls("copy")).Equal([]string{"copy " + from})
+			g.Assert(f.repos()[len(f.repos())-1]).Equal(secondary)
+			g.Assert(len(f.calls("forget"))).Equal(0)
+		})
+
+		g.It("keeps the key of the secondary repository when the primary key is rotated", func() {
+			backup()
+			g.Assert(wait().LastStatus).Equal("completed")
+
+			f.respond("key", 1, `[{"id":"11111111","current":true}]`, "", 0)
+			f.respond("key", 2, "saved new key with ID 22222222\n", "", 0)
+			f.respond("key", 3, `[{"id":"11111111","current":false},{"id":"22222222","current":true}]`, "", 0)
+			body := map[string]interface{}{"owner_username": testOwner, "encryption_key": testKey, "new_key": "rotated-key"}
+			w := f.request(RotateServerResticKey, http.MethodPost, "/", params, body, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusOK)
+
+			backup()
+			g.Assert(wait().LastStatus).Equal("completed")
+			g.Assert(len(f.calls("copy"))).Equal(2)
+
+			repos, passwords := f.repos(), f.passwords()
+			g.Assert(repos[len(repos)-1]).Equal(secondary)
+			last := ""
+			for i, repo := range repos {
+				switch repo {
+				case secondary:
+					g.Assert(passwords[i]).Equal(testKey)
+				case primary:
+					last = passwords[i]
+				}
+			}
+			g.Assert(last).Equal("rotated-key")
+		})
+
+		g.It("applies the retention policy of the secondary repository", func() {
+			w := save(map[string]interface{}{"keep_last": 3})
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(decode(w)["target"]).Equal(filepath.Dir(secondary))
+			backup()
+
+			g.Assert(wait().LastStatus).Equal("completed")
+			g.Assert(f.calls("forget")).Equal([]string{"forget --prune --group-by host --keep-tag locked --keep-last 3"})
+			g.Assert(f.repos()[len(f.repos())-1]).Equal(secondary)
+		})
+
+		g.It("does not copy snapshots when replication is disabled for the server", func() {
+			g.Assert(save(map[string]interface{}{"enabled": false}).Code).Equal(http.StatusOK)
+			backup()
+
+			r, err := resticsvc.LoadReplica(testServer)
+			g.Assert(err).IsNil()
+			g.Assert(r.LastStatus).Equal("")
+			g.Assert(len(f.calls("copy"))).Equal(0)
+		})
+
+		g.It("requires the location to be absolute", func() {
+			g.Assert(save(map[string]interface{}{"location": "replicas"}).Code).Equal(http.StatusBadRequest)
+		})
+
+		g.It("reports the newest snapshot in each repository", func() {
+			f.respond("snapshots", 1, `[{"id":"aaaa","time":"2025-01-01T00:00:00Z"},{"id":"bbbb","time":"2025-01-01T01:00:00Z"},{"id":"cccc","time":"2025-01-01T02:00:00Z"}]`, "", 0)
+			f.respond("snapshots", 2, `[{"id":"dddd","original":"aaaa","time":"2025-01-01T00:00:00Z"}]`, "", 0)
+
+			f.header.Set(encryptionKeyHeader, testKey)
+			w := f.request(GetServerResticReplicationStatus, http.MethodGet, "/?owner_username="+testOwner, params, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			res := decode(w)
+			g.Assert(res["primary"].(map[string]interface{})["snapshot_id"]).Equal("cccc")
+			g.Assert(res["secondary"].(map[string]interface{})["snapshot_id"]).Equal("dddd")
+			g.Assert(res["in_sync"]).IsFalse()
+			g.Assert(res["behind"]).Equal(float64(2))
+			g.Assert(res["lag_seconds"]).Equal(float64(7200))
+		})
+
+		g.It("returns an error if the server is not replicated", func() {
+			config.Update(func(c *config.Configuration) {
+				c.Restic.Replication.URL = ""
+			})
+			f.header.Set(encryptionKeyHeader, testKey)
+			w := f.request(GetServerResticReplicationStatus, http.MethodGet, "/", params, nil, nil)
+			g.Assert(w.Code).Equal(http.StatusNotFound)
+		})
+	})
+}
+
+func TestResticTransfer(t *testing.T) {
+	g := Goblin(t)
+	var f *fakeRestic
+	var repo string
+
+	params := gin.Params{{Key: "server", Value: testServer}}
+	body := map[string]interface{}{"owner_username": testOwner, "encryption_key": testKey}
+
+	// send returns the manifest and archive that the source node sends for the
+	// repositories of the test server.
+	send := func() ([]byte, *bytes.Buffer) {
+		m, err := resticsvc.NewTransferManifest(testServer)
+		g.Assert(err).IsNil()
+		manifest, err := json.Marshal(m)
+		g.Assert(err).IsNil()
+		var archive bytes.Buffer
+		g.Assert(m.Stream(context.Background(), &archive)).IsNil()
+		return manifest, &archive
+	}
+
+	g.Describe("ResticTransfer", func() {
+		g.BeforeEach(func() {
+			database.Instance().Where("server = ?", testServer).Delete(&models.ResticPolicy{})
+			database.Instance().Where("server = ?", testServer).Delete(&models.ResticReplica{})
+			f = newFakeRestic(t)
+			repo = f.initRepo(testRepoDir, testKey)
+			g.Assert(os.MkdirAll(filepath.Join(repo, "locks"), 0o755)).IsNil()
+			g.Assert(os.WriteFile(filepath.Join(repo, "locks", "abcd"), []byte("lock"), 0o644)).IsNil()
+			g.Assert(database.Instance().Create(&models.ResticPolicy{Server: testServer, OwnerUsername: testOwner, Cron: "0 3 * * *", KeepLast: 5}).Error).IsNil()
+		})
+
+		g.AfterEach(func() {
+			resticsvc.ReleaseTransfer(testServer)
+		})
+
+		g.It("installs and verifies the repositories on the target node", func() {
+			manifest, archive := send()
+
+			target := newFakeRestic(t)
+			in, err := resticsvc.NewIncomingRepositories(testServer, manifest)
+			g.Assert(err).IsNil()
+			g.Assert(in.Receive(context.Background(), archive)).IsNil()
+			n, err := in.Install(context.Background())
+			g.Assert(err).IsNil()
+			g.Assert(n).Equal(1)
+
+			lock, err := os.ReadFile(filepath.Join(target.repoBase, testRepoDir, "locks", "abcd"))
+			g.Assert(err).IsNil()
+			g.Assert(string(lock)).Equal("lock")
+			g.Assert(target.storedKey(testRepoDir)).Equal(testKey)
+			g.Assert(target.calls("check")).Equal([]string{"check"})
+			_, err = os.Stat(filepath.Join(target.repoBase, ".transfer-"+testServer))
+			g.Assert(os.IsNotExist(err)).IsTrue()
+
+			var p models.ResticPolicy
+			g.Assert(database.Instance().First(&p, "server = ?", testServer).Error).IsNil()
+			g.Assert(p.KeepLast).Equal(5)
+		})
+
+		g.It("removes the repositories again if they fail verification", func() {
+			manifest, archive := send()
+
+			target := newFakeRestic(t)
+			target.respond("check", 0, "", "Fatal: repository contains errors", 1)
+			in, err := resticsvc.NewIncomingRepositories(testServer, manifest)
+			g.Assert(err).IsNil()
+			g.Assert(in.Receive(context.Background(), archive)).IsNil()
+			_, err = in.Install(context.Background())
+			g.Assert(err).IsNotNil()
+
+			_, err = os.Stat(filepath.Join(target.repoBase, testRepoDir))
+			g.Assert(os.IsNotExist(err)).IsTrue()
+			g.Assert(target.storedKey(testRepoDir)).Equal("")
+		})
+
+		g.It("rejects repositories that do not belong to the server", func() {
+			_, err := resticsvc.NewIncomingRepositories(testServer, []byte(`{"repositories":[{"dir":"other+owner"}]}`))
+			g.Assert(err).IsNotNil()
+
+			var archive bytes.Buffer
+			gw := gzip.NewWriter(&archive)
+			tw := tar.NewWriter(gw)
+			g.Assert(tw.WriteHeader(&tar.Header{Name: testRepoDir + "/../escaped", Typeflag: tar.TypeReg, Mode: 0o644})).IsNil()
+			g.Assert(tw.Close()).IsNil()
+			g.Assert(gw.Close()).IsNil()
+
+			in, err := resticsvc.NewIncomingRepositories(testServer, []byte(`{"repositories":[{"dir":"`+testRepoDir+`"}]}`))
+			g.Assert(err).IsNil()
+			g.Assert(in.Receive(context.Background(), &archive)).IsNotNil()
+		})
+
+		g.It("does not send repositories while the server has a job queued", func() {
+			release := make(chan struct{})
+			j := resticsvc.Jobs.Submit(&resticsvc.Job{Type: resticsvc.JobCheck, Server: testServer}, func(*resticsvc.Job) error {
+				<-release
+				return nil
+			})
+			_, err := resticsvc.NewTransferManifest(testServer)
+			close(release)
+			<-j.Done()
+			g.Assert(err).IsNotNil()
+		})
+
+		g.It("refuses restic requests for the server until the transfer has finished", func() {
+			_, err := resticsvc.NewTransferManifest(testServer)
+			g.Assert(err).IsNil()
+			_, err = resticsvc.NewTransferManifest(testServer)
+			g.Assert(err).IsNotNil()
+
+			w := f.request(CreateServerResticBackup, http.MethodPost, "/", params, body, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusConflict)
+			w = f.request(PruneServerResticBackup, http.MethodPost, "/", params, body, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusConflict)
+			g.Assert(len(f.calls("backup"))).Equal(0)
+
+			resticsvc.ReleaseTransfer(testServer)
+			w = f.request(CreateServerResticBackup, http.MethodPost, "/", params, body, f.newServer(testServer))
+			g.Assert(w.Code).Equal(http.StatusOK)
+		})
+
+		g.It("removes the repositories from the source node", func() {
+			m, err := resticsvc.NewTransferManifest(testServer)
+			g.Assert(err).IsNil()
+			resticsvc.RemoveTransferredRepositories(testServer, m)
+
+			_, err = os.Stat(repo)
+			g.Assert(os.IsNotExist(err)).IsTrue()
+			g.Assert(f.storedKey(testRepoDir)).Equal("")
+			g.Assert(database.Instance().First(&models.ResticPolicy{}, "server = ?", testServer).Error).IsNotNil()
+		})
+	})
+}
+
+func TestResticBackupAdapter(t *testing.T) {
+	g := Goblin(t)
+	var f *fakeRestic
+	var s *server.Server
+
+	const backupUuid = "9a8b7c6d-1111-4222-8333-944445555666"
+	const snapshots = `[
+		{"id":"0123456789abcdef0123456789abcdef","short_id":"01234567","time":"2025-01-01T00:00:00Z","tree":"t","paths":["/data"],"tags":["backup:` + backupUuid + `"],"summary":{"total_bytes_processed":2048}},
+		{"id":"fedcba9876543210fedcba9876543210","short_id":"fedcba98","time":"2025-01-02T00:00:00Z","tree":"t","paths":["/data"],"tags":["locked","backup:locked-backup"]},
+		{"id":"abcdef0123456789abcdef0123456789","short_id":"abcdef01","time":"2025-01-03T00:00:00Z","tree":"t","paths":["/tmp/import/data"],"tags":["backup:imported-backup","imported"]}
+	]`
+
+	g.Describe("BackupAdapter", func() {
+		g.BeforeEach(func() {
+			database.Instance().Where("server = ?", testServer).Delete(&models.ResticReplica{})
+			f = newFakeRestic(t)
+			s = f.newServer(testServer)
+			f.respond("snapshots", 0, snapshots, "", 0)
+		})
+
+		g.It("creates a tagged snapshot with a generated key for a new repository", func() {
+			ad, err := NewBackupAdapter(nil, s, backupUuid, "").Generate(context.Background(), s.Filesystem(), "*.log")
+			g.Assert(err).IsNil()
+			g.Assert(ad.Checksum).Equal("0123456789abcdef0123456789abcdef")
+			g.Assert(ad.ChecksumType).Equal("sha256")
+			g.Assert(ad.Size).Equal(int64(2048))
+
+			g.Assert(len(f.calls("init"))).Equal(1)
+			g.Assert(strings.Contains(f.calls("backup")[0], "--tag backup:"+backupUuid)).IsTrue()
+			g.Assert(f.storedKey(testServer) != "").IsTrue()
+			excludes, err := os.ReadFile(filepath.Join(f.state, "exclude-file"))
+			g.Assert(err).IsNil()
+			g.Assert(strings.Contains(string(excludes), "*.log")).IsTrue()
+		})
+
+		g.It("does not back up to a repository without a stored key", func() {
+			repo := filepath.Join(f.repoBase, testServer)
+			g.Assert(os.MkdirAll(repo, 0o755)).IsNil()
+			g.Assert(os.WriteFile(filepath.Join(repo, "config"), []byte("config"), 0o644)).IsNil()
+
+			_, err := NewBackupAdapter(nil, s, backupUuid, "").Generate(context.Background(), s.Filesystem(), "")
+			g.Assert(err).IsNotNil()
+			g.Assert(len(f.calls("backup"))).Equal(0)
+		})
+
+		g.It("restores every file in the snapshot through the callback", func() {
+			f.initRepo(testServer, testKey)
+			var archive bytes.Buffer
+			tw := tar.NewWriter(&archive)
+			g.Assert(tw.WriteHeader(&tar.Header{Name: "/config", Typeflag: tar.TypeDir, Mode: 0o755})).IsNil()
+			g.Assert(tw.WriteHeader(&tar.Header{Name: "/config/server.properties", Typeflag: tar.TypeReg, Mode: 0o644, Size: 5})).IsNil()
+			_, err := tw.Write([]byte("motd="))
+			g.Assert(err).IsNil()
+			g.Assert(tw.Close()).IsNil()
+			f.respond("dump", 0, archive.String(), "", 0)
+
+			b, err := LocateBackup(nil, s, backupUuid)
+			g.Assert(err).IsNil()
+			restored := map[string]string{}
+			err = b.Restore(context.Background(), nil, func(file string, info fs.FileInfo, r io.ReadCloser) error {
+				data, err := io.ReadAll(r)
+				restored[file] = string(data)
+				return err
+			})
+			g.Assert(err).IsNil()
+			g.Assert(restored).Equal(map[string]string{"config/server.properties": "motd="})
+			g.Assert(f.calls("dump")).Equal([]string{"dump --no-lock --archive tar 0123456789abcdef0123456789abcdef:/data /"})
+		})
+
+		g.It("restores imported snapshots from the server data directory", func() {
+			f.initRepo(testServer, testKey)
+			var archive bytes.Buffer
+			g.Assert(tar.NewWriter(&archive).Close()).IsNil()
+			f.respond("dump", 0, archive.String(), "", 0)
+
+			b, err := LocateBackup(nil, s, "imported-backup")
+			g.Assert(err).IsNil()
+			err = b.Restore(context.Background(), nil, func(string, fs.FileInfo, io.ReadCloser) error { return nil })
+			g.Assert(err).IsNil()
+			g.Assert(f.calls("dump")).Equal([]string{"dump --no-lock --archive tar abcdef0123456789abcdef0123456789:" + filepath.Join(f.data, testServer) + " /"})
+		})
+
+		g.It("removes unlocked snapshots only", func() {
+			f.initRepo(testServer, testKey)
+			b, err := LocateBackup(nil, s, backupUuid)
+			g.Assert(err).IsNil()
+			g.Assert(b.Remove()).IsNil()
+			g.Assert(f.calls("forget")).Equal([]string{"forget 0123456789abcdef0123456789abcdef --prune"})
+			var job models.ResticJob
+			g.Assert(database.Instance().First(&job, "server = ? AND type = ? AND snapshot = ?", testServer, resticsvc.JobPrune, "0123456789abcdef0123456789abcdef").Error).IsNil()
+			g.Assert(job.Status).Equal(models.ResticJobCompleted)
+
+			b, err = LocateBackup(nil, s, "locked-backup")
+			g.Assert(err).IsNil()
+			g.Assert(errors.Is(b.Remove(), ErrSnapshotLocked)).IsTrue()
+			g.Assert(len(f.calls("forget"))).Equal(1)
+		})
+
+		g.It("reports backups that are not stored in restic as missing", func() {
+			_, err := LocateBackup(nil, s, backupUuid)
+			g.Assert(errors.Is(err, os.ErrNotExist)).IsTrue()
+
+			f.initRepo(testServer, testKey)
+			_, err = LocateBackup(nil, s, "00000000-0000-0000-0000-000000000000")
+			g.Assert(errors.Is(err, os.ErrNotExist)).IsTrue()
+		})
+	})
+}
+
+func TestImportServerResticBackups(t *testing.T) {
+	g := Goblin(t)
+	var f *fakeRestic
+	var s *server.Server
+	var backups string
+	params := gin.Params{{Key: "server", Value: testServer}}
+
+	const backupUuid = "3c4d5e6f-1111-4222-8333-944445555666"
+	created := time.Date(2023, 5, 6, 7, 8, 9, 0, time.Local)
+
+	// writeArchive writes a backup archive like the ones created by the local
+	// backup adapter, with two files and a symbolic link.
+	writeArchive := func() string {
+		p := filepath.Join(backups, backupUuid+".tar.gz")
+		out, err := os.Create(p)
+		g.Assert(err).IsNil()
+		gw := gzip.NewWriter(out)
+		tw := tar.NewWriter(gw)
+		g.Assert(tw.WriteHeader(&tar.Header{Name: "config", Typeflag: tar.TypeDir, Mode: 0o755})).IsNil()
+		for name, data := range map[string]string{"config/server.properties": "motd=", "level.dat": "abc"} {
+			g.Assert(tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(data))})).IsNil()
+			_, err = tw.Write([]byte(data))
+			g.Assert(err).IsNil()
+		}
+		g.Assert(tw.WriteHeader(&tar.Header{Name: "config/link", Typeflag: tar.TypeSymlink, Linkname: "/etc"})).IsNil()
+		g.Assert(tw.Close()).IsNil()
+		g.Assert(gw.Close()).IsNil()
+		g.Assert(out.Close()).IsNil()
+		g.Assert(os.Chtimes(p, created, created)).IsNil()
+		return p
+	}
+
+	// listing returns the output of restic ls for a snapshot of the archive.
+	listing := func(levelSize int) string {
+		vol := filepath.Join(f.data, testServer)
+		return `{"message_type":"node","name":"config","type":"dir","path":"` + vol + `/config"}
+{"message_type":"node","name":"server.properties","type":"file","path":"` + vol + `/config/server.properties","size":5}
+{"message_type":"node","name":"link","type":"symlink","path":"` + vol + `/config/link"}
+{"message_type":"node","name":"level.dat","type":"file","path":"` + vol + `/level.dat","size":` + strconv.Itoa(levelSize) + `}`
+	}
+
+	// importBackups starts an import and waits for the job to finish, returning
+	// the job.
+	importBackups := func(body map[string]interface{}) models.ResticJob {
+		w := f.request(ImportServerResticBackups, http.MethodPost, "/", params, body, s)
+		g.Assert(w.Code).Equal(http.StatusAccepted)
+		id := decode(w)["job_id"].(string)
+		var job models.ResticJob
+		for i := 0; i < 500; i++ {
+			if database.Instance().First(&job, "id = ?", id); job.Status == models.ResticJobCompleted || job.Status == models.ResticJobFailed {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return job
+	}
+
+	g.Describe("ImportServerResticBackups", func() {
+		g.BeforeEach(func() {
+			f = newFakeRestic(t)
+			f.header.Set(encryptionKeyHeader, testKey)
+			backups = filepath.Join(f.repoBase, "backups")
+			g.Assert(os.MkdirAll(backups, 0o755)).IsNil()
+			config.Update(func(c *config.Configuration) {
+				c.System.BackupDirectory = backups
+			})
+			s = f.newServer(testServer)
+			f.initRepo(testRepoDir, testKey)
+		})
+
+		g.It("imports an archive as a tagged snapshot with the time of the archive", func() {
+			archive := writeArchive()
+			f.respond("ls", 0, listing(3), "", 0)
+
+			job := importBackups(map[string]interface{}{"backups": []string{backupUuid}, "owner_username": testOwner})
+			g.Assert(job.Status).Equal(models.ResticJobCompleted)
+
+			rel := strings.TrimPrefix(filepath.Join(f.data, testServer), "/")
+			g.Assert(f.calls("backup")).Equal([]string{"backup --json --tag backup:" + backupUuid + " --tag imported --time 2023-05-06 07:08:09 " + rel})
+			g.Assert(len(f.calls("forget"))).Equal(0)
+			_, err := os.Stat(archive)
+			g.Assert(err).IsNil()
+			g.Assert(activity(s, server.ActivityResticImport, "") != nil).IsTrue()
+		})
+
+		g.It("deletes the archives once the repository has been checked", func() {
+			archive := writeArchive()
+			f.respond("ls", 0, listing(3), "", 0)
+
+			job := importBackups(map[string]interface{}{"backups": []string{backupUuid}, "owner_username": testOwner, "delete_archives": true})
+			g.Assert(job.Status).Equal(models.ResticJobCompleted)
+			g.Assert(len(f.calls("check"))).Equal(1)
+			_, err := os.Stat(archive)
+			g.Assert(os.IsNotExist(err)).IsTrue()
+		})
+
+		g.It("removes the snapshot and keeps the archive if they do not match", func() {
+			archive := writeArchive()
+			f.respond("ls", 0, listing(2), "", 0)
+
+			job := importBackups(map[string]interface{}{"backups": []string{backupUuid}, "owner_username": testOwner, "delete_archives": true})
+			g.Assert(job.Status).Equal(models.ResticJobFailed)
+			g.Assert(f.calls("forget")).Equal([]string{"forget 0123456789abcdef0123456789abcdef --prune"})
+			_, err := os.Stat(archive)
+			g.Assert(err).IsNil()
+		})
+
+		g.It("applies retention to imported snapshots along with the others", func() {
+			writeArchive()
+			f.respond("ls", 0, listing(3), "", 0)
+			job := importBackups(map[string]interface{}{"backups": []string{backupUuid}, "owner_username": testOwner})
+			g.Assert(job.Status).Equal(models.ResticJobCompleted)
+
+			w := f.request(PruneServerResticBackup, http.MethodPost, "/", params, map[string]interface{}{"owner_username": testOwner, "encryption_key": testKey, "keep_last": 3}, s)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(f.calls("forget")).Equal([]string{"forget --prune --group-by host --keep-tag locked --keep-last 3"})
+		})
+
+		g.It("skips archives that have already been imported", func() {
+			writeArchive()
+			f.respond("snapshots", 0, `[{"id":"abcdef0123456789","time":"2023-05-06T07:08:09Z","tree":"t","paths":["/data"],"tags":["backup:`+backupUuid+`"]}]`, "", 0)
+
+			job := importBackups(map[string]interface{}{"backups": []string{backupUuid}, "owner_username": testOwner})
+			g.Assert(job.Status).Equal(models.ResticJobCompleted)
+			g.Assert(len(f.calls("backup"))).Equal(0)
+		})
+
+		g.It("rejects missing and invalid archives", func() {
+			w := f.request(ImportServerResticBackups, http.MethodPost, "/", params, map[string]interface{}{"backups": []string{"../../etc/passwd"}}, s)
+			g.Assert(w.Code).Equal(http.StatusBadRequest)
+
+			w = f.request(ImportServerResticBackups, http.MethodPost, "/", params, map[string]interface{}{"backups": []string{backupUuid}}, s)
+			g.Assert(w.Code).Equal(http.StatusNotFound)
+		})
+
+		g.It("does not extract paths outside of the staging directory", func() {
+			var archive bytes.Buffer
+			gw := gzip.NewWriter(&archive)
+			tw := tar.NewWriter(gw)
+			g.Assert(tw.WriteHeader(&tar.Header{Name: "../escaped", Typeflag: tar.TypeReg, Mode: 0o644})).IsNil()
+			g.Assert(tw.Close()).IsNil()
+			g.Assert(gw.Close()).IsNil()
+			p := filepath.Join(backups, backupUuid+".tar.gz")
+			g.Assert(os.WriteFile(p, archive.Bytes(), 0o644)).IsNil()
+
+			_, err := extractBackupArchive(context.Background(), p, filepath.Join(f.repoBase, "staging"))
+			g.Assert(err).IsNotNil()
+			_, err = os.Stat(filepath.Join(f.repoBase, "escaped"))
+			g.Assert(os.IsNotExist(err)).IsTrue()
+		})
+
+		g.It("does not create links within other links of the archive", func() {
+			outside := filepath.Join(f.repoBase, "outside")
+			g.Assert(os.MkdirAll(outside, 0o755)).IsNil()
+			var archive bytes.Buffer
+			gw := gzip.NewWriter(&archive)
+			tw := tar.NewWriter(gw)
+			g.Assert(tw.WriteHeader(&tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: outside})).IsNil()
+			g.Assert(tw.WriteHeader(&tar.Header{Name: "a/b/x", Typeflag: tar.TypeSymlink, Linkname: "/etc"})).IsNil()
+			g.Assert(tw.Close()).IsNil()
+			g.Assert(gw.Close()).IsNil()
+			p := filepath.Join(backups, backupUuid+".tar.gz")
+			g.Assert(os.WriteFile(p, archive.Bytes(), 0o644)).IsNil()
+
+			_, err := extractBackupArchive(context.Background(), p, filepath.Join(f.repoBase, "staging"))
+			g.Assert(err).IsNotNil()
+			entries, err := os.ReadDir(outside)
+			g.Assert(err).IsNil()
+			g.Assert(len(entries)).Equal(0)
+		})
+	})
+}
@@ -0,0 +1,342 @@
+package restic
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"emperror.dev/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/models"
+	resticcli "github.com/pterodactyl/wings/internal/restic"
+	"github.com/pterodactyl/wings/internal/resticsvc"
+	"github.com/pterodactyl/wings/server"
+)
+
+// backupArchivePath returns the path of an archive created by the local backup
+// adapter.
+func backupArchivePath(backupUuid string) string {
+	return filepath.Join(config.Get().System.BackupDirectory, backupUuid+".tar.gz")
+}
+
+// archiveContents is what was extracted from a backup archive.
+type archiveContents struct {
+	Files int
+	Bytes uint64
+}
+
+// extractBackupArchive extracts a backup archive into dir. Symbolic links are
+// created once everything else has been extracted, and entries within a symbolic
+// link of the archive are rejected, so that nothing is written through them.
+func extractBackupArchive(ctx context.Context, archive string, dir string) (archiveContents, error) {
+	var contents archiveContents
+	f, err := os.Open(archive)
+	if err != nil {
+		return contents, errors.WithStack(err)
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return contents, errors.Wrap(err, "failed to read archive")
+	}
+	defer gr.Close()
+
+	var links []*tar.Header
+	tr := tar.NewReader(gr)
+	for {
+		if err := ctx.Err(); err != nil {
+			return contents, err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return contents, errors.Wrap(err, "failed to read archive")
+		}
+		name := path.Clean(strings.TrimSuffix(hdr.Name, "/"))
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return contents, errors.New("archive contains an unexpected path: " + hdr.Name)
+		}
+		if name == "." {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return contents, errors.WithStack(err)
+			}
+		case tar.TypeReg:
+			if err := resticsvc.ExtractFile(target, hdr.FileInfo().Mode().Perm(), tr); err != nil {
+				return contents, err
+			}
+			if err := os.Chtimes(target, hdr.ModTime, hdr.ModTime); err != nil {
+				return contents, errors.WithStack(err)
+			}
+			contents.Files++
+			contents.Bytes += uint64(hdr.Size)
+		case tar.TypeSymlink:
+			hdr.Name = name
+			links = append(links, hdr)
+		}
+	}
+	for _, hdr := range links {
+		if err := checkArchiveParents(dir, hdr.Name); err != nil {
+			return contents, err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return contents, errors.WithStack(err)
+		}
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+			return contents, errors.WithStack(err)
+		}
+	}
+	return contents, nil
+}
+
+// checkArchiveParents returns an error if any parent of the entry that has already
+// been extracted into dir is a symbolic link.
+func checkArchiveParents(dir string, name string) error {
+	p := dir
+	for _, part := range strings.Split(path.Dir(name), "/") {
+		if part == "." {
+			continue
+		}
+		p = filepath.Join(p, part)
+		st, err := os.Lstat(p)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if st.Mode()&os.ModeSymlink != 0 {
+			return errors.New("archive contains a path within a symbolic link: " + name)
+		}
+	}
+	return nil
+}
+
+// importResult is the outcome of importing a single backup archive.
+type importResult struct {
+	Backup     string
+	SnapshotID string
+	// Present is true once a verified snapshot of the archive is in the repository.
+	Present bool
+	Deleted bool
+	Err     error
+}
+
+// importArchive imports a backup archive into the repository as a snapshot of
+// the server data directory, tagged with the UUID of the backup and as imported,
+// and with the time that the archive was created. The archive is extracted into a staging directory
+// that mirrors the data directory, which restic is given as a relative path, so
+// that the snapshot has the same layout as every other snapshot of the server.
+// The snapshot is then listed and compared with the archive, and removed again if
+// they do not match.
+func importArchive(ctx context.Context, s *server.Server, client *resticcli.Client, backupUuid string) (string, error) {
+	archive := backupArchivePath(backupUuid)
+	st, err := os.Stat(archive)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, resticsvc.Seconds(config.Get().Restic.Timeouts.Import))
+	defer cancel()
+
+	volumePath := resticsvc.VolumePath(s.ID())
+	staging := filepath.Join(resticsvc.TempDir(), "import-"+s.ID())
+	defer os.RemoveAll(staging)
+	if err := os.RemoveAll(staging); err != nil {
+		return "", errors.WithStack(err)
+	}
+	contents, err := extractBackupArchive(ctx, archive, filepath.Join(staging, volumePath))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to extract archive")
+	}
+
+	opts := resticcli.BackupOptions{
+		Paths: []string{strings.TrimPrefix(filepath.ToSlash(volumePath), "/")},
+		Dir:   staging,
+		Time:  st.ModTime(),
+		Tags:  []string{resticcli.BackupTagPrefix + backupUuid, resticcli.ImportedTag},
+	}
+	var summary *resticcli.BackupSummary
+	err = resticsvc.RetryAfterStaleUnlock(client, func() error {
+		var err error
+		summary, err = client.Backup(ctx, opts)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var stored archiveContents
+	err = client.Walk(ctx, summary.SnapshotID, volumePath, func(n resticcli.Node) {
+		if n.Type == resticcli.NodeFile {
+			stored.Files++
+			stored.Bytes += n.Size
+		}
+	})
+	if err == nil && stored != contents {
+		err = fmt.Errorf("snapshot contains %d files (%d bytes) but the archive contains %d files (%d bytes)", stored.Files, stored.Bytes, contents.Files, contents.Bytes)
+	}
+	if err != nil {
+		forget, cancel := context.WithTimeout(context.Background(), resticsvc.Seconds(config.Get().Restic.Timeouts.Prune))
+		defer cancel()
+		if _, ferr := client.Forget(forget, summary.SnapshotID); ferr != nil {
+			s.Log().WithField("error", ferr).Warn("failed to remove unverified restic snapshot of imported backup")
+		}
+		return "", errors.Wrap(err, "failed to verify snapshot")
+	}
+	return summary.SnapshotID, nil
+}
+
+// importArchives imports each of the backup archives into the repository. Archives
+// that already have a snapshot are skipped. Once every archive has been imported
+// the repository is checked, and if deleteArchives is true the archives that are
+// in the repository are deleted.
+func importArchives(ctx context.Context, s *server.Server, client *resticcli.Client, backups []string, deleteArchives bool, user string, ip string) ([]importResult, error) {
+	lookup, cancel := context.WithTimeout(ctx, resticsvc.Seconds(config.Get().Restic.Timeouts.Snapshots))
+	snapshots, err := client.Snapshots(lookup, 0)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]string)
+	for _, snap := range snapshots {
+		if id := snap.BackupUUID(); id != "" {
+			existing[id] = snap.ID
+		}
+	}
+
+	results := make([]importResult, 0, len(backups))
+	failed := 0
+	for _, backupUuid := range backups {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		r := importResult{Backup: backupUuid}
+		if id, ok := existing[backupUuid]; ok {
+			r.SnapshotID, r.Present = id, true
+			results = append(results, r)
+			continue
+		}
+		activity := resticsvc.NewActivity(s, user, ip, server.ActivityResticImport)
+		r.SnapshotID, r.Err = importArchive(ctx, s, client, backupUuid)
+		r.Present = r.Err == nil
+		meta := models.ActivityMeta{"backup_uuid": backupUuid}
+		if r.Present {
+			meta["snapshot_id"] = r.SnapshotID
+		} else {
+			failed++
+		}
+		activity.Save(r.Err, meta)
+		results = append(results, r)
+	}
+
+	if deleteArchives {
+		err := resticsvc.RetryAfterStaleUnlock(client, func() error {
+			ctx, cancel := context.WithTimeout(ctx, resticsvc.Seconds(config.Get().Restic.Timeouts.Check))
+			defer cancel()
+			_, err := client.Check(ctx, "")
+			return err
+		})
+		if err != nil {
+			return results, errors.Wrap(err, "repository check failed, no archives were deleted: "+resticsvc.Output(err))
+		}
+		for i, r := range results {
+			if !r.Present {
+				continue
+			}
+			if err := os.Remove(backupArchivePath(r.Backup)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				s.Log().WithField("backup", r.Backup).WithField("error", err).Warn("failed to delete imported backup archive")
+				continue
+			}
+			results[i].Deleted = true
+		}
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d backup archives could not be imported", failed, len(backups))
+	}
+	return results, nil
+}
+
+// importOutput describes the results of an import for the job history.
+func importOutput(results []importResult) string {
+	var b strings.Builder
+	for _, r := range results {
+		switch {
+		case r.Err != nil:
+			fmt.Fprintf(&b, "%s: failed: %s\n", r.Backup, resticsvc.TruncateStatusMessage(resticsvc.Output(r.Err)))
+		case r.Deleted:
+			fmt.Fprintf(&b, "%s: imported as %s, archive deleted\n", r.Backup, resticcli.Snapshot{ID: r.SnapshotID}.Short())
+		default:
+			fmt.Fprintf(&b, "%s: imported as %s\n", r.Backup, resticcli.Snapshot{ID: r.SnapshotID}.Short())
+		}
+	}
+	return b.String()
+}
+
+// POST /api/servers/:server/backups/restic/import
+//
+// Imports backup archives created by the local backup adapter into the repository
+// of the server as an import job. The archives are only deleted if requested, and
+// only once the repository has been checked after the import.
+func ImportServerResticBackups(c *gin.Context) {
+	if refuseWhileTransferring(c) {
+		return
+	}
+	var body struct {
+		Backups        []string `json:"backups"`
+		DeleteArchives bool     `json:"delete_archives"`
+	}
+	if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil || len(body.Backups) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing backups"})
+		return
+	}
+	for _, id := range body.Backups {
+		if _, err := uuid.Parse(id); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid backup uuid: " + id})
+			return
+		}
+		if _, err := os.Stat(backupArchivePath(id)); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "backup archive not found: " + id})
+			return
+		}
+	}
+
+	client, err := resticRepoFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := resticsvc.InitRepoIfMissing(client); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "init failed", "output": resticsvc.Output(err)})
+		return
+	}
+
+	s := c.MustGet("server").(*server.Server)
+	user, ip := requestActor(c)
+	job := newJob(c, resticsvc.JobImport, s.ID(), "")
+	job.Client = client
+	resticsvc.Jobs.Submit(job, func(j *resticsvc.Job) error {
+		results, err := importArchives(j.Context(), s, client, body.Backups, body.DeleteArchives, user, ip)
+		j.Output = importOutput(results)
+		return err
+	})
+	c.JSON(http.StatusAccepted, gin.H{"message": "import started", "job_id": job.ID, "queue_position": resticsvc.Jobs.Position(resticsvc.JobImport, s.ID(), "")})
+}
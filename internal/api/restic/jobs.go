@@ -0,0 +1,130 @@
+package restic
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"emperror.dev/errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/pterodactyl/wings/internal/database"
+	"github.com/pterodactyl/wings/internal/models"
+	"github.com/pterodactyl/wings/internal/resticsvc"
+)
+
+// newJob returns a job of the given type for the server, started by the user
+// that made the request.
+func newJob(c *gin.Context, t resticsvc.JobType, server string, ref string) *resticsvc.Job {
+	user, ip := requestActor(c)
+	return &resticsvc.Job{
+		Type:     t,
+		Server:   server,
+		Ref:      ref,
+		Priority: jobPriorityFromRequest(c),
+		Actor:    user,
+		IP:       ip,
+	}
+}
+
+// jobPriorityFromRequest returns the priority of a job started by the request.
+// Requests made by a schedule pass scheduled=true so that backups started by a
+// user are not stuck behind every scheduled backup on the node.
+func jobPriorityFromRequest(c *gin.Context) resticsvc.JobPriority {
+	v := strings.ToLower(strings.TrimSpace(c.Query("scheduled")))
+	if v == "1" || v == "true" || v == "yes" {
+		return resticsvc.PriorityScheduled
+	}
+	return resticsvc.PriorityManual
+}
+
+// refuseWhileTransferring responds with a conflict and returns true if the
+// repositories of the server are being transferred to another node, since
+// anything written to them now would be lost once they have been removed.
+func refuseWhileTransferring(c *gin.Context) bool {
+	if !resticsvc.Jobs.Holding(c.Param("server")) {
+		return false
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": resticsvc.ErrServerTransferring.Error()})
+	return true
+}
+
+// GET /api/servers/:server/backups/restic/jobs
+//
+// Returns the restic jobs that have been run for the server, newest first. The
+// results can be filtered by type and status, and are paged using the limit and
+// cursor query parameters.
+func ListServerResticJobs(c *gin.Context) {
+	serverId := c.Param("server")
+	if serverId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing server id"})
+		return
+	}
+	resticsvc.FailInterruptedJobs()
+
+	limit, offset := pageParams(c)
+	query := func() *gorm.DB {
+		tx := database.Instance().Model(&models.ResticJob{}).Where("server = ?", serverId)
+		if t := c.Query("type"); t != "" {
+			tx = tx.Where("type = ?", t)
+		}
+		if status := c.Query("status"); status != "" {
+			tx = tx.Where("status = ?", status)
+		}
+		return tx
+	}
+
+	var total int64
+	if err := query().Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list jobs"})
+		return
+	}
+	page := []models.ResticJob{}
+	if err := query().Order("queued_at DESC").Order("id").Limit(limit).Offset(offset).Find(&page).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list jobs"})
+		return
+	}
+
+	nextCursor := ""
+	if int64(offset+len(page)) < total {
+		nextCursor = strconv.Itoa(offset + len(page))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"jobs":        page,
+		"next_cursor": nextCursor,
+		"limit":       limit,
+		"total":       total,
+	})
+}
+
+// DELETE /api/servers/:server/backups/restic/jobs/:id
+//
+// Cancels a job that is waiting in the queue or running. A running job has its
+// restic process interrupted, and the request waits for it to exit.
+func CancelServerResticJob(c *gin.Context) {
+	serverId := c.Param("server")
+	id := c.Param("id")
+	if serverId == "" || id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing server or job id"})
+		return
+	}
+
+	var job models.ResticJob
+	if err := database.Instance().Where("id = ? AND server = ?", id, serverId).First(&job).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to find job"})
+		return
+	}
+
+	j := resticsvc.Jobs.Cancel(id)
+	if j == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "job has already finished", "status": job.Status})
+		return
+	}
+	<-j.Done()
+	c.JSON(http.StatusOK, gin.H{"message": "job cancelled", "job_id": id})
+}
@@ -0,0 +1,277 @@
+package restic
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/models"
+	resticcli "github.com/pterodactyl/wings/internal/restic"
+	"github.com/pterodactyl/wings/internal/resticsvc"
+	"github.com/pterodactyl/wings/server"
+)
+
+// keyCommandContext returns the context that key management commands are run
+// with. They only read and write a handful of small files in the repository.
+func keyCommandContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), resticsvc.Seconds(config.Get().Restic.Timeouts.Snapshots))
+}
+
+// newKeyFromRequest returns the new key sent in the body of the request.
+func newKeyFromRequest(c *gin.Context) string {
+	var body struct {
+		NewKey string `json:"new_key"`
+	}
+	_ = c.ShouldBindBodyWith(&body, binding.JSON)
+	return strings.TrimSpace(body.NewKey)
+}
+
+// keyCommandFailed responds with the error from a key management command.
+func keyCommandFailed(c *gin.Context, err error, message string) {
+	switch {
+	case resticcli.IsLocked(err):
+		c.JSON(http.StatusConflict, gin.H{"error": "repo busy"})
+	case resticcli.IsTimeout(err):
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": message + ": timed out"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message, "output": resticsvc.Output(err)})
+	}
+}
+
+// currentKeyID returns the ID of the key that the client opens the repository
+// with, which also verifies that its password is correct.
+func currentKeyID(client *resticcli.Client) (string, error) {
+	ctx, cancel := keyCommandContext()
+	defer cancel()
+	keys, err := client.Keys(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, k := range keys {
+		if k.Current {
+			return k.ID, nil
+		}
+	}
+	return "", nil
+}
+
+// verifyResticKey checks that the repository can be opened with the key and
+// returns the ID of the key that it opened.
+func verifyResticKey(repo string, key string) (string, error) {
+	return currentKeyID(resticsvc.NewClient(repo, key))
+}
+
+// GET /api/servers/:server/backups/restic/keys
+func ListServerResticKeys(c *gin.Context) {
+	client, err := resticRepoFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx, cancel := keyCommandContext()
+	defer cancel()
+	keys, err := client.Keys(ctx)
+	if err != nil {
+		keyCommandFailed(c, err, "failed to list keys")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// POST /api/servers/:server/backups/restic/keys
+//
+// Adds a key with the password given as new_key, which is checked by opening the
+// repository with it. The key that Wings uses is not changed.
+func AddServerResticKey(c *gin.Context) {
+	if refuseWhileTransferring(c) {
+		return
+	}
+	client, err := resticRepoFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	newKey := newKeyFromRequest(c)
+	if newKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing new_key"})
+		return
+	}
+
+	activity := newResticActivity(c, server.ActivityResticKeyAdd)
+	ctx, cancel := keyCommandContext()
+	defer cancel()
+	id, err := client.AddKey(ctx, newKey)
+	if err == nil {
+		id, err = verifyResticKey(client.Repository(), newKey)
+	}
+	activity.Save(err, models.ActivityMeta{"key_id": id})
+	if err != nil {
+		keyCommandFailed(c, err, "failed to add key")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"key_id": id})
+}
+
+// DELETE /api/servers/:server/backups/restic/keys/:keyId
+func RemoveServerResticKey(c *gin.Context) {
+	if refuseWhileTransferring(c) {
+		return
+	}
+	client, err := resticRepoFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	id := strings.TrimSpace(c.Param("keyId"))
+	if !snapshotIDPattern.MatchString(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key id"})
+		return
+	}
+
+	ctx, cancel := keyCommandContext()
+	defer cancel()
+	keys, err := client.Keys(ctx)
+	if err != nil {
+		keyCommandFailed(c, err, "failed to list keys")
+		return
+	}
+	for _, k := range keys {
+		if k.Current && strings.HasPrefix(k.ID, id) {
+			c.JSON(http.StatusConflict, gin.H{"error": "cannot remove the key that Wings uses, rotate it instead"})
+			return
+		}
+	}
+
+	activity := newResticActivity(c, server.ActivityResticKeyRemove)
+	err = client.RemoveKey(ctx, id)
+	activity.Save(err, models.ActivityMeta{"key_id": id})
+	if err != nil {
+		if resticcli.AsError(err).NotFound() {
+			c.JSON(http.StatusNotFound, gin.H{"error": "key not found"})
+			return
+		}
+		keyCommandFailed(c, err, "failed to remove key")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"removed": true, "key_id": id})
+}
+
+// POST /api/servers/:server/backups/restic/keys/passwd
+//
+// Changes the password of the key that Wings uses to new_key. Restic replaces
+// the key with a new one, so the ID of the key changes.
+func ChangeServerResticKeyPassword(c *gin.Context) {
+	if refuseWhileTransferring(c) {
+		return
+	}
+	client, err := resticRepoFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	newKey := newKeyFromRequest(c)
+	if newKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing new_key"})
+		return
+	}
+
+	activity := newResticActivity(c, server.ActivityResticKeyRotate)
+	ctx, cancel := keyCommandContext()
+	defer cancel()
+	if err := client.ChangePassword(ctx, newKey); err != nil {
+		activity.Save(err, models.ActivityMeta{"method": "passwd"})
+		keyCommandFailed(c, err, "failed to change key password")
+		return
+	}
+
+	// The old password no longer opens the repository, so the new one must be
+	// stored even if it cannot be verified.
+	repo := client.Repository()
+	if err := resticsvc.StoreKey(repo, newKey); err != nil {
+		activity.Save(err, models.ActivityMeta{"method": "passwd"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "password changed but the new key could not be stored"})
+		return
+	}
+	id, err := verifyResticKey(repo, newKey)
+	activity.Save(err, models.ActivityMeta{"method": "passwd", "key_id": id})
+	if err != nil {
+		keyCommandFailed(c, err, "failed to verify new key")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"key_id": id})
+}
+
+// POST /api/servers/:server/backups/restic/keys/rotate
+//
+// Replaces the key that Wings uses with new_key without ever leaving the
+// repository without a working key: the new key is added and verified, then
+// stored, and only then is the old key removed.
+func RotateServerResticKey(c *gin.Context) {
+	if refuseWhileTransferring(c) {
+		return
+	}
+	client, err := resticRepoFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	newKey := newKeyFromRequest(c)
+	if newKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing new_key"})
+		return
+	}
+	repo := client.Repository()
+	if stored, _ := resticsvc.ResolveKey(repo, ""); stored == newKey {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "new_key is already in use"})
+		return
+	}
+
+	oldId, err := currentKeyID(client)
+	if err != nil {
+		keyCommandFailed(c, err, "failed to open repository with the current key")
+		return
+	}
+
+	activity := newResticActivity(c, server.ActivityResticKeyRotate)
+	meta := models.ActivityMeta{"method": "rotate", "old_key_id": oldId}
+	ctx, cancel := keyCommandContext()
+	defer cancel()
+	addedId, err := client.AddKey(ctx, newKey)
+	if err != nil {
+		activity.Save(err, meta)
+		keyCommandFailed(c, err, "failed to add key")
+		return
+	}
+	newId, err := verifyResticKey(repo, newKey)
+	if err == nil {
+		err = resticsvc.StoreKey(repo, newKey)
+	}
+	if err != nil {
+		// Nothing depends on the new key yet, so remove it again and keep using the
+		// old one.
+		if newId == "" {
+			newId = addedId
+		}
+		if newId != "" {
+			_ = client.RemoveKey(ctx, newId)
+		}
+		activity.Save(err, meta)
+		keyCommandFailed(c, err, "failed to verify new key")
+		return
+	}
+	meta["key_id"] = newId
+
+	removed := true
+	if oldId != "" {
+		if err := resticsvc.NewClient(repo, newKey).RemoveKey(ctx, oldId); err != nil {
+			removed = false
+			meta["remove_error"] = resticsvc.TruncateStatusMessage(resticsvc.Output(err))
+		}
+	}
+	activity.Save(nil, meta)
+	c.JSON(http.StatusOK, gin.H{"key_id": newId, "old_key_id": oldId, "old_key_removed": removed})
+}
@@ -5,7 +5,6 @@ import (
     "context"
     "crypto/sha256"
     "encoding/hex"
-    "encoding/json"
     "fmt"
     "log"
     "net/http"
@@ -16,24 +15,32 @@ import (
     "time"
 
     "github.com/gin-gonic/gin"
+    "github.com/gin-gonic/gin/binding"
+    "github.com/pterodactyl/wings/config"
+    "github.com/pterodactyl/wings/internal/resticsvc"
     "github.com/pterodactyl/wings/server"
+
+    resticcli "github.com/pterodactyl/wings/internal/restic"
 )
 
 // POST /api/servers/:server/backups/restic/:backupId/prepare
 func PrepareServerResticBackupHandler(c *gin.Context) {
+    if refuseWhileTransferring(c) {
+        return
+    }
     backupId := c.Param("backupId")
     log.Printf("restic prepare handler hit server=%s backup=%s", c.Param("server"), backupId)
+    encryptionKey, err := requestEncryptionKey(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
     var body struct {
-        EncryptionKey string `json:"encryption_key"`
         OwnerUsername string `json:"owner_username"`
     }
-    _ = c.ShouldBindJSON(&body)
+    _ = c.ShouldBindBodyWith(&body, binding.JSON)
 
-    encryptionKey := body.EncryptionKey
     ownerUsername := body.OwnerUsername
-    if encryptionKey == "" {
-        encryptionKey = c.Query("encryption_key")
-    }
     if ownerUsername == "" {
         ownerUsername = c.Query("owner_username")
     }
@@ -54,21 +61,33 @@ func PrepareServerResticBackupHandler(c *gin.Context) {
         return
     }
 
+    repo := resticsvc.RepoPath(resticsvc.ResolveRepoDir(s.ID(), ownerUsername))
+    resolvedKey, err := resticsvc.ResolveKey(repo, encryptionKey)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    job := newJob(c, resticsvc.JobPrepare, s.ID(), backupId)
+    job.Snapshot = backupId
+    job.Client = resticsvc.NewClient(repo, resolvedKey)
     if async {
-        setDownloadStatus(s.ID(), backupId, "running", "")
+        resticsvc.SetDownloadStatus(s.ID(), backupId, "running", "")
         serverId := s.ID()
-        go func() {
-            if err := prepareServerResticBackupInternal(serverId, backupId, encryptionKey, ownerUsername); err != nil {
-                setDownloadStatus(serverId, backupId, "failed", err.Error())
-                return
+        resticsvc.Jobs.Submit(job, func(j *resticsvc.Job) error {
+            resticsvc.SetDownloadStatus(serverId, backupId, "running", "")
+            if err := prepareServerResticBackupInternal(j.Context(), serverId, backupId, resolvedKey, ownerUsername); err != nil {
+                resticsvc.SetDownloadStatus(serverId, backupId, "failed", err.Error())
+                return err
             }
-            setDownloadStatus(serverId, backupId, "ready", "")
-        }()
-        c.JSON(http.StatusAccepted, gin.H{"message": "preparing"})
+            resticsvc.SetDownloadStatus(serverId, backupId, "ready", "")
+            return nil
+        })
+        c.JSON(http.StatusAccepted, gin.H{"message": "preparing", "job_id": job.ID, "queue_position": resticsvc.Jobs.Position(resticsvc.JobPrepare, serverId, backupId)})
         return
     }
 
-    if err := prepareServerResticBackupInternal(s.ID(), backupId, encryptionKey, ownerUsername); err != nil {
+    if err := prepareQueued(job, resolvedKey, ownerUsername); err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
@@ -84,107 +103,25 @@ func GetServerResticBackupPrepareStatus(c *gin.Context) {
         return
     }
     s := c.MustGet("server").(*server.Server)
-    status, err := readDownloadStatus(s.ID(), backupId)
+    status, err := resticsvc.ReadDownloadStatus(s.ID(), backupId)
     if err != nil || status.Status == "" {
         c.JSON(http.StatusOK, gin.H{"status": "idle"})
         return
     }
+    status.QueuePosition = resticsvc.Jobs.Position(resticsvc.JobPrepare, s.ID(), backupId)
     c.JSON(http.StatusOK, status)
 }
 
-type resticDownloadStatus struct {
-    Status     string `json:"status"`
-    StartedAt  string `json:"started_at,omitempty"`
-    FinishedAt string `json:"finished_at,omitempty"`
-    Message    string `json:"message,omitempty"`
-}
-
-func downloadStatusDir() string {
-    return "/var/lib/pterodactyl/restic/.download-status"
-}
-
-func downloadStatusPath(serverId string, backupId string) string {
-    return filepath.Join(downloadStatusDir(), serverId+"-"+backupId+".json")
-}
-
-func readDownloadStatus(serverId string, backupId string) (resticDownloadStatus, error) {
-    var status resticDownloadStatus
-    data, err := os.ReadFile(downloadStatusPath(serverId, backupId))
-    if err != nil {
-        return status, err
-    }
-    if err := json.Unmarshal(data, &status); err != nil {
-        return resticDownloadStatus{}, err
-    }
-    return status, nil
-}
-
-func writeDownloadStatus(serverId string, backupId string, status resticDownloadStatus) {
-    if serverId == "" || backupId == "" {
-        return
-    }
-    _ = os.MkdirAll(downloadStatusDir(), 0755)
-    data, err := json.Marshal(status)
-    if err != nil {
-        return
-    }
-    tmp := downloadStatusPath(serverId, backupId) + ".tmp"
-    if err := os.WriteFile(tmp, data, 0644); err == nil {
-        _ = os.Rename(tmp, downloadStatusPath(serverId, backupId))
-    }
-    cleanupDownloadStatusDir(downloadStatusDir(), 7*24*time.Hour)
-}
-
 func prepareLog(message string) {
     line := "[" + time.Now().Format(time.RFC3339) + "] " + message + "\n"
     log.Printf("restic prepare: %s", message)
-    _ = os.MkdirAll("/var/lib/pterodactyl/restic", 0755)
-    if f, err := os.OpenFile("/var/lib/pterodactyl/restic/prepare.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+    _ = os.MkdirAll(resticsvc.RepoBaseDir(), 0755)
+    if f, err := os.OpenFile(filepath.Join(resticsvc.RepoBaseDir(), "prepare.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
         _, _ = f.WriteString(line)
         _ = f.Close()
     }
 }
 
-func cleanupDownloadStatusDir(dir string, maxAge time.Duration) {
-    entries, err := os.ReadDir(dir)
-    if err != nil {
-        return
-    }
-    now := time.Now()
-    for _, entry := range entries {
-        info, err := entry.Info()
-        if err != nil {
-            continue
-        }
-        if now.Sub(info.ModTime()) > maxAge {
-            _ = os.Remove(filepath.Join(dir, entry.Name()))
-        }
-    }
-}
-
-func setDownloadStatus(serverId string, backupId string, status string, message string) {
-    if serverId == "" || backupId == "" {
-        return
-    }
-    current, _ := readDownloadStatus(serverId, backupId)
-    next := resticDownloadStatus{
-        Status: status,
-    }
-    if current.StartedAt != "" {
-        next.StartedAt = current.StartedAt
-    }
-    if status == "running" && next.StartedAt == "" {
-        next.StartedAt = time.Now().Format(time.RFC3339)
-    }
-    if status == "ready" || status == "failed" {
-        next.FinishedAt = time.Now().Format(time.RFC3339)
-    }
-    if message != "" {
-        next.Message = message
-    }
-    writeDownloadStatus(serverId, backupId, next)
-}
-
 // PrepareServerResticBackup restores a snapshot to a temp directory and creates a tar.gz archive.
 func PrepareServerResticBackup(c *gin.Context, s *server.Server, backupId, encryptionKey, ownerUsername string) error {
     serverId := s.ID()
@@ -199,21 +136,37 @@ func PrepareServerResticBackup(c *gin.Context, s *server.Server, backupId, encry
         c.JSON(http.StatusBadRequest, gin.H{"error": "missing encryption_key or owner_username"})
         return fmt.Errorf("missing encryption_key or owner_username")
     }
-    if err := prepareServerResticBackupInternal(serverId, backupId, encryptionKey, ownerUsername); err != nil {
+    if err := prepareQueued(newJob(c, resticsvc.JobPrepare, serverId, backupId), encryptionKey, ownerUsername); err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "prepare failed"})
         return err
     }
     return nil
 }
 
+// prepareQueued prepares the snapshot that the job refers to for download once a
+// worker is available and waits for it to finish. The password stored for the
+// repository is used in place of the provided key when there is one.
+func prepareQueued(job *resticsvc.Job, encryptionKey, ownerUsername string) error {
+    repo := resticsvc.RepoPath(resticsvc.ResolveRepoDir(job.Server, ownerUsername))
+    resolvedKey, err := resticsvc.ResolveKey(repo, encryptionKey)
+    if err != nil {
+        return err
+    }
+    job.Snapshot = job.Ref
+    job.Client = resticsvc.NewClient(repo, resolvedKey)
+    return resticsvc.Jobs.Run(job, func(j *resticsvc.Job) error {
+        return prepareServerResticBackupInternal(j.Context(), j.Server, j.Ref, resolvedKey, ownerUsername)
+    })
+}
+
 func preparedArchivePath(serverId, backupId, ext string) string {
-    tempDir := "/var/lib/pterodactyl/restic/temp"
+    tempDir := resticsvc.TempDir()
     sum := sha256.Sum256([]byte(backupId))
     short := hex.EncodeToString(sum[:8])
     return filepath.Join(tempDir, serverId+"-"+short+ext)
 }
 
-func prepareServerResticBackupInternal(serverId, backupId, encryptionKey, ownerUsername string) error {
+func prepareServerResticBackupInternal(ctx context.Context, serverId, backupId, encryptionKey, ownerUsername string) error {
     if backupId == "" {
         return fmt.Errorf("missing backup_id")
     }
@@ -223,9 +176,9 @@ func prepareServerResticBackupInternal(serverId, backupId, encryptionKey, ownerU
 
     prepareLog("prepare start server=" + serverId + " backup=" + backupId)
 
-    repoDir := resolveRepoDir(serverId, ownerUsername)
-    repo := fmt.Sprintf("/var/lib/pterodactyl/restic/%s", repoDir)
-    tempDir := "/var/lib/pterodactyl/restic/temp"
+    repoDir := resticsvc.ResolveRepoDir(serverId, ownerUsername)
+    repo := resticsvc.RepoPath(repoDir)
+    tempDir := resticsvc.TempDir()
     if err := os.MkdirAll(tempDir, 0700); err != nil {
         return err
     }
@@ -236,29 +189,21 @@ func prepareServerResticBackupInternal(serverId, backupId, encryptionKey, ownerU
     restoreDir := filepath.Join(tempDir, serverId+"-"+short+"-restore")
     _ = os.RemoveAll(restoreDir)
 
-    env := append(os.Environ(), "RESTIC_PASSWORD="+encryptionKey)
-    restoreCtx, restoreCancel := context.WithTimeout(context.Background(), 2*time.Hour)
+    restoreCtx, restoreCancel := context.WithTimeout(ctx, resticsvc.Seconds(config.Get().Restic.Timeouts.Prepare))
     defer restoreCancel()
-    restoreCmd := exec.CommandContext(restoreCtx, "restic", "-r", repo, "restore", backupId, "--target", restoreDir)
-    restoreCmd.Env = env
-
-    var restoreErr bytes.Buffer
-    restoreCmd.Stderr = &restoreErr
-    if err := restoreCmd.Run(); err != nil {
+    _, err := resticsvc.NewClient(repo, encryptionKey).Restore(restoreCtx, resticcli.RestoreOptions{Snapshot: backupId, Target: restoreDir})
+    if err != nil {
         _ = os.RemoveAll(restoreDir)
-        if restoreCtx.Err() == context.DeadlineExceeded {
+        if resticcli.IsTimeout(err) {
             prepareLog("restore timeout server=" + serverId + " backup=" + backupId)
             return fmt.Errorf("restore timed out")
         }
-        detail := strings.TrimSpace(restoreErr.String())
-        if detail == "" {
-            detail = err.Error()
-        }
+        detail := resticsvc.Output(err)
         prepareLog("restore failed server=" + serverId + " backup=" + backupId + " error=" + detail)
         return fmt.Errorf("restic restore failed: %s", detail)
     }
 
-    volumeSubdir := filepath.Join(restoreDir, "var/lib/pterodactyl/volumes", serverId)
+    volumeSubdir := filepath.Join(restoreDir, resticsvc.VolumePath(serverId))
     tarBase := restoreDir
     if st, err := os.Stat(volumeSubdir); err == nil && st.IsDir() {
         tarBase = volumeSubdir
@@ -282,7 +227,7 @@ func prepareServerResticBackupInternal(serverId, backupId, encryptionKey, ownerU
     }
     _ = os.Remove(tarFile)
 
-    tarCtx, tarCancel := context.WithTimeout(context.Background(), 2*time.Hour)
+    tarCtx, tarCancel := context.WithTimeout(ctx, resticsvc.Seconds(config.Get().Restic.Timeouts.Prepare))
     defer tarCancel()
     var tarCmd *exec.Cmd
     if useZstd {
@@ -310,4 +255,4 @@ func prepareServerResticBackupInternal(serverId, backupId, encryptionKey, ownerU
     _ = os.RemoveAll(restoreDir)
     prepareLog("prepare ok server=" + serverId + " backup=" + backupId + " file=" + tarFile)
     return nil
-}
\ No newline at end of file
+}
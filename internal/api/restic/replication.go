@@ -0,0 +1,191 @@
+package restic
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/database"
+	"github.com/pterodactyl/wings/internal/models"
+	resticcli "github.com/pterodactyl/wings/internal/restic"
+	"github.com/pterodactyl/wings/internal/resticsvc"
+)
+
+type resticReplicaResponse struct {
+	models.ResticReplica
+	// Target is where the secondary repositories of the server are created, or
+	// an empty string if its snapshots are not copied.
+	Target string `json:"target"`
+}
+
+// GET /api/servers/:server/backups/restic/replication
+//
+// Returns the replication settings of the server along with the result of the
+// most recent copy.
+func GetServerResticReplication(c *gin.Context) {
+	r, err := resticsvc.LoadReplica(c.Param("server"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load replication settings"})
+		return
+	}
+	c.JSON(http.StatusOK, resticReplicaResponse{ResticReplica: r, Target: resticsvc.ReplicaBase(r)})
+}
+
+// POST /api/servers/:server/backups/restic/replication
+//
+// Saves the replication settings of the server. The location can be left empty
+// to use the replication URL of the node.
+func SaveServerResticReplication(c *gin.Context) {
+	serverId := c.Param("server")
+	var body struct {
+		Enabled  *bool  `json:"enabled"`
+		Location string `json:"location"`
+		retentionRules
+	}
+	if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	location := strings.TrimSpace(body.Location)
+	if location != "" && !resticsvc.IsRemoteRepo(location) && !filepath.IsAbs(location) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "location must be an absolute path or a restic repository URL"})
+		return
+	}
+	rules, err := body.policy()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	r, err := resticsvc.LoadReplica(serverId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load replication settings"})
+		return
+	}
+	if body.Enabled != nil {
+		r.Enabled = *body.Enabled
+	}
+	r.Location = ""
+	if location != "" {
+		r.Location = resticsvc.NormalizeRepo(location)
+	}
+	r.KeepLast, r.KeepHourly, r.KeepDaily = rules.KeepLast, rules.KeepHourly, rules.KeepDaily
+	r.KeepWeekly, r.KeepMonthly, r.KeepYearly = rules.KeepWeekly, rules.KeepMonthly, rules.KeepYearly
+	r.KeepWithin = rules.KeepWithin
+
+	if err := database.Instance().Save(&r).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save replication settings"})
+		return
+	}
+	c.JSON(http.StatusOK, resticReplicaResponse{ResticReplica: r, Target: resticsvc.ReplicaBase(r)})
+}
+
+// replicaSnapshot is the newest snapshot in a repository.
+type replicaSnapshot struct {
+	SnapshotID string     `json:"snapshot_id"`
+	Time       *time.Time `json:"time"`
+	Count      int        `json:"count"`
+}
+
+// newestSnapshot returns the newest of the snapshots.
+func newestSnapshot(snapshots []resticcli.Snapshot) (replicaSnapshot, *resticcli.Snapshot) {
+	res := replicaSnapshot{Count: len(snapshots)}
+	var newest *resticcli.Snapshot
+	for i := range snapshots {
+		if newest == nil || snapshots[i].Time.After(newest.Time) {
+			newest = &snapshots[i]
+		}
+	}
+	if newest != nil {
+		res.SnapshotID = newest.ID
+		res.Time = &newest.Time
+	}
+	return res, newest
+}
+
+// GET /api/servers/:server/backups/restic/replication/status
+//
+// Returns the newest snapshot in the primary and secondary repositories of the
+// server, and how far the secondary repository is behind. Copies keep the time of
+// the snapshot they were made from, so every primary snapshot newer than the
+// newest copy is still waiting to be copied.
+func GetServerResticReplicationStatus(c *gin.Context) {
+	primary, err := resticRepoFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	r, err := resticsvc.LoadReplica(c.Param("server"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load replication settings"})
+		return
+	}
+	repo := resticsvc.ReplicaRepo(r, primary.Repository())
+	if repo == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "replication is not configured for this server"})
+		return
+	}
+	key, err := resticsvc.ResolveKey(primary.Repository(), "")
+	if err == nil {
+		key, err = resticsvc.ReplicaKey(repo, key)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	secondary := resticsvc.NewReplicaClient(repo, key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), resticsvc.Seconds(config.Get().Restic.Timeouts.Snapshots))
+	defer cancel()
+	primarySnapshots, err := primary.Snapshots(ctx, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list primary snapshots", "output": resticsvc.Output(err)})
+		return
+	}
+	var secondarySnapshots []resticcli.Snapshot
+	ok, err := secondary.Initialized(ctx)
+	if err == nil && ok {
+		secondarySnapshots, err = secondary.Snapshots(ctx, 0)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list secondary snapshots", "output": resticsvc.Output(err)})
+		return
+	}
+
+	p, newestPrimary := newestSnapshot(primarySnapshots)
+	s, newestSecondary := newestSnapshot(secondarySnapshots)
+	behind := 0
+	for _, snap := range primarySnapshots {
+		if newestSecondary == nil || snap.Time.After(newestSecondary.Time) {
+			behind++
+		}
+	}
+	// The lag is unknown until the secondary repository has a snapshot.
+	var lag *float64
+	if newestSecondary != nil {
+		v := 0.0
+		if newestPrimary != nil && newestPrimary.Time.After(newestSecondary.Time) {
+			v = newestPrimary.Time.Sub(newestSecondary.Time).Seconds()
+		}
+		lag = &v
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"repository":   repo,
+		"primary":      p,
+		"secondary":    s,
+		"in_sync":      behind == 0,
+		"behind":       behind,
+		"lag_seconds":  lag,
+		"last_copy_at": r.LastCopyAt,
+		"last_status":  r.LastStatus,
+		"last_message": r.LastMessage,
+		"last_job_id":  r.LastJobID,
+	})
+}
@@ -1,35 +1,143 @@
 package restic
 
 import (
-    "bytes"
     "context"
-    "encoding/json"
     "fmt"
     "net/http"
     "os"
-    "os/exec"
     "path/filepath"
     "strings"
     "time"
 
+    "emperror.dev/errors"
     "github.com/gin-gonic/gin"
+    "github.com/gin-gonic/gin/binding"
+    "golang.org/x/sys/unix"
+
+    "github.com/pterodactyl/wings/config"
+    "github.com/pterodactyl/wings/environment"
+    "github.com/pterodactyl/wings/internal/models"
+    "github.com/pterodactyl/wings/internal/resticsvc"
     "github.com/pterodactyl/wings/server"
+
+    resticcli "github.com/pterodactyl/wings/internal/restic"
+)
+
+// The restore modes control what happens when the server is running at the time
+// a snapshot is restored over its data directory.
+const (
+    // restoreModeStop gracefully stops the server before restoring the snapshot.
+    restoreModeStop = "stop"
+    // restoreModeRefuse rejects the restore while the server is running.
+    restoreModeRefuse = "refuse"
+)
+
+// The restore destinations control where the files from a snapshot are written.
+const (
+    // restoreToInPlace overwrites the files in the server data directory.
+    restoreToInPlace = "in_place"
+    // restoreToFolder writes the files into a new restored-<shortid> folder within
+    // the server data directory, leaving the existing files untouched.
+    restoreToFolder = "folder"
 )
 
 // POST /api/servers/:server/backups/restic/:backupId/restore
 func RestoreServerResticBackupHandler(c *gin.Context) {
-    backupId := c.Param("backupId")
+    restoreServerResticSnapshot(c, c.Param("backupId"))
+}
+
+// POST /api/servers/:server/backups/restic/rollback
+//
+// Restores the snapshot that was taken before the most recent restore, or the
+// pre-restore snapshot given in the request, undoing a restore of the wrong
+// snapshot. The rollback is a restore in itself, so the current state of the
+// server is snapshotted again before it is rolled back.
+func RollbackServerResticRestore(c *gin.Context) {
+    encryptionKey, err := requestEncryptionKey(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
     var body struct {
-        EncryptionKey string `json:"encryption_key"`
         OwnerUsername string `json:"owner_username"`
+        SnapshotID    string `json:"snapshot_id"`
     }
-    _ = c.ShouldBindJSON(&body)
+    _ = c.ShouldBindBodyWith(&body, binding.JSON)
 
-    encryptionKey := body.EncryptionKey
     ownerUsername := body.OwnerUsername
-    if encryptionKey == "" {
-        encryptionKey = c.Query("encryption_key")
+    if ownerUsername == "" {
+        ownerUsername = c.Query("owner_username")
+    }
+    if encryptionKey == "" || ownerUsername == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "missing encryption_key or owner_username"})
+        return
+    }
+
+    s := c.MustGet("server").(*server.Server)
+    repo := resticsvc.RepoPath(resticsvc.ResolveRepoDir(s.ID(), ownerUsername))
+    if !resticsvc.RepoExists(repo) {
+        c.JSON(http.StatusNotFound, gin.H{"error": "repo not found"})
+        return
+    }
+
+    resolvedKey, err := resticsvc.ResolveKey(repo, encryptionKey)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), resticsvc.Seconds(config.Get().Restic.Timeouts.Snapshots))
+    snapshots, err := resticsvc.NewClient(repo, resolvedKey).Snapshots(ctx, 0)
+    cancel()
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list snapshots", "output": resticsvc.Output(err)})
+        return
+    }
+
+    var target *resticcli.Snapshot
+    for i, snap := range snapshots {
+        if !snap.PreRestore() || (body.SnapshotID != "" && !snap.Matches(body.SnapshotID)) {
+            continue
+        }
+        if target == nil || snap.Time.After(target.Time) {
+            target = &snapshots[i]
+        }
+    }
+    if target == nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": "no pre-restore snapshot found"})
+        return
+    }
+
+    restoreServerResticSnapshot(c, target.ID)
+}
+
+// restoreServerResticSnapshot restores a snapshot, or the given paths within it,
+// into the data directory of the server on the request context. When files are
+// overwritten in place a pre-restore snapshot of the current state of the server
+// is taken first.
+func restoreServerResticSnapshot(c *gin.Context, backupId string) {
+    if refuseWhileTransferring(c) {
+        return
+    }
+    encryptionKey, err := requestEncryptionKey(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
     }
+    var body struct {
+        OwnerUsername string `json:"owner_username"`
+        Mode          string `json:"mode"`
+        // RestorePowerState starts the server again once the restore has finished
+        // if it was running before it was stopped for the restore.
+        RestorePowerState bool `json:"restore_power_state"`
+        // Paths limits the restore to these files and folders, relative to the
+        // root of the server data directory.
+        Paths       []string `json:"paths"`
+        Destination string   `json:"destination"`
+    }
+    _ = c.ShouldBindBodyWith(&body, binding.JSON)
+
+    ownerUsername := body.OwnerUsername
     if ownerUsername == "" {
         ownerUsername = c.Query("owner_username")
     }
@@ -38,18 +146,71 @@ func RestoreServerResticBackupHandler(c *gin.Context) {
         return
     }
 
+    mode := strings.ToLower(strings.TrimSpace(body.Mode))
+    if mode == "" {
+        mode = strings.ToLower(strings.TrimSpace(c.Query("mode")))
+    }
+    if mode == "" {
+        mode = restoreModeStop
+    }
+    if mode != restoreModeStop && mode != restoreModeRefuse {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid restore mode"})
+        return
+    }
+
+    destination := strings.ToLower(strings.TrimSpace(body.Destination))
+    if destination == "" {
+        destination = restoreToInPlace
+    }
+    if destination != restoreToInPlace && destination != restoreToFolder {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid restore destination"})
+        return
+    }
+    overwrite := destination == restoreToInPlace
+
     s := c.MustGet("server").(*server.Server)
     serverId := s.ID()
 
+    var includes []string
+    for _, p := range body.Paths {
+        rel, err := s.Filesystem().ValidatePath(p)
+        if err != nil || rel == "." {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "invalid restore path: " + p})
+            return
+        }
+        includes = append(includes, "/"+resticcli.EscapePattern(rel))
+    }
+
+    var restoredTo string
+    if !overwrite {
+        var err error
+        restoredTo, err = s.Filesystem().ValidatePath("restored-" + resticcli.Snapshot{ID: backupId}.Short())
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "invalid restore destination"})
+            return
+        }
+    }
+
+    if s.IsRestoring() {
+        c.JSON(http.StatusConflict, gin.H{"error": server.ErrServerIsRestoring.Error()})
+        return
+    }
+    // The server only needs to be stopped if its files are about to be overwritten.
+    wasRunning := overwrite && s.Environment.State() != environment.ProcessOfflineState
+    if wasRunning && mode == restoreModeRefuse {
+        c.JSON(http.StatusConflict, gin.H{"error": server.ErrIsRunning.Error()})
+        return
+    }
+
     // Async restore to avoid HTTP timeouts behind proxies/CDNs.
     asyncParam := strings.ToLower(strings.TrimSpace(c.Query("async")))
     async := asyncParam == "1" || asyncParam == "true" || asyncParam == "yes"
 
     // Prevent concurrent restores (best-effort).
-    if status, err := readRestoreStatus(serverId); err == nil && status.Status == "running" {
+    if status, err := resticsvc.ReadRestoreStatus(serverId); err == nil && status.Status == "running" {
         if status.StartedAt != "" {
             if started, err := time.Parse(time.RFC3339, status.StartedAt); err == nil {
-                if time.Since(started) <= 6*time.Hour {
+                if time.Since(started) <= resticsvc.StaleJobAfter() {
                     c.JSON(http.StatusConflict, gin.H{"error": "restore already running"})
                     return
                 }
@@ -58,7 +219,7 @@ func RestoreServerResticBackupHandler(c *gin.Context) {
                 if status.Message == "" {
                     status.Message = "Restore appears stale. Please retry."
                 }
-                writeRestoreStatus(serverId, status)
+                resticsvc.WriteRestoreStatus(serverId, status)
             }
         } else {
             c.JSON(http.StatusConflict, gin.H{"error": "restore already running"})
@@ -66,54 +227,201 @@ func RestoreServerResticBackupHandler(c *gin.Context) {
         }
     }
 
-    repoDir := resolveRepoDir(serverId, ownerUsername)
-    repo := fmt.Sprintf("/var/lib/pterodactyl/restic/%s", repoDir)
-    targetPath := fmt.Sprintf("/var/lib/pterodactyl/volumes/%s", serverId)
+    repoDir := resticsvc.ResolveRepoDir(serverId, ownerUsername)
+    repo := resticsvc.RepoPath(repoDir)
+    targetPath := resticsvc.VolumePath(serverId)
+
+    resolvedKey, err := resticsvc.ResolveKey(repo, encryptionKey)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    client := resticsvc.NewClient(repo, resolvedKey)
+    activity := newResticActivity(c, server.ActivityResticRestore)
+    var preRestoreId string
+    var restoredBytes uint64
+    restore := func(ctx context.Context) error {
+        if overwrite {
+            // Mark the server as restoring so that power actions are rejected until the
+            // snapshot has been restored, and make sure that nothing managed to start
+            // the server again while it was being stopped.
+            s.SetRestoring(true)
+            defer s.SetRestoring(false)
+            if s.Environment.State() != environment.ProcessOfflineState {
+                return fmt.Errorf("server was started before the restore could begin")
+            }
+
+            id, err := takePreRestoreSnapshot(ctx, client, s, targetPath, backupId)
+            if err != nil {
+                return err
+            }
+            if id != "" {
+                preRestoreId = id
+                resticsvc.SetRestorePreRestoreSnapshot(serverId, id)
+            }
+        }
 
-    run := func() error {
-        env := append(os.Environ(), "RESTIC_PASSWORD="+encryptionKey)
         // Keep the same command semantics as existing installs to avoid breaking behavior.
-        cmdCtx, cancel := context.WithTimeout(context.Background(), 6*time.Hour)
-        defer cancel()
-        cmd := exec.CommandContext(cmdCtx, "restic", "-r", repo, "restore", backupId, "--target", "/", "--path", targetPath)
-        cmd.Env = env
+        opts := resticcli.RestoreOptions{
+            Snapshot: backupId,
+            Target:   "/",
+            Paths:    []string{targetPath},
+            OnStatus: resticsvc.RestoreProgress(s, backupId),
+        }
+        if len(includes) > 0 || !overwrite {
+            // Restore the server data directory from within the snapshot straight into
+            // the target, so that the included paths are relative to the root of it.
+            opts.Subfolder = targetPath
+            opts.Target = targetPath
+            opts.Paths = nil
+            opts.Includes = includes
+        }
+        if !overwrite {
+            // Restic runs as root and follows symbolic links in the target, so a
+            // folder is restored where nothing in the container can reach it and only
+            // moved into the data directory once restic has finished. A partially
+            // restored folder is never left behind.
+            opts.Target = restoreStagingPath(serverId)
+            if err := os.RemoveAll(opts.Target); err != nil {
+                return err
+            }
+            if err := os.MkdirAll(opts.Target, 0o700); err != nil {
+                return err
+            }
+            defer os.RemoveAll(opts.Target)
+        }
 
-        var restoreErr bytes.Buffer
-        cmd.Stderr = &restoreErr
-        if err := cmd.Run(); err != nil {
-            if cmdCtx.Err() == context.DeadlineExceeded {
+        cmdCtx, cancel := context.WithTimeout(ctx, resticsvc.Seconds(config.Get().Restic.Timeouts.Restore))
+        defer cancel()
+        summary, err := client.Restore(cmdCtx, opts)
+        if err == nil && !overwrite {
+            if err = moveRestoredFolder(s, opts.Target, restoredTo); err != nil {
+                err = errors.Wrap(err, "failed to move the restored folder into the server data directory")
+            }
+        }
+        meta := models.ActivityMeta{"snapshot_id": backupId, "mode": mode, "destination": destination, "paths": body.Paths, "pre_restore_snapshot_id": preRestoreId}
+        if summary != nil {
+            restoredBytes = summary.BytesRestored
+            meta["bytes"] = summary.BytesRestored
+        }
+        activity.Save(err, meta)
+        if err != nil {
+            if resticcli.IsTimeout(err) {
                 return fmt.Errorf("restore timed out")
             }
-            detail := strings.TrimSpace(restoreErr.String())
-            if detail == "" {
-                detail = err.Error()
+            return fmt.Errorf("restic restore failed: %s", resticsvc.Output(err))
+        }
+        return nil
+    }
+
+    run := func(ctx context.Context) error {
+        if wasRunning {
+            if err := s.HandlePowerAction(server.PowerActionStop, 30); err != nil {
+                return fmt.Errorf("failed to stop server before restore: %s", err)
+            }
+        }
+        if err := restore(ctx); err != nil {
+            return err
+        }
+        if wasRunning && body.RestorePowerState {
+            if err := s.HandlePowerAction(server.PowerActionStart, 30); err != nil {
+                s.Log().WithField("error", err).Warn("failed to start server after restic restore")
             }
-            return fmt.Errorf("restic restore failed: %s", detail)
         }
         return nil
     }
 
+    job := newJob(c, resticsvc.JobRestore, serverId, "")
+    job.Snapshot = backupId
+    job.Client = client
     if async {
-        setRestoreStatus(serverId, "running", "")
-        go func() {
-            if err := run(); err != nil {
-                setRestoreStatus(serverId, "failed", err.Error())
-                return
+        resticsvc.SetRestoreStatus(serverId, "running", "")
+        resticsvc.Jobs.Submit(job, func(j *resticsvc.Job) error {
+            resticsvc.SetRestoreStatus(serverId, "running", "")
+            err := run(j.Context())
+            j.Bytes = restoredBytes
+            if err != nil {
+                resticsvc.SetRestoreStatus(serverId, "failed", err.Error())
+                return err
             }
-            setRestoreStatus(serverId, "completed", "")
-        }()
-        c.JSON(http.StatusAccepted, gin.H{"message": "restore started"})
+            resticsvc.SetRestoreStatus(serverId, "completed", "")
+            return nil
+        })
+        c.JSON(http.StatusAccepted, gin.H{"message": "restore started", "job_id": job.ID, "queue_position": resticsvc.Jobs.Position(resticsvc.JobRestore, serverId, "")})
         return
     }
 
-    setRestoreStatus(serverId, "running", "")
-    if err := run(); err != nil {
-        setRestoreStatus(serverId, "failed", err.Error())
+    resticsvc.SetRestoreStatus(serverId, "running", "")
+    err = resticsvc.Jobs.Run(job, func(j *resticsvc.Job) error {
+        resticsvc.SetRestoreStatus(serverId, "running", "")
+        err := run(j.Context())
+        j.Bytes = restoredBytes
+        return err
+    })
+    if errors.Is(err, resticsvc.ErrJobCancelled) {
+        c.JSON(http.StatusConflict, gin.H{"error": "restore cancelled"})
+        return
+    }
+    if err != nil {
+        resticsvc.SetRestoreStatus(serverId, "failed", err.Error())
         c.JSON(http.StatusInternalServerError, gin.H{"error": "restic restore failed"})
         return
     }
-    setRestoreStatus(serverId, "completed", "")
-    c.JSON(http.StatusOK, gin.H{"message": "restore completed"})
+    resticsvc.SetRestoreStatus(serverId, "completed", "")
+    c.JSON(http.StatusOK, gin.H{"message": "restore completed", "pre_restore_snapshot_id": preRestoreId, "restored_to": restoredTo})
+}
+
+// restoreStagingPath returns the directory that a snapshot is restored into
+// before it is moved into the data directory of the server as a new folder. It
+// is next to the data directories so that it can be moved there with a rename,
+// but is not within any of them.
+func restoreStagingPath(serverId string) string {
+    return filepath.Join(config.Get().System.Data, ".restore-"+serverId)
+}
+
+// moveRestoredFolder moves a folder that has been restored into the staging
+// directory into the data directory of the server. The rename is made relative
+// to a directory that was opened through the server filesystem, and never
+// follows a symbolic link, or replaces anything other than an empty folder.
+func moveRestoredFolder(s *server.Server, staging string, name string) error {
+    if err := os.Chmod(staging, 0o755); err != nil {
+        return err
+    }
+    dirfd, file, closeFd, err := s.Filesystem().UnixFS().SafePath(name)
+    defer closeFd()
+    if err != nil {
+        return err
+    }
+    if err := unix.Renameat(unix.AT_FDCWD, staging, dirfd, file); err != nil {
+        return &os.LinkError{Op: "rename", Old: staging, New: name, Err: err}
+    }
+    return s.Filesystem().Chown(name)
+}
+
+// takePreRestoreSnapshot snapshots the current contents of the server data
+// directory before the given snapshot is restored over it, returning the ID of
+// the new snapshot. Nothing is snapshotted if the directory does not exist.
+func takePreRestoreSnapshot(ctx context.Context, client *resticcli.Client, s *server.Server, volumePath string, source string) (string, error) {
+    if _, err := os.Stat(volumePath); os.IsNotExist(err) {
+        return "", nil
+    }
+    ctx, cancel := context.WithTimeout(ctx, resticsvc.Seconds(config.Get().Restic.Timeouts.Backup))
+    defer cancel()
+    summary, err := client.Backup(ctx, resticcli.BackupOptions{
+        Paths:    []string{volumePath},
+        Tags:     []string{resticcli.PreRestoreTag, resticcli.RestoreSourceTagPrefix + source},
+        Excludes: resticsvc.BackupExcludes(s, volumePath),
+    })
+    if err != nil {
+        return "", fmt.Errorf("failed to create pre-restore snapshot: %s", resticsvc.Output(err))
+    }
+    return summary.SnapshotID, nil
+}
+
+// isProtectedPreRestore reports whether the snapshot was taken before a restore
+// recently enough that it must not be removed to make room for a new backup.
+func isProtectedPreRestore(snap resticcli.Snapshot) bool {
+    return snap.PreRestore() && time.Since(snap.Time) < resticsvc.Seconds(config.Get().Restic.PreRestoreWindow)
 }
 
 // GET /api/servers/:server/backups/restic/restore/status
@@ -124,99 +432,25 @@ func GetServerResticRestoreStatus(c *gin.Context) {
         return
     }
 
-    status, err := readRestoreStatus(serverId)
+    status, err := resticsvc.ReadRestoreStatus(serverId)
     if err != nil || status.Status == "" {
         c.JSON(http.StatusOK, gin.H{"status": "idle"})
         return
     }
 
-    if status.Status == "running" && status.StartedAt != "" {
+    status.QueuePosition = resticsvc.Jobs.Position(resticsvc.JobRestore, serverId, "")
+    if status.Status == "running" && status.QueuePosition == 0 && status.StartedAt != "" {
         if started, err := time.Parse(time.RFC3339, status.StartedAt); err == nil {
-            if time.Since(started) > 6*time.Hour {
+            if time.Since(started) > resticsvc.StaleJobAfter() {
                 status.Status = "failed"
                 status.FinishedAt = time.Now().Format(time.RFC3339)
                 if status.Message == "" {
                     status.Message = "Restore appears stale. Please retry."
                 }
-                writeRestoreStatus(serverId, status)
+                resticsvc.WriteRestoreStatus(serverId, status)
             }
         }
     }
 
     c.JSON(http.StatusOK, status)
 }
-
-type resticRestoreStatus struct {
-    Status     string `json:"status"`
-    StartedAt  string `json:"started_at,omitempty"`
-    FinishedAt string `json:"finished_at,omitempty"`
-    Message    string `json:"message,omitempty"`
-}
-
-func restoreStatusDir() string {
-    return "/var/lib/pterodactyl/restic/.restore-status"
-}
-
-func restoreStatusPath(serverId string) string {
-    return filepath.Join(restoreStatusDir(), serverId+".json")
-}
-
-func readRestoreStatus(serverId string) (resticRestoreStatus, error) {
-    var status resticRestoreStatus
-    data, err := os.ReadFile(restoreStatusPath(serverId))
-    if err != nil {
-        return status, err
-    }
-    if err := json.Unmarshal(data, &status); err != nil {
-        return resticRestoreStatus{}, err
-    }
-    return status, nil
-}
-
-func writeRestoreStatus(serverId string, status resticRestoreStatus) {
-    if serverId == "" {
-        return
-    }
-    _ = os.MkdirAll(restoreStatusDir(), 0755)
-    data, err := json.Marshal(status)
-    if err != nil {
-        return
-    }
-    tmp := restoreStatusPath(serverId) + ".tmp"
-    if err := os.WriteFile(tmp, data, 0644); err == nil {
-        _ = os.Rename(tmp, restoreStatusPath(serverId))
-    }
-    // Cleanup: keep files for 7 days (same as backup status).
-    cleanupStatusDir(restoreStatusDir(), 7*24*time.Hour)
-}
-
-func setRestoreStatus(serverId string, status string, message string) {
-    if serverId == "" {
-        return
-    }
-    current, _ := readRestoreStatus(serverId)
-    next := resticRestoreStatus{Status: status}
-
-    if status == "running" {
-        next.StartedAt = time.Now().Format(time.RFC3339)
-        next.FinishedAt = ""
-        next.Message = ""
-    } else {
-        if current.StartedAt != "" {
-            next.StartedAt = current.StartedAt
-        }
-        if status == "completed" || status == "failed" {
-            next.FinishedAt = time.Now().Format(time.RFC3339)
-        }
-        if message != "" {
-            // Clamp message size.
-            if len(message) > 2000 {
-                message = message[:2000]
-            }
-            next.Message = message
-        } else if current.Message != "" {
-            next.Message = current.Message
-        }
-    }
-    writeRestoreStatus(serverId, next)
-}
@@ -0,0 +1,175 @@
+package restic
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm"
+
+	"github.com/pterodactyl/wings/internal/database"
+	"github.com/pterodactyl/wings/internal/models"
+	resticcli "github.com/pterodactyl/wings/internal/restic"
+	"github.com/pterodactyl/wings/internal/resticsvc"
+)
+
+// keepWithinPattern matches the durations accepted by "restic forget --keep-within",
+// such as 7d or 1y6m.
+var keepWithinPattern = regexp.MustCompile(`^(\d+[ymdh])+$`)
+
+// retentionRules are the retention rules sent in the body of a request. Pointers
+// are used so that JSON null does not cause binding to fail, since the Panel sends
+// null for rules that are not set.
+type retentionRules struct {
+	KeepLast    *int    `json:"keep_last"`
+	KeepHourly  *int    `json:"keep_hourly"`
+	KeepDaily   *int    `json:"keep_daily"`
+	KeepWeekly  *int    `json:"keep_weekly"`
+	KeepMonthly *int    `json:"keep_monthly"`
+	KeepYearly  *int    `json:"keep_yearly"`
+	KeepWithin  *string `json:"keep_within"`
+}
+
+// policy validates the rules and returns them as a retention policy. Rules that
+// are not set are zero.
+func (r retentionRules) policy() (resticcli.Policy, error) {
+	var p resticcli.Policy
+	for _, rule := range []struct {
+		v *int
+		p *int
+	}{
+		{r.KeepLast, &p.KeepLast},
+		{r.KeepHourly, &p.KeepHourly},
+		{r.KeepDaily, &p.KeepDaily},
+		{r.KeepWeekly, &p.KeepWeekly},
+		{r.KeepMonthly, &p.KeepMonthly},
+		{r.KeepYearly, &p.KeepYearly},
+	} {
+		if rule.v == nil {
+			continue
+		}
+		if *rule.v < 0 {
+			return p, errors.New("retention rules cannot be negative")
+		}
+		*rule.p = *rule.v
+	}
+	if r.KeepWithin != nil {
+		p.KeepWithin = strings.TrimSpace(*r.KeepWithin)
+	}
+	if p.KeepWithin != "" && !keepWithinPattern.MatchString(p.KeepWithin) {
+		return p, errors.New("invalid keep_within duration")
+	}
+	return p, nil
+}
+
+type resticPolicyResponse struct {
+	models.ResticPolicy
+	// NextRunAt is when the schedule will next start a backup, or null if the
+	// policy is disabled.
+	NextRunAt *time.Time `json:"next_run_at"`
+}
+
+func newResticPolicyResponse(p models.ResticPolicy) resticPolicyResponse {
+	res := resticPolicyResponse{ResticPolicy: p}
+	if p.Enabled {
+		if sched, err := resticsvc.ParseSchedule(p.Cron); err == nil {
+			next := sched.Next(time.Now()).UTC()
+			res.NextRunAt = &next
+		}
+	}
+	return res
+}
+
+// GET /api/servers/:server/backups/restic/policy
+//
+// Returns the backup schedule and retention policy of the server, along with when
+// the schedule last ran and will next run. A disabled policy is returned if the
+// server does not have one.
+func GetServerResticPolicy(c *gin.Context) {
+	serverId := c.Param("server")
+	p := models.ResticPolicy{Server: serverId}
+	if err := database.Instance().First(&p, "server = ?", serverId).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load policy"})
+		return
+	}
+	c.JSON(http.StatusOK, newResticPolicyResponse(p))
+}
+
+// POST /api/servers/:server/backups/restic/policy
+//
+// Saves the backup schedule and retention policy of the server and schedules it
+// on the node. The encryption key is stored with the repository so that the
+// schedule can run without the Panel.
+func SaveServerResticPolicy(c *gin.Context) {
+	serverId := c.Param("server")
+	var body struct {
+		OwnerUsername string `json:"owner_username"`
+		Enabled       bool   `json:"enabled"`
+		Cron          string `json:"cron"`
+		retentionRules
+	}
+	if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	encryptionKey, err := requestEncryptionKey(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	p := models.ResticPolicy{Server: serverId}
+	if err := database.Instance().First(&p, "server = ?", serverId).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load policy"})
+		return
+	}
+	if v := strings.TrimSpace(body.OwnerUsername); v != "" {
+		p.OwnerUsername = v
+	}
+	p.Enabled = body.Enabled
+	p.Cron = strings.TrimSpace(body.Cron)
+	rules, err := body.policy()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p.KeepLast, p.KeepHourly, p.KeepDaily = rules.KeepLast, rules.KeepHourly, rules.KeepDaily
+	p.KeepWeekly, p.KeepMonthly, p.KeepYearly = rules.KeepWeekly, rules.KeepMonthly, rules.KeepYearly
+	p.KeepWithin = rules.KeepWithin
+
+	if p.Cron != "" {
+		if _, err := resticsvc.ParseSchedule(p.Cron); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cron expression"})
+			return
+		}
+	} else if p.Enabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing cron expression"})
+		return
+	}
+
+	if p.Enabled || encryptionKey != "" {
+		repo := resticsvc.RepoPath(resticsvc.ResolveRepoDir(serverId, p.OwnerUsername))
+		if err := resticsvc.PrepareRepo(repo); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create repo dir"})
+			return
+		}
+		if _, err := resticsvc.ResolveKey(repo, encryptionKey); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := database.Instance().Save(&p).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save policy"})
+		return
+	}
+	if err := resticsvc.UpdateSchedule(p); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to schedule backups"})
+		return
+	}
+	c.JSON(http.StatusOK, newResticPolicyResponse(p))
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/go-co-op/gocron"
 
 	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/resticsvc"
 	"github.com/pterodactyl/wings/server"
 	"github.com/pterodactyl/wings/system"
 )
@@ -69,5 +70,10 @@ func Scheduler(ctx context.Context, m *server.Manager) (*gocron.Scheduler, error
 		}
 	})
 
+	l.Info("scheduling restic backups")
+	if err := resticsvc.StartSchedules(s, m); err != nil {
+		return nil, err
+	}
+
 	return s, nil
 }
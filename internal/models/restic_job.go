@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// The states that a restic job moves through. A job is queued until a worker is
+// available to run it, and finishes as either completed, failed or cancelled.
+const (
+	ResticJobQueued    = "queued"
+	ResticJobRunning   = "running"
+	ResticJobCompleted = "completed"
+	ResticJobFailed    = "failed"
+	ResticJobCancelled = "cancelled"
+)
+
+// ResticJob is a restic operation, such as a backup or restore, that was run for a
+// server. Jobs are kept so that the history of a server's backups can be audited long
+// after the status of its most recent operations has been replaced.
+type ResticJob struct {
+	// ID is the UUID assigned to the job when it was queued.
+	ID string `gorm:"primaryKey;not null" json:"id"`
+	// Type is the kind of operation, such as backup, restore, prune, check, prepare or copy.
+	Type string `gorm:"index;not null" json:"type"`
+	// Server is the UUID of the server that the job was run for.
+	Server string `gorm:"type:uuid;index;not null" json:"server"`
+	// Snapshot is the ID of the snapshot that was created or used by the job, if any.
+	Snapshot string `json:"snapshot_id"`
+	Status   string `gorm:"index;not null" json:"status"`
+	// Actor is the UUID of the user that started the job, or null if it was started by
+	// the system or a schedule. IP is the address that the user connected from.
+	Actor JsonNullString `gorm:"type:uuid" json:"actor"`
+	IP    string         `json:"ip"`
+	// Bytes is the amount of data that was processed by the job, where it is known.
+	Bytes uint64 `json:"bytes"`
+	// Duration is the number of seconds that the job ran for, excluding the time it
+	// spent waiting in the queue.
+	Duration float64 `json:"duration"`
+	// Output is the truncated output or error message from restic.
+	Output     string     `json:"output"`
+	QueuedAt   time.Time  `gorm:"index;not null" json:"queued_at"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// SetActor sets the user that started the job. If an empty string is provided it
+// is cast into a null value when stored.
+func (j *ResticJob) SetActor(u string) {
+	j.Actor = JsonNullString{}
+	if u != "" {
+		j.Actor.String = u
+		j.Actor.Valid = true
+	}
+}
+
+// BeforeCreate ensures that the time the job was queued is set and stored as UTC.
+func (j *ResticJob) BeforeCreate(_ *gorm.DB) error {
+	if j.QueuedAt.IsZero() {
+		j.QueuedAt = time.Now()
+	}
+	j.QueuedAt = j.QueuedAt.UTC()
+	if j.Status == "" {
+		j.Status = ResticJobQueued
+	}
+	return nil
+}
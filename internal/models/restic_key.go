@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// ResticKey is the password of a restic repository, encrypted with the master key
+// of the node. Passwords are stored in the Wings database rather than alongside
+// the repository so that a copy of the repository does not include its password.
+type ResticKey struct {
+	// Repository is the path to the repository that the password opens.
+	Repository string `gorm:"primaryKey;not null"`
+	// Ciphertext is the encrypted password, encoded as base64.
+	Ciphertext string `gorm:"not null"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
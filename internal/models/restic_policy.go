@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+)
+
+// ResticPolicy is the backup schedule and retention policy of a server. Policies
+// are stored on the node so that Wings can keep running scheduled backups without
+// depending on the Panel being available.
+type ResticPolicy struct {
+	// Server is the UUID of the server that the policy belongs to.
+	Server string `gorm:"type:uuid;primaryKey;not null" json:"server"`
+	// OwnerUsername identifies the repository of the server, along with its UUID.
+	OwnerUsername string `json:"owner_username"`
+	Enabled       bool   `json:"enabled"`
+	// Cron is a standard five field cron expression, or a descriptor such as
+	// @daily, that is evaluated in the timezone of the node.
+	Cron string `json:"cron"`
+
+	// The grandfather-father-son retention rules applied with "restic forget"
+	// after each scheduled backup. Rules that are zero are not applied, and no
+	// snapshots are removed if every rule is zero.
+	KeepLast    int    `json:"keep_last"`
+	KeepHourly  int    `json:"keep_hourly"`
+	KeepDaily   int    `json:"keep_daily"`
+	KeepWeekly  int    `json:"keep_weekly"`
+	KeepMonthly int    `json:"keep_monthly"`
+	KeepYearly  int    `json:"keep_yearly"`
+	KeepWithin  string `json:"keep_within"`
+
+	// LastRunAt is when the schedule last started a backup, and LastStatus and
+	// LastMessage hold the result of it. LastJobID is the ID of the backup job.
+	LastRunAt   *time.Time `json:"last_run_at"`
+	LastStatus  string     `json:"last_status"`
+	LastMessage string     `json:"last_message"`
+	LastJobID   string     `json:"last_job_id"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+)
+
+// ResticReplica is the secondary repository of a server that its snapshots are
+// copied to after each successful backup, along with the result of the most
+// recent copy.
+type ResticReplica struct {
+	// Server is the UUID of the server that the replica belongs to.
+	Server string `gorm:"type:uuid;primaryKey;not null" json:"server"`
+	// Enabled can be set to false to stop copying the snapshots of a server to the
+	// replication URL of the node.
+	Enabled bool `json:"enabled"`
+	// Location is where the secondary repositories of the server are created, in
+	// place of the replication URL of the node.
+	Location string `json:"location"`
+
+	// The retention rules applied to the secondary repository with "restic forget"
+	// after each copy, independently of those of the primary repository. Rules that
+	// are zero are not applied, and no snapshots are removed if every rule is zero.
+	KeepLast    int    `json:"keep_last"`
+	KeepHourly  int    `json:"keep_hourly"`
+	KeepDaily   int    `json:"keep_daily"`
+	KeepWeekly  int    `json:"keep_weekly"`
+	KeepMonthly int    `json:"keep_monthly"`
+	KeepYearly  int    `json:"keep_yearly"`
+	KeepWithin  string `json:"keep_within"`
+
+	// LastCopyAt is when snapshots were last copied to the secondary repository,
+	// and LastStatus and LastMessage hold the result. LastJobID is the ID of the
+	// copy job.
+	LastCopyAt  *time.Time `json:"last_copy_at"`
+	LastStatus  string     `json:"last_status"`
+	LastMessage string     `json:"last_message"`
+	LastJobID   string     `json:"last_job_id"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
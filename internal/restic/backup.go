@@ -0,0 +1,107 @@
+package restic
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// BackupOptions controls how a snapshot is created.
+type BackupOptions struct {
+	// Paths are the files and directories that are included in the snapshot.
+	Paths []string
+	// Dir is the directory that restic is run in. Restic stores relative paths
+	// at the root of the snapshot rather than within this directory.
+	Dir string
+	// Time is recorded as the time of the snapshot instead of the current time
+	// when it is not zero.
+	Time time.Time
+	// Tags are added to the snapshot once it has been created.
+	Tags []string
+	// Excludes are restic exclude patterns, such as those returned by
+	// IgnorePatterns, that are passed to restic using an exclude file.
+	Excludes []string
+	// OnStatus is called for every progress update that restic reports while
+	// the backup is running.
+	OnStatus func(BackupStatus)
+}
+
+// BackupStatus is a progress update reported by "restic backup --json".
+type BackupStatus struct {
+	SecondsElapsed   uint64   `json:"seconds_elapsed"`
+	SecondsRemaining uint64   `json:"seconds_remaining,omitempty"`
+	PercentDone      float64  `json:"percent_done"`
+	TotalFiles       uint64   `json:"total_files"`
+	FilesDone        uint64   `json:"files_done"`
+	TotalBytes       uint64   `json:"total_bytes"`
+	BytesDone        uint64   `json:"bytes_done"`
+	ErrorCount       uint64   `json:"error_count"`
+	CurrentFiles     []string `json:"current_files,omitempty"`
+}
+
+// BackupSummary is the final message reported by "restic backup --json" once
+// the snapshot has been saved.
+type BackupSummary struct {
+	SnapshotID          string    `json:"snapshot_id"`
+	BackupStart         time.Time `json:"backup_start,omitzero"`
+	BackupEnd           time.Time `json:"backup_end,omitzero"`
+	FilesNew            uint64    `json:"files_new"`
+	FilesChanged        uint64    `json:"files_changed"`
+	FilesUnmodified     uint64    `json:"files_unmodified"`
+	DirsNew             uint64    `json:"dirs_new"`
+	DirsChanged         uint64    `json:"dirs_changed"`
+	DirsUnmodified      uint64    `json:"dirs_unmodified"`
+	DataBlobs           int64     `json:"data_blobs"`
+	TreeBlobs           int64     `json:"tree_blobs"`
+	DataAdded           uint64    `json:"data_added"`
+	DataAddedPacked     uint64    `json:"data_added_packed,omitempty"`
+	TotalFilesProcessed uint64    `json:"total_files_processed"`
+	TotalBytesProcessed uint64    `json:"total_bytes_processed"`
+	TotalDuration       float64   `json:"total_duration"`
+}
+
+// Backup creates a new snapshot of the configured paths and returns the summary
+// that restic reported once it completed.
+func (c *Client) Backup(ctx context.Context, opts BackupOptions) (*BackupSummary, error) {
+	args := []string{"backup", "--json"}
+	for _, t := range opts.Tags {
+		args = append(args, "--tag", t)
+	}
+	if !opts.Time.IsZero() {
+		args = append(args, "--time", opts.Time.Local().Format(time.DateTime))
+	}
+	if len(opts.Excludes) > 0 {
+		name, err := writeExcludeFile(opts.Excludes)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(name)
+		args = append(args, "--exclude-file", name)
+	}
+	args = append(args, opts.Paths...)
+
+	var summary *BackupSummary
+	err := c.streamIn(ctx, opts.Dir, args, func(messageType string, line []byte) error {
+		switch messageType {
+		case "status":
+			if opts.OnStatus != nil {
+				var s BackupStatus
+				if json.Unmarshal(line, &s) == nil {
+					opts.OnStatus(s)
+				}
+			}
+		case "summary":
+			summary = &BackupSummary{}
+			return json.Unmarshal(line, summary)
+		}
+		return nil
+	})
+	if err != nil {
+		return summary, err
+	}
+	if summary == nil {
+		return nil, newError(ctx, args, errMissingSummary, "", "")
+	}
+	return summary, nil
+}
@@ -0,0 +1,41 @@
+package restic
+
+import (
+	"context"
+)
+
+// Copy copies every snapshot of the source repository that the repository does
+// not have yet into it. Restic records the ID of the snapshot that each copy was
+// made from as its original.
+func (c *Client) Copy(ctx context.Context, from *Client) ([]byte, error) {
+	return c.runFrom(ctx, from, "copy")
+}
+
+// InitFrom creates the repository using the chunker parameters of the source
+// repository, so that data copied between them is deduplicated. If the
+// repository has already been initialized no error is returned.
+func (c *Client) InitFrom(ctx context.Context, from *Client) error {
+	_, err := c.runFrom(ctx, from, "init", "--copy-chunker-params")
+	if err != nil && AsError(err).AlreadyInitialized() {
+		return nil
+	}
+	return err
+}
+
+// runFrom executes a restic command that reads from a second repository, which
+// is opened with the password and environment of the source client. Variables
+// set for the repository itself take precedence over those of the source.
+func (c *Client) runFrom(ctx context.Context, from *Client, args ...string) ([]byte, error) {
+	args = append(args, "--from-repo", from.repository, "--from-password-file", secondPasswordFile)
+	cmd := c.Command(ctx, args...)
+	defer release(cmd)
+	cmd.Env = append(append([]string{}, from.env...), cmd.Env...)
+	if err := passSecondPassword(cmd, from.password); err != nil {
+		return nil, newError(ctx, args, err, "", "")
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return out, newError(ctx, args, err, string(out), "")
+	}
+	return out, nil
+}
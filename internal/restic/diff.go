@@ -0,0 +1,65 @@
+package restic
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Modifiers used by "restic diff" to describe how a path changed between two
+// snapshots.
+const (
+	DiffAdded        = "+"
+	DiffRemoved      = "-"
+	DiffModified     = "M"
+	DiffTypeChanged  = "T"
+	DiffMetadataOnly = "U"
+)
+
+// DiffChange is a single path that differs between two snapshots. Directories
+// have a trailing slash.
+type DiffChange struct {
+	Path     string `json:"path"`
+	Modifier string `json:"modifier"`
+}
+
+// DiffStat counts the items that were added to or removed from a snapshot.
+type DiffStat struct {
+	Files     int    `json:"files"`
+	Dirs      int    `json:"dirs"`
+	Others    int    `json:"others"`
+	DataBlobs int    `json:"data_blobs"`
+	TreeBlobs int    `json:"tree_blobs"`
+	Bytes     uint64 `json:"bytes"`
+}
+
+// DiffStatistics is the summary written at the end of "restic diff --json".
+type DiffStatistics struct {
+	SourceSnapshot string   `json:"source_snapshot"`
+	TargetSnapshot string   `json:"target_snapshot"`
+	ChangedFiles   int      `json:"changed_files"`
+	Added          DiffStat `json:"added"`
+	Removed        DiffStat `json:"removed"`
+}
+
+// Diff returns the paths that differ between two snapshots, along with the
+// summary of the differences. The repository is not locked while comparing.
+func (c *Client) Diff(ctx context.Context, from string, to string) ([]DiffChange, *DiffStatistics, error) {
+	changes := []DiffChange{}
+	var stats DiffStatistics
+	err := c.stream(ctx, []string{"diff", "--json", "--no-lock", from, to}, func(messageType string, line []byte) error {
+		switch messageType {
+		case "change":
+			var ch DiffChange
+			if json.Unmarshal(line, &ch) == nil {
+				changes = append(changes, ch)
+			}
+		case "statistics":
+			_ = json.Unmarshal(line, &stats)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return changes, &stats, nil
+}
@@ -0,0 +1,38 @@
+package restic
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path"
+)
+
+// Dump writes the contents of a single file within a snapshot to w as restic
+// reads it from the repository, without staging anything on the disk. The file
+// must be an absolute path within the snapshot. The repository is not locked
+// while the file is read.
+func (c *Client) Dump(ctx context.Context, snapshot string, file string, w io.Writer) error {
+	return c.dump(ctx, []string{"dump", "--no-lock", snapshot, path.Clean("/" + file)}, w)
+}
+
+// DumpArchive writes the contents of a directory within a snapshot to w as an
+// uncompressed tar archive. The entries in the archive are relative to the
+// directory rather than to the root of the snapshot.
+func (c *Client) DumpArchive(ctx context.Context, snapshot string, dir string, w io.Writer) error {
+	return c.dump(ctx, []string{"dump", "--no-lock", "--archive", "tar", snapshot + ":" + path.Clean("/"+dir), "/"}, w)
+}
+
+// dump runs a dump command with stdout connected to w. Writes to w block restic
+// until they complete, so a slow reader slows down restic rather than the
+// output being buffered in memory.
+func (c *Client) dump(ctx context.Context, args []string, w io.Writer) error {
+	var stderr bytes.Buffer
+	cmd := c.Command(ctx, args...)
+	defer release(cmd)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return newError(ctx, args, err, stderr.String(), "")
+	}
+	return nil
+}
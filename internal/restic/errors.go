@@ -0,0 +1,230 @@
+package restic
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+)
+
+// Exit codes used by restic 0.17 and newer. Older versions always exit with 1
+// so the output is also inspected when determining the cause of a failure.
+const (
+	exitRepoMissing   = 10
+	exitLockFailed    = 11
+	exitWrongPassword = 12
+)
+
+var errMissingSummary = errors.New("restic did not report a summary")
+
+// Error is returned whenever a restic command fails to run or returns output
+// that cannot be understood.
+type Error struct {
+	// Command is the restic subcommand that was executed, such as "backup".
+	Command string
+	// ExitCode is the exit code of the restic process, or -1 if the process did
+	// not exit normally.
+	ExitCode int
+	// Output is the output that restic produced, preferring stderr when it is
+	// available since that is where restic reports its errors.
+	Output string
+
+	err      error
+	timedOut bool
+	canceled bool
+}
+
+func newError(ctx context.Context, args []string, err error, stderr string, stdout string) *Error {
+	e := &Error{ExitCode: -1, err: err}
+	for _, a := range args {
+		if !strings.HasPrefix(a, "-") {
+			e.Command = a
+			break
+		}
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		e.ExitCode = exitErr.ExitCode()
+	}
+	e.Output = strings.TrimSpace(stderr)
+	if e.Output == "" {
+		e.Output = strings.TrimSpace(stdout)
+	}
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		e.timedOut = true
+	case context.Canceled:
+		e.canceled = true
+	}
+	return e
+}
+
+// AsError returns the *Error contained within err, or nil if there is not one.
+func AsError(err error) *Error {
+	var rerr *Error
+	if err != nil && errors.As(err, &rerr) {
+		return rerr
+	}
+	return nil
+}
+
+// IsLocked reports whether err is a restic error caused by the repository
+// being locked by another process.
+func IsLocked(err error) bool {
+	return AsError(err).Locked()
+}
+
+// IsWrongPassword reports whether err is a restic error caused by the password
+// not matching any key in the repository.
+func IsWrongPassword(err error) bool {
+	return AsError(err).WrongPassword()
+}
+
+// IsTimeout reports whether err is a restic error caused by the command running
+// for longer than its context allowed.
+func IsTimeout(err error) bool {
+	return AsError(err).TimedOut()
+}
+
+// IsCanceled reports whether err is a restic error caused by the command being
+// stopped because its context was canceled.
+func IsCanceled(err error) bool {
+	return AsError(err).Canceled()
+}
+
+// Error returns a single line description of the failure.
+func (e *Error) Error() string {
+	msg := "restic " + e.Command
+	switch {
+	case e.timedOut:
+		msg += " timed out"
+	case e.canceled:
+		msg += " was canceled"
+	case e.err != nil:
+		msg += ": " + e.err.Error()
+	}
+	if line := firstLine(e.Output); line != "" {
+		msg += ": " + line
+	}
+	return msg
+}
+
+// Unwrap returns the underlying process or decoding error.
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// TimedOut reports whether the command was killed because its context deadline
+// was exceeded.
+func (e *Error) TimedOut() bool {
+	return e != nil && e.timedOut
+}
+
+// Canceled reports whether the command was killed because its context was
+// canceled.
+func (e *Error) Canceled() bool {
+	return e != nil && e.canceled
+}
+
+// Locked reports whether restic failed to acquire a lock on the repository.
+func (e *Error) Locked() bool {
+	if e == nil {
+		return false
+	}
+	if e.ExitCode == exitLockFailed {
+		return true
+	}
+	lower := strings.ToLower(e.Output)
+	return strings.Contains(lower, "repository is already locked") ||
+		strings.Contains(lower, "unable to create lock")
+}
+
+// WrongPassword reports whether restic was unable to open the repository with
+// the password it was given.
+func (e *Error) WrongPassword() bool {
+	if e == nil {
+		return false
+	}
+	if e.ExitCode == exitWrongPassword {
+		return true
+	}
+	lower := strings.ToLower(e.Output)
+	return strings.Contains(lower, "ciphertext verification failed") ||
+		strings.Contains(lower, "wrong password") ||
+		strings.Contains(lower, "config or key")
+}
+
+// NotFound reports whether restic could not find the snapshot that was requested.
+func (e *Error) NotFound() bool {
+	if e == nil {
+		return false
+	}
+	lower := strings.ToLower(e.Output)
+	if strings.Contains(lower, "no matching id found") {
+		return true
+	}
+	return strings.Contains(lower, "snapshot") && strings.Contains(lower, "not found")
+}
+
+// NotInitialized reports whether restic failed because there is no repository at
+// the location it was given.
+func (e *Error) NotInitialized() bool {
+	if e == nil {
+		return false
+	}
+	if e.ExitCode == exitRepoMissing {
+		return true
+	}
+	lower := strings.ToLower(e.Output)
+	return strings.Contains(lower, "repository does not exist") ||
+		strings.Contains(lower, "is there a repository at the following location")
+}
+
+// AlreadyInitialized reports whether an init command failed because the
+// repository already exists.
+func (e *Error) AlreadyInitialized() bool {
+	if e == nil {
+		return false
+	}
+	return strings.Contains(e.Output, "already initialized") || strings.Contains(e.Output, "config already exists")
+}
+
+// LockCreatedAt returns the time that the lock which caused the command to fail
+// was created, or nil if it is not present in the output.
+func (e *Error) LockCreatedAt() *time.Time {
+	if e == nil {
+		return nil
+	}
+	return parseLockCreatedAt(e.Output)
+}
+
+func parseLockCreatedAt(output string) *time.Time {
+	marker := "lock was created at "
+	idx := strings.Index(output, marker)
+	if idx == -1 {
+		return nil
+	}
+	rest := output[idx+len(marker):]
+	end := strings.Index(rest, " (")
+	if end == -1 {
+		end = len(rest)
+	}
+	ts := strings.TrimSpace(rest[:end])
+	if ts == "" {
+		return nil
+	}
+	if t, err := time.Parse("2006-01-02 15:04:05", ts); err == nil {
+		return &t
+	}
+	return nil
+}
+
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i != -1 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,86 @@
+package restic
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"emperror.dev/errors"
+)
+
+// IgnorePatterns translates rules written in the format used by .pteroignore
+// files into restic exclude patterns that are anchored to the given root
+// directory. The rules are returned in the same order, which restic relies on
+// when a later negated rule re-includes a path that an earlier rule excluded.
+//
+// Rules that contain a slash are matched relative to the root, while rules
+// without one match files and directories of that name at any depth. Restic
+// has no way to restrict a pattern to directories only, so a trailing slash is
+// dropped and the pattern matches files of the same name as well.
+func IgnorePatterns(root string, rules string) []string {
+	root = strings.TrimSuffix(filepath.ToSlash(root), "/")
+
+	var patterns []string
+	for _, line := range strings.Split(rules, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		negate := strings.HasPrefix(line, "!")
+		if negate {
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		line = strings.TrimSuffix(line, "/")
+		if line == "" {
+			continue
+		}
+
+		var p string
+		if strings.Contains(line, "/") {
+			p = root + "/" + strings.TrimPrefix(line, "/")
+		} else {
+			p = root + "/**/" + line
+		}
+		// Restic expands environment variables in exclude files and provides no
+		// way to escape them, so match a literal "$" with a single character
+		// wildcard instead.
+		p = strings.ReplaceAll(p, "$", "?")
+		if negate {
+			p = "!" + p
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// EscapePattern escapes the characters in a path that restic would otherwise
+// treat as part of a pattern, so that it only matches the path itself.
+func EscapePattern(p string) string {
+	var b strings.Builder
+	for _, r := range p {
+		switch r {
+		case '\\', '*', '?', '[':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// writeExcludeFile writes the patterns to a temporary file that can be passed
+// to restic using --exclude-file. The caller is responsible for removing the
+// file once restic has exited.
+func writeExcludeFile(patterns []string) (string, error) {
+	f, err := os.CreateTemp("", "restic-excludes-*")
+	if err != nil {
+		return "", errors.Wrap(err, "restic: failed to create exclude file")
+	}
+	defer f.Close()
+	if _, err := f.WriteString(strings.Join(patterns, "\n") + "\n"); err != nil {
+		_ = os.Remove(f.Name())
+		return "", errors.Wrap(err, "restic: failed to write exclude file")
+	}
+	return f.Name(), nil
+}
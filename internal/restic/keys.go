@@ -0,0 +1,75 @@
+package restic
+
+import (
+	"context"
+	"regexp"
+)
+
+// Key is a key that can be used to open the repository. Every key has its own
+// password that unlocks the same master key, so adding and removing keys never
+// requires the data in the repository to be encrypted again.
+type Key struct {
+	ID       string `json:"id"`
+	UserName string `json:"userName"`
+	HostName string `json:"hostName"`
+	Created  string `json:"created"`
+	// Current is true for the key that was opened with the password of the client.
+	Current bool `json:"current"`
+}
+
+// savedKeyPattern matches the ID that "restic key add" reports for the new key.
+var savedKeyPattern = regexp.MustCompile(`saved new key (?:with ID|as) ([0-9a-f]+)`)
+
+// Keys returns the keys of the repository.
+func (c *Client) Keys(ctx context.Context) ([]Key, error) {
+	keys := []Key{}
+	if err := c.runJSON(ctx, &keys, "key", "list", "--json"); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// AddKey adds a key with the given password to the repository and returns the
+// ID of the new key, if restic reported it.
+func (c *Client) AddKey(ctx context.Context, password string) (string, error) {
+	out, err := c.runNewPassword(ctx, password, "key", "add")
+	if err != nil {
+		return "", err
+	}
+	if m := savedKeyPattern.FindSubmatch(out); m != nil {
+		return string(m[1]), nil
+	}
+	return "", nil
+}
+
+// RemoveKey removes a key from the repository. Restic refuses to remove the key
+// that the client opened the repository with.
+func (c *Client) RemoveKey(ctx context.Context, id string) error {
+	_, err := c.Run(ctx, "key", "remove", id)
+	return err
+}
+
+// ChangePassword replaces the key that the client opened the repository with by
+// one that has the given password.
+func (c *Client) ChangePassword(ctx context.Context, password string) error {
+	_, err := c.runNewPassword(ctx, password, "key", "passwd")
+	return err
+}
+
+// runNewPassword executes a restic key command that sets the given password on
+// a key, and returns stdout and stderr interleaved. The password is passed to
+// restic through a pipe, so it is never written to the disk or visible in the
+// arguments of the process.
+func (c *Client) runNewPassword(ctx context.Context, password string, args ...string) ([]byte, error) {
+	args = append(args, "--new-password-file", secondPasswordFile)
+	cmd := c.Command(ctx, args...)
+	defer release(cmd)
+	if err := passSecondPassword(cmd, password); err != nil {
+		return nil, newError(ctx, args, err, "", "")
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return out, newError(ctx, args, err, string(out), "")
+	}
+	return out, nil
+}
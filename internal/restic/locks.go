@@ -0,0 +1,84 @@
+package restic
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Lock is a lock held on a repository. Depending on the restic version only the
+// ID of the lock may be known.
+type Lock struct {
+	ID        string    `json:"id,omitempty"`
+	Time      time.Time `json:"time,omitzero"`
+	Exclusive bool      `json:"exclusive,omitempty"`
+	Hostname  string    `json:"hostname,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	PID       int       `json:"pid,omitempty"`
+}
+
+// Locks returns the locks that currently exist in the repository.
+func (c *Client) Locks(ctx context.Context) ([]Lock, error) {
+	args := []string{"list", "locks", "--json"}
+	out, err := c.Run(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	locks, err := ParseLocks(out)
+	if err != nil {
+		return nil, newError(ctx, args, err, "", string(out))
+	}
+	return locks, nil
+}
+
+// Lock returns the lock with the given ID, including when it was created, which
+// "restic list locks" does not report.
+func (c *Client) Lock(ctx context.Context, id string) (Lock, error) {
+	args := []string{"cat", "lock", "--no-lock", id}
+	out, err := c.Run(ctx, args...)
+	if err != nil {
+		return Lock{}, err
+	}
+	var l Lock
+	if err := json.Unmarshal(out, &l); err != nil {
+		return Lock{}, newError(ctx, args, err, "", string(out))
+	}
+	l.ID = id
+	return l, nil
+}
+
+// ParseLocks parses the output of "restic list locks". Each line is either a
+// JSON encoded lock or the ID of a lock, and a single JSON array of locks is
+// also accepted.
+func ParseLocks(out []byte) ([]Lock, error) {
+	locks := []Lock{}
+	trimmed := strings.TrimSpace(string(out))
+	if trimmed == "" {
+		return locks, nil
+	}
+	if strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal([]byte(trimmed), &locks); err != nil {
+			return nil, err
+		}
+		return locks, nil
+	}
+	for _, line := range strings.Split(trimmed, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "{"):
+			var l Lock
+			if err := json.Unmarshal([]byte(line), &l); err != nil {
+				return nil, err
+			}
+			locks = append(locks, l)
+		case strings.Contains(strings.ToLower(line), "no lock"):
+			continue
+		case len(line) >= 8 && !strings.Contains(line, " "):
+			locks = append(locks, Lock{ID: line})
+		}
+	}
+	return locks, nil
+}
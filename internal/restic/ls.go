@@ -0,0 +1,81 @@
+package restic
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"time"
+)
+
+// Node types returned by "restic ls --json".
+const (
+	NodeFile    = "file"
+	NodeDir     = "dir"
+	NodeSymlink = "symlink"
+)
+
+// Node is a single file, directory or other entry within a snapshot as
+// returned by "restic ls --json".
+type Node struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type"`
+	Path        string      `json:"path"`
+	UID         uint32      `json:"uid"`
+	GID         uint32      `json:"gid"`
+	Size        uint64      `json:"size,omitempty"`
+	Mode        os.FileMode `json:"mode,omitempty"`
+	Permissions string      `json:"permissions,omitempty"`
+	ModTime     time.Time   `json:"mtime"`
+	AccessTime  time.Time   `json:"atime"`
+	ChangeTime  time.Time   `json:"ctime"`
+}
+
+// List returns the entries directly within the given directory of a snapshot.
+// The directory must be an absolute path within the snapshot. The repository is
+// not locked while listing.
+func (c *Client) List(ctx context.Context, snapshot string, dir string) ([]Node, error) {
+	nodes := []Node{}
+	err := c.ls(ctx, snapshot, dir, false, func(n Node) {
+		nodes = append(nodes, n)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// Walk calls fn for every entry within the given directory of a snapshot and
+// all of its subdirectories, without holding the entire listing in memory.
+func (c *Client) Walk(ctx context.Context, snapshot string, dir string, fn func(n Node)) error {
+	return c.ls(ctx, snapshot, dir, true, fn)
+}
+
+func (c *Client) ls(ctx context.Context, snapshot string, dir string, recursive bool, fn func(n Node)) error {
+	dir = path.Clean("/" + dir)
+	args := []string{"ls", "--json", "--no-lock"}
+	if recursive {
+		args = append(args, "--recursive")
+	}
+	return c.stream(ctx, append(args, snapshot, dir), func(messageType string, line []byte) error {
+		// Restic 0.17 added message_type to every line, older versions only set
+		// struct_type, so accept either of them.
+		var n struct {
+			Node
+			StructType string `json:"struct_type"`
+		}
+		if json.Unmarshal(line, &n) != nil {
+			return nil
+		}
+		if messageType != "node" && n.StructType != "node" {
+			return nil
+		}
+		// Some versions of restic include the directory that was listed as well
+		// as its contents.
+		if path.Clean(n.Path) == dir {
+			return nil
+		}
+		fn(n.Node)
+		return nil
+	})
+}
@@ -0,0 +1,293 @@
+// Package restic provides a small typed client around the restic command line
+// tool. Every command is executed with a context so that callers control how
+// long an operation may run for, and every failure is returned as an *Error
+// which can be inspected to determine why restic failed.
+package restic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+)
+
+// Client executes restic commands against a single repository.
+type Client struct {
+	binary     string
+	repository string
+	password   string
+	env        []string
+}
+
+// New returns a client that runs the given restic binary against a repository
+// using the provided password. If binary is empty "restic" is resolved using
+// the PATH of the current process.
+func New(binary string, repository string, password string) *Client {
+	if binary == "" {
+		binary = "restic"
+	}
+	return &Client{binary: binary, repository: repository, password: password}
+}
+
+// WithEnv adds environment variables, such as the credentials of a remote
+// repository backend, to every restic process started by the client.
+func (c *Client) WithEnv(env ...string) *Client {
+	c.env = append(c.env, env...)
+	return c
+}
+
+// Repository returns the repository that this client operates on.
+func (c *Client) Repository() string {
+	return c.repository
+}
+
+// passwordFile is where restic reads the password of the repository from. It is
+// the read end of a pipe that is passed to restic as its first extra file, so
+// the password is never visible in the environment or arguments of the process.
+const passwordFile = "/dev/fd/3"
+
+// Env returns the environment that restic processes are started with. Any
+// password configured on the Wings process itself is removed so that it can
+// never be used in place of the repository password.
+func (c *Client) Env() []string {
+	base := os.Environ()
+	env := make([]string, 0, len(base)+len(c.env)+1)
+	for _, v := range base {
+		if strings.HasPrefix(v, "RESTIC_PASSWORD") {
+			continue
+		}
+		env = append(env, v)
+	}
+	env = append(env, c.env...)
+	return append(env, "RESTIC_PASSWORD_FILE="+passwordFile)
+}
+
+// passwordPipe returns a pipe that the password of the repository can be read
+// from until it is closed.
+func (c *Client) passwordPipe() (*os.File, error) {
+	return newPasswordPipe(c.password)
+}
+
+// secondPasswordFile is where restic reads a second password from, such as that
+// of the source repository when copying or of a new key. It is the read end of
+// a second pipe, passed to restic after the one for the password of the
+// repository itself.
+const secondPasswordFile = "/dev/fd/4"
+
+// passSecondPassword passes a second password to the command through a pipe that
+// restic can read from secondPasswordFile. The pipe is closed by release.
+func passSecondPassword(cmd *exec.Cmd, password string) error {
+	r, err := newPasswordPipe(password)
+	if err != nil {
+		return err
+	}
+	if len(cmd.ExtraFiles) == 0 {
+		// Without the pipe for the repository itself the second password would be
+		// read in its place, so the command must not be run.
+		_ = r.Close()
+		return errors.New("restic: failed to pass the repository password")
+	}
+	cmd.ExtraFiles = append(cmd.ExtraFiles, r)
+	return nil
+}
+
+// newPasswordPipe returns a pipe that the password can be read from until it is
+// closed. The password is small enough to fit in the buffer of the pipe, so it
+// is written up front and the write end is closed immediately.
+func newPasswordPipe(password string) (*os.File, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.WriteString(w, password)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// release closes the files that were passed to a restic process, which is safe
+// to do once the process has started.
+func release(cmd *exec.Cmd) {
+	for _, f := range cmd.ExtraFiles {
+		_ = f.Close()
+	}
+}
+
+// interruptGracePeriod is how long restic is given to exit after it has been
+// interrupted before it is killed.
+const interruptGracePeriod = 30 * time.Second
+
+// Command returns a restic command for the repository which will be stopped if
+// the context is canceled before it completes. The repository flag is added to
+// the arguments automatically.
+//
+// Restic is interrupted rather than killed so that it can remove its lock from
+// the repository before exiting, and is only killed if it has not exited once
+// the grace period has passed.
+//
+// The password of the repository is passed to restic through a pipe, which must
+// be closed with release once the command has run. If the pipe cannot be created
+// restic fails to read the password and the command returns an error.
+func (c *Client) Command(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, c.binary, append([]string{"-r", c.repository}, args...)...)
+	cmd.Env = c.Env()
+	if r, err := c.passwordPipe(); err == nil {
+		cmd.ExtraFiles = []*os.File{r}
+	}
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = interruptGracePeriod
+	return cmd
+}
+
+// Run executes restic with the given arguments and returns everything that was
+// written to stdout. If the command fails an *Error is returned that includes
+// the output that restic wrote to stderr.
+func (c *Client) Run(ctx context.Context, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := c.Command(ctx, args...)
+	defer release(cmd)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.Bytes(), newError(ctx, args, err, stderr.String(), stdout.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+// RunCombined executes restic and returns stdout and stderr interleaved, which
+// is useful for commands whose output is shown to a user as-is.
+func (c *Client) RunCombined(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := c.Command(ctx, args...)
+	defer release(cmd)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return out, newError(ctx, args, err, string(out), "")
+	}
+	return out, nil
+}
+
+// runJSON executes restic and decodes the output into v.
+func (c *Client) runJSON(ctx context.Context, v interface{}, args ...string) error {
+	out, err := c.Run(ctx, args...)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(out), v); err != nil {
+		return newError(ctx, args, err, "", string(out))
+	}
+	return nil
+}
+
+// stream executes restic with JSON output enabled and calls fn with the
+// message type of every line that is written to stdout.
+func (c *Client) stream(ctx context.Context, args []string, fn func(messageType string, line []byte) error) error {
+	return c.streamIn(ctx, "", args, fn)
+}
+
+// streamIn is stream with restic run in the given directory, or in the current
+// directory if it is empty.
+func (c *Client) streamIn(ctx context.Context, dir string, args []string, fn func(messageType string, line []byte) error) error {
+	var stderr bytes.Buffer
+	cmd := c.Command(ctx, args...)
+	defer release(cmd)
+	cmd.Dir = dir
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return newError(ctx, args, err, "", "")
+	}
+	if err := cmd.Start(); err != nil {
+		return newError(ctx, args, err, "", "")
+	}
+
+	var last []byte
+	var fnErr error
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] != '{' {
+			continue
+		}
+		last = append(last[:0], line...)
+		var m struct {
+			MessageType string `json:"message_type"`
+		}
+		if json.Unmarshal(line, &m) != nil || fnErr != nil {
+			continue
+		}
+		fnErr = fn(m.MessageType, line)
+	}
+	// Drain anything left over if a line was too long to scan so that restic
+	// does not block writing to the pipe.
+	_, _ = io.Copy(io.Discard, stdout)
+
+	if err := cmd.Wait(); err != nil {
+		return newError(ctx, args, err, stderr.String(), string(last))
+	}
+	if fnErr != nil {
+		return newError(ctx, args, fnErr, stderr.String(), string(last))
+	}
+	return nil
+}
+
+// Init creates the repository. If the repository has already been initialized,
+// for example by a concurrent request, no error is returned.
+func (c *Client) Init(ctx context.Context) error {
+	_, err := c.Run(ctx, "init")
+	if err != nil && AsError(err).AlreadyInitialized() {
+		return nil
+	}
+	return err
+}
+
+// Initialized reports whether the repository has been initialized. Reading the
+// config of the repository also requires the password to be correct, so an
+// error is returned if it is not.
+func (c *Client) Initialized(ctx context.Context) (bool, error) {
+	_, err := c.Run(ctx, "cat", "config")
+	if err == nil {
+		return true, nil
+	}
+	if AsError(err).NotInitialized() {
+		return false, nil
+	}
+	return false, err
+}
+
+// Unlock removes stale locks from the repository.
+func (c *Client) Unlock(ctx context.Context) error {
+	_, err := c.Run(ctx, "unlock")
+	return err
+}
+
+// UnlockAll removes every lock from the repository, including those that are
+// still held by running processes.
+func (c *Client) UnlockAll(ctx context.Context) error {
+	_, err := c.Run(ctx, "unlock", "--remove-all")
+	return err
+}
+
+// Check verifies the integrity of the repository. When subset is not empty
+// that portion of the pack files is also read and verified, for example "5%".
+func (c *Client) Check(ctx context.Context, subset string) ([]byte, error) {
+	args := []string{"check"}
+	if subset = strings.TrimSpace(subset); subset != "" {
+		args = append(args, "--read-data-subset", subset)
+	}
+	return c.RunCombined(ctx, args...)
+}
@@ -0,0 +1,230 @@
+package restic_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+
+	"github.com/pterodactyl/wings/internal/restic"
+)
+
+func TestSnapshot(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Snapshot", func() {
+		raw := `[{"time":"2025-01-02T03:04:05.123456789+01:00","tree":"abc","paths":["/var/lib/pterodactyl/volumes/uuid"],"hostname":"node","tags":["locked"],"id":"0123456789abcdef","short_id":"01234567","summary":{"total_bytes_processed":2048}},{"time":"2025-01-01T00:00:00Z","tree":"def","paths":["/data"],"id":"fedcba9876543210"}]`
+
+		var snapshots []restic.Snapshot
+		g.Before(func() {
+			g.Assert(json.Unmarshal([]byte(raw), &snapshots)).IsNil()
+		})
+
+		g.It("decodes the output of restic snapshots", func() {
+			g.Assert(len(snapshots)).Equal(2)
+			g.Assert(snapshots[0].ID).Equal("0123456789abcdef")
+			g.Assert(snapshots[0].Time.Year()).Equal(2025)
+			g.Assert(snapshots[0].Paths).Equal([]string{"/var/lib/pterodactyl/volumes/uuid"})
+		})
+
+		g.It("detects locked snapshots by tag", func() {
+			g.Assert(snapshots[0].Locked()).IsTrue()
+			g.Assert(snapshots[1].Locked()).IsFalse()
+		})
+
+		g.It("returns the source of a pre-restore snapshot", func() {
+			snap := restic.Snapshot{Tags: []string{restic.PreRestoreTag, restic.RestoreSourceTagPrefix + "abcdef12"}}
+			g.Assert(snap.PreRestore()).IsTrue()
+			g.Assert(snap.RestoreSource()).Equal("abcdef12")
+			g.Assert(snapshots[0].PreRestore()).IsFalse()
+			g.Assert(snapshots[0].RestoreSource()).Equal("")
+		})
+
+		g.It("returns the Panel backup that a snapshot was created for", func() {
+			snap := restic.Snapshot{Tags: []string{restic.BackupTagPrefix + "b0c1d2e3"}}
+			g.Assert(snap.BackupUUID()).Equal("b0c1d2e3")
+			g.Assert(snapshots[0].BackupUUID()).Equal("")
+		})
+
+		g.It("matches full and short ids", func() {
+			g.Assert(snapshots[0].Matches("0123456789abcdef")).IsTrue()
+			g.Assert(snapshots[0].Matches("01234567")).IsTrue()
+			g.Assert(snapshots[1].Matches("fedcba98")).IsTrue()
+			g.Assert(snapshots[1].Matches("")).IsFalse()
+			g.Assert(snapshots[1].Matches("01234567")).IsFalse()
+		})
+
+		g.It("returns the size from the summary when present", func() {
+			size, ok := snapshots[0].Size()
+			g.Assert(ok).IsTrue()
+			g.Assert(size).Equal(uint64(2048))
+
+			_, ok = snapshots[1].Size()
+			g.Assert(ok).IsFalse()
+		})
+	})
+}
+
+func TestPolicy(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Policy", func() {
+		g.It("is empty when only tags are kept", func() {
+			g.Assert(restic.Policy{KeepTags: []string{restic.LockedTag}}.Empty()).IsTrue()
+			g.Assert(restic.Policy{KeepWithin: "7d"}.Empty()).IsFalse()
+		})
+
+		g.It("builds the forget arguments", func() {
+			p := restic.Policy{KeepLast: 3, KeepWeekly: 2, KeepWithin: "1y", KeepTags: []string{"locked"}}
+			g.Assert(p.Args()).Equal([]string{"--group-by", "host", "--keep-tag", "locked", "--keep-last", "3", "--keep-weekly", "2", "--keep-within", "1y"})
+		})
+	})
+}
+
+func TestIgnorePatterns(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("IgnorePatterns", func() {
+		g.It("skips blank lines and comments", func() {
+			g.Assert(len(restic.IgnorePatterns("/data", "\n# comment\n   \n"))).Equal(0)
+		})
+
+		g.It("anchors rules containing a slash to the root", func() {
+			g.Assert(restic.IgnorePatterns("/data/", "/logs/*.log\nworld/region\r\n")).Equal([]string{"/data/logs/*.log", "/data/world/region"})
+		})
+
+		g.It("matches rules without a slash at any depth", func() {
+			g.Assert(restic.IgnorePatterns("/data", "cache/\n*.tmp")).Equal([]string{"/data/**/cache", "/data/**/*.tmp"})
+		})
+
+		g.It("keeps negated rules in order", func() {
+			g.Assert(restic.IgnorePatterns("/data", "logs\n!logs/latest.log\n\\!bang")).Equal([]string{"/data/**/logs", "!/data/logs/latest.log", "/data/**/!bang"})
+		})
+
+		g.It("does not allow rules to expand environment variables", func() {
+			g.Assert(restic.IgnorePatterns("/data", "$HOME")).Equal([]string{"/data/**/?HOME"})
+		})
+	})
+}
+
+func TestParseLocks(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("ParseLocks", func() {
+		g.It("returns no locks for empty output", func() {
+			locks, err := restic.ParseLocks([]byte("\n"))
+			g.Assert(err).IsNil()
+			g.Assert(len(locks)).Equal(0)
+		})
+
+		g.It("parses lock ids one per line", func() {
+			locks, err := restic.ParseLocks([]byte("a1b2c3d4e5f6\nf6e5d4c3b2a1\n"))
+			g.Assert(err).IsNil()
+			g.Assert(len(locks)).Equal(2)
+			g.Assert(locks[1].ID).Equal("f6e5d4c3b2a1")
+		})
+
+		g.It("parses json encoded locks", func() {
+			locks, err := restic.ParseLocks([]byte(`{"id":"a1b2c3d4","time":"2025-01-01T00:00:00Z","exclusive":true,"hostname":"node","pid":42}`))
+			g.Assert(err).IsNil()
+			g.Assert(len(locks)).Equal(1)
+			g.Assert(locks[0].Exclusive).IsTrue()
+			g.Assert(locks[0].PID).Equal(42)
+		})
+
+		g.It("returns an error for malformed json", func() {
+			_, err := restic.ParseLocks([]byte(`{"id":`))
+			g.Assert(err).IsNotNil()
+		})
+	})
+}
+
+func TestError(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Error", func() {
+		g.It("detects a locked repository", func() {
+			err := &restic.Error{Command: "backup", ExitCode: 1, Output: "unable to create lock in backend: repository is already locked by PID 1 on node by root (UID 0, GID 0)\nlock was created at 2025-01-02 03:04:05 (1h0m0s ago)"}
+			g.Assert(restic.IsLocked(err)).IsTrue()
+			g.Assert(restic.IsWrongPassword(err)).IsFalse()
+			g.Assert(err.LockCreatedAt().Format("2006-01-02 15:04:05")).Equal("2025-01-02 03:04:05")
+		})
+
+		g.It("detects errors using restic exit codes", func() {
+			g.Assert(restic.IsLocked(&restic.Error{ExitCode: 11})).IsTrue()
+			g.Assert(restic.IsWrongPassword(&restic.Error{ExitCode: 12})).IsTrue()
+		})
+
+		g.It("detects a wrong password", func() {
+			err := &restic.Error{Command: "snapshots", ExitCode: 1, Output: "Fatal: wrong password or no key found"}
+			g.Assert(restic.IsWrongPassword(err)).IsTrue()
+			g.Assert(err.Error()).Equal("restic snapshots: Fatal: wrong password or no key found")
+		})
+
+		g.It("is found through wrapped errors", func() {
+			err := errors.WithStack(&restic.Error{Command: "forget", Output: "Ignoring \"abc\": snapshot abc not found"})
+			g.Assert(restic.AsError(err)).IsNotNil()
+			g.Assert(restic.AsError(err).NotFound()).IsTrue()
+		})
+
+		g.It("handles errors that did not come from restic", func() {
+			err := errors.New("something else")
+			g.Assert(restic.AsError(err) == nil).IsTrue()
+			g.Assert(restic.IsLocked(err)).IsFalse()
+			g.Assert(restic.IsTimeout(nil)).IsFalse()
+		})
+	})
+}
+
+func TestClientPassword(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Client", func() {
+		g.It("passes the password through a pipe rather than the environment", func() {
+			bin := filepath.Join(t.TempDir(), "restic")
+			script := "#!/bin/sh\nprintf '%s|%s' \"$RESTIC_PASSWORD\" \"$(cat \"$RESTIC_PASSWORD_FILE\")\"\n"
+			g.Assert(os.WriteFile(bin, []byte(script), 0o755)).IsNil()
+			t.Setenv("RESTIC_PASSWORD", "from-wings")
+
+			out, err := restic.New(bin, "/repo", "secret").Run(context.Background(), "snapshots")
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("|secret")
+		})
+
+		g.It("adds the environment of the backend to every process", func() {
+			bin := filepath.Join(t.TempDir(), "restic")
+			script := "#!/bin/sh\nprintf '%s' \"$AWS_ACCESS_KEY_ID\"\n"
+			g.Assert(os.WriteFile(bin, []byte(script), 0o755)).IsNil()
+
+			out, err := restic.New(bin, "s3:http://127.0.0.1:9000/wings", "secret").WithEnv("AWS_ACCESS_KEY_ID=minio").Run(context.Background(), "snapshots")
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("minio")
+		})
+
+		g.It("passes the password of the source repository through a second pipe", func() {
+			bin := filepath.Join(t.TempDir(), "restic")
+			script := "#!/bin/sh\nprintf '%s|%s|%s|%s' \"$5\" \"$(cat \"$RESTIC_PASSWORD_FILE\")\" \"$(cat \"$7\")\" \"$AWS_ACCESS_KEY_ID\"\n"
+			g.Assert(os.WriteFile(bin, []byte(script), 0o755)).IsNil()
+
+			from := restic.New(bin, "/primary", "primary-secret").WithEnv("AWS_ACCESS_KEY_ID=primary")
+			to := restic.New(bin, "/secondary", "secondary-secret").WithEnv("AWS_ACCESS_KEY_ID=secondary")
+			out, err := to.Copy(context.Background(), from)
+			g.Assert(err).IsNil()
+			g.Assert(string(out)).Equal("/primary|secondary-secret|primary-secret|secondary")
+		})
+
+		g.It("reports a missing repository as not initialized", func() {
+			bin := filepath.Join(t.TempDir(), "restic")
+			script := "#!/bin/sh\necho 'Fatal: repository does not exist: unable to open config file' >&2\nexit 10\n"
+			g.Assert(os.WriteFile(bin, []byte(script), 0o755)).IsNil()
+
+			ok, err := restic.New(bin, "rest:http://127.0.0.1:8000/wings", "secret").Initialized(context.Background())
+			g.Assert(err).IsNil()
+			g.Assert(ok).IsFalse()
+		})
+	})
+}
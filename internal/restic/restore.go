@@ -0,0 +1,74 @@
+package restic
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// RestoreOptions controls how a snapshot is restored.
+type RestoreOptions struct {
+	// Snapshot is the ID of the snapshot to restore.
+	Snapshot string
+	// Subfolder restores only the contents of this directory within the snapshot
+	// into the target, rather than the snapshot as a whole.
+	Subfolder string
+	// Target is the directory that the snapshot is restored into.
+	Target string
+	// Paths limits which snapshots are considered when Snapshot is "latest".
+	Paths []string
+	// Includes limits the restore to the files matching these patterns. When a
+	// Subfolder is set the patterns are relative to it.
+	Includes []string
+	// OnStatus is called for every progress update that restic reports while
+	// the restore is running.
+	OnStatus func(RestoreStatus)
+}
+
+// RestoreStatus is a progress update or the final summary reported by
+// "restic restore --json".
+type RestoreStatus struct {
+	SecondsElapsed uint64  `json:"seconds_elapsed"`
+	PercentDone    float64 `json:"percent_done"`
+	TotalFiles     uint64  `json:"total_files"`
+	FilesRestored  uint64  `json:"files_restored"`
+	FilesSkipped   uint64  `json:"files_skipped,omitempty"`
+	FilesDeleted   uint64  `json:"files_deleted,omitempty"`
+	TotalBytes     uint64  `json:"total_bytes"`
+	BytesRestored  uint64  `json:"bytes_restored"`
+	BytesSkipped   uint64  `json:"bytes_skipped,omitempty"`
+}
+
+// Restore restores a snapshot into the target directory. The summary reported
+// by restic is returned when it is available; versions of restic older than
+// 0.17 do not report one.
+func (c *Client) Restore(ctx context.Context, opts RestoreOptions) (*RestoreStatus, error) {
+	snapshot := opts.Snapshot
+	if opts.Subfolder != "" {
+		snapshot += ":" + opts.Subfolder
+	}
+	args := []string{"restore", snapshot, "--json", "--target", opts.Target}
+	for _, p := range opts.Paths {
+		args = append(args, "--path", p)
+	}
+	for _, p := range opts.Includes {
+		args = append(args, "--include", p)
+	}
+
+	var summary *RestoreStatus
+	err := c.stream(ctx, args, func(messageType string, line []byte) error {
+		var s RestoreStatus
+		if json.Unmarshal(line, &s) != nil {
+			return nil
+		}
+		switch messageType {
+		case "status":
+			if opts.OnStatus != nil {
+				opts.OnStatus(s)
+			}
+		case "summary":
+			summary = &s
+		}
+		return nil
+	})
+	return summary, err
+}
@@ -0,0 +1,252 @@
+package restic
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// LockedTag is the tag added to snapshots that must never be removed by
+	// retention policies or when making room for a new backup.
+	LockedTag = "locked"
+	// PreRestoreTag is the tag added to the snapshot of a server that is taken
+	// immediately before another snapshot is restored over it.
+	PreRestoreTag = "pre-restore"
+	// RestoreSourceTagPrefix prefixes the tag that records the ID of the snapshot
+	// that was restored after a pre-restore snapshot was taken.
+	RestoreSourceTagPrefix = "restore-source:"
+	// BackupTagPrefix prefixes the tag that records the UUID of the Panel backup
+	// that a snapshot was created for.
+	BackupTagPrefix = "backup:"
+	// ImportedTag is the tag added to snapshots that were imported from a backup
+	// archive, which are taken of a staging directory rather than the server
+	// data directory.
+	ImportedTag = "imported"
+)
+
+// Snapshot is a single snapshot as returned by "restic snapshots --json".
+type Snapshot struct {
+	ID             string           `json:"id"`
+	ShortID        string           `json:"short_id"`
+	Time           time.Time        `json:"time"`
+	Parent         string           `json:"parent,omitempty"`
+	Original       string           `json:"original,omitempty"`
+	Tree           string           `json:"tree"`
+	Paths          []string         `json:"paths"`
+	Hostname       string           `json:"hostname,omitempty"`
+	Username       string           `json:"username,omitempty"`
+	UID            uint32           `json:"uid,omitempty"`
+	GID            uint32           `json:"gid,omitempty"`
+	Excludes       []string         `json:"excludes,omitempty"`
+	Tags           []string         `json:"tags,omitempty"`
+	ProgramVersion string           `json:"program_version,omitempty"`
+	Summary        *SnapshotSummary `json:"summary,omitempty"`
+}
+
+// SnapshotSummary is the summary that restic 0.17 and newer store alongside
+// each snapshot when it is created.
+type SnapshotSummary struct {
+	BackupStart         time.Time `json:"backup_start"`
+	BackupEnd           time.Time `json:"backup_end"`
+	FilesNew            uint64    `json:"files_new"`
+	FilesChanged        uint64    `json:"files_changed"`
+	FilesUnmodified     uint64    `json:"files_unmodified"`
+	DirsNew             uint64    `json:"dirs_new"`
+	DirsChanged         uint64    `json:"dirs_changed"`
+	DirsUnmodified      uint64    `json:"dirs_unmodified"`
+	DataBlobs           int64     `json:"data_blobs"`
+	TreeBlobs           int64     `json:"tree_blobs"`
+	DataAdded           uint64    `json:"data_added"`
+	DataAddedPacked     uint64    `json:"data_added_packed"`
+	TotalFilesProcessed uint64    `json:"total_files_processed"`
+	TotalBytesProcessed uint64    `json:"total_bytes_processed"`
+}
+
+// HasTag reports whether the snapshot has the given tag.
+func (s Snapshot) HasTag(tag string) bool {
+	for _, t := range s.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Locked reports whether the snapshot has been locked against removal.
+func (s Snapshot) Locked() bool {
+	return s.HasTag(LockedTag)
+}
+
+// PreRestore reports whether the snapshot was taken before a restore.
+func (s Snapshot) PreRestore() bool {
+	return s.HasTag(PreRestoreTag)
+}
+
+// Imported reports whether the snapshot was imported from a backup archive.
+func (s Snapshot) Imported() bool {
+	return s.HasTag(ImportedTag)
+}
+
+// RestoreSource returns the ID of the snapshot that was restored after this
+// pre-restore snapshot was taken, or an empty string if it is not known.
+func (s Snapshot) RestoreSource() string {
+	for _, t := range s.Tags {
+		if strings.HasPrefix(t, RestoreSourceTagPrefix) {
+			return strings.TrimPrefix(t, RestoreSourceTagPrefix)
+		}
+	}
+	return ""
+}
+
+// BackupUUID returns the UUID of the Panel backup that the snapshot was created
+// for, or an empty string if it was not created for one.
+func (s Snapshot) BackupUUID() string {
+	for _, t := range s.Tags {
+		if strings.HasPrefix(t, BackupTagPrefix) {
+			return strings.TrimPrefix(t, BackupTagPrefix)
+		}
+	}
+	return ""
+}
+
+// Short returns the short form of the snapshot ID.
+func (s Snapshot) Short() string {
+	if s.ShortID != "" {
+		return s.ShortID
+	}
+	if len(s.ID) >= 8 {
+		return s.ID[:8]
+	}
+	return s.ID
+}
+
+// Matches reports whether id refers to this snapshot, either by its full ID
+// or by its short ID.
+func (s Snapshot) Matches(id string) bool {
+	if id == "" {
+		return false
+	}
+	return id == s.ID || id == s.Short()
+}
+
+// Size returns the number of bytes that were processed when the snapshot was
+// created. The second return value is false if restic did not record a
+// summary for the snapshot.
+func (s Snapshot) Size() (uint64, bool) {
+	if s.Summary == nil {
+		return 0, false
+	}
+	return s.Summary.TotalBytesProcessed, true
+}
+
+// Snapshots returns the snapshots in the repository. When latest is greater
+// than zero only that many of the most recent snapshots are returned. The
+// repository is not locked while listing.
+func (c *Client) Snapshots(ctx context.Context, latest int) ([]Snapshot, error) {
+	args := []string{"snapshots", "--json", "--no-lock"}
+	if latest > 0 {
+		args = append(args, "--latest", strconv.Itoa(latest))
+	}
+	var snapshots []Snapshot
+	if err := c.runJSON(ctx, &snapshots, args...); err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// FindSnapshot returns the snapshot with the given full or short ID, or nil if
+// no such snapshot exists.
+func (c *Client) FindSnapshot(ctx context.Context, id string) (*Snapshot, error) {
+	snapshots, err := c.Snapshots(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range snapshots {
+		if s.Matches(id) {
+			return &s, nil
+		}
+	}
+	return nil, nil
+}
+
+// AddTags adds the given tags to a snapshot.
+func (c *Client) AddTags(ctx context.Context, id string, tags ...string) error {
+	args := []string{"tag"}
+	for _, t := range tags {
+		args = append(args, "--add", t)
+	}
+	_, err := c.Run(ctx, append(args, id)...)
+	return err
+}
+
+// RemoveTags removes the given tags from a snapshot.
+func (c *Client) RemoveTags(ctx context.Context, id string, tags ...string) error {
+	args := []string{"tag"}
+	for _, t := range tags {
+		args = append(args, "--remove", t)
+	}
+	_, err := c.Run(ctx, append(args, id)...)
+	return err
+}
+
+// Forget removes the given snapshots and prunes any data that is no longer
+// referenced by the remaining snapshots.
+func (c *Client) Forget(ctx context.Context, ids ...string) ([]byte, error) {
+	args := append([]string{"forget"}, ids...)
+	return c.RunCombined(ctx, append(args, "--prune")...)
+}
+
+// Policy is a retention policy applied with "restic forget". Zero values are
+// ignored.
+type Policy struct {
+	KeepLast    int
+	KeepHourly  int
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+	KeepYearly  int
+	KeepWithin  string
+	KeepTags    []string
+}
+
+// Empty reports whether the policy has no rules that would keep a snapshot,
+// in which case applying it would remove every snapshot that is not tagged.
+func (p Policy) Empty() bool {
+	return p.KeepLast <= 0 && p.KeepHourly <= 0 && p.KeepDaily <= 0 && p.KeepWeekly <= 0 &&
+		p.KeepMonthly <= 0 && p.KeepYearly <= 0 && p.KeepWithin == ""
+}
+
+// Args returns the arguments for "restic forget" that implement the policy.
+// Snapshots are grouped by host only, since restic groups them by their paths as
+// well by default. Every snapshot in a repository is of the same server, but
+// imported snapshots and those taken before the data directory was moved record
+// other paths, and would otherwise be kept on top of the regular history.
+func (p Policy) Args() []string {
+	args := []string{"--group-by", "host"}
+	for _, t := range p.KeepTags {
+		args = append(args, "--keep-tag", t)
+	}
+	add := func(flag string, v int) {
+		if v > 0 {
+			args = append(args, flag, strconv.Itoa(v))
+		}
+	}
+	add("--keep-last", p.KeepLast)
+	add("--keep-hourly", p.KeepHourly)
+	add("--keep-daily", p.KeepDaily)
+	add("--keep-weekly", p.KeepWeekly)
+	add("--keep-monthly", p.KeepMonthly)
+	add("--keep-yearly", p.KeepYearly)
+	if p.KeepWithin != "" {
+		args = append(args, "--keep-within", p.KeepWithin)
+	}
+	return args
+}
+
+// ApplyPolicy removes every snapshot that is not kept by the policy and prunes
+// the repository afterwards.
+func (c *Client) ApplyPolicy(ctx context.Context, p Policy) ([]byte, error) {
+	return c.RunCombined(ctx, append([]string{"forget", "--prune"}, p.Args()...)...)
+}
@@ -0,0 +1,40 @@
+package restic
+
+import (
+	"context"
+)
+
+// Modes that can be passed to Stats.
+const (
+	StatsRestoreSize     = "restore-size"
+	StatsFilesByContents = "files-by-contents"
+	StatsRawData         = "raw-data"
+)
+
+// Stats is the output of "restic stats --json".
+type Stats struct {
+	TotalSize              uint64  `json:"total_size"`
+	TotalFileCount         uint64  `json:"total_file_count"`
+	TotalBlobCount         uint64  `json:"total_blob_count,omitempty"`
+	SnapshotsCount         int     `json:"snapshots_count"`
+	TotalUncompressedSize  uint64  `json:"total_uncompressed_size,omitempty"`
+	CompressionRatio       float64 `json:"compression_ratio,omitempty"`
+	CompressionProgress    float64 `json:"compression_progress,omitempty"`
+	CompressionSpaceSaving float64 `json:"compression_space_saving,omitempty"`
+}
+
+// Stats returns statistics about the repository using the given counting mode.
+// An empty mode uses the restic default which is StatsRestoreSize. When snapshot
+// IDs are given only those snapshots are counted.
+func (c *Client) Stats(ctx context.Context, mode string, snapshots ...string) (*Stats, error) {
+	args := []string{"stats", "--json", "--no-lock"}
+	if mode != "" {
+		args = append(args, "--mode", mode)
+	}
+	args = append(args, snapshots...)
+	var stats Stats
+	if err := c.runJSON(ctx, &stats, args...); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
@@ -0,0 +1,45 @@
+package resticsvc
+
+import (
+	"time"
+
+	"github.com/pterodactyl/wings/internal/models"
+	"github.com/pterodactyl/wings/server"
+)
+
+// Activity tracks a restic operation so that it can be recorded in the
+// server activity log, along with the result, once it has finished.
+type Activity struct {
+	s       *server.Server
+	ra      server.RequestActivity
+	event   models.Event
+	started time.Time
+}
+
+// NewActivity starts tracking a restic operation for the server that was
+// started by the given user, or by the system if user is empty.
+func NewActivity(s *server.Server, user string, ip string, event models.Event) *Activity {
+	return &Activity{
+		s:       s,
+		ra:      s.NewRequestActivity(user, ip),
+		event:   event,
+		started: time.Now(),
+	}
+}
+
+// Save records the operation in the activity log with the given metadata, the
+// time it took to run, and whether or not it was successful.
+func (a *Activity) Save(err error, metadata models.ActivityMeta) {
+	meta := models.ActivityMeta{
+		"duration": time.Since(a.started).Round(time.Millisecond).Seconds(),
+		"result":   "success",
+	}
+	for k, v := range metadata {
+		meta[k] = v
+	}
+	if err != nil {
+		meta["result"] = "failed"
+		meta["error"] = TruncateStatusMessage(Output(err))
+	}
+	a.s.SaveActivity(a.ra, a.event, meta)
+}
@@ -0,0 +1,465 @@
+package resticsvc
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/database"
+	"github.com/pterodactyl/wings/internal/models"
+	resticcli "github.com/pterodactyl/wings/internal/restic"
+)
+
+// Backend is where the repositories of servers are stored. Anything that
+// depends on how a repository is stored goes through its backend, so that the
+// rest of the package only deals with the location that is passed to restic.
+type Backend interface {
+	// Location returns the repository that restic is given for a repository
+	// directory name, such as "<server>+<owner>".
+	Location(dir string) string
+	// Env returns the environment variables that restic needs to access the
+	// backend.
+	Env() []string
+	// Exists reports whether anything has been created at the location.
+	Exists(repo string) bool
+	// Initialized reports whether the repository has been initialized.
+	Initialized(repo string) bool
+	// Prepare is called before a repository is initialized.
+	Prepare(repo string) error
+	// Dirs returns the directory names of the repositories of the server.
+	Dirs(serverId string) []string
+	// Size returns the number of bytes that the repository uses.
+	Size(repo string) (int64, error)
+	// CreatedWithin reports whether the repository was initialized within the
+	// window, and so cannot contain anything worth keeping.
+	CreatedWithin(repo string, window time.Duration) bool
+	// HasLocks reports whether the repository is locked.
+	HasLocks(repo string) bool
+	// RemoveStaleLocks removes every lock from the repository if all of them are
+	// older than minAge, returning the reason if nothing was removed.
+	RemoveStaleLocks(repo string, minAge time.Duration) (bool, string)
+	// Remove deletes the repository.
+	Remove(repo string) error
+	// Archive moves the repository of a deleted server out of the way and
+	// returns its new location.
+	Archive(repo string) (string, error)
+}
+
+// remoteSchemes are the prefixes of the repository locations that restic
+// accesses over the network rather than on the local disk.
+var remoteSchemes = []string{"rest:", "s3:", "sftp:", "b2:", "azure:", "gs:", "swift:", "rclone:"}
+
+// ErrRemoteRemove is returned when removing a repository on a remote backend.
+var ErrRemoteRemove = errors.New("remote repositories cannot be deleted by Wings, delete them from the storage backend instead")
+
+// IsRemoteRepo reports whether the repository is stored on a remote backend.
+func IsRemoteRepo(repo string) bool {
+	for _, scheme := range remoteSchemes {
+		if strings.HasPrefix(repo, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeRepo returns the form of a repository location that it is stored as
+// in the database.
+func NormalizeRepo(repo string) string {
+	if IsRemoteRepo(repo) {
+		return strings.TrimRight(repo, "/")
+	}
+	return filepath.Clean(repo)
+}
+
+// ServerBackend returns the backend that the repositories of the server are
+// stored in.
+func ServerBackend(serverId string) Backend {
+	cfg := config.Get().Restic.Backend
+	base := cfg.URL
+	if v := cfg.Servers[serverId]; v != "" {
+		base = v
+	}
+	if base == "" {
+		return localBackend{base: RepoBaseDir()}
+	}
+	return remoteBackend{base: strings.TrimRight(base, "/")}
+}
+
+// backendOf returns the backend that the repository is stored in.
+func backendOf(repo string) Backend {
+	if IsRemoteRepo(repo) {
+		return remoteBackend{}
+	}
+	return localBackend{base: RepoBaseDir()}
+}
+
+// serverOfDir returns the UUID of the server that a repository directory name
+// belongs to.
+func serverOfDir(dir string) string {
+	serverId, _, _ := strings.Cut(dir, "+")
+	return serverId
+}
+
+// dirBelongsTo reports whether a repository directory name belongs to the server.
+func dirBelongsTo(name string, serverId string) bool {
+	return name == serverId || strings.HasPrefix(name, serverId+"+")
+}
+
+func RepoExists(repo string) bool {
+	return repo != "" && backendOf(repo).Exists(repo)
+}
+
+func RepoInitialized(repo string) bool {
+	return repo != "" && backendOf(repo).Initialized(repo)
+}
+
+func PrepareRepo(repo string) error {
+	return backendOf(repo).Prepare(repo)
+}
+
+func RepoSizeBytes(repo string) (int64, error) {
+	if repo == "" {
+		return 0, errors.New("missing repo")
+	}
+	return backendOf(repo).Size(repo)
+}
+
+func isRecentRepo(repo string, window time.Duration) bool {
+	return backendOf(repo).CreatedWithin(repo, window)
+}
+
+func repoHasLocks(repo string) bool {
+	return repo != "" && backendOf(repo).HasLocks(repo)
+}
+
+func ForceRemoveRepoLocks(repo string, minAge time.Duration) (bool, string) {
+	if repo == "" {
+		return false, ""
+	}
+	return backendOf(repo).RemoveStaleLocks(repo, minAge)
+}
+
+func ReposForServer(serverId string) []string {
+	if serverId == "" {
+		return []string{}
+	}
+	b := ServerBackend(serverOfDir(serverId))
+	repos := []string{}
+	if strings.Contains(serverId, "+") {
+		if candidate := b.Location(serverId); RepoExists(candidate) {
+			repos = append(repos, candidate)
+		}
+	}
+	for _, dir := range b.Dirs(serverId) {
+		repos = append(repos, b.Location(dir))
+	}
+	return repos
+}
+
+// localBackend stores repositories in directories within the repository
+// directory of the node.
+type localBackend struct {
+	base string
+}
+
+func (b localBackend) Location(dir string) string {
+	return filepath.Join(b.base, dir)
+}
+
+func (localBackend) Env() []string {
+	return nil
+}
+
+func (localBackend) Exists(repo string) bool {
+	if _, err := os.Stat(filepath.Join(repo, "config")); err == nil {
+		return true
+	}
+	if info, err := os.Stat(repo); err == nil && info.IsDir() {
+		return true
+	}
+	return false
+}
+
+func (localBackend) Initialized(repo string) bool {
+	_, err := os.Stat(filepath.Join(repo, "config"))
+	return err == nil
+}
+
+func (localBackend) Prepare(repo string) error {
+	return os.MkdirAll(repo, 0755)
+}
+
+func (b localBackend) Dirs(serverId string) []string {
+	entries, err := os.ReadDir(b.base)
+	if err != nil {
+		return []string{}
+	}
+	dirs := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && dirBelongsTo(entry.Name(), serverId) {
+			dirs = append(dirs, entry.Name())
+		}
+	}
+	return dirs
+}
+
+func (localBackend) Size(repo string) (int64, error) {
+	return repoDiskUsageBytes(repo)
+}
+
+func (localBackend) CreatedWithin(repo string, window time.Duration) bool {
+	st, err := os.Stat(filepath.Join(repo, "config"))
+	if err != nil {
+		return false
+	}
+	return time.Since(st.ModTime()) <= window
+}
+
+func (localBackend) HasLocks(repo string) bool {
+	entries, err := os.ReadDir(filepath.Join(repo, "locks"))
+	if err != nil {
+		return false
+	}
+	return len(entries) > 0
+}
+
+func (localBackend) RemoveStaleLocks(repo string, minAge time.Duration) (bool, string) {
+	lockDir := filepath.Join(repo, "locks")
+	entries, err := os.ReadDir(lockDir)
+	if err != nil {
+		return false, ""
+	}
+	if len(entries) == 0 {
+		return false, "no locks"
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return false, ""
+		}
+		if time.Since(info.ModTime()) < minAge {
+			return false, "locks not stale"
+		}
+	}
+	for _, entry := range entries {
+		_ = os.Remove(filepath.Join(lockDir, entry.Name()))
+	}
+	return true, ""
+}
+
+func (localBackend) Remove(repo string) error {
+	return os.RemoveAll(repo)
+}
+
+func (localBackend) Archive(repo string) (string, error) {
+	archive := ArchiveDir()
+	if err := os.MkdirAll(archive, 0755); err != nil {
+		return "", errors.Wrap(err, "failed to create restic archive directory")
+	}
+	name := filepath.Base(repo)
+	ts := time.Now().Format("20060102-150405")
+	to := filepath.Join(archive, name+"-"+ts)
+	if _, err := os.Stat(to); err == nil {
+		to = filepath.Join(archive, name+"-"+ts+"-"+strconv.FormatInt(time.Now().UnixNano(), 10))
+	}
+	if err := os.Rename(repo, to); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return to, nil
+}
+
+func repoDiskUsageBytes(path string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
+
+// remoteInitialized caches the remote repositories that are known to have been
+// initialized, so that the backend does not have to be asked on every request.
+var remoteInitialized sync.Map
+
+// remoteBackend stores repositories on a backend that restic accesses over the
+// network, such as a REST server, S3 or SFTP. Nothing can be known about a
+// remote repository without its password, so the repositories of a server are
+// those that Wings has stored a password for.
+type remoteBackend struct {
+	base string
+}
+
+func (b remoteBackend) Location(dir string) string {
+	return b.base + "/" + dir
+}
+
+func (remoteBackend) Env() []string {
+	return envList(config.Get().Restic.Backend.Env)
+}
+
+// envList returns environment variables in the form that restic is started with,
+// sorted by name.
+func envList(vars map[string]string) []string {
+	env := make([]string, 0, len(vars))
+	for k, v := range vars {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// client returns a client for the repository using its stored password, or nil
+// if there is not one.
+func (remoteBackend) client(repo string) *resticcli.Client {
+	key, err := LoadKey(repo)
+	if err != nil || key == "" {
+		return nil
+	}
+	return NewClient(repo, key)
+}
+
+func (b remoteBackend) Exists(repo string) bool {
+	return b.Initialized(repo)
+}
+
+func (b remoteBackend) Initialized(repo string) bool {
+	repo = NormalizeRepo(repo)
+	if _, ok := remoteInitialized.Load(repo); ok {
+		return true
+	}
+	client := b.client(repo)
+	if client == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), Seconds(config.Get().Restic.Timeouts.Snapshots))
+	defer cancel()
+	ok, err := client.Initialized(ctx)
+	if err != nil {
+		log.WithFields(log.Fields{"repo": repo, "error": err}).Warn("restic: failed to check remote repository")
+	}
+	if ok {
+		remoteInitialized.Store(repo, true)
+	}
+	return ok
+}
+
+func (remoteBackend) Prepare(string) error {
+	return nil
+}
+
+func (b remoteBackend) Dirs(serverId string) []string {
+	var keys []models.ResticKey
+	prefix := b.base + "/"
+	if err := database.Instance().Where("repository LIKE ?", prefix+serverId+"%").Order("repository").Find(&keys).Error; err != nil {
+		log.WithFields(log.Fields{"server": serverId, "error": err}).Warn("restic: failed to list remote repositories")
+		return []string{}
+	}
+	dirs := []string{}
+	for _, k := range keys {
+		name := strings.TrimPrefix(k.Repository, prefix)
+		if strings.HasPrefix(k.Repository, prefix) && !strings.Contains(name, "/") && dirBelongsTo(name, serverId) {
+			dirs = append(dirs, name)
+		}
+	}
+	return dirs
+}
+
+func (b remoteBackend) Size(repo string) (int64, error) {
+	client := b.client(repo)
+	if client == nil {
+		return 0, errors.New("missing encryption key")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), Seconds(config.Get().Restic.Timeouts.Stats))
+	defer cancel()
+	stats, err := client.Stats(ctx, resticcli.StatsRawData)
+	if err != nil {
+		return 0, err
+	}
+	return int64(stats.TotalSize), nil
+}
+
+// CreatedWithin always returns false, since the creation time of a remote
+// repository is not known and it must never be replaced.
+func (remoteBackend) CreatedWithin(string, time.Duration) bool {
+	return false
+}
+
+func (b remoteBackend) locks(repo string) ([]resticcli.Lock, *resticcli.Client, error) {
+	client := b.client(repo)
+	if client == nil {
+		return nil, nil, errors.New("missing encryption key")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), Seconds(config.Get().Restic.Timeouts.Snapshots))
+	defer cancel()
+	locks, err := client.Locks(ctx)
+	return locks, client, err
+}
+
+func (b remoteBackend) HasLocks(repo string) bool {
+	locks, _, err := b.locks(repo)
+	return err == nil && len(locks) > 0
+}
+
+// RemoveStaleLocks removes every lock with restic once they are all older than
+// minAge. The age of a lock that restic does not list is read from the lock
+// itself, and the locks are never removed if the age of one is not known, since
+// it could be held by a backup that is still running.
+func (b remoteBackend) RemoveStaleLocks(repo string, minAge time.Duration) (bool, string) {
+	locks, client, err := b.locks(repo)
+	if err != nil {
+		return false, ""
+	}
+	if len(locks) == 0 {
+		return false, "no locks"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), Seconds(config.Get().Restic.Timeouts.Unlock))
+	defer cancel()
+	for _, l := range locks {
+		if l.Time.IsZero() && l.ID != "" {
+			if full, err := client.Lock(ctx, l.ID); err == nil {
+				l = full
+			}
+		}
+		if l.Time.IsZero() || time.Since(l.Time) < minAge {
+			return false, "locks not stale"
+		}
+	}
+	if err := client.UnlockAll(ctx); err != nil {
+		return false, Output(err)
+	}
+	return true, ""
+}
+
+func (remoteBackend) Remove(string) error {
+	return ErrRemoteRemove
+}
+
+// Archive leaves the repository where it is, since it is already stored away
+// from the node, along with its stored password. It is not listed with the
+// archived repositories, which are only those in the archive directory.
+func (remoteBackend) Archive(repo string) (string, error) {
+	return repo, nil
+}
@@ -0,0 +1,171 @@
+package resticsvc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+
+	"github.com/pterodactyl/wings/config"
+	resticcli "github.com/pterodactyl/wings/internal/restic"
+	"github.com/pterodactyl/wings/server"
+)
+
+// ResolveKey returns the stored password of the repository, storing the provided
+// one first if there is not one yet.
+func ResolveKey(repo string, provided string) (string, error) {
+	if repo == "" {
+		return "", fmt.Errorf("missing repo")
+	}
+	stored, err := LoadKey(repo)
+	if err != nil {
+		return "", err
+	}
+	if stored != "" {
+		return stored, nil
+	}
+	if provided == "" {
+		return "", fmt.Errorf("missing encryption key")
+	}
+	if err := StoreKey(repo, provided); err != nil {
+		log.WithFields(log.Fields{"repo": repo, "error": err}).Warn("restic: failed to store repository password")
+	}
+	return provided, nil
+}
+
+// BackupExcludes returns the restic exclude patterns for a backup of the server
+// volume. The node-wide default excludes are applied first so that negated rules
+// in the server's .pteroignore file are able to re-include those paths.
+func BackupExcludes(s *server.Server, volumePath string) []string {
+	rules := config.Get().Restic.DefaultExcludes
+	ignored, err := s.GetServerwideIgnoredFiles()
+	if err != nil {
+		s.Log().WithField("error", err).Warn("failed to get server-wide ignored files for restic backup")
+	} else if ignored != "" {
+		rules = append(append([]string{}, rules...), ignored)
+	}
+	return resticcli.IgnorePatterns(volumePath, strings.Join(rules, "\n"))
+}
+
+// InitRepoIfMissing initializes the repository of the client if it has not been
+// initialized yet.
+func InitRepoIfMissing(client *resticcli.Client) error {
+	repo := client.Repository()
+	if !RepoInitialized(repo) {
+		if err := PrepareRepo(repo); err != nil {
+			return err
+		}
+		if err := client.Init(context.Background()); err != nil {
+			if !RepoInitialized(repo) {
+				return err
+			}
+			// repo initialized concurrently; continue
+		}
+	}
+	return nil
+}
+
+// RunBackupWithRecovery creates a snapshot and records the result in the backup
+// status of the server. A stale lock is removed, and a repository created moments
+// ago with a different password is initialized again, before trying once more.
+func RunBackupWithRecovery(ctx context.Context, client *resticcli.Client, opts resticcli.BackupOptions, encryptionKey string, serverId string) (*resticcli.BackupSummary, error) {
+	backup := func() (*resticcli.BackupSummary, error) {
+		ctx, cancel := context.WithTimeout(ctx, Seconds(config.Get().Restic.Timeouts.Backup))
+		defer cancel()
+		return client.Backup(ctx, opts)
+	}
+
+	summary, err := backup()
+	if err == nil {
+		SetBackupStatus(serverId, "completed", "")
+		return summary, nil
+	}
+	if resticcli.IsCanceled(err) {
+		return nil, err
+	}
+
+	if resticcli.IsLocked(err) && tryUnlockStaleLock(client, err) {
+		retrySummary, retryErr := backup()
+		if retryErr == nil {
+			SetBackupStatus(serverId, "completed", "")
+			return retrySummary, nil
+		}
+		SetBackupStatus(serverId, "failed", TruncateStatusMessage(Output(retryErr)))
+		return nil, retryErr
+	}
+
+	repo := client.Repository()
+	if resticcli.IsWrongPassword(err) && isRecentRepo(repo, 2*time.Minute) && isSafeToReinitRepo(repo) && !repoHasLocks(repo) {
+		if reinitErr := reinitRepo(repo, encryptionKey); reinitErr == nil {
+			retrySummary, retryErr := backup()
+			if retryErr == nil {
+				SetBackupStatus(serverId, "completed", "")
+				return retrySummary, nil
+			}
+			SetBackupStatus(serverId, "failed", TruncateStatusMessage(Output(retryErr)))
+			return nil, retryErr
+		}
+	}
+	SetBackupStatus(serverId, "failed", TruncateStatusMessage(Output(err)))
+	return nil, err
+}
+
+func isSafeToReinitRepo(repo string) bool {
+	if repo == "" {
+		return false
+	}
+	size, err := RepoSizeBytes(repo)
+	if err != nil {
+		return false
+	}
+	return size <= 1024*1024
+}
+
+func reinitRepo(repo string, encryptionKey string) error {
+	if repo == "" {
+		return fmt.Errorf("missing repo")
+	}
+	if err := backendOf(repo).Remove(repo); err != nil {
+		return err
+	}
+	if err := DeleteKey(repo); err != nil {
+		return err
+	}
+	if err := PrepareRepo(repo); err != nil {
+		return err
+	}
+	_, err := ResolveKey(repo, encryptionKey)
+	if err != nil {
+		return err
+	}
+	return NewClient(repo, encryptionKey).Init(context.Background())
+}
+
+// ResolveRepoDir returns the directory name of the repository of the server
+// that belongs to the owner, preferring one that already exists.
+func ResolveRepoDir(serverId string, ownerUsername string) string {
+	candidates := []string{}
+	if ownerUsername != "" {
+		candidates = append(candidates, fmt.Sprintf("%s+%s", serverId, ownerUsername))
+	}
+	candidates = append(candidates, serverId)
+
+	for _, dir := range candidates {
+		repo := RepoPath(dir)
+		if RepoExists(repo) {
+			return dir
+		}
+	}
+
+	for _, name := range ServerBackend(serverId).Dirs(serverId) {
+		if strings.HasPrefix(name, serverId+"+") {
+			return name
+		}
+	}
+	if ownerUsername != "" {
+		return fmt.Sprintf("%s+%s", serverId, ownerUsername)
+	}
+	return serverId
+}
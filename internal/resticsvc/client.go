@@ -0,0 +1,52 @@
+package resticsvc
+
+import (
+	"context"
+	"time"
+
+	"github.com/pterodactyl/wings/config"
+	resticcli "github.com/pterodactyl/wings/internal/restic"
+)
+
+// NewClient returns a client for the repository at the given location which
+// authenticates using the provided key.
+func NewClient(repo string, key string) *resticcli.Client {
+	return resticcli.New(Binary(), repo, key).WithEnv(backendOf(repo).Env()...)
+}
+
+// Output returns the output that restic produced when it failed, falling
+// back to the error message itself if the error did not come from restic.
+func Output(err error) string {
+	if err == nil {
+		return ""
+	}
+	if rerr := resticcli.AsError(err); rerr != nil && rerr.Output != "" {
+		return rerr.Output
+	}
+	return err.Error()
+}
+
+// tryUnlockStaleLock removes the locks from a repository if the error indicates
+// that the lock blocking the command is older than the configured threshold.
+func tryUnlockStaleLock(client *resticcli.Client, err error) bool {
+	createdAt := resticcli.AsError(err).LockCreatedAt()
+	if createdAt == nil {
+		return false
+	}
+	if time.Since(*createdAt) < Seconds(config.Get().Restic.Stale.Lock) {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), Seconds(config.Get().Restic.Timeouts.Unlock))
+	defer cancel()
+	return client.Unlock(ctx) == nil
+}
+
+// RetryAfterStaleUnlock calls run and, if it fails because the repository is
+// held by a stale lock, removes the lock and calls it a second time.
+func RetryAfterStaleUnlock(client *resticcli.Client, run func() error) error {
+	err := run()
+	if err != nil && resticcli.IsLocked(err) && tryUnlockStaleLock(client, err) {
+		return run()
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+package resticsvc
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/pterodactyl/wings/config"
+)
+
+// Binary returns the restic executable configured for this instance.
+func Binary() string {
+	if b := config.Get().Restic.Binary; b != "" {
+		return b
+	}
+	return "restic"
+}
+
+// RepoBaseDir returns the directory that all server repositories are stored within
+// when they are kept on the local disk, and that job status files are kept in.
+func RepoBaseDir() string {
+	return filepath.Clean(config.Get().Restic.RepositoryDirectory)
+}
+
+// RepoPath returns the location of a repository directory on the backend that the
+// repositories of its server are stored in.
+func RepoPath(dir string) string {
+	return ServerBackend(serverOfDir(dir)).Location(dir)
+}
+
+// TempDir returns the directory used when preparing snapshots for download.
+func TempDir() string {
+	return filepath.Clean(config.Get().Restic.TempDirectory)
+}
+
+// ArchiveDir returns the directory that repositories of deleted servers are moved to.
+// The archive API is intended for panel-admin tooling (browse/download/delete).
+func ArchiveDir() string {
+	return filepath.Clean(config.Get().Restic.ArchiveDirectory)
+}
+
+// VolumePath returns the data directory for a server, which is the path that
+// is passed to restic when creating and restoring snapshots.
+func VolumePath(serverId string) string {
+	return filepath.Join(config.Get().System.Data, serverId)
+}
+
+// Seconds converts a configured number of seconds into a duration.
+func Seconds(v int) time.Duration {
+	return time.Duration(v) * time.Second
+}
+
+// StaleJobAfter returns the amount of time after which a running job is considered dead.
+func StaleJobAfter() time.Duration {
+	return Seconds(config.Get().Restic.Stale.Job)
+}
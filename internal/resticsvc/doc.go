@@ -0,0 +1,6 @@
+// Package resticsvc runs the restic operations of the servers on the node. It
+// holds the job queue that every operation goes through, the stored passwords
+// and backends of repositories, the backup schedules, and the logic for moving
+// repositories between nodes when a server is transferred. The HTTP handlers in
+// internal/api/restic, the cron scheduler and server transfers all build on it.
+package resticsvc
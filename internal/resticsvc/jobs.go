@@ -0,0 +1,123 @@
+package resticsvc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/database"
+	"github.com/pterodactyl/wings/internal/models"
+)
+
+// interruptedJobs marks the jobs that were left queued or running by a previous
+// run of Wings as failed, the first time the job history is used.
+var interruptedJobs sync.Once
+
+// FailInterruptedJobs marks the jobs left behind by a previous run of Wings as
+// failed, if that has not been done yet.
+func FailInterruptedJobs() {
+	interruptedJobs.Do(func() {
+		now := time.Now().UTC()
+		tx := database.Instance().Model(&models.ResticJob{}).
+			Where("status IN ?", []string{models.ResticJobQueued, models.ResticJobRunning}).
+			Updates(map[string]interface{}{
+				"status":      models.ResticJobFailed,
+				"output":      "Job was interrupted by a restart of Wings.",
+				"finished_at": now,
+			})
+		if tx.Error != nil {
+			log.WithField("error", tx.Error).Warn("restic: failed to mark interrupted jobs as failed")
+		}
+	})
+}
+
+// recordJobQueued adds the job to the job history.
+func recordJobQueued(j *Job) {
+	FailInterruptedJobs()
+	m := models.ResticJob{
+		ID:       j.ID,
+		Type:     string(j.Type),
+		Server:   j.Server,
+		Snapshot: j.Snapshot,
+		Status:   models.ResticJobQueued,
+		IP:       j.IP,
+	}
+	m.SetActor(j.Actor)
+	if tx := database.Instance().Create(&m); tx.Error != nil {
+		log.WithFields(log.Fields{"job_id": j.ID, "error": tx.Error}).Warn("restic: failed to record queued job")
+	}
+}
+
+// recordJobStarted marks the job as running once it has been given a worker.
+func recordJobStarted(j *Job) {
+	now := time.Now().UTC()
+	updateJob(j.ID, map[string]interface{}{"status": models.ResticJobRunning, "started_at": now})
+}
+
+// recordJobFinished stores the result of the job along with the time it took to
+// run, not including the time it spent in the queue.
+func recordJobFinished(j *Job, duration time.Duration, err error) {
+	status := models.ResticJobCompleted
+	output := j.Output
+	switch {
+	case errors.Is(err, ErrJobCancelled):
+		status = models.ResticJobCancelled
+		if output == "" {
+			output = "Job was cancelled."
+		}
+	case err != nil:
+		status = models.ResticJobFailed
+		if output == "" {
+			output = Output(err)
+		}
+	}
+	updateJob(j.ID, map[string]interface{}{
+		"status":      status,
+		"snapshot":    j.Snapshot,
+		"bytes":       j.Bytes,
+		"output":      TruncateCommandOutput(output),
+		"duration":    duration.Round(time.Millisecond).Seconds(),
+		"finished_at": time.Now().UTC(),
+	})
+}
+
+// cleanupCancelledJob marks the status of a cancelled job as cancelled so that it
+// is no longer reported as running. If restic was running it is given the chance
+// to remove its own lock when interrupted, but any lock left behind because it
+// had to be killed is removed from the repository as well.
+func cleanupCancelledJob(j *Job, started bool) {
+	if started && j.Client != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), Seconds(config.Get().Restic.Timeouts.Unlock))
+		if err := j.Client.Unlock(ctx); err != nil {
+			log.WithFields(log.Fields{"job_id": j.ID, "error": err}).Warn("restic: failed to unlock repository after cancelling job")
+		}
+		cancel()
+	}
+
+	const msg = "Cancelled by request."
+	switch j.Type {
+	case JobBackup:
+		SetBackupStatus(j.Server, "cancelled", msg)
+	case JobRestore:
+		SetRestoreStatus(j.Server, "cancelled", msg)
+	case JobPrune:
+		SetPruneStatus(j.Server, "cancelled", msg, TruncateCommandOutput(j.Output))
+	case JobCheck:
+		SetRepoHealthStatus(j.Server, "cancelled", msg, TruncateCommandOutput(j.Output))
+	case JobPrepare:
+		SetDownloadStatus(j.Server, j.Ref, "cancelled", msg)
+	case JobCopy:
+		saveReplicaRun(j.Server, map[string]interface{}{"last_status": "cancelled", "last_message": msg})
+	}
+}
+
+func updateJob(id string, values map[string]interface{}) {
+	tx := database.Instance().Model(&models.ResticJob{}).Where("id = ?", id).Updates(values)
+	if tx.Error != nil {
+		log.WithFields(log.Fields{"job_id": id, "error": tx.Error}).Warn("restic: failed to update job history")
+	}
+}
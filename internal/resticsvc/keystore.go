@@ -0,0 +1,214 @@
+package resticsvc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+	"gorm.io/gorm"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/database"
+	"github.com/pterodactyl/wings/internal/models"
+)
+
+// LegacyKeyFile is where the password of a repository used to be stored, in
+// plain text inside the repository itself. Passwords found there are moved into
+// the key store the first time they are used.
+const LegacyKeyFile = ".restic-key"
+
+// masterKeys caches the master key of the node by the path it was read from.
+var masterKeys struct {
+	mu   sync.Mutex
+	path string
+	key  []byte
+}
+
+// masterKey returns the 256-bit master key of the node, creating it the first
+// time it is needed.
+func masterKey() ([]byte, error) {
+	path := config.Get().Restic.MasterKeyFile
+	if path == "" {
+		return nil, errors.New("restic: no master key file is configured")
+	}
+
+	masterKeys.mu.Lock()
+	defer masterKeys.mu.Unlock()
+	if masterKeys.path == path {
+		return masterKeys.key, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		b = make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, errors.Wrap(err, "restic: failed to generate master key")
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, errors.Wrap(err, "restic: failed to create master key directory")
+		}
+		// O_EXCL makes sure that a key written by someone else in the meantime is
+		// never replaced, since that would make every stored password unreadable.
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return nil, errors.Wrap(err, "restic: failed to create master key")
+		}
+		_, err = f.WriteString(base64.StdEncoding.EncodeToString(b) + "\n")
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "restic: failed to write master key")
+		}
+		log.WithField("path", path).Info("restic: created master key for repository passwords")
+	} else if err != nil {
+		return nil, errors.Wrap(err, "restic: failed to read master key")
+	} else {
+		b, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+		if err != nil || len(b) != 32 {
+			return nil, errors.New("restic: master key is not a base64 encoded 256-bit key")
+		}
+	}
+
+	masterKeys.path = path
+	masterKeys.key = b
+	return b, nil
+}
+
+func masterCipher() (cipher.AEAD, error) {
+	key, err := masterKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealResticKey encrypts a password with the master key using AES-GCM.
+func sealResticKey(key string) (string, error) {
+	aead, err := masterCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(key), nil)), nil
+}
+
+// openResticKey decrypts a password that was encrypted by sealResticKey.
+func openResticKey(ciphertext string) (string, error) {
+	aead, err := masterCipher()
+	if err != nil {
+		return "", err
+	}
+	b, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil || len(b) < aead.NonceSize() {
+		return "", errors.New("restic: stored password is malformed")
+	}
+	key, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("restic: stored password cannot be decrypted with the master key")
+	}
+	return string(key), nil
+}
+
+// LoadKey returns the stored password of the repository, or an empty
+// string if there is not one. A password left in the repository by an older
+// version of Wings is moved into the key store.
+func LoadKey(repo string) (string, error) {
+	repo = NormalizeRepo(repo)
+	var m models.ResticKey
+	err := database.Instance().First(&m, "repository = ?", repo).Error
+	if err == nil {
+		removeLegacyKey(repo)
+		return openResticKey(m.Ciphertext)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", errors.Wrap(err, "restic: failed to read stored password")
+	}
+
+	if IsRemoteRepo(repo) {
+		return "", nil
+	}
+	data, err := os.ReadFile(filepath.Join(repo, LegacyKeyFile))
+	if err != nil {
+		return "", nil
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return "", nil
+	}
+	if err := StoreKey(repo, key); err != nil {
+		log.WithFields(log.Fields{"repo": repo, "error": err}).Warn("restic: failed to move password out of repository")
+	}
+	return key, nil
+}
+
+// StoreKey encrypts the password of the repository and stores it, replacing
+// any password that was stored before.
+func StoreKey(repo string, key string) error {
+	repo = NormalizeRepo(repo)
+	ciphertext, err := sealResticKey(key)
+	if err != nil {
+		return err
+	}
+	var m models.ResticKey
+	if err := database.Instance().First(&m, "repository = ?", repo).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return errors.Wrap(err, "restic: failed to read stored password")
+	}
+	m.Repository = repo
+	m.Ciphertext = ciphertext
+	if err := database.Instance().Save(&m).Error; err != nil {
+		return errors.Wrap(err, "restic: failed to store password")
+	}
+	removeLegacyKey(repo)
+	return nil
+}
+
+// DeleteKey removes the stored password of the repository.
+func DeleteKey(repo string) error {
+	repo = NormalizeRepo(repo)
+	if err := database.Instance().Delete(&models.ResticKey{}, "repository = ?", repo).Error; err != nil {
+		return errors.Wrap(err, "restic: failed to delete stored password")
+	}
+	return nil
+}
+
+// removeLegacyKey removes the plain text password from the repository once it
+// is no longer needed.
+func removeLegacyKey(repo string) {
+	if IsRemoteRepo(repo) {
+		return
+	}
+	for _, name := range []string{LegacyKeyFile, LegacyKeyFile + ".tmp"} {
+		if err := os.Remove(filepath.Join(repo, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.WithFields(log.Fields{"repo": repo, "error": err}).Warn("restic: failed to remove password from repository")
+		}
+	}
+}
+
+// MoveKey moves the stored password of a repository that has been moved,
+// such as when it is archived.
+func MoveKey(from string, to string) error {
+	from, to = NormalizeRepo(from), NormalizeRepo(to)
+	if from == to {
+		return nil
+	}
+	tx := database.Instance().Model(&models.ResticKey{}).Where("repository = ?", from).Update("repository", to)
+	if tx.Error != nil {
+		return errors.Wrap(tx.Error, "restic: failed to move stored password")
+	}
+	return nil
+}
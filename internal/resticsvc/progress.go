@@ -0,0 +1,99 @@
+package resticsvc
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	resticcli "github.com/pterodactyl/wings/internal/restic"
+	"github.com/pterodactyl/wings/server"
+)
+
+// progressInterval is the minimum amount of time between two progress events
+// for the same operation, so that restic does not flood the websocket.
+const progressInterval = time.Second
+
+// resticProgress is the data sent along with the restic progress events that
+// are published on the event bus of a server.
+type resticProgress struct {
+	SnapshotID       string  `json:"snapshot_id,omitempty"`
+	PercentDone      float64 `json:"percent_done"`
+	FilesDone        uint64  `json:"files_done"`
+	TotalFiles       uint64  `json:"total_files"`
+	BytesDone        uint64  `json:"bytes_done"`
+	TotalBytes       uint64  `json:"total_bytes"`
+	SecondsElapsed   uint64  `json:"seconds_elapsed"`
+	SecondsRemaining uint64  `json:"seconds_remaining"`
+}
+
+// progressPublisher publishes the progress of a single restic operation to the
+// event bus of a server.
+type progressPublisher struct {
+	mu    sync.Mutex
+	s     *server.Server
+	event string
+	last  time.Time
+}
+
+func newProgressPublisher(s *server.Server, event string) *progressPublisher {
+	return &progressPublisher{s: s, event: event}
+}
+
+// publish sends the progress to the server's event bus unless an update was
+// already sent within the last interval. Updates reporting that the operation
+// is done are always sent.
+func (p *progressPublisher) publish(v resticProgress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if v.PercentDone < 1 && time.Since(p.last) < progressInterval {
+		return
+	}
+	p.last = time.Now()
+	p.s.Events().Publish(p.event, v)
+}
+
+// BackupProgress returns a callback that publishes the progress updates from
+// restic backup as backup progress events for the server.
+func BackupProgress(s *server.Server) func(resticcli.BackupStatus) {
+	p := newProgressPublisher(s, server.ResticBackupProgressEvent)
+	return func(st resticcli.BackupStatus) {
+		p.publish(resticProgress{
+			PercentDone:      st.PercentDone,
+			FilesDone:        st.FilesDone,
+			TotalFiles:       st.TotalFiles,
+			BytesDone:        st.BytesDone,
+			TotalBytes:       st.TotalBytes,
+			SecondsElapsed:   st.SecondsElapsed,
+			SecondsRemaining: st.SecondsRemaining,
+		})
+	}
+}
+
+// RestoreProgress returns a callback that publishes the progress updates from
+// restic restore as restore progress events for the server. Restic does not
+// report the time remaining for a restore, so it is estimated from the time
+// elapsed so far.
+func RestoreProgress(s *server.Server, snapshot string) func(resticcli.RestoreStatus) {
+	p := newProgressPublisher(s, server.ResticRestoreProgressEvent)
+	return func(st resticcli.RestoreStatus) {
+		p.publish(resticProgress{
+			SnapshotID:       snapshot,
+			PercentDone:      st.PercentDone,
+			FilesDone:        st.FilesRestored,
+			TotalFiles:       st.TotalFiles,
+			BytesDone:        st.BytesRestored,
+			TotalBytes:       st.TotalBytes,
+			SecondsElapsed:   st.SecondsElapsed,
+			SecondsRemaining: estimateRemaining(st.SecondsElapsed, st.PercentDone),
+		})
+	}
+}
+
+// estimateRemaining returns the number of seconds an operation is expected to
+// continue running for based on how far along it is.
+func estimateRemaining(elapsed uint64, percent float64) uint64 {
+	if percent <= 0 || percent >= 1 {
+		return 0
+	}
+	return uint64(math.Round(float64(elapsed) * (1 - percent) / percent))
+}
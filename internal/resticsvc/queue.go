@@ -0,0 +1,402 @@
+package resticsvc
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+
+	"github.com/pterodactyl/wings/config"
+	resticcli "github.com/pterodactyl/wings/internal/restic"
+)
+
+// JobType identifies the pool of workers that a restic operation runs in.
+type JobType string
+
+const (
+	JobBackup  JobType = "backup"
+	JobRestore JobType = "restore"
+	JobPrune   JobType = "prune"
+	JobCheck   JobType = "check"
+	JobPrepare JobType = "prepare"
+	JobCopy    JobType = "copy"
+	JobImport  JobType = "import"
+)
+
+// JobPriority decides which waiting job is given the next free worker. Jobs with
+// a higher priority always run before those with a lower one.
+type JobPriority int
+
+const (
+	PriorityScheduled JobPriority = iota
+	PriorityManual
+)
+
+// ErrJobCancelled is returned when waiting for a job that was cancelled.
+var ErrJobCancelled = errors.New("restic: job was cancelled")
+
+// ErrServerTransferring is returned for a job of a server whose repositories are
+// being transferred to another node.
+var ErrServerTransferring = errors.New("restic: the server is being transferred")
+
+// Job is a restic operation that is waiting for, or holding, a worker.
+type Job struct {
+	ID     string
+	Type   JobType
+	Server string
+	// Ref further identifies the job when a server can have more than one job of
+	// the same type, such as the snapshot that is being prepared.
+	Ref      string
+	Priority JobPriority
+	// Actor and IP identify the user that started the job, if any.
+	Actor string
+	IP    string
+
+	// Snapshot, Bytes and Output are set while the job runs and are stored in
+	// the job history once it has finished.
+	Snapshot string
+	Bytes    uint64
+	Output   string
+
+	// Client is the restic client for the repository that the job operates on,
+	// which is unlocked if the job is cancelled while restic is running.
+	Client *resticcli.Client
+
+	seq       uint64
+	run       func(j *Job) error
+	err       error
+	ctx       context.Context
+	cancel    context.CancelFunc
+	cancelled atomic.Bool
+	done      chan struct{}
+}
+
+// Done returns a channel that is closed once the job has finished running.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Context returns the context that the restic commands of the job should be run
+// with, which is canceled if the job is cancelled.
+func (j *Job) Context() context.Context {
+	return j.ctx
+}
+
+// Cancelled reports whether the job was cancelled, either while it was waiting
+// for a worker or while it was running.
+func (j *Job) Cancelled() bool {
+	return j.cancelled.Load()
+}
+
+// jobPool holds the jobs of a single type.
+type jobPool struct {
+	pending []*Job
+	active  int
+	// running counts the jobs that are running for each server, and served
+	// records when each server was last given a worker.
+	running map[string]int
+	served  map[string]uint64
+}
+
+// JobQueue limits the number of restic processes that run at the same time on
+// the node. Every type of job has its own pool of workers, and each server only
+// runs one job of a type at a time, in the order they were queued. When a worker
+// becomes free it is given to the highest priority job at the front of a server
+// queue, preferring the server that was served the longest time ago, so that a
+// server with many jobs queued cannot hold up every other server.
+type JobQueue struct {
+	mu      sync.Mutex
+	seq     uint64
+	pools   map[JobType]*jobPool
+	active  map[string]*Job
+	workers func(t JobType) int
+	// held are the servers that no jobs are accepted for while their repositories
+	// are being transferred to another node.
+	held map[string]bool
+}
+
+// Jobs is the queue that every restic operation on the node is run through.
+var Jobs = newJobQueue(configuredWorkers)
+
+func newJobQueue(workers func(t JobType) int) *JobQueue {
+	return &JobQueue{pools: make(map[JobType]*jobPool), active: make(map[string]*Job), workers: workers, held: make(map[string]bool)}
+}
+
+// configuredWorkers returns the number of workers for a type of job.
+func configuredWorkers(t JobType) int {
+	w := config.Get().Restic.Workers
+	n := map[JobType]int{
+		JobBackup:  w.Backup,
+		JobRestore: w.Restore,
+		JobPrune:   w.Prune,
+		JobCheck:   w.Check,
+		JobPrepare: w.Prepare,
+		JobCopy:    w.Copy,
+		JobImport:  w.Import,
+	}[t]
+	return max(n, 1)
+}
+
+// Submit queues the job to run fn once a worker is available and returns it. The
+// job has already started if a worker was free. A job for a server that is held
+// fails straight away with ErrServerTransferring without ever running.
+func (q *JobQueue) Submit(j *Job, fn func(j *Job) error) *Job {
+	j.ID = uuid.NewString()
+	j.run = fn
+	j.ctx, j.cancel = context.WithCancel(context.Background())
+	j.done = make(chan struct{})
+	recordJobQueued(j)
+
+	q.mu.Lock()
+	if q.held[j.Server] {
+		q.mu.Unlock()
+		j.cancel()
+		j.err = ErrServerTransferring
+		recordJobFinished(j, 0, j.err)
+		close(j.done)
+		return j
+	}
+	defer q.mu.Unlock()
+	q.seq++
+	j.seq = q.seq
+	p := q.pool(j.Type)
+	p.pending = append(p.pending, j)
+	q.dispatch(j.Type, p)
+	return j
+}
+
+// Run queues the job and waits for it to finish, returning the error from fn, or
+// ErrJobCancelled if the job was cancelled.
+func (q *JobQueue) Run(j *Job, fn func(j *Job) error) error {
+	<-q.Submit(j, fn).Done()
+	if j.cancelled.Load() {
+		return ErrJobCancelled
+	}
+	return j.err
+}
+
+// Cancel stops the job with the given ID and returns it, or returns nil if there
+// is no such job waiting for or holding a worker. A waiting job is removed from
+// the queue without ever running, while a running job has its context canceled
+// so that restic is interrupted. The job is finished once its Done channel has
+// been closed.
+func (q *JobQueue) Cancel(id string) *Job {
+	q.mu.Lock()
+	if j, ok := q.active[id]; ok {
+		q.mu.Unlock()
+		j.cancelled.Store(true)
+		j.cancel()
+		return j
+	}
+	for _, p := range q.pools {
+		for i, j := range p.pending {
+			if j.ID != id {
+				continue
+			}
+			p.pending = append(p.pending[:i], p.pending[i+1:]...)
+			p.release(j.Server)
+			q.mu.Unlock()
+			j.cancelled.Store(true)
+			j.cancel()
+			cleanupCancelledJob(j, false)
+			recordJobFinished(j, 0, ErrJobCancelled)
+			close(j.done)
+			return j
+		}
+	}
+	q.mu.Unlock()
+	return nil
+}
+
+// Position returns the place of the first job for the server and reference
+// that is still waiting for a worker, starting at 1, or 0 if there is no such
+// job. Jobs that are queued later with a higher priority can move the position
+// back, so it is only an estimate.
+func (q *JobQueue) Position(t JobType, server string, ref string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	p := q.pool(t)
+	served := make(map[string]uint64, len(p.served))
+	for k, v := range p.served {
+		served[k] = v
+	}
+	pending := append([]*Job(nil), p.pending...)
+	order := q.seq
+	for i := 1; len(pending) > 0; i++ {
+		idx := next(pending, nil, served)
+		j := pending[idx]
+		if j.Server == server && j.Ref == ref {
+			return i
+		}
+		order++
+		served[j.Server] = order
+		pending = append(pending[:idx], pending[idx+1:]...)
+	}
+	return 0
+}
+
+// hold stops jobs from being accepted for the server until unhold is called. An
+// error is returned if the server already has jobs queued or running, or is
+// already held.
+func (q *JobQueue) hold(server string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.held[server] {
+		return ErrServerTransferring
+	}
+	if q.hasJobs(server) {
+		return errors.New("restic: the server has restic jobs queued or running")
+	}
+	q.held[server] = true
+	return nil
+}
+
+// unhold accepts jobs for the server again.
+func (q *JobQueue) unhold(server string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.held, server)
+}
+
+// Holding reports whether jobs are not being accepted for the server.
+func (q *JobQueue) Holding(server string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.held[server]
+}
+
+// hasJobs reports whether the server has a job of any type that is waiting for,
+// or holding, a worker. The lock must be held by the caller.
+func (q *JobQueue) hasJobs(server string) bool {
+	for _, j := range q.active {
+		if j.Server == server {
+			return true
+		}
+	}
+	for _, p := range q.pools {
+		for _, j := range p.pending {
+			if j.Server == server {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// release removes the bookkeeping for a server once it has no jobs waiting for,
+// or holding, a worker in the pool. The lock must be held by the caller.
+func (p *jobPool) release(server string) {
+	if p.running[server] > 0 {
+		return
+	}
+	for _, j := range p.pending {
+		if j.Server == server {
+			return
+		}
+	}
+	delete(p.running, server)
+	delete(p.served, server)
+}
+
+func (q *JobQueue) pool(t JobType) *jobPool {
+	p, ok := q.pools[t]
+	if !ok {
+		p = &jobPool{running: make(map[string]int), served: make(map[string]uint64)}
+		q.pools[t] = p
+	}
+	return p
+}
+
+// dispatch starts as many pending jobs as there are free workers. The lock must
+// be held by the caller.
+func (q *JobQueue) dispatch(t JobType, p *jobPool) {
+	for p.active < q.workers(t) {
+		idx := next(p.pending, p.running, p.served)
+		if idx < 0 {
+			return
+		}
+		j := p.pending[idx]
+		p.pending = append(p.pending[:idx], p.pending[idx+1:]...)
+		p.active++
+		p.running[j.Server]++
+		q.active[j.ID] = j
+		q.seq++
+		p.served[j.Server] = q.seq
+
+		go func() {
+			defer func() {
+				j.cancel()
+				q.mu.Lock()
+				delete(q.active, j.ID)
+				p.active--
+				p.running[j.Server]--
+				p.release(j.Server)
+				q.dispatch(t, p)
+				q.mu.Unlock()
+				close(j.done)
+			}()
+			recordJobStarted(j)
+			started := time.Now()
+			err := runJob(j)
+			j.err = err
+			if j.cancelled.Load() {
+				cleanupCancelledJob(j, true)
+				err = ErrJobCancelled
+			}
+			recordJobFinished(j, time.Since(started), err)
+		}()
+	}
+}
+
+// runJob runs the job, returning an error if it panics so that the job is
+// recorded as failed and its worker is freed, rather than Wings crashing.
+func runJob(j *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.WithFields(log.Fields{"job_id": j.ID, "type": j.Type, "server": j.Server, "stack": string(debug.Stack())}).Error("restic: job panicked")
+			err = fmt.Errorf("restic: job panicked: %v", r)
+		}
+	}()
+	return j.run(j)
+}
+
+// next returns the index of the pending job that should be given the next free
+// worker, or -1 if every pending job belongs to a server that is already running
+// a job.
+func next(pending []*Job, running map[string]int, served map[string]uint64) int {
+	best := -1
+	seen := make(map[string]bool)
+	for i, j := range pending {
+		// Only the oldest job of each server is eligible so that a server's jobs
+		// always run in the order they were queued.
+		if seen[j.Server] || running[j.Server] > 0 {
+			seen[j.Server] = true
+			continue
+		}
+		seen[j.Server] = true
+		if best < 0 {
+			best = i
+			continue
+		}
+		b := pending[best]
+		switch {
+		case j.Priority != b.Priority:
+			if j.Priority > b.Priority {
+				best = i
+			}
+		case served[j.Server] != served[b.Server]:
+			if served[j.Server] < served[b.Server] {
+				best = i
+			}
+		case j.seq < b.seq:
+			best = i
+		}
+	}
+	return best
+}
@@ -0,0 +1,186 @@
+package resticsvc
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/franela/goblin"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/database"
+)
+
+// TestMain initializes the database that the job history is stored in, and keeps
+// the status files of cancelled jobs out of the source tree.
+func TestMain(m *testing.M) {
+	root, err := os.MkdirTemp("", "wings-resticsvc-")
+	if err != nil {
+		panic(err)
+	}
+	config.Set(&config.Configuration{
+		AuthenticationToken: "abc",
+		System:              config.SystemConfiguration{RootDirectory: root},
+		Restic:              config.ResticConfiguration{RepositoryDirectory: filepath.Join(root, "restic")},
+	})
+	if err := database.Initialize(); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	_ = os.RemoveAll(root)
+	os.Exit(code)
+}
+
+func TestJobQueue(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("JobQueue", func() {
+		var q *JobQueue
+		var mu sync.Mutex
+		var order []string
+		var release chan struct{}
+
+		// block queues a job that records when it starts and then waits until the
+		// release channel is closed.
+		block := func(server string, priority JobPriority, name string) *Job {
+			return q.Submit(&Job{Type: JobBackup, Server: server, Priority: priority}, func(*Job) error {
+				mu.Lock()
+				order = append(order, name)
+				mu.Unlock()
+				<-release
+				return nil
+			})
+		}
+		// wait queues a job that does nothing and waits for it to finish.
+		wait := func(t JobType, server string, priority JobPriority) error {
+			return q.Run(&Job{Type: t, Server: server, Priority: priority}, func(*Job) error { return nil })
+		}
+
+		g.BeforeEach(func() {
+			q = newJobQueue(func(JobType) int { return 1 })
+			order = nil
+			release = make(chan struct{})
+		})
+
+		g.It("limits the number of jobs that run at once", func() {
+			first := block("a", PriorityManual, "first")
+			second := block("b", PriorityManual, "second")
+			g.Assert(q.Position(JobBackup, "a", "")).Equal(0)
+			g.Assert(q.Position(JobBackup, "b", "")).Equal(1)
+
+			close(release)
+			<-first.Done()
+			<-second.Done()
+			g.Assert(order).Equal([]string{"first", "second"})
+		})
+
+		g.It("runs manual jobs before scheduled jobs", func() {
+			first := block("a", PriorityScheduled, "running")
+			block("b", PriorityScheduled, "scheduled")
+			last := block("c", PriorityManual, "manual")
+			g.Assert(q.Position(JobBackup, "c", "")).Equal(1)
+			g.Assert(q.Position(JobBackup, "b", "")).Equal(2)
+
+			close(release)
+			<-first.Done()
+			<-last.Done()
+			wait(JobBackup, "d", PriorityScheduled)
+			g.Assert(order).Equal([]string{"running", "manual", "scheduled"})
+		})
+
+		g.It("runs the jobs of each server in order while sharing workers between servers", func() {
+			first := block("a", PriorityManual, "a1")
+			block("a", PriorityManual, "a2")
+			block("a", PriorityManual, "a3")
+			block("b", PriorityManual, "b1")
+			g.Assert(q.Position(JobBackup, "b", "")).Equal(1)
+
+			close(release)
+			<-first.Done()
+			wait(JobBackup, "z", PriorityScheduled)
+			g.Assert(order).Equal([]string{"a1", "b1", "a2", "a3"})
+		})
+
+		g.It("cancels a job that is waiting for a worker without running it", func() {
+			first := block("a", PriorityManual, "first")
+			second := block("b", PriorityManual, "second")
+			g.Assert(q.Cancel(second.ID) == second).IsTrue()
+			<-second.Done()
+			g.Assert(q.Position(JobBackup, "b", "")).Equal(0)
+
+			close(release)
+			<-first.Done()
+			g.Assert(wait(JobBackup, "c", PriorityManual)).IsNil()
+			g.Assert(order).Equal([]string{"first"})
+		})
+
+		g.It("cancels the context of a running job", func() {
+			started := make(chan struct{})
+			var err error
+			done := make(chan struct{})
+			var j *Job
+			go func() {
+				defer close(done)
+				j = &Job{Type: JobBackup, Server: "a"}
+				err = q.Run(j, func(j *Job) error {
+					close(started)
+					<-j.Context().Done()
+					return j.Context().Err()
+				})
+			}()
+			<-started
+			g.Assert(q.Cancel(j.ID) == j).IsTrue()
+			<-done
+			g.Assert(err).Equal(ErrJobCancelled)
+			g.Assert(q.Cancel(j.ID) == nil).IsTrue()
+		})
+
+		g.It("fails a job that panics and frees its worker", func() {
+			err := q.Run(&Job{Type: JobBackup, Server: "a"}, func(*Job) error {
+				panic("boom")
+			})
+			g.Assert(err).IsNotNil()
+			g.Assert(strings.Contains(err.Error(), "boom")).IsTrue()
+			g.Assert(wait(JobBackup, "b", PriorityManual)).IsNil()
+		})
+
+		g.It("forgets a server once it has no jobs left", func() {
+			first := block("a", PriorityManual, "a1")
+			second := block("b", PriorityManual, "b1")
+			g.Assert(q.Cancel(second.ID) == second).IsTrue()
+			close(release)
+			<-first.Done()
+			g.Assert(wait(JobBackup, "c", PriorityManual)).IsNil()
+
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			p := q.pool(JobBackup)
+			g.Assert(len(p.served)).Equal(0)
+			g.Assert(len(p.running)).Equal(0)
+		})
+
+		g.It("refuses the jobs of a server that is held", func() {
+			first := block("a", PriorityManual, "first")
+			g.Assert(q.hold("a")).IsNotNil()
+			close(release)
+			<-first.Done()
+
+			g.Assert(q.hold("a")).IsNil()
+			g.Assert(q.hold("a")).Equal(ErrServerTransferring)
+			g.Assert(wait(JobPrune, "a", PriorityManual)).Equal(ErrServerTransferring)
+			g.Assert(wait(JobBackup, "b", PriorityManual)).IsNil()
+
+			q.unhold("a")
+			g.Assert(wait(JobPrune, "a", PriorityManual)).IsNil()
+		})
+
+		g.It("keeps the pools of each job type separate", func() {
+			first := block("a", PriorityManual, "backup")
+			g.Assert(wait(JobPrune, "a", PriorityManual)).IsNil()
+			close(release)
+			<-first.Done()
+		})
+	})
+}
@@ -0,0 +1,196 @@
+package resticsvc
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+	"gorm.io/gorm"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/database"
+	"github.com/pterodactyl/wings/internal/models"
+	resticcli "github.com/pterodactyl/wings/internal/restic"
+	"github.com/pterodactyl/wings/server"
+)
+
+// LoadReplica returns the replication settings of the server. A server that does
+// not have settings of its own copies its snapshots to the replication URL of the
+// node, if there is one.
+func LoadReplica(serverId string) (models.ResticReplica, error) {
+	r := models.ResticReplica{Server: serverId, Enabled: true}
+	err := database.Instance().First(&r, "server = ?", serverId).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return r, errors.Wrap(err, "restic: failed to load replication settings")
+	}
+	return r, nil
+}
+
+// ReplicaBase returns the location that the secondary repositories of the server
+// are created within, or an empty string if its snapshots are not copied.
+func ReplicaBase(r models.ResticReplica) string {
+	if !r.Enabled {
+		return ""
+	}
+	if r.Location != "" {
+		return r.Location
+	}
+	return config.Get().Restic.Replication.URL
+}
+
+// ReplicaRepo returns the secondary repository of a primary repository, or an
+// empty string if its snapshots are not copied.
+func ReplicaRepo(r models.ResticReplica, primary string) string {
+	base := ReplicaBase(r)
+	if base == "" {
+		return ""
+	}
+	dir := path.Base(primary)
+	if IsRemoteRepo(base) {
+		return strings.TrimRight(base, "/") + "/" + dir
+	}
+	return filepath.Join(base, dir)
+}
+
+// NewReplicaClient returns a client for a secondary repository.
+func NewReplicaClient(repo string, key string) *resticcli.Client {
+	return resticcli.New(Binary(), repo, key).WithEnv(envList(config.Get().Restic.Replication.Env)...)
+}
+
+// ReplicaKey returns the password of a secondary repository. A secondary
+// repository is created with the password of its primary repository, which is
+// stored for the secondary repository once it has been opened with it, so that
+// copies keep working after the key of the primary repository is changed.
+func ReplicaKey(repo string, primaryKey string) (string, error) {
+	key, err := LoadKey(repo)
+	if err != nil || key != "" {
+		return key, err
+	}
+	return primaryKey, nil
+}
+
+// storeReplicaKey stores the password that a secondary repository was opened
+// with if it does not have one stored yet.
+func storeReplicaKey(repo string, key string) {
+	stored, err := LoadKey(repo)
+	if err == nil && stored == "" {
+		err = StoreKey(repo, key)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"repo": repo, "error": err}).Warn("restic: failed to store password of secondary repository")
+	}
+}
+
+// replicaRetention returns the retention policy of a secondary repository.
+// Locked snapshots are always kept.
+func replicaRetention(r models.ResticReplica) resticcli.Policy {
+	return resticcli.Policy{
+		KeepLast:    r.KeepLast,
+		KeepHourly:  r.KeepHourly,
+		KeepDaily:   r.KeepDaily,
+		KeepWeekly:  r.KeepWeekly,
+		KeepMonthly: r.KeepMonthly,
+		KeepYearly:  r.KeepYearly,
+		KeepWithin:  r.KeepWithin,
+		KeepTags:    []string{resticcli.LockedTag},
+	}
+}
+
+// saveReplicaRun records the result of copying the snapshots of the server,
+// creating its replication settings if it does not have any yet.
+func saveReplicaRun(serverId string, values map[string]interface{}) {
+	db := database.Instance()
+	tx := db.Model(&models.ResticReplica{}).Where("server = ?", serverId).Updates(values)
+	if tx.Error == nil && tx.RowsAffected == 0 {
+		if tx = db.Create(&models.ResticReplica{Server: serverId, Enabled: true}); tx.Error == nil {
+			tx = db.Model(&models.ResticReplica{}).Where("server = ?", serverId).Updates(values)
+		}
+	}
+	if tx.Error != nil {
+		log.WithFields(log.Fields{"server": serverId, "error": tx.Error}).Warn("restic: failed to update replication status")
+	}
+}
+
+// Replicate queues a job that copies the snapshots of the primary repository of
+// the server to its secondary repository, and returns the job, or nil if the
+// server does not have a secondary repository. The job does not wait for a
+// worker, so the backup that it follows can finish straight away.
+func Replicate(s *server.Server, primary *resticcli.Client, key string, priority JobPriority, user string, ip string) *Job {
+	serverId := s.ID()
+	r, err := LoadReplica(serverId)
+	if err != nil {
+		log.WithFields(log.Fields{"server": serverId, "error": err}).Warn("restic: failed to Replicate snapshots")
+		return nil
+	}
+	repo := ReplicaRepo(r, primary.Repository())
+	if repo == "" {
+		return nil
+	}
+	secondaryKey, err := ReplicaKey(repo, key)
+	if err != nil {
+		log.WithFields(log.Fields{"server": serverId, "error": err}).Warn("restic: failed to Replicate snapshots")
+		return nil
+	}
+	secondary := NewReplicaClient(repo, secondaryKey)
+	policy := replicaRetention(r)
+
+	activity := NewActivity(s, user, ip, server.ActivityResticCopy)
+	job := &Job{Type: JobCopy, Server: serverId, Priority: priority, Actor: user, IP: ip, Client: secondary}
+	saveReplicaRun(serverId, map[string]interface{}{"last_status": "queued", "last_message": ""})
+	return Jobs.Submit(job, func(j *Job) error {
+		saveReplicaRun(serverId, map[string]interface{}{"last_copy_at": time.Now().UTC(), "last_status": "running", "last_job_id": j.ID})
+		out, err := copySnapshots(j.Context(), primary, secondary, secondaryKey, policy)
+		j.Output = string(out)
+		meta := models.ActivityMeta{}
+		if !policy.Empty() {
+			meta["policy"] = strings.Join(policy.Args(), " ")
+		}
+		activity.Save(err, meta)
+		if err != nil {
+			saveReplicaRun(serverId, map[string]interface{}{"last_status": "failed", "last_message": TruncateStatusMessage(Output(err))})
+			return err
+		}
+		saveReplicaRun(serverId, map[string]interface{}{"last_status": "completed", "last_message": ""})
+		return nil
+	})
+}
+
+// copySnapshots creates the secondary repository if it does not exist yet, copies
+// every snapshot that it is missing from the primary repository, and then applies
+// its retention policy. The key that the secondary repository was opened with is
+// stored for it. The output of restic is returned.
+func copySnapshots(ctx context.Context, primary *resticcli.Client, secondary *resticcli.Client, key string, policy resticcli.Policy) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, Seconds(config.Get().Restic.Timeouts.Copy))
+	defer cancel()
+
+	repo := secondary.Repository()
+	ok, err := secondary.Initialized(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if err := PrepareRepo(repo); err != nil {
+			return nil, errors.Wrap(err, "failed to create secondary repo dir")
+		}
+		if err := secondary.InitFrom(ctx, primary); err != nil {
+			return nil, err
+		}
+	}
+	storeReplicaKey(repo, key)
+
+	var out []byte
+	err = RetryAfterStaleUnlock(secondary, func() error {
+		var err error
+		out, err = secondary.Copy(ctx, primary)
+		return err
+	})
+	if err != nil || policy.Empty() {
+		return out, err
+	}
+	forgot, err := secondary.ApplyPolicy(ctx, policy)
+	return append(out, forgot...), err
+}
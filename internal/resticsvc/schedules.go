@@ -0,0 +1,240 @@
+package resticsvc
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+	"github.com/go-co-op/gocron"
+	"github.com/robfig/cron/v3"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/database"
+	"github.com/pterodactyl/wings/internal/models"
+	resticcli "github.com/pterodactyl/wings/internal/restic"
+	"github.com/pterodactyl/wings/server"
+)
+
+// scheduleRunner runs the backup schedules of servers using the cron scheduler of
+// Wings, so that scheduled backups do not depend on the Panel being available.
+type scheduleRunner struct {
+	mu        sync.Mutex
+	scheduler *gocron.Scheduler
+	manager   *server.Manager
+}
+
+var schedules scheduleRunner
+
+// StartSchedules adds the backup schedule of every server with an enabled policy
+// to the scheduler. Policies that are saved afterwards are added to, or removed
+// from, the same scheduler.
+func StartSchedules(s *gocron.Scheduler, m *server.Manager) error {
+	schedules.mu.Lock()
+	schedules.scheduler = s
+	schedules.manager = m
+	schedules.mu.Unlock()
+
+	var policies []models.ResticPolicy
+	if err := database.Instance().Where("enabled = ?", true).Find(&policies).Error; err != nil {
+		return errors.Wrap(err, "restic: failed to load backup policies")
+	}
+	for _, p := range policies {
+		if err := schedules.update(p); err != nil {
+			log.WithFields(log.Fields{"server": p.Server, "cron": p.Cron, "error": err}).Warn("restic: failed to schedule backups for server")
+		}
+	}
+	return nil
+}
+
+// UpdateSchedule schedules the backups of the server for a policy that has been
+// saved, or unschedules them if the policy is disabled.
+func UpdateSchedule(p models.ResticPolicy) error {
+	return schedules.update(p)
+}
+
+// update replaces the scheduled job of the server with one for the policy, or
+// removes it if the policy is disabled.
+func (r *scheduleRunner) update(p models.ResticPolicy) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.scheduler == nil {
+		return nil
+	}
+	tag := "restic:" + p.Server
+	_ = r.scheduler.RemoveByTag(tag)
+	if !p.Enabled {
+		return nil
+	}
+	serverId := p.Server
+	_, err := r.scheduler.Cron(p.Cron).Tag("restic", tag).SingletonMode().Do(func() {
+		r.run(serverId)
+	})
+	return err
+}
+
+// server returns the server with the given UUID, or nil if it does not exist on
+// this node.
+func (r *scheduleRunner) server(uuid string) *server.Server {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.manager == nil {
+		return nil
+	}
+	s, _ := r.manager.Get(uuid)
+	return s
+}
+
+// run creates a scheduled backup of the server, applies its retention policy,
+// and records the result against the policy.
+func (r *scheduleRunner) run(serverId string) {
+	var p models.ResticPolicy
+	if err := database.Instance().First(&p, "server = ?", serverId).Error; err != nil || !p.Enabled {
+		return
+	}
+	l := log.WithFields(log.Fields{"subsystem": "cron", "cron": "restic", "server": serverId})
+
+	now := time.Now().UTC()
+	savePolicyRun(serverId, map[string]interface{}{"last_run_at": now, "last_status": "running", "last_message": "", "last_job_id": ""})
+
+	status, message := "completed", ""
+	jobId, err := runScheduledBackup(r.server(serverId), p)
+	if err != nil {
+		status, message = "failed", TruncateStatusMessage(err.Error())
+		if errors.Is(err, ErrJobCancelled) {
+			status, message = "cancelled", ""
+		}
+		l.WithField("error", err).Warn("scheduled restic backup failed")
+	}
+	savePolicyRun(serverId, map[string]interface{}{"last_status": status, "last_message": message, "last_job_id": jobId})
+}
+
+func savePolicyRun(serverId string, values map[string]interface{}) {
+	tx := database.Instance().Model(&models.ResticPolicy{}).Where("server = ?", serverId).Updates(values)
+	if tx.Error != nil {
+		log.WithFields(log.Fields{"server": serverId, "error": tx.Error}).Warn("restic: failed to update backup policy")
+	}
+}
+
+// runScheduledBackup creates a snapshot of the server using the key stored in its
+// repository and then applies the retention policy, returning the ID of the
+// backup job. Both run through the job queue at the scheduled priority.
+func runScheduledBackup(s *server.Server, p models.ResticPolicy) (string, error) {
+	if s == nil {
+		return "", errors.New("server does not exist on this node")
+	}
+	// The repositories may be on their way to another node.
+	if s.IsTransferring() {
+		return "", errors.New("server is being transferred")
+	}
+	serverId := s.ID()
+	repo := RepoPath(ResolveRepoDir(serverId, p.OwnerUsername))
+	key, err := ResolveKey(repo, "")
+	if err != nil {
+		return "", errors.New("no encryption key is stored for the repository")
+	}
+	client := NewClient(repo, key)
+	if err := InitRepoIfMissing(client); err != nil {
+		return "", errors.New("failed to initialize repository: " + Output(err))
+	}
+
+	volumePath := VolumePath(serverId)
+	opts := resticcli.BackupOptions{
+		Paths:    []string{volumePath},
+		Excludes: BackupExcludes(s, volumePath),
+		OnStatus: BackupProgress(s),
+	}
+	activity := NewActivity(s, "", "", server.ActivityResticBackup)
+	job := &Job{Type: JobBackup, Server: serverId, Priority: PriorityScheduled, Client: client}
+	SetBackupStatus(serverId, "running", "")
+	err = Jobs.Run(job, func(j *Job) error {
+		SetBackupStatus(serverId, "running", "")
+		summary, err := RunBackupWithRecovery(j.Context(), client, opts, key, serverId)
+		meta := models.ActivityMeta{"scheduled": true}
+		if summary != nil {
+			j.Snapshot = summary.SnapshotID
+			j.Bytes = summary.TotalBytesProcessed
+			meta["snapshot_id"] = summary.SnapshotID
+			meta["bytes"] = summary.TotalBytesProcessed
+		}
+		activity.Save(err, meta)
+		return err
+	})
+	if err != nil {
+		return job.ID, err
+	}
+	// Snapshots are copied once the retention policy has been applied, so that
+	// the copy does not have to wait for the lock that pruning holds.
+	defer Replicate(s, client, key, PriorityScheduled, "", "")
+
+	policy := retentionPolicy(p)
+	if policy.Empty() {
+		return job.ID, nil
+	}
+	activity = NewActivity(s, "", "", server.ActivityResticPrune)
+	SetPruneStatus(serverId, "running", "", "")
+	err = Jobs.Run(&Job{Type: JobPrune, Server: serverId, Priority: PriorityScheduled, Client: client}, func(j *Job) error {
+		SetPruneStatus(serverId, "running", "", "")
+		var out []byte
+		err := RetryAfterStaleUnlock(client, func() error {
+			ctx, cancel := context.WithTimeout(j.Context(), Seconds(config.Get().Restic.Timeouts.Prune))
+			defer cancel()
+			var err error
+			out, err = client.ApplyPolicy(ctx, policy)
+			return err
+		})
+		activity.Save(err, models.ActivityMeta{"policy": strings.Join(policy.Args(), " "), "scheduled": true})
+		j.Output = string(out)
+		if err != nil {
+			SetPruneStatus(serverId, "failed", TruncateStatusMessage(err.Error()), TruncateCommandOutput(j.Output))
+			return err
+		}
+		SetPruneStatus(serverId, "completed", "", TruncateCommandOutput(j.Output))
+		return nil
+	})
+	if err != nil {
+		return job.ID, errors.Wrap(err, "backup completed but the retention policy could not be applied")
+	}
+	return job.ID, nil
+}
+
+// retentionPolicy returns the restic retention policy for a backup policy. Locked
+// snapshots are always kept.
+func retentionPolicy(p models.ResticPolicy) resticcli.Policy {
+	return resticcli.Policy{
+		KeepLast:    p.KeepLast,
+		KeepHourly:  p.KeepHourly,
+		KeepDaily:   p.KeepDaily,
+		KeepWeekly:  p.KeepWeekly,
+		KeepMonthly: p.KeepMonthly,
+		KeepYearly:  p.KeepYearly,
+		KeepWithin:  p.KeepWithin,
+		KeepTags:    []string{resticcli.LockedTag},
+	}
+}
+
+// ParseSchedule parses a cron expression the same way as the scheduler, in the
+// timezone of the node unless the expression sets its own.
+func ParseSchedule(expr string) (cron.Schedule, error) {
+	if !strings.HasPrefix(expr, "TZ=") && !strings.HasPrefix(expr, "CRON_TZ=") {
+		location, err := time.LoadLocation(config.Get().System.Timezone)
+		if err != nil {
+			location = time.Local
+		}
+		expr = "CRON_TZ=" + location.String() + " " + expr
+	}
+	return cron.ParseStandard(expr)
+}
+
+// RemoveServerPolicy unschedules the backups of a server and removes its policy,
+// such as when the server is deleted from the node.
+func RemoveServerPolicy(serverId string) {
+	if err := schedules.update(models.ResticPolicy{Server: serverId}); err != nil {
+		log.WithFields(log.Fields{"server": serverId, "error": err}).Warn("restic: failed to unschedule backups for server")
+	}
+	if err := database.Instance().Delete(&models.ResticPolicy{}, "server = ?", serverId).Error; err != nil {
+		log.WithFields(log.Fields{"server": serverId, "error": err}).Warn("restic: failed to remove backup policy")
+	}
+}
@@ -0,0 +1,449 @@
+package resticsvc
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type BackupStatus struct {
+	Status     string `json:"status"`
+	StartedAt  string `json:"started_at,omitempty"`
+	FinishedAt string `json:"finished_at,omitempty"`
+	Message    string `json:"message,omitempty"`
+	// QueuePosition is set while the backup is waiting for a worker.
+	QueuePosition int `json:"queue_position,omitempty"`
+}
+
+func statusDir() string {
+	return filepath.Join(RepoBaseDir(), ".status")
+}
+
+func statusPath(serverId string) string {
+	return filepath.Join(statusDir(), serverId+".json")
+}
+
+func ReadBackupStatus(serverId string) (BackupStatus, error) {
+	var status BackupStatus
+	data, err := os.ReadFile(statusPath(serverId))
+	if err != nil {
+		return status, err
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return BackupStatus{}, err
+	}
+	return status, nil
+}
+
+func WriteBackupStatus(serverId string, status BackupStatus) {
+	if serverId == "" {
+		return
+	}
+	_ = os.MkdirAll(statusDir(), 0755)
+	data, err := json.Marshal(status)
+	if err != nil {
+		return
+	}
+	tmp := statusPath(serverId) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err == nil {
+		_ = os.Rename(tmp, statusPath(serverId))
+	}
+	cleanupStatusDir(statusDir(), 7*24*time.Hour)
+}
+
+func cleanupStatusDir(dir string, maxAge time.Duration) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if now.Sub(info.ModTime()) > maxAge {
+			_ = os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+}
+
+func SetBackupStatus(serverId string, status string, message string) {
+	if serverId == "" {
+		return
+	}
+	current, _ := ReadBackupStatus(serverId)
+	next := BackupStatus{Status: status}
+
+	if status == "running" {
+		// Always reset start time for a new run
+		next.StartedAt = time.Now().Format(time.RFC3339)
+		next.FinishedAt = ""
+		next.Message = ""
+	} else {
+		if current.StartedAt != "" {
+			next.StartedAt = current.StartedAt
+		}
+		if status == "completed" || status == "failed" || status == "cancelled" {
+			next.FinishedAt = time.Now().Format(time.RFC3339)
+		}
+		if message != "" {
+			next.Message = message
+		} else if current.Message != "" {
+			next.Message = current.Message
+		}
+	}
+	WriteBackupStatus(serverId, next)
+}
+
+func TruncateStatusMessage(msg string) string {
+	const max = 2000
+	trimmed := strings.TrimSpace(msg)
+	if len(trimmed) <= max {
+		return trimmed
+	}
+	return trimmed[:max]
+}
+
+func TruncateCommandOutput(msg string) string {
+	// Keep outputs readable in the panel/admin UI but prevent huge blobs from being returned.
+	const max = 20000
+	trimmed := strings.TrimSpace(msg)
+	if len(trimmed) <= max {
+		return trimmed
+	}
+	return trimmed[:max]
+}
+
+type PruneStatus struct {
+	Status     string `json:"status"`
+	StartedAt  string `json:"started_at,omitempty"`
+	FinishedAt string `json:"finished_at,omitempty"`
+	Message    string `json:"message,omitempty"`
+	Output     string `json:"output,omitempty"`
+	// QueuePosition is set while the prune is waiting for a worker.
+	QueuePosition int `json:"queue_position,omitempty"`
+}
+
+func pruneStatusDir() string {
+	return filepath.Join(RepoBaseDir(), ".prune-status")
+}
+
+func pruneStatusPath(serverId string) string {
+	return filepath.Join(pruneStatusDir(), serverId+".json")
+}
+
+func ReadPruneStatus(serverId string) (PruneStatus, error) {
+	var status PruneStatus
+	data, err := os.ReadFile(pruneStatusPath(serverId))
+	if err != nil {
+		return status, err
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return PruneStatus{}, err
+	}
+	return status, nil
+}
+
+func WritePruneStatus(serverId string, status PruneStatus) {
+	if serverId == "" {
+		return
+	}
+	_ = os.MkdirAll(pruneStatusDir(), 0755)
+	data, err := json.Marshal(status)
+	if err != nil {
+		return
+	}
+	tmp := pruneStatusPath(serverId) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err == nil {
+		_ = os.Rename(tmp, pruneStatusPath(serverId))
+	}
+	cleanupStatusDir(pruneStatusDir(), 7*24*time.Hour)
+}
+
+func SetPruneStatus(serverId string, status string, message string, output string) {
+	if serverId == "" {
+		return
+	}
+	current, _ := ReadPruneStatus(serverId)
+	next := PruneStatus{Status: status}
+
+	if status == "running" {
+		next.StartedAt = time.Now().Format(time.RFC3339)
+		next.FinishedAt = ""
+		next.Message = ""
+		next.Output = ""
+	} else {
+		if current.StartedAt != "" {
+			next.StartedAt = current.StartedAt
+		}
+		if status == "completed" || status == "failed" || status == "cancelled" {
+			next.FinishedAt = time.Now().Format(time.RFC3339)
+		}
+		if message != "" {
+			next.Message = TruncateStatusMessage(message)
+		} else if current.Message != "" {
+			next.Message = current.Message
+		}
+		if output != "" {
+			next.Output = TruncateCommandOutput(output)
+		} else if current.Output != "" {
+			next.Output = current.Output
+		}
+	}
+	WritePruneStatus(serverId, next)
+}
+
+type RepoHealthStatus struct {
+	Status     string `json:"status"`
+	StartedAt  string `json:"started_at,omitempty"`
+	FinishedAt string `json:"finished_at,omitempty"`
+	Message    string `json:"message,omitempty"`
+	Output     string `json:"output,omitempty"`
+	// QueuePosition is set while the health check is waiting for a worker.
+	QueuePosition int `json:"queue_position,omitempty"`
+}
+
+func repoHealthStatusDir() string {
+	return filepath.Join(RepoBaseDir(), ".repo-health-status")
+}
+
+func repoHealthStatusPath(serverId string) string {
+	return filepath.Join(repoHealthStatusDir(), serverId+".json")
+}
+
+func ReadRepoHealthStatus(serverId string) (RepoHealthStatus, error) {
+	var status RepoHealthStatus
+	data, err := os.ReadFile(repoHealthStatusPath(serverId))
+	if err != nil {
+		return status, err
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return RepoHealthStatus{}, err
+	}
+	return status, nil
+}
+
+func WriteRepoHealthStatus(serverId string, status RepoHealthStatus) {
+	if serverId == "" {
+		return
+	}
+	_ = os.MkdirAll(repoHealthStatusDir(), 0755)
+	data, err := json.Marshal(status)
+	if err != nil {
+		return
+	}
+	tmp := repoHealthStatusPath(serverId) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err == nil {
+		_ = os.Rename(tmp, repoHealthStatusPath(serverId))
+	}
+	cleanupStatusDir(repoHealthStatusDir(), 7*24*time.Hour)
+}
+
+func SetRepoHealthStatus(serverId string, status string, message string, output string) {
+	if serverId == "" {
+		return
+	}
+	current, _ := ReadRepoHealthStatus(serverId)
+	next := RepoHealthStatus{Status: status}
+
+	if status == "running" {
+		next.StartedAt = time.Now().Format(time.RFC3339)
+		next.FinishedAt = ""
+		next.Message = ""
+		next.Output = ""
+	} else {
+		if current.StartedAt != "" {
+			next.StartedAt = current.StartedAt
+		}
+		if status == "completed" || status == "failed" || status == "cancelled" {
+			next.FinishedAt = time.Now().Format(time.RFC3339)
+		}
+		if message != "" {
+			next.Message = TruncateStatusMessage(message)
+		} else if current.Message != "" {
+			next.Message = current.Message
+		}
+		if output != "" {
+			next.Output = TruncateCommandOutput(output)
+		} else if current.Output != "" {
+			next.Output = current.Output
+		}
+	}
+	WriteRepoHealthStatus(serverId, next)
+}
+
+type RestoreStatus struct {
+	Status               string `json:"status"`
+	StartedAt            string `json:"started_at,omitempty"`
+	FinishedAt           string `json:"finished_at,omitempty"`
+	Message              string `json:"message,omitempty"`
+	PreRestoreSnapshotID string `json:"pre_restore_snapshot_id,omitempty"`
+	// QueuePosition is set while the restore is waiting for a worker.
+	QueuePosition int `json:"queue_position,omitempty"`
+}
+
+func restoreStatusDir() string {
+	return filepath.Join(RepoBaseDir(), ".restore-status")
+}
+
+func restoreStatusPath(serverId string) string {
+	return filepath.Join(restoreStatusDir(), serverId+".json")
+}
+
+func ReadRestoreStatus(serverId string) (RestoreStatus, error) {
+	var status RestoreStatus
+	data, err := os.ReadFile(restoreStatusPath(serverId))
+	if err != nil {
+		return status, err
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return RestoreStatus{}, err
+	}
+	return status, nil
+}
+
+func WriteRestoreStatus(serverId string, status RestoreStatus) {
+	if serverId == "" {
+		return
+	}
+	_ = os.MkdirAll(restoreStatusDir(), 0755)
+	data, err := json.Marshal(status)
+	if err != nil {
+		return
+	}
+	tmp := restoreStatusPath(serverId) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err == nil {
+		_ = os.Rename(tmp, restoreStatusPath(serverId))
+	}
+	// Cleanup: keep files for 7 days (same as backup status).
+	cleanupStatusDir(restoreStatusDir(), 7*24*time.Hour)
+}
+
+func SetRestoreStatus(serverId string, status string, message string) {
+	if serverId == "" {
+		return
+	}
+	current, _ := ReadRestoreStatus(serverId)
+	next := RestoreStatus{Status: status}
+
+	if status == "running" {
+		next.StartedAt = time.Now().Format(time.RFC3339)
+		next.FinishedAt = ""
+		next.Message = ""
+	} else {
+		if current.StartedAt != "" {
+			next.StartedAt = current.StartedAt
+		}
+		next.PreRestoreSnapshotID = current.PreRestoreSnapshotID
+		if status == "completed" || status == "failed" || status == "cancelled" {
+			next.FinishedAt = time.Now().Format(time.RFC3339)
+		}
+		if message != "" {
+			// Clamp message size.
+			if len(message) > 2000 {
+				message = message[:2000]
+			}
+			next.Message = message
+		} else if current.Message != "" {
+			next.Message = current.Message
+		}
+	}
+	WriteRestoreStatus(serverId, next)
+}
+
+func SetRestorePreRestoreSnapshot(serverId string, snapshotId string) {
+	if serverId == "" {
+		return
+	}
+	current, _ := ReadRestoreStatus(serverId)
+	current.PreRestoreSnapshotID = snapshotId
+	WriteRestoreStatus(serverId, current)
+}
+
+type DownloadStatus struct {
+	Status     string `json:"status"`
+	StartedAt  string `json:"started_at,omitempty"`
+	FinishedAt string `json:"finished_at,omitempty"`
+	Message    string `json:"message,omitempty"`
+	// QueuePosition is set while the snapshot is waiting for a worker.
+	QueuePosition int `json:"queue_position,omitempty"`
+}
+
+func downloadStatusDir() string {
+	return filepath.Join(RepoBaseDir(), ".download-status")
+}
+
+func downloadStatusPath(serverId string, backupId string) string {
+	return filepath.Join(downloadStatusDir(), serverId+"-"+backupId+".json")
+}
+
+func ReadDownloadStatus(serverId string, backupId string) (DownloadStatus, error) {
+	var status DownloadStatus
+	data, err := os.ReadFile(downloadStatusPath(serverId, backupId))
+	if err != nil {
+		return status, err
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return DownloadStatus{}, err
+	}
+	return status, nil
+}
+
+func writeDownloadStatus(serverId string, backupId string, status DownloadStatus) {
+	if serverId == "" || backupId == "" {
+		return
+	}
+	_ = os.MkdirAll(downloadStatusDir(), 0755)
+	data, err := json.Marshal(status)
+	if err != nil {
+		return
+	}
+	tmp := downloadStatusPath(serverId, backupId) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err == nil {
+		_ = os.Rename(tmp, downloadStatusPath(serverId, backupId))
+	}
+	cleanupDownloadStatusDir(downloadStatusDir(), 7*24*time.Hour)
+}
+
+func cleanupDownloadStatusDir(dir string, maxAge time.Duration) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if now.Sub(info.ModTime()) > maxAge {
+			_ = os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+}
+
+func SetDownloadStatus(serverId string, backupId string, status string, message string) {
+	if serverId == "" || backupId == "" {
+		return
+	}
+	current, _ := ReadDownloadStatus(serverId, backupId)
+	next := DownloadStatus{
+		Status: status,
+	}
+	if current.StartedAt != "" {
+		next.StartedAt = current.StartedAt
+	}
+	if status == "running" && next.StartedAt == "" {
+		next.StartedAt = time.Now().Format(time.RFC3339)
+	}
+	if status == "ready" || status == "failed" || status == "cancelled" {
+		next.FinishedAt = time.Now().Format(time.RFC3339)
+	}
+	if message != "" {
+		next.Message = message
+	}
+	writeDownloadStatus(serverId, backupId, next)
+}
@@ -0,0 +1,394 @@
+package resticsvc
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+	"gorm.io/gorm"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/database"
+	"github.com/pterodactyl/wings/internal/models"
+)
+
+// TransferManifest describes the restic repositories of a server that is being
+// transferred to another node. It is sent to the target node ahead of the
+// repositories themselves, which are streamed as a single tar archive.
+type TransferManifest struct {
+	Repositories []TransferRepository `json:"repositories"`
+	// Policy and Replica are the backup policy and replication settings of the
+	// server, if it has them.
+	Policy  *models.ResticPolicy  `json:"policy,omitempty"`
+	Replica *models.ResticReplica `json:"replica,omitempty"`
+}
+
+// TransferRepository is a repository of a server that is being transferred.
+type TransferRepository struct {
+	// Dir is the directory name of the repository, such as "<server>+<owner>".
+	Dir string `json:"dir"`
+	// Location is set for repositories that are stored on a remote backend. Their
+	// data stays where it is, and only their key is sent.
+	Location string `json:"location,omitempty"`
+	// Key is the password of the repository, if one is stored.
+	Key string `json:"key,omitempty"`
+}
+
+// NewTransferManifest returns the manifest for the repositories of the server.
+// The repositories must not change while they are sent, so an error is returned
+// if the server has restic jobs queued or running, and no jobs are accepted for
+// the server until ReleaseTransfer is called once the transfer has finished.
+func NewTransferManifest(serverId string) (*TransferManifest, error) {
+	if err := Jobs.hold(serverId); err != nil {
+		return nil, err
+	}
+	m, err := newTransferManifest(serverId)
+	if err != nil {
+		Jobs.unhold(serverId)
+		return nil, err
+	}
+	return m, nil
+}
+
+func newTransferManifest(serverId string) (*TransferManifest, error) {
+	m := &TransferManifest{Repositories: []TransferRepository{}}
+	b := ServerBackend(serverId)
+	for _, dir := range b.Dirs(serverId) {
+		repo := b.Location(dir)
+		key, err := LoadKey(repo)
+		if err != nil {
+			return nil, err
+		}
+		r := TransferRepository{Dir: dir, Key: key}
+		if IsRemoteRepo(repo) {
+			r.Location = repo
+		}
+		m.Repositories = append(m.Repositories, r)
+	}
+
+	var p models.ResticPolicy
+	if err := database.Instance().First(&p, "server = ?", serverId).Error; err == nil {
+		m.Policy = &p
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Wrap(err, "restic: failed to load backup policy")
+	}
+	var r models.ResticReplica
+	if err := database.Instance().First(&r, "server = ?", serverId).Error; err == nil {
+		m.Replica = &r
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Wrap(err, "restic: failed to load replication settings")
+	}
+	return m, nil
+}
+
+// ReleaseTransfer accepts restic jobs for the server again once the transfer
+// that NewTransferManifest was called for has finished, whether or not it
+// succeeded.
+func ReleaseTransfer(serverId string) {
+	Jobs.unhold(serverId)
+}
+
+// Empty reports whether the server does not have anything to transfer.
+func (m *TransferManifest) Empty() bool {
+	return len(m.Repositories) == 0 && m.Policy == nil && m.Replica == nil
+}
+
+// Stream writes a gzip compressed tar archive of the repositories that are
+// stored on this node to w. Locks are included, so that the repositories arrive
+// in the same state that they were left in.
+func (m *TransferManifest) Stream(ctx context.Context, w io.Writer) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, r := range m.Repositories {
+		if r.Location != "" {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := WriteTarDir(tw, filepath.Join(RepoBaseDir(), r.Dir), r.Dir); err != nil {
+			return errors.Wrap(err, "restic: failed to archive repository "+r.Dir)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(gw.Close())
+}
+
+// RemoveTransferredRepositories removes the repositories in the manifest from
+// this node, along with their keys and the backup policy of the server, once the
+// target node has verified them.
+func RemoveTransferredRepositories(serverId string, m *TransferManifest) {
+	for _, r := range m.Repositories {
+		repo := r.Location
+		if repo == "" {
+			repo = filepath.Join(RepoBaseDir(), r.Dir)
+			if err := os.RemoveAll(repo); err != nil {
+				log.WithFields(log.Fields{"repo": repo, "error": err}).Warn("restic: failed to remove transferred repository")
+				continue
+			}
+		}
+		if err := DeleteKey(repo); err != nil {
+			log.WithFields(log.Fields{"repo": repo, "error": err}).Warn("restic: failed to remove key of transferred repository")
+		}
+	}
+	RemoveServerPolicy(serverId)
+	if err := database.Instance().Delete(&models.ResticReplica{}, "server = ?", serverId).Error; err != nil {
+		log.WithFields(log.Fields{"server": serverId, "error": err}).Warn("restic: failed to remove replication settings")
+	}
+}
+
+// IncomingRepositories are the restic repositories of a server that is being
+// transferred to this node. They are extracted into a staging directory while
+// they are received, and only moved into place once the transfer is complete.
+type IncomingRepositories struct {
+	serverId string
+	manifest TransferManifest
+	staging  string
+
+	// installed and keys are the repositories and keys that have been added to
+	// this node, which are removed again if the transfer fails.
+	installed []string
+	keys      []string
+	policy    bool
+}
+
+// NewIncomingRepositories validates the manifest that was sent by the source node
+// of a transfer.
+func NewIncomingRepositories(serverId string, manifest []byte) (*IncomingRepositories, error) {
+	in := &IncomingRepositories{
+		serverId: serverId,
+		staging:  filepath.Join(RepoBaseDir(), ".transfer-"+serverId),
+	}
+	if err := json.Unmarshal(manifest, &in.manifest); err != nil {
+		return nil, errors.Wrap(err, "restic: failed to parse transfer manifest")
+	}
+	for _, r := range in.manifest.Repositories {
+		if strings.ContainsAny(r.Dir, `/\`) || !dirBelongsTo(r.Dir, serverId) {
+			return nil, errors.New("restic: transfer manifest contains an invalid repository: " + r.Dir)
+		}
+		if r.Location != "" && !IsRemoteRepo(r.Location) {
+			return nil, errors.New("restic: transfer manifest contains an invalid location: " + r.Location)
+		}
+	}
+	return in, nil
+}
+
+// Receive extracts the archive of the repositories into the staging directory.
+// Only directories and regular files within the repositories in the manifest
+// are extracted.
+func (in *IncomingRepositories) Receive(ctx context.Context, r io.Reader) error {
+	local := make(map[string]bool)
+	for _, repo := range in.manifest.Repositories {
+		if repo.Location == "" {
+			local[repo.Dir] = true
+		}
+	}
+	if err := os.RemoveAll(in.staging); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.MkdirAll(in.staging, 0o700); err != nil {
+		return errors.Wrap(err, "restic: failed to create transfer directory")
+	}
+
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return errors.Wrap(err, "restic: failed to read repository archive")
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "restic: failed to read repository archive")
+		}
+		name := path.Clean(strings.TrimSuffix(hdr.Name, "/"))
+		dir, _, _ := strings.Cut(name, "/")
+		if path.IsAbs(name) || !local[dir] || strings.Contains("/"+name+"/", "/../") {
+			return errors.New("restic: repository archive contains an unexpected path: " + hdr.Name)
+		}
+		target := filepath.Join(in.staging, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return errors.WithStack(err)
+			}
+		case tar.TypeReg:
+			if err := ExtractFile(target, hdr.FileInfo().Mode().Perm(), tr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ExtractFile writes the contents of r to a new file at target, creating its
+// parent directories.
+func ExtractFile(target string, mode os.FileMode, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return errors.WithStack(err)
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return errors.WithStack(err)
+}
+
+// Install moves the received repositories into place, stores their keys and
+// verifies each of them with "restic check", and then saves the backup policy of
+// the server. If anything cannot be installed or verified, everything that was
+// installed is removed again and an error is returned. The number of
+// repositories is returned.
+func (in *IncomingRepositories) Install(ctx context.Context) (int, error) {
+	defer os.RemoveAll(in.staging)
+	for _, r := range in.manifest.Repositories {
+		if err := in.install(ctx, r); err != nil {
+			in.Discard()
+			return 0, err
+		}
+	}
+
+	if p := in.manifest.Policy; p != nil {
+		p.Server = in.serverId
+		if err := database.Instance().Save(p).Error; err != nil {
+			in.Discard()
+			return 0, errors.Wrap(err, "restic: failed to save backup policy")
+		}
+		in.policy = true
+		if err := schedules.update(*p); err != nil {
+			log.WithFields(log.Fields{"server": in.serverId, "error": err}).Warn("restic: failed to schedule backups for transferred server")
+		}
+	}
+	if r := in.manifest.Replica; r != nil {
+		r.Server = in.serverId
+		if err := database.Instance().Save(r).Error; err != nil {
+			log.WithFields(log.Fields{"server": in.serverId, "error": err}).Warn("restic: failed to save replication settings of transferred server")
+		}
+	}
+	return len(in.manifest.Repositories), nil
+}
+
+func (in *IncomingRepositories) install(ctx context.Context, r TransferRepository) error {
+	repo := r.Location
+	if repo == "" {
+		repo = filepath.Join(RepoBaseDir(), r.Dir)
+		if _, err := os.Stat(repo); err == nil {
+			return errors.New("restic: repository " + r.Dir + " already exists on this node")
+		}
+		if err := os.Rename(filepath.Join(in.staging, r.Dir), repo); err != nil {
+			return errors.Wrap(err, "restic: failed to install repository "+r.Dir)
+		}
+		in.installed = append(in.installed, repo)
+	}
+	if r.Key == "" {
+		log.WithField("repo", repo).Warn("restic: transferred repository does not have a stored key and cannot be verified")
+		return nil
+	}
+	if err := StoreKey(repo, r.Key); err != nil {
+		return err
+	}
+	in.keys = append(in.keys, repo)
+
+	client := NewClient(repo, r.Key)
+	err := RetryAfterStaleUnlock(client, func() error {
+		ctx, cancel := context.WithTimeout(ctx, Seconds(config.Get().Restic.Timeouts.Check))
+		defer cancel()
+		_, err := client.Check(ctx, "")
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "restic: transferred repository "+r.Dir+" failed verification: "+Output(err))
+	}
+	return nil
+}
+
+// Discard removes everything that was received, such as when the transfer fails.
+func (in *IncomingRepositories) Discard() {
+	_ = os.RemoveAll(in.staging)
+	for _, repo := range in.installed {
+		if err := os.RemoveAll(repo); err != nil {
+			log.WithFields(log.Fields{"repo": repo, "error": err}).Warn("restic: failed to remove transferred repository")
+		}
+	}
+	for _, repo := range in.keys {
+		_ = DeleteKey(repo)
+	}
+	if in.policy {
+		RemoveServerPolicy(in.serverId)
+	}
+	in.installed, in.keys, in.policy = nil, nil, false
+}
+
+// WriteTarDir adds the contents of dir to the archive, within baseName.
+func WriteTarDir(tw *tar.Writer, dir string, baseName string) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		// Repositories archived by older versions of Wings still contain their
+		// password, which must never be sent along with the repository.
+		if rel == LegacyKeyFile || rel == LegacyKeyFile+".tmp" {
+			return nil
+		}
+
+		name := filepath.ToSlash(filepath.Join(baseName, rel))
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			// Best-effort: record the link target in the archive header.
+			if t, e := os.Readlink(p); e == nil {
+				link = t
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err := io.Copy(tw, f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
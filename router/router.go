@@ -44,6 +44,7 @@ func Configure(m *wserver.Manager, client remote.Client) *gin.Engine {
 	// These routes use signed URLs to validate access to the resource being requested.
 	router.GET("/download/backup", getDownloadBackup)
 	router.GET("/download/restic-backup", getDownloadResticBackup)
+	router.GET("/download/restic-file", getDownloadResticFile)
 	router.GET("/download/file", getDownloadFile)
 	router.POST("/upload/file", postServerUploadFiles)
 
@@ -97,6 +98,20 @@ func Configure(m *wserver.Manager, client remote.Client) *gin.Engine {
 			server.GET("/backups/restic", restic.ListServerResticBackups)
 			server.GET("/backups/restic/status", restic.GetServerResticBackupStatus)
 			server.GET("/backups/restic/stats", restic.GetServerResticStats)
+			server.GET("/backups/restic/diff", restic.DiffServerResticBackups)
+			server.GET("/backups/restic/jobs", restic.ListServerResticJobs)
+			server.DELETE("/backups/restic/jobs/:id", restic.CancelServerResticJob)
+			server.GET("/backups/restic/policy", restic.GetServerResticPolicy)
+			server.POST("/backups/restic/policy", restic.SaveServerResticPolicy)
+			server.GET("/backups/restic/replication", restic.GetServerResticReplication)
+			server.POST("/backups/restic/replication", restic.SaveServerResticReplication)
+			server.GET("/backups/restic/replication/status", restic.GetServerResticReplicationStatus)
+			server.POST("/backups/restic/import", restic.ImportServerResticBackups)
+			server.GET("/backups/restic/keys", restic.ListServerResticKeys)
+			server.POST("/backups/restic/keys", restic.AddServerResticKey)
+			server.POST("/backups/restic/keys/passwd", restic.ChangeServerResticKeyPassword)
+			server.POST("/backups/restic/keys/rotate", restic.RotateServerResticKey)
+			server.DELETE("/backups/restic/keys/:keyId", restic.RemoveServerResticKey)
 			server.GET("/backups/restic/restore/status", restic.GetServerResticRestoreStatus)
 			server.POST("/backups/restic/prune", restic.PruneServerResticBackup)
 			server.GET("/backups/restic/prune/status", restic.GetServerResticPruneStatus)
@@ -116,7 +131,9 @@ func Configure(m *wserver.Manager, client remote.Client) *gin.Engine {
 			server.POST("/backups/restic/:backupId/restore", restic.RestoreServerResticBackupHandler)
 			// Backwards-compatible restore route
 			server.POST("/backups/restic/restore/:backupId", restic.RestoreServerResticBackupHandler)
+			server.POST("/backups/restic/rollback", restic.RollbackServerResticRestore)
 			server.GET("/backups/restic/:backupId/download", restic.DownloadServerResticBackup)
+			server.GET("/backups/restic/:backupId/files", restic.ListServerResticBackupFiles)
 
 		files := server.Group("/files")
 		{
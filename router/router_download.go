@@ -113,6 +113,26 @@ func getDownloadResticBackup(c *gin.Context) {
 	restic.DownloadServerResticBackupFromToken(c, s, token.BackupId)
 }
 
+// Handle a download request for a single file within a Restic backup.
+func getDownloadResticFile(c *gin.Context) {
+	manager := middleware.ExtractManager(c)
+	token := tokens.ResticFilePayload{}
+	if err := tokens.ParseToken([]byte(c.Query("token")), &token); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+
+	s, ok := manager.Get(token.ServerUuid)
+	if !ok || !token.IsUniqueRequest() {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "The requested resource was not found on this server.",
+		})
+		return
+	}
+
+	restic.StreamResticFileFromToken(c, s, token.BackupId, token.OwnerUsername, token.FilePath)
+}
+
 // Handles downloading a specific file for a server.
 func getDownloadFile(c *gin.Context) {
 	manager := middleware.ExtractManager(c)
@@ -9,6 +9,7 @@ import (
 	"github.com/apex/log"
 	"github.com/gin-gonic/gin"
 
+	"github.com/pterodactyl/wings/internal/api/restic"
 	"github.com/pterodactyl/wings/router/middleware"
 	"github.com/pterodactyl/wings/server"
 	"github.com/pterodactyl/wings/server/backup"
@@ -35,6 +36,8 @@ func postServerBackup(c *gin.Context) {
 		adapter = backup.NewLocal(client, data.Uuid, data.Ignore)
 	case backup.S3BackupAdapter:
 		adapter = backup.NewS3(client, data.Uuid, data.Ignore)
+	case backup.ResticBackupAdapter:
+		adapter = restic.NewBackupAdapter(client, s, data.Uuid, data.Ignore)
 	default:
 		middleware.CaptureAndAbort(c, errors.New("router/backups: provided adapter is not valid: "+string(data.Adapter)))
 		return
@@ -71,7 +74,7 @@ func postServerRestoreBackup(c *gin.Context) {
 	logger := middleware.ExtractLogger(c)
 
 	var data struct {
-		Adapter           backup.AdapterType `binding:"required,oneof=wings s3" json:"adapter"`
+		Adapter           backup.AdapterType `binding:"required,oneof=wings s3 restic" json:"adapter"`
 		TruncateDirectory bool               `json:"truncate_directory"`
 		// A UUID is always required for this endpoint, however the download URL
 		// is only present when the given adapter type is s3.
@@ -127,6 +130,28 @@ func postServerRestoreBackup(c *gin.Context) {
 		return
 	}
 
+	// Restic backups are restored straight out of the repository of the server.
+	if data.Adapter == backup.ResticBackupAdapter {
+		b, err := restic.LocateBackup(client, s, c.Param("backup"))
+		if err != nil {
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
+		go func(s *server.Server, b backup.BackupInterface, logger *log.Entry) {
+			logger.Info("starting restoration process for server backup using restic driver")
+			if err := s.RestoreBackup(b, nil); err != nil {
+				logger.WithField("error", err).Error("failed to restore restic backup to server")
+			}
+			s.Events().Publish(server.DaemonMessageEvent, "Completed server restoration from restic backup.")
+			s.Events().Publish(server.BackupRestoreCompletedEvent, "")
+			logger.Info("completed server restoration from restic backup")
+			s.SetRestoring(false)
+		}(s, b, logger)
+		hasError = false
+		c.Status(http.StatusAccepted)
+		return
+	}
+
 	// Since this is not a local backup we need to stream the archive and then
 	// parse over the contents as we go in order to restore it to the server.
 	httpClient := http.Client{}
@@ -171,12 +196,16 @@ func postServerRestoreBackup(c *gin.Context) {
 	c.Status(http.StatusAccepted)
 }
 
-// deleteServerBackup deletes a local backup of a server. If the backup is not
-// found on the machine just return a 404 error. The service calling this
+// deleteServerBackup deletes a local or restic backup of a server. If the backup
+// is not found on the machine just return a 404 error. The service calling this
 // endpoint can make its own decisions as to how it wants to handle that
 // response.
 func deleteServerBackup(c *gin.Context) {
+	var b backup.BackupInterface
 	b, _, err := backup.LocateLocal(middleware.ExtractApiClient(c), c.Param("backup"))
+	if errors.Is(err, os.ErrNotExist) {
+		b, err = restic.LocateBackup(middleware.ExtractApiClient(c), middleware.ExtractServer(c), c.Param("backup"))
+	}
 	if err != nil {
 		// Just return from the function at this point if the backup was not located.
 		if errors.Is(err, os.ErrNotExist) {
@@ -192,6 +221,12 @@ func deleteServerBackup(c *gin.Context) {
 	// locate the backup previously and it is now missing when we go to delete, just
 	// treat it as having been successful, rather than returning a 404.
 	if err := b.Remove(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		if errors.Is(err, restic.ErrSnapshotLocked) {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+				"error": "The requested backup is locked and cannot be deleted.",
+			})
+			return
+		}
 		middleware.CaptureAndAbort(c, err)
 		return
 	}
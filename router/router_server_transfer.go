@@ -9,6 +9,7 @@ import (
 	"emperror.dev/errors"
 	"github.com/gin-gonic/gin"
 
+	"github.com/pterodactyl/wings/config"
 	"github.com/pterodactyl/wings/environment"
 	"github.com/pterodactyl/wings/router/middleware"
 	"github.com/pterodactyl/wings/server"
@@ -21,6 +22,8 @@ type serverTransferRequest struct {
 	URL    string                  `binding:"required" json:"url"`
 	Token  string                  `binding:"required" json:"token"`
 	Server installer.ServerDetails `json:"server"`
+	// ResticRepositories overrides restic.transfer_repositories for this transfer.
+	ResticRepositories *bool `json:"restic_repositories"`
 }
 
 // postServerTransfer handles the start of a transfer for a server.
@@ -75,12 +78,28 @@ func postServerTransfer(c *gin.Context) {
 
 	// Create a new transfer instance for this server.
 	trnsfr := transfer.New(context.Background(), s)
+
+	includeRestic := config.Get().Restic.TransferRepositories
+	if data.ResticRepositories != nil {
+		includeRestic = *data.ResticRepositories
+	}
+	if includeRestic {
+		if err := trnsfr.IncludeResticRepositories(); err != nil {
+			s.SetTransferring(false)
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+				"error": "The restic repositories of this server cannot be transferred right now: " + err.Error(),
+			})
+			return
+		}
+	}
 	transfer.Outgoing().Add(trnsfr)
 
 	go func() {
 		defer transfer.Outgoing().Remove(trnsfr)
+		defer trnsfr.ReleaseResticRepositories()
 
-		if _, err := trnsfr.PushArchiveToTarget(data.URL, data.Token); err != nil {
+		res, err := trnsfr.PushArchiveToTarget(data.URL, data.Token)
+		if err != nil {
 			notifyPanelOfFailure()
 
 			if err == context.Canceled {
@@ -99,6 +118,7 @@ func postServerTransfer(c *gin.Context) {
 		// the server state on the destination node, we just need to make sure
 		// we clean up our statuses for failure.
 
+		trnsfr.RemoveResticRepositories(res)
 		trnsfr.Log().Debug("transfer complete")
 	}()
 
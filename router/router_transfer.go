@@ -18,6 +18,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
+	"github.com/pterodactyl/wings/internal/resticsvc"
 	"github.com/pterodactyl/wings/router/middleware"
 	"github.com/pterodactyl/wings/router/tokens"
 	"github.com/pterodactyl/wings/server"
@@ -89,10 +90,16 @@ func postTransfers(c *gin.Context) {
 	// the transfer.
 
 	successful := false
+	// The restic repositories of the server, if the source node sent them.
+	var repos *resticsvc.IncomingRepositories
 	defer func(ctx context.Context, trnsfr *transfer.Transfer) {
 		// Remove the transfer from the list of incoming transfers.
 		transfer.Incoming().Remove(trnsfr)
 
+		if !successful && repos != nil {
+			repos.Discard()
+		}
+
 		if !successful {
 			trnsfr.Server.Events().Publish(server.TransferStatusEvent, "failure")
 			manager.Remove(func(match *server.Server) bool {
@@ -136,6 +143,7 @@ func postTransfers(c *gin.Context) {
 
 	// Used to calculate the hash of the file as it is being uploaded.
 	h := sha256.New()
+	rh := sha256.New()
 
 	// Used to read the file and checksum from the request body.
 	mr := multipart.NewReader(c.Request.Body, params["boundary"])
@@ -145,6 +153,9 @@ func postTransfers(c *gin.Context) {
 		hasArchive       bool
 		hasChecksum      bool
 		checksumVerified bool
+
+		hasRestic              bool
+		resticChecksumVerified bool
 	)
 out:
 	for {
@@ -214,6 +225,50 @@ out:
 
 				trnsfr.Log().Debug("checksums match")
 				checksumVerified = true
+			case "restic_manifest":
+				trnsfr.Log().Debug("received restic manifest")
+
+				v, err := io.ReadAll(p)
+				if err != nil {
+					middleware.CaptureAndAbort(c, err)
+					return
+				}
+				if repos, err = resticsvc.NewIncomingRepositories(trnsfr.Server.ID(), v); err != nil {
+					middleware.CaptureAndAbort(c, err)
+					return
+				}
+			case "restic":
+				trnsfr.Log().Debug("received restic repositories")
+
+				if repos == nil {
+					middleware.CaptureAndAbort(c, errors.New("restic manifest must be sent before the restic repositories"))
+					return
+				}
+				if err := repos.Receive(ctx, io.TeeReader(p, rh)); err != nil {
+					middleware.CaptureAndAbort(c, err)
+					return
+				}
+
+				hasRestic = true
+			case "restic_checksum":
+				trnsfr.Log().Debug("received restic checksum")
+
+				if !hasRestic {
+					middleware.CaptureAndAbort(c, errors.New("restic repositories must be sent before the restic checksum"))
+					return
+				}
+
+				v, err := io.ReadAll(p)
+				if err != nil {
+					middleware.CaptureAndAbort(c, err)
+					return
+				}
+				if string(bytes.TrimSpace(v)) != hex.EncodeToString(rh.Sum(nil)) {
+					middleware.CaptureAndAbort(c, errors.New("restic checksums don't match"))
+					return
+				}
+
+				resticChecksumVerified = true
 			default:
 				continue
 			}
@@ -230,6 +285,24 @@ out:
 		return
 	}
 
+	// Restic repositories are optional, but once the source node has started to
+	// send them they must arrive intact, since it removes its own copy as soon as
+	// this node confirms that it has them.
+	installedRepos := -1
+	if repos != nil {
+		if !resticChecksumVerified {
+			middleware.CaptureAndAbort(c, errors.New("missing restic repositories or checksum"))
+			return
+		}
+		trnsfr.Log().Debug("verifying restic repositories")
+		n, err := repos.Install(ctx)
+		if err != nil {
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
+		installedRepos = n
+	}
+
 	// Transfer is almost complete, we just want to ensure the environment is
 	// configured correctly.  We might want to not fail the transfer at this
 	// stage, but we will just to be safe.
@@ -244,6 +317,12 @@ out:
 	// rather than failing the transfer like we do by default.
 	successful = true
 
+	// The source node removes its restic repositories only when this confirms
+	// that they were installed and verified.
+	if installedRepos >= 0 {
+		c.JSON(http.StatusOK, gin.H{"restic_repositories": installedRepos})
+	}
+
 	// The rest of the logic for ensuring the server is unlocked and everything
 	// is handled in the deferred function above.
 	trnsfr.Log().Debug("done!")
@@ -0,0 +1,31 @@
+package tokens
+
+import (
+	"github.com/gbrlsnchs/jwt/v3"
+)
+
+// ResticFilePayload authorizes the download of a single file from a restic
+// snapshot. The repository is opened using the key stored alongside it, so the
+// token never contains the encryption key.
+type ResticFilePayload struct {
+	jwt.Payload
+
+	ServerUuid    string `json:"server_uuid"`
+	BackupId      string `json:"backup_id"`
+	OwnerUsername string `json:"owner_username"`
+	FilePath      string `json:"file_path"`
+	UniqueId      string `json:"unique_id"`
+}
+
+// Returns the JWT payload.
+func (p *ResticFilePayload) GetPayload() *jwt.Payload {
+	return &p.Payload
+}
+
+// Determines if this JWT is valid for the given request cycle. If the
+// unique ID passed in the token has already been seen before this will
+// return false. This allows us to use this JWT as a one-time token that
+// validates all of the request.
+func (p *ResticFilePayload) IsUniqueRequest() bool {
+	return getTokenStore().IsValidToken(p.UniqueId)
+}
@@ -76,6 +76,8 @@ var e = []string{
 	server.DaemonMessageEvent,
 	server.BackupCompletedEvent,
 	server.BackupRestoreCompletedEvent,
+	server.ResticBackupProgressEvent,
+	server.ResticRestoreProgressEvent,
 	server.TransferLogsEvent,
 	server.TransferStatusEvent,
 }
@@ -155,7 +155,7 @@ func (h *Handler) SendJson(v Message) error {
 
 		// If the user does not have permission to see backup events, do not emit
 		// them over the socket.
-		if strings.HasPrefix(string(v.Event), server.BackupCompletedEvent) {
+		if strings.HasPrefix(string(v.Event), server.BackupCompletedEvent) || v.Event == server.ResticBackupProgressEvent || v.Event == server.ResticRestoreProgressEvent {
 			if !j.HasPermission(PermissionReceiveBackups) {
 				return nil
 			}
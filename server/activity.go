@@ -20,6 +20,18 @@ const (
 	ActivitySftpRename          = models.Event("server:sftp.rename")
 	ActivitySftpDelete          = models.Event("server:sftp.delete")
 	ActivityFileUploaded        = models.Event("server:file.uploaded")
+	ActivityResticBackup        = models.Event("server:restic.backup")
+	ActivityResticRestore       = models.Event("server:restic.restore")
+	ActivityResticDelete        = models.Event("server:restic.delete")
+	ActivityResticLock          = models.Event("server:restic.lock")
+	ActivityResticUnlock        = models.Event("server:restic.unlock")
+	ActivityResticPrune         = models.Event("server:restic.prune")
+	ActivityResticRepoUnlock    = models.Event("server:restic.repo-unlock")
+	ActivityResticKeyAdd        = models.Event("server:restic.key-add")
+	ActivityResticKeyRemove     = models.Event("server:restic.key-remove")
+	ActivityResticKeyRotate     = models.Event("server:restic.key-rotate")
+	ActivityResticCopy          = models.Event("server:restic.copy")
+	ActivityResticImport        = models.Event("server:restic.import")
 )
 
 // RequestActivity is a wrapper around a LoggedEvent that is able to track additional request
@@ -33,8 +33,9 @@ func (s *Server) notifyPanelOfBackup(uuid string, ad *backup.ArchiveDetails, suc
 	return nil
 }
 
-// Get all of the ignored files for a server based on its .pteroignore file in the root.
-func (s *Server) getServerwideIgnoredFiles() (string, error) {
+// GetServerwideIgnoredFiles returns all of the ignored files for a server based
+// on its .pteroignore file in the root.
+func (s *Server) GetServerwideIgnoredFiles() (string, error) {
 	f, st, err := s.Filesystem().File(".pteroignore")
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
@@ -60,7 +61,7 @@ func (s *Server) getServerwideIgnoredFiles() (string, error) {
 func (s *Server) Backup(b backup.BackupInterface) error {
 	ignored := b.Ignored()
 	if b.Ignored() == "" {
-		if i, err := s.getServerwideIgnoredFiles(); err != nil {
+		if i, err := s.GetServerwideIgnoredFiles(); err != nil {
 			log.WithField("server", s.ID()).WithField("error", err).Warn("failed to get server-wide ignored files")
 		} else {
 			ignored = i
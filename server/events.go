@@ -16,6 +16,8 @@ const (
 	StatsEvent                  = "stats"
 	BackupRestoreCompletedEvent = "backup restore completed"
 	BackupCompletedEvent        = "backup completed"
+	ResticBackupProgressEvent   = "restic backup progress"
+	ResticRestoreProgressEvent  = "restic restore progress"
 	TransferLogsEvent           = "transfer logs"
 	TransferStatusEvent         = "transfer status"
 	DeletedEvent                = "deleted"
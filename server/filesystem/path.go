@@ -5,6 +5,8 @@ import (
 	"strings"
 
 	"emperror.dev/errors"
+
+	"github.com/pterodactyl/wings/internal/ufs"
 )
 
 // Checks if the given file or path is in the server's file denylist. If so, an Error
@@ -23,6 +25,29 @@ func (fs *Filesystem) IsIgnored(paths ...string) error {
 	return nil
 }
 
+// ValidatePath checks that the given path resolves within the server data
+// directory, is not a symlink, and is not in the server's file denylist. The
+// path does not need to exist, which allows it to be used for files that are
+// about to be written by an external process, such as a backup restore. The
+// cleaned path relative to the root of the data directory is returned.
+func (fs *Filesystem) ValidatePath(p string) (string, error) {
+	rel := strings.TrimPrefix(filepath.Clean(filepath.Join("/", strings.TrimPrefix(p, fs.Path()))), "/")
+	if rel == "" {
+		rel = "."
+	}
+	st, err := fs.unixFS.Lstat(rel)
+	if err != nil && !errors.Is(err, ufs.ErrNotExist) {
+		return "", err
+	}
+	if err == nil && st.Mode()&ufs.ModeSymlink != 0 {
+		return "", errors.WithStack(&Error{code: ErrCodePathResolution, path: p, resolved: rel})
+	}
+	if err := fs.IsIgnored(rel); err != nil {
+		return "", err
+	}
+	return rel, nil
+}
+
 // Generate a path to the file by cleaning it up and appending the root server path to it. This
 // DOES NOT guarantee that the file resolves within the server data directory. You'll want to use
 // the fs.unsafeIsInDataDirectory(p) function to confirm.
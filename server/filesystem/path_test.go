@@ -109,6 +109,31 @@ func TestFilesystem_Blocks_Symlinks(t *testing.T) {
 		})
 	})
 
+	g.Describe("ValidatePath", func() {
+		g.It("cleans a path within the root that does not exist", func() {
+			p, err := fs.ValidatePath("/world/../world/region/r.0.0.mca")
+			g.Assert(err).IsNil()
+			g.Assert(p).Equal("world/region/r.0.0.mca")
+		})
+
+		g.It("clamps paths that escape the root to within it", func() {
+			p, err := fs.ValidatePath("../../malicious.txt")
+			g.Assert(err).IsNil()
+			g.Assert(p).Equal("malicious.txt")
+		})
+
+		g.It("cannot be a symlink", func() {
+			_, err := fs.ValidatePath("symlinked.txt")
+			g.Assert(err).IsNotNil()
+			g.Assert(IsErrorCode(err, ErrCodePathResolution)).IsTrue()
+		})
+
+		g.It("cannot be within a directory symlinked outside the root", func() {
+			_, err := fs.ValidatePath("external_dir/foo.txt")
+			g.Assert(err).IsNotNil()
+		})
+	})
+
 	g.Describe("Rename", func() {
 		g.It("can rename a file symlinked outside the directory root", func() {
 			_, err := os.Lstat(filepath.Join(rfs.root, "server", "symlinked.txt"))
@@ -0,0 +1,85 @@
+package transfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+
+	"github.com/pterodactyl/wings/internal/resticsvc"
+)
+
+// IncludeResticRepositories sends the restic repositories of the server to the
+// target node after the server archive. An error is returned if they cannot be
+// sent right now, such as when a backup of the server is running. No restic jobs
+// are accepted for the server until ReleaseResticRepositories is called.
+func (t *Transfer) IncludeResticRepositories() error {
+	m, err := resticsvc.NewTransferManifest(t.Server.ID())
+	if err != nil {
+		return err
+	}
+	t.resticHeld = true
+	if !m.Empty() {
+		t.restic = m
+	}
+	return nil
+}
+
+// ReleaseResticRepositories accepts restic jobs for the server again once the
+// transfer has finished.
+func (t *Transfer) ReleaseResticRepositories() {
+	if t.resticHeld {
+		resticsvc.ReleaseTransfer(t.Server.ID())
+	}
+}
+
+// streamResticRepositories writes the manifest of the restic repositories, the
+// archive of the repositories and its checksum to the request.
+func (t *Transfer) streamResticRepositories(ctx context.Context, mp *multipart.Writer) error {
+	t.SendMessage("Streaming restic repositories to destination...")
+
+	manifest, err := json.Marshal(t.restic)
+	if err != nil {
+		return fmt.Errorf("failed to encode restic manifest: %w", err)
+	}
+	if err := mp.WriteField("restic_manifest", string(manifest)); err != nil {
+		return fmt.Errorf("failed to stream restic manifest: %w", err)
+	}
+
+	dest, err := mp.CreateFormFile("restic", "restic.tar.gz")
+	if err != nil {
+		return fmt.Errorf("failed to create restic form file: %w", err)
+	}
+	h := sha256.New()
+	if err := t.restic.Stream(ctx, io.MultiWriter(dest, h)); err != nil {
+		return fmt.Errorf("failed to stream restic repositories to destination: %w", err)
+	}
+	if err := mp.WriteField("restic_checksum", hex.EncodeToString(h.Sum(nil))); err != nil {
+		return fmt.Errorf("failed to stream restic checksum: %w", err)
+	}
+
+	t.SendMessage("Finished streaming restic repositories to destination.")
+	return nil
+}
+
+// RemoveResticRepositories removes the restic repositories that were sent to the
+// target node from this node. They are only removed if the response from the
+// target node confirms that it has verified them, since a target node running an
+// older version of Wings ignores them.
+func (t *Transfer) RemoveResticRepositories(response []byte) {
+	if t.restic == nil {
+		return
+	}
+	var res struct {
+		ResticRepositories *int `json:"restic_repositories"`
+	}
+	if err := json.Unmarshal(response, &res); err != nil || res.ResticRepositories == nil {
+		t.Log().Warn("destination did not confirm that it received the restic repositories, leaving them on this node")
+		return
+	}
+	resticsvc.RemoveTransferredRepositories(t.Server.ID(), t.restic)
+	t.SendMessage("Removed restic repositories from source node.")
+}
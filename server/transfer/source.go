@@ -119,6 +119,13 @@ func (t *Transfer) PushArchiveToTarget(url, token string) ([]byte, error) {
 		cancel2()
 		t.SendMessage("Finished streaming archive to destination.")
 
+		if t.restic != nil {
+			if err := t.streamResticRepositories(ctx, mp); err != nil {
+				errChan <- err
+				return
+			}
+		}
+
 		if err := mp.Close(); err != nil {
 			t.Log().WithError(err).Error("error while closing multipart writer")
 		}
@@ -7,6 +7,7 @@ import (
 	"github.com/apex/log"
 	"github.com/mitchellh/colorstring"
 
+	"github.com/pterodactyl/wings/internal/resticsvc"
 	"github.com/pterodactyl/wings/server"
 	"github.com/pterodactyl/wings/system"
 )
@@ -53,6 +54,12 @@ type Transfer struct {
 
 	// archive is the archive that is being created for the transfer.
 	archive *Archive
+
+	// restic is the manifest of the restic repositories that are sent along with
+	// the server, or nil if they are not. resticHeld is true while restic jobs
+	// are not accepted for the server because of the transfer.
+	restic     *resticsvc.TransferManifest
+	resticHeld bool
 }
 
 // New returns a new transfer instance for the given server.